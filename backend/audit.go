@@ -0,0 +1,548 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"gopkg.in/natefinch/lumberjack.v2"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"github.com/Mirantis/cri-dockerd/config"
+	"github.com/Mirantis/cri-dockerd/streaming"
+)
+
+// AuditLevel is how much of an operation is audited.
+type AuditLevel int
+
+const (
+	// AuditLevelNone doesn't audit the operation.
+	AuditLevelNone AuditLevel = iota
+	// AuditLevelMetadata audits who did the operation on what, its
+	// security-relevant fields and its result.
+	AuditLevelMetadata
+	// AuditLevelRequest also audits the commands, ports and environment
+	// variable names of the request.
+	AuditLevelRequest
+)
+
+// auditReadChunkSize is how much of the audit log is read at once, from its
+// end, to find its last line.
+const auditReadChunkSize = 64 << 10
+
+// auditedOperations are the CRI methods which are audited.
+var auditedOperations = []string{
+	"CreateContainer",
+	"Exec",
+	"ExecSync",
+	"Attach",
+	"PortForward",
+	"RemoveImage",
+	"PullImage",
+}
+
+// optInAuditedOperations are only audited when the policy lists them, the
+// kubelet runs the exec probes through ExecSync.
+var optInAuditedOperations = []string{
+	"ExecSync",
+}
+
+var auditLevelNames = map[string]AuditLevel{
+	"none":     AuditLevelNone,
+	"metadata": AuditLevelMetadata,
+	"request":  AuditLevelRequest,
+}
+
+func (l AuditLevel) String() string {
+	for name, level := range auditLevelNames {
+		if level == l {
+			return name
+		}
+	}
+	return fmt.Sprintf("AuditLevel(%d)", int(l))
+}
+
+// AuditPolicy is the audit level of each audited operation.
+type AuditPolicy map[string]AuditLevel
+
+// ParseAuditPolicy parses a comma-separated list of operation=level pairs,
+// a bare level applying to the operations which aren't listed, except for
+// the opt-in ones, e.g. "metadata,ExecSync=request,PullImage=none".
+func ParseAuditPolicy(value string) (AuditPolicy, error) {
+	defaultLevel := AuditLevelMetadata
+	levels := make(map[string]AuditLevel)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		operation, name, found := strings.Cut(entry, "=")
+		if !found {
+			operation, name = "", operation
+		}
+		level, ok := auditLevelNames[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown audit level %q", name)
+		}
+		if operation == "" {
+			defaultLevel = level
+			continue
+		}
+		if !isAuditedOperation(operation) {
+			return nil, fmt.Errorf(
+				"operation %q is not audited, expected one of %s",
+				operation,
+				strings.Join(auditedOperations, ", "),
+			)
+		}
+		levels[operation] = level
+	}
+
+	policy := make(AuditPolicy)
+	for _, operation := range auditedOperations {
+		policy[operation] = defaultLevel
+		if isOptInAuditedOperation(operation) {
+			policy[operation] = AuditLevelNone
+		}
+		if level, ok := levels[operation]; ok {
+			policy[operation] = level
+		}
+	}
+	return policy, nil
+}
+
+func isAuditedOperation(operation string) bool {
+	for _, audited := range auditedOperations {
+		if operation == audited {
+			return true
+		}
+	}
+	return false
+}
+
+func isOptInAuditedOperation(operation string) bool {
+	for _, optIn := range optInAuditedOperations {
+		if operation == optIn {
+			return true
+		}
+	}
+	return false
+}
+
+// AuditLogOptions configures where the audit events are written and how the
+// file is rotated.
+type AuditLogOptions struct {
+	// Path is the file the events are written to.
+	Path string
+	// MaxSize is the size in megabytes the file is rotated at.
+	MaxSize int
+	// MaxBackups is the number of rotated files kept, all of them if 0.
+	MaxBackups int
+	// MaxAge is the number of days rotated files are kept, forever if 0.
+	MaxAge int
+	// Policy is the audit level of each operation.
+	Policy AuditPolicy
+	// HMACKeyFile is the file holding the key the chain of the events is
+	// signed with. Without a key, the chain is a plain SHA-256 which anyone
+	// able to write the log can recompute, and it only detects tampering
+	// when its last hash is anchored outside of the node.
+	HMACKeyFile string
+}
+
+// auditEvent is a line of the audit log. Each event holds the hash of the
+// previous line, an HMAC if a key is configured, so that removed or
+// modified lines break the chain.
+type auditEvent struct {
+	Timestamp    time.Time `json:"timestamp"`
+	Level        string    `json:"level"`
+	Operation    string    `json:"operation"`
+	Namespace    string    `json:"namespace,omitempty"`
+	Pod          string    `json:"pod,omitempty"`
+	PodSandboxID string    `json:"podSandboxID,omitempty"`
+	ContainerID  string    `json:"containerID,omitempty"`
+	Container    string    `json:"container,omitempty"`
+	Image        string    `json:"image,omitempty"`
+	// SessionID identifies the exec or attach session, and its recording if
+	// it is recorded.
+	SessionID    string         `json:"sessionID,omitempty"`
+	Security     *auditSecurity `json:"security,omitempty"`
+	Request      *auditRequest  `json:"request,omitempty"`
+	Result       auditResult    `json:"result"`
+	PreviousHash string         `json:"previousHash"`
+}
+
+// auditSecurity are the security-relevant fields of a created container.
+type auditSecurity struct {
+	Privileged       bool              `json:"privileged,omitempty"`
+	AddCapabilities  []string          `json:"addCapabilities,omitempty"`
+	DropCapabilities []string          `json:"dropCapabilities,omitempty"`
+	HostPathMounts   []auditMount      `json:"hostPathMounts,omitempty"`
+	Namespaces       map[string]string `json:"namespaces,omitempty"`
+	RunAsUser        *int64            `json:"runAsUser,omitempty"`
+	ReadonlyRootfs   bool              `json:"readonlyRootfs,omitempty"`
+}
+
+type auditMount struct {
+	HostPath      string `json:"hostPath"`
+	ContainerPath string `json:"containerPath"`
+	Readonly      bool   `json:"readonly,omitempty"`
+}
+
+// auditRequest are the fields of the request audited at AuditLevelRequest.
+// Environment variable values and registry credentials are never written.
+type auditRequest struct {
+	Command     []string `json:"command,omitempty"`
+	Env         []string `json:"env,omitempty"`
+	Ports       []int32  `json:"ports,omitempty"`
+	Stdin       bool     `json:"stdin,omitempty"`
+	Tty         bool     `json:"tty,omitempty"`
+	Credentials bool     `json:"credentials,omitempty"`
+}
+
+type auditResult struct {
+	Code  string `json:"code"`
+	Error string `json:"error,omitempty"`
+	// ImageRef is the reference of the pulled image.
+	ImageRef string `json:"imageRef,omitempty"`
+}
+
+// auditStatusGetter finds the pods of the containers and sandboxes audited
+// requests apply to.
+type auditStatusGetter interface {
+	ContainerStatus(
+		ctx context.Context,
+		r *runtimeapi.ContainerStatusRequest,
+	) (*runtimeapi.ContainerStatusResponse, error)
+	PodSandboxStatus(
+		ctx context.Context,
+		r *runtimeapi.PodSandboxStatusRequest,
+	) (*runtimeapi.PodSandboxStatusResponse, error)
+}
+
+// auditor writes the audit events of the CRI requests.
+type auditor struct {
+	policy  AuditPolicy
+	service auditStatusGetter
+
+	// key signs the chain of the events, if set.
+	key []byte
+
+	lock         sync.Mutex
+	out          io.Writer
+	previousHash string
+}
+
+func newAuditor(opts AuditLogOptions, service auditStatusGetter) (*auditor, error) {
+	var key []byte
+	if opts.HMACKeyFile != "" {
+		data, err := os.ReadFile(opts.HMACKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read audit HMAC key: %v", err)
+		}
+		key = bytes.TrimSpace(data)
+		if len(key) == 0 {
+			return nil, fmt.Errorf("audit HMAC key file %q is empty", opts.HMACKeyFile)
+		}
+	}
+	lastLine, err := lastLine(opts.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log %q: %v", opts.Path, err)
+	}
+	var previousHash string
+	if lastLine != nil {
+		previousHash = hashLine(key, lastLine)
+	}
+	return &auditor{
+		policy:  opts.Policy,
+		service: service,
+		key:     key,
+		out: &lumberjack.Logger{
+			Filename:   opts.Path,
+			MaxSize:    opts.MaxSize,
+			MaxBackups: opts.MaxBackups,
+			MaxAge:     opts.MaxAge,
+		},
+		previousHash: previousHash,
+	}, nil
+}
+
+// lastLine returns the last line of the existing audit log, for the chain to
+// go on across restarts, or nil if there is none.
+func lastLine(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// Read chunks backwards until the line before the last one ends, lines
+	// aren't bounded in size.
+	var line []byte
+	for end := info.Size(); end > 0; {
+		start := end - auditReadChunkSize
+		if start < 0 {
+			start = 0
+		}
+		chunk := make([]byte, end-start)
+		if _, err := f.ReadAt(chunk, start); err != nil && err != io.EOF {
+			return nil, err
+		}
+		line = append(chunk, line...)
+		end = start
+		if len(bytes.TrimRight(line, "\n")) == 0 {
+			line = nil
+			continue
+		}
+		if bytes.LastIndexByte(bytes.TrimRight(line, "\n"), '\n') >= 0 {
+			break
+		}
+	}
+	line = bytes.TrimRight(line, "\n")
+	if len(line) == 0 {
+		return nil, nil
+	}
+	if i := bytes.LastIndexByte(line, '\n'); i >= 0 {
+		line = line[i+1:]
+	}
+	return line, nil
+}
+
+// hashLine returns the HMAC-SHA256 of the line with the key, or its SHA-256
+// without one.
+func hashLine(key, line []byte) string {
+	var h hash.Hash
+	if key != nil {
+		h = hmac.New(sha256.New, key)
+	} else {
+		h = sha256.New()
+	}
+	h.Write(line)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// write chains the event to the previous one and appends it to the log.
+func (a *auditor) write(event *auditEvent) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	event.PreviousHash = a.previousHash
+	line, err := json.Marshal(event)
+	if err != nil {
+		logrus.Errorf("Failed to encode audit event of %s: %v", event.Operation, err)
+		return
+	}
+	if _, err := a.out.Write(append(line, '\n')); err != nil {
+		logrus.Errorf("Failed to write audit event of %s: %v", event.Operation, err)
+		return
+	}
+	a.previousHash = hashLine(a.key, line)
+}
+
+// audit writes the event of the request if the policy audits its operation.
+func (a *auditor) audit(ctx context.Context, method string, req, resp interface{}, err error) {
+	operation := path.Base(method)
+	level := a.policy[operation]
+	if level == AuditLevelNone {
+		return
+	}
+	event := &auditEvent{
+		Timestamp: time.Now().UTC(),
+		Level:     level.String(),
+		Operation: operation,
+		Result:    auditResult{Code: status.Code(err).String()},
+	}
+	if err != nil {
+		event.Result.Error = err.Error()
+	}
+
+	var containerID string
+	switch r := req.(type) {
+	case *runtimeapi.CreateContainerRequest:
+		event.PodSandboxID = r.GetPodSandboxId()
+		event.setPod(r.GetSandboxConfig().GetMetadata())
+		event.Container = r.GetConfig().GetMetadata().GetName()
+		event.Image = r.GetConfig().GetImage().GetImage()
+		event.Security = containerSecurity(r.GetConfig())
+		if level >= AuditLevelRequest {
+			event.Request = &auditRequest{
+				Command: append(r.GetConfig().GetCommand(), r.GetConfig().GetArgs()...),
+				Env:     redactEnv(r.GetConfig().GetEnvs()),
+				Stdin:   r.GetConfig().GetStdin(),
+				Tty:     r.GetConfig().GetTty(),
+			}
+		}
+		if resp, ok := resp.(*runtimeapi.CreateContainerResponse); ok {
+			event.ContainerID = resp.GetContainerId()
+		}
+	case *runtimeapi.ExecRequest:
+		containerID = r.GetContainerId()
+		if level >= AuditLevelRequest {
+			event.Request = &auditRequest{Command: r.GetCmd(), Stdin: r.GetStdin(), Tty: r.GetTty()}
+		}
+		if resp, ok := resp.(*runtimeapi.ExecResponse); ok {
+			event.SessionID = streaming.SessionIDFromURL(resp.GetUrl())
+		}
+	case *runtimeapi.ExecSyncRequest:
+		containerID = r.GetContainerId()
+		if level >= AuditLevelRequest {
+			event.Request = &auditRequest{Command: r.GetCmd()}
+		}
+	case *runtimeapi.AttachRequest:
+		containerID = r.GetContainerId()
+		if level >= AuditLevelRequest {
+			event.Request = &auditRequest{Stdin: r.GetStdin(), Tty: r.GetTty()}
+		}
+		if resp, ok := resp.(*runtimeapi.AttachResponse); ok {
+			event.SessionID = streaming.SessionIDFromURL(resp.GetUrl())
+		}
+	case *runtimeapi.PortForwardRequest:
+		event.PodSandboxID = r.GetPodSandboxId()
+		if sandbox, err := a.service.PodSandboxStatus(
+			ctx,
+			&runtimeapi.PodSandboxStatusRequest{PodSandboxId: r.GetPodSandboxId()},
+		); err == nil {
+			event.setPod(sandbox.GetStatus().GetMetadata())
+		}
+		if level >= AuditLevelRequest {
+			event.Request = &auditRequest{Ports: r.GetPort()}
+		}
+	case *runtimeapi.RemoveImageRequest:
+		event.Image = r.GetImage().GetImage()
+	case *runtimeapi.PullImageRequest:
+		event.setPod(r.GetSandboxConfig().GetMetadata())
+		event.Image = r.GetImage().GetImage()
+		if level >= AuditLevelRequest {
+			event.Request = &auditRequest{Credentials: r.GetAuth() != nil}
+		}
+		if resp, ok := resp.(*runtimeapi.PullImageResponse); ok {
+			event.Result.ImageRef = resp.GetImageRef()
+		}
+	}
+
+	if containerID != "" {
+		event.ContainerID = containerID
+		if container, err := a.service.ContainerStatus(
+			ctx,
+			&runtimeapi.ContainerStatusRequest{ContainerId: containerID},
+		); err == nil {
+			labels := container.GetStatus().GetLabels()
+			event.Namespace = labels[config.KubernetesPodNamespaceLabel]
+			event.Pod = labels[config.KubernetesPodNameLabel]
+			event.Container = container.GetStatus().GetMetadata().GetName()
+			event.Image = container.GetStatus().GetImage().GetImage()
+		}
+	}
+	a.write(event)
+}
+
+func (e *auditEvent) setPod(metadata *runtimeapi.PodSandboxMetadata) {
+	e.Namespace = metadata.GetNamespace()
+	e.Pod = metadata.GetName()
+}
+
+// containerSecurity extracts the security-relevant fields of a container.
+func containerSecurity(c *runtimeapi.ContainerConfig) *auditSecurity {
+	security := &auditSecurity{}
+	sc := c.GetLinux().GetSecurityContext()
+	security.Privileged = sc.GetPrivileged()
+	security.AddCapabilities = sc.GetCapabilities().GetAddCapabilities()
+	security.DropCapabilities = sc.GetCapabilities().GetDropCapabilities()
+	security.ReadonlyRootfs = sc.GetReadonlyRootfs()
+	if sc.GetRunAsUser() != nil {
+		uid := sc.GetRunAsUser().GetValue()
+		security.RunAsUser = &uid
+	}
+	if ns := sc.GetNamespaceOptions(); ns != nil {
+		security.Namespaces = map[string]string{
+			"network": ns.GetNetwork().String(),
+			"pid":     ns.GetPid().String(),
+			"ipc":     ns.GetIpc().String(),
+		}
+	}
+	for _, m := range c.GetMounts() {
+		if m.GetHostPath() == "" {
+			continue
+		}
+		security.HostPathMounts = append(security.HostPathMounts, auditMount{
+			HostPath:      m.GetHostPath(),
+			ContainerPath: m.GetContainerPath(),
+			Readonly:      m.GetReadonly(),
+		})
+	}
+	return security
+}
+
+// redactEnv returns the names of the environment variables, their values may
+// hold secrets.
+func redactEnv(envs []*runtimeapi.KeyValue) []string {
+	var names []string
+	for _, env := range envs {
+		names = append(names, env.GetKey())
+	}
+	return names
+}
+
+// auditInterceptor writes the audit events of the requests once they are
+// handled.
+func auditInterceptor(a *auditor) Interceptor {
+	return Interceptor{
+		Unary: func(
+			ctx context.Context,
+			req interface{},
+			info *grpc.UnaryServerInfo,
+			handler grpc.UnaryHandler,
+		) (interface{}, error) {
+			resp, err := handler(ctx, req)
+			a.audit(ctx, info.FullMethod, req, resp, err)
+			return resp, err
+		},
+	}
+}
+
+// EnableAudit writes the audit events of the mutating requests to the audit
+// log, outside of the default interceptors so that panics are audited as
+// errors. It must be called before Start.
+func (s *CriDockerService) EnableAudit(opts AuditLogOptions) error {
+	a, err := newAuditor(opts, s.service)
+	if err != nil {
+		return err
+	}
+	s.interceptors = append([]Interceptor{auditInterceptor(a)}, s.interceptors...)
+	return nil
+}
@@ -0,0 +1,250 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"github.com/Mirantis/cri-dockerd/config"
+	"github.com/Mirantis/cri-dockerd/streaming"
+)
+
+type fakeStatusGetter struct{}
+
+func (fakeStatusGetter) ContainerStatus(
+	_ context.Context,
+	r *runtimeapi.ContainerStatusRequest,
+) (*runtimeapi.ContainerStatusResponse, error) {
+	return &runtimeapi.ContainerStatusResponse{Status: &runtimeapi.ContainerStatus{
+		Id:       r.ContainerId,
+		Metadata: &runtimeapi.ContainerMetadata{Name: "app"},
+		Image:    &runtimeapi.ImageSpec{Image: "busybox"},
+		Labels: map[string]string{
+			config.KubernetesPodNameLabel:      "web",
+			config.KubernetesPodNamespaceLabel: "default",
+		},
+	}}, nil
+}
+
+func (fakeStatusGetter) PodSandboxStatus(
+	context.Context,
+	*runtimeapi.PodSandboxStatusRequest,
+) (*runtimeapi.PodSandboxStatusResponse, error) {
+	return nil, status.Error(codes.NotFound, "not found")
+}
+
+func TestParseAuditPolicy(t *testing.T) {
+	policy, err := ParseAuditPolicy("request,PullImage=none, ExecSync=Metadata")
+	require.NoError(t, err)
+	assert.Equal(t, AuditLevelRequest, policy["CreateContainer"])
+	assert.Equal(t, AuditLevelNone, policy["PullImage"])
+	assert.Equal(t, AuditLevelMetadata, policy["ExecSync"])
+
+	policy, err = ParseAuditPolicy("")
+	require.NoError(t, err)
+	assert.Equal(t, AuditLevelMetadata, policy["Exec"])
+	// The exec probes aren't audited unless asked for.
+	assert.Equal(t, AuditLevelNone, policy["ExecSync"])
+
+	_, err = ParseAuditPolicy("verbose")
+	assert.ErrorContains(t, err, `unknown audit level "verbose"`)
+	_, err = ParseAuditPolicy("ListContainers=request")
+	assert.ErrorContains(t, err, `operation "ListContainers" is not audited`)
+}
+
+func readAuditEvents(t *testing.T, path string) ([]map[string]interface{}, [][]byte) {
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	var events []map[string]interface{}
+	for _, line := range lines {
+		var event map[string]interface{}
+		require.NoError(t, json.Unmarshal(line, &event))
+		events = append(events, event)
+	}
+	return events, lines
+}
+
+func TestAuditInterceptor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	policy, err := ParseAuditPolicy("request,RemoveImage=none,ExecSync=request")
+	require.NoError(t, err)
+	a, err := newAuditor(AuditLogOptions{Path: path, MaxSize: 1, Policy: policy}, fakeStatusGetter{})
+	require.NoError(t, err)
+	call := func(method string, req, resp interface{}, err error) {
+		_, _ = auditInterceptor(a).Unary(
+			context.Background(),
+			req,
+			&grpc.UnaryServerInfo{FullMethod: method},
+			func(context.Context, interface{}) (interface{}, error) { return resp, err },
+		)
+	}
+
+	call("/runtime.v1.RuntimeService/CreateContainer", &runtimeapi.CreateContainerRequest{
+		PodSandboxId: "sandbox",
+		Config: &runtimeapi.ContainerConfig{
+			Metadata: &runtimeapi.ContainerMetadata{Name: "app"},
+			Image:    &runtimeapi.ImageSpec{Image: "busybox"},
+			Envs:     []*runtimeapi.KeyValue{{Key: "PASSWORD", Value: "hunter2"}},
+			Mounts: []*runtimeapi.Mount{
+				{HostPath: "/etc", ContainerPath: "/host/etc", Readonly: true},
+			},
+			Linux: &runtimeapi.LinuxContainerConfig{SecurityContext: &runtimeapi.LinuxContainerSecurityContext{
+				Privileged:       true,
+				Capabilities:     &runtimeapi.Capability{AddCapabilities: []string{"SYS_ADMIN"}},
+				NamespaceOptions: &runtimeapi.NamespaceOption{Network: runtimeapi.NamespaceMode_NODE},
+			}},
+		},
+		SandboxConfig: &runtimeapi.PodSandboxConfig{
+			Metadata: &runtimeapi.PodSandboxMetadata{Name: "web", Namespace: "default"},
+		},
+	}, &runtimeapi.CreateContainerResponse{ContainerId: "c1"}, nil)
+	call("/runtime.v1.RuntimeService/ExecSync", &runtimeapi.ExecSyncRequest{
+		ContainerId: "c1",
+		Cmd:         []string{"sh", "-c", "id"},
+	}, nil, status.Error(codes.Unavailable, "docker is down"))
+	call("/runtime.v1.ImageService/RemoveImage", &runtimeapi.RemoveImageRequest{}, nil, nil)
+	call("/runtime.v1.RuntimeService/ListContainers", &runtimeapi.ListContainersRequest{}, nil, nil)
+	call("/runtime.v1.ImageService/PullImage", &runtimeapi.PullImageRequest{
+		Image: &runtimeapi.ImageSpec{Image: "private/app"},
+		Auth:  &runtimeapi.AuthConfig{Username: "user", Password: "s3cret"},
+	}, &runtimeapi.PullImageResponse{ImageRef: "sha256:abc"}, nil)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "hunter2")
+	assert.NotContains(t, string(data), "s3cret")
+
+	events, lines := readAuditEvents(t, path)
+	require.Len(t, events, 3)
+	create, execSync, pull := events[0], events[1], events[2]
+
+	assert.Equal(t, "CreateContainer", create["operation"])
+	assert.Equal(t, "default", create["namespace"])
+	assert.Equal(t, "web", create["pod"])
+	assert.Equal(t, "c1", create["containerID"])
+	assert.Equal(t, map[string]interface{}{
+		"privileged":      true,
+		"addCapabilities": []interface{}{"SYS_ADMIN"},
+		"hostPathMounts": []interface{}{map[string]interface{}{
+			"hostPath": "/etc", "containerPath": "/host/etc", "readonly": true,
+		}},
+		"namespaces": map[string]interface{}{"network": "NODE", "pid": "POD", "ipc": "POD"},
+	}, create["security"])
+	assert.Equal(t, []interface{}{"PASSWORD"}, create["request"].(map[string]interface{})["env"])
+	assert.Equal(t, map[string]interface{}{"code": "OK"}, create["result"])
+
+	assert.Equal(t, "web", execSync["pod"])
+	assert.Equal(t, "busybox", execSync["image"])
+	assert.Equal(t, []interface{}{"sh", "-c", "id"}, execSync["request"].(map[string]interface{})["command"])
+	assert.Equal(t, "Unavailable", execSync["result"].(map[string]interface{})["code"])
+
+	assert.Equal(t, true, pull["request"].(map[string]interface{})["credentials"])
+	assert.Equal(t, "sha256:abc", pull["result"].(map[string]interface{})["imageRef"])
+
+	// Every event holds the hash of the previous line, across restarts too.
+	assert.Equal(t, "", create["previousHash"])
+	assert.Equal(t, hashLine(nil, lines[0]), execSync["previousHash"])
+	assert.Equal(t, hashLine(nil, lines[1]), pull["previousHash"])
+	a, err = newAuditor(AuditLogOptions{Path: path, MaxSize: 1, Policy: policy}, fakeStatusGetter{})
+	require.NoError(t, err)
+	call(
+		"/runtime.v1.RuntimeService/Exec",
+		&runtimeapi.ExecRequest{ContainerId: "c1"},
+		&runtimeapi.ExecResponse{Url: "http://127.0.0.1:10010/exec/Xo3lOhJ5"},
+		nil,
+	)
+	events, _ = readAuditEvents(t, path)
+	require.Len(t, events, 4)
+	assert.Equal(t, hashLine(nil, lines[2]), events[3]["previousHash"])
+	// The session is identified by the recordings without revealing the
+	// token.
+	assert.Equal(t, streaming.SessionIDFromURL("/exec/Xo3lOhJ5"), events[3]["sessionID"])
+	assert.NotContains(t, events[3]["sessionID"], "Xo3lOhJ5")
+}
+
+func TestAuditChainWithHMACKey(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	keyFile := filepath.Join(dir, "audit.key")
+	require.NoError(t, os.WriteFile(keyFile, []byte("s3cret-key\n"), 0o600))
+	policy, err := ParseAuditPolicy("metadata")
+	require.NoError(t, err)
+	opts := AuditLogOptions{Path: path, MaxSize: 1, Policy: policy, HMACKeyFile: keyFile}
+	removeImage := func(a *auditor) {
+		a.audit(context.Background(), "/runtime.v1.ImageService/RemoveImage", &runtimeapi.RemoveImageRequest{}, nil, nil)
+	}
+
+	a, err := newAuditor(opts, fakeStatusGetter{})
+	require.NoError(t, err)
+	removeImage(a)
+	removeImage(a)
+	a, err = newAuditor(opts, fakeStatusGetter{})
+	require.NoError(t, err)
+	removeImage(a)
+
+	events, lines := readAuditEvents(t, path)
+	require.Len(t, events, 3)
+	key := []byte("s3cret-key")
+	assert.Equal(t, hashLine(key, lines[0]), events[1]["previousHash"])
+	assert.Equal(t, hashLine(key, lines[1]), events[2]["previousHash"])
+	// The chain can't be recomputed without the key.
+	assert.NotEqual(t, hashLine(nil, lines[1]), events[2]["previousHash"])
+
+	require.NoError(t, os.WriteFile(keyFile, []byte("\n"), 0o600))
+	_, err = newAuditor(opts, fakeStatusGetter{})
+	assert.ErrorContains(t, err, "is empty")
+}
+
+func TestAuditLastLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	line, err := lastLine(path)
+	require.NoError(t, err)
+	assert.Nil(t, line)
+
+	// Lines are read in full, whatever their size.
+	long := bytes.Repeat([]byte("x"), 3*auditReadChunkSize+10)
+	for _, tc := range []struct {
+		content  []byte
+		expected []byte
+	}{
+		{content: []byte(""), expected: nil},
+		{content: []byte("\n\n"), expected: nil},
+		{content: []byte("first\nsecond\n"), expected: []byte("second")},
+		{content: []byte("first\nsecond"), expected: []byte("second")},
+		{content: append(append([]byte("first\n"), long...), '\n'), expected: long},
+		{content: append(append([]byte{}, long...), []byte("\nlast\n")...), expected: []byte("last")},
+		{content: append(append([]byte("first\n"), long...), bytes.Repeat([]byte("\n"), auditReadChunkSize)...), expected: long},
+	} {
+		require.NoError(t, os.WriteFile(path, tc.content, 0o600))
+		line, err := lastLine(path)
+		require.NoError(t, err)
+		assert.Equal(t, tc.expected, line)
+	}
+}
@@ -42,16 +42,33 @@ type CriDockerService struct {
 	service core.DockerService
 	// server is the grpc server.
 	server *grpc.Server
+	// interceptors wrap the handling of every request, outermost first.
+	interceptors []Interceptor
+	// health runs the health checks of the service.
+	health *healthChecker
 }
 
-// NewCriDockerServer creates the cri-dockerd grpc backend.
-func NewCriDockerServer(endpoint string, s core.DockerService) *CriDockerService {
+// NewCriDockerServer creates the cri-dockerd grpc backend, logging every
+// request at requestLogLevel.
+func NewCriDockerServer(
+	endpoint string,
+	s core.DockerService,
+	requestLogLevel logrus.Level,
+) *CriDockerService {
 	return &CriDockerService{
-		endpoint: endpoint,
-		service:  s,
+		endpoint:     endpoint,
+		service:      s,
+		interceptors: defaultInterceptors(requestLogLevel),
+		health:       newHealthChecker(s.HealthChecks()),
 	}
 }
 
+// AddInterceptors adds interceptors run inside the default ones, in the given
+// order. It must be called before Start.
+func (s *CriDockerService) AddInterceptors(interceptors ...Interceptor) {
+	s.interceptors = append(s.interceptors, interceptors...)
+}
+
 func getListener(addr string) (net.Listener, error) {
 	addrSlice := strings.SplitN(addr, "://", 2)
 	proto := addrSlice[0]
@@ -78,10 +95,12 @@ func (s *CriDockerService) Start() error {
 		return fmt.Errorf("cri-dockerd failed to listen on %q: %v", s.endpoint, err)
 	}
 	// Create the grpc backend and register runtime and image services.
-	s.server = grpc.NewServer(
+	opts := []grpc.ServerOption{
 		grpc.MaxRecvMsgSize(maxMsgSize),
 		grpc.MaxSendMsgSize(maxMsgSize),
-	)
+	}
+	opts = append(opts, chainInterceptors(s.interceptors)...)
+	s.server = grpc.NewServer(opts...)
 
 	runtimeapi.RegisterRuntimeServiceServer(s.server, s.service)
 	runtimeapi.RegisterImageServiceServer(s.server, s.service)
@@ -94,5 +113,6 @@ func (s *CriDockerService) Start() error {
 	}()
 
 	handleNotify()
+	handleWatchdog(s.health)
 	return nil
 }
@@ -5,6 +5,7 @@ package backend
 
 import (
 	"errors"
+	"fmt"
 	"net"
 	"os"
 	"os/signal"
@@ -12,6 +13,8 @@ import (
 
 	"github.com/coreos/go-systemd/v22/activation"
 	"github.com/coreos/go-systemd/v22/daemon"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/wait"
 )
 
 func listenFD(addr string) (net.Listener, error) {
@@ -57,3 +60,56 @@ func handleNotify() {
 		sdNotify(daemon.SdNotifyStopping)
 	}()
 }
+
+// handleWatchdog pings the systemd watchdog, when the service has one, as
+// long as the liveness checks pass, so that systemd restarts a wedged
+// cri-dockerd. The checks of dockerd are readiness only, not to restart
+// cri-dockerd while dockerd restarts.
+func handleWatchdog(health *healthChecker) {
+	interval, err := daemon.SdWatchdogEnabled(false)
+	if err != nil {
+		logrus.Errorf("Failed to get the systemd watchdog interval: %v", err)
+		return
+	}
+	if interval == 0 {
+		return
+	}
+	// Ping twice per interval, as systemd recommends, leaving time for the
+	// checks to run.
+	period := interval/2 - healthCheckTimeout
+	if period < interval/4 {
+		period = interval / 4
+	}
+	logrus.Infof("Pinging the systemd watchdog every %v while healthy", period)
+	go wait.Forever(func() {
+		if health.healthy() {
+			sdNotify(daemon.SdNotifyWatchdog)
+		}
+	}, period)
+}
+
+// HandleDumpSignal writes the state of the service and the goroutine stacks
+// to a file in dir on every SIGUSR1. The directory must exist.
+func (s *CriDockerService) HandleDumpSignal(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("cri-dockerd can't dump its state to %q: %v", dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("cri-dockerd can't dump its state to %q: not a directory", dir)
+	}
+	logrus.Infof("Dumping the state to %s on SIGUSR1", dir)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1)
+	go func() {
+		for range sigs {
+			path, err := s.writeDump(dir)
+			if err != nil {
+				logrus.Errorf("Failed to dump the state on SIGUSR1: %v", err)
+				continue
+			}
+			logrus.Infof("Dumped the state to %s", path)
+		}
+	}()
+	return nil
+}
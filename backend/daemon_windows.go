@@ -14,3 +14,11 @@ func listenFD(addr string) (net.Listener, error) {
 
 func handleNotify() {
 }
+
+func handleWatchdog(health *healthChecker) {
+}
+
+// HandleDumpSignal does nothing, there is no SIGUSR1 on Windows.
+func (s *CriDockerService) HandleDumpSignal(dir string) error {
+	return nil
+}
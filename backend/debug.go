@@ -0,0 +1,136 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	runtimepprof "runtime/pprof"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/kubernetes/pkg/kubelet/util"
+
+	"github.com/Mirantis/cri-dockerd/core"
+)
+
+// debugDump is the state written on SIGUSR1.
+type debugDump struct {
+	Time       time.Time        `json:"time"`
+	State      *core.DebugState `json:"state"`
+	Goroutines string           `json:"goroutines"`
+}
+
+// debugHandler serves pprof, the log level and the state of the service.
+func (s *CriDockerService) debugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/loglevel", serveLogLevel)
+	mux.HandleFunc("/debug/state", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(s.service.DebugState()); err != nil {
+			logrus.Errorf("Failed to write the debug state: %v", err)
+		}
+	})
+	return mux
+}
+
+// serveLogLevel returns the log level, and sets it to the body of PUT
+// requests.
+func serveLogLevel(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		body, err := io.ReadAll(io.LimitReader(r.Body, 64))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		level, err := logrus.ParseLevel(strings.TrimSpace(string(body)))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logrus.Infof("Setting the log level to %s", level)
+		logrus.SetLevel(level)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	fmt.Fprintln(w, logrus.GetLevel())
+}
+
+// ServeDebug serves the debug endpoints on the unix socket at path in the
+// background, only root can connect to it.
+func (s *CriDockerService) ServeDebug(path string) error {
+	l, err := util.CreateListener("unix://" + path)
+	if err != nil {
+		return fmt.Errorf("cri-dockerd failed to listen on %q for debugging: %v", path, err)
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		l.Close()
+		return fmt.Errorf("failed to restrict the access to %q: %v", path, err)
+	}
+	server := &http.Server{
+		Handler:           s.debugHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	logrus.Infof("Serving debug endpoints on %s", path)
+	go func() {
+		if err := server.Serve(l); err != nil {
+			logrus.Errorf("Failed to serve debug endpoints: %v", err)
+		}
+	}()
+	return nil
+}
+
+// writeDump writes the state of the service and the goroutine stacks to a
+// new file in dir, and returns its path.
+func (s *CriDockerService) writeDump(dir string) (string, error) {
+	var stacks bytes.Buffer
+	if err := runtimepprof.Lookup("goroutine").WriteTo(&stacks, 2); err != nil {
+		return "", fmt.Errorf("failed to get goroutine stacks: %v", err)
+	}
+	dump := debugDump{
+		Time:       time.Now(),
+		State:      s.service.DebugState(),
+		Goroutines: stacks.String(),
+	}
+	data, err := json.MarshalIndent(dump, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode dump: %v", err)
+	}
+	path := filepath.Join(dir, fmt.Sprintf("cri-dockerd-dump-%s.json", dump.Time.Format("20060102T150405.000000000")))
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return "", fmt.Errorf("failed to write dump: %v", err)
+	}
+	return path, nil
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Mirantis/cri-dockerd/core"
+)
+
+// debugStateService is a docker service which only has a debug state.
+type debugStateService struct {
+	core.DockerService
+}
+
+func (debugStateService) DebugState() *core.DebugState {
+	return &core.DebugState{NetworkReady: map[string]bool{"sandbox": true}}
+}
+
+func TestDebugHandler(t *testing.T) {
+	defer logrus.SetLevel(logrus.GetLevel())
+	logrus.SetLevel(logrus.InfoLevel)
+	s := &CriDockerService{service: debugStateService{}}
+	handler := s.debugHandler()
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPut, "/debug/loglevel", strings.NewReader("debug\n")))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "debug\n", recorder.Body.String())
+	assert.Equal(t, logrus.DebugLevel, logrus.GetLevel())
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPut, "/debug/loglevel", strings.NewReader("loud")))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Equal(t, logrus.DebugLevel, logrus.GetLevel())
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/state", nil))
+	var state core.DebugState
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &state))
+	assert.Equal(t, map[string]bool{"sandbox": true}, state.NetworkReady)
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
+
+func TestWriteDump(t *testing.T) {
+	s := &CriDockerService{service: debugStateService{}}
+	path, err := s.writeDump(t.TempDir())
+	require.NoError(t, err)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var dump debugDump
+	require.NoError(t, json.Unmarshal(data, &dump))
+	assert.Equal(t, map[string]bool{"sandbox": true}, dump.State.NetworkReady)
+	assert.Contains(t, dump.Goroutines, "TestWriteDump")
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/component-base/metrics/legacyregistry"
+
+	"github.com/Mirantis/cri-dockerd/core"
+)
+
+// healthCheckTimeout bounds every health check, a check still running then
+// fails.
+const healthCheckTimeout = 10 * time.Second
+
+// healthChecker runs the health checks of the docker service.
+type healthChecker struct {
+	checks []core.HealthCheck
+}
+
+// checkResult is the outcome of a health check.
+type checkResult struct {
+	name string
+	err  error
+}
+
+func newHealthChecker(checks []core.HealthCheck) *healthChecker {
+	return &healthChecker{checks: checks}
+}
+
+// run runs the liveness checks, and the readiness only ones too if readiness,
+// in parallel, and returns their results in order.
+func (h *healthChecker) run(readiness bool) []checkResult {
+	var results []checkResult
+	var done []chan error
+	for _, check := range h.checks {
+		if check.ReadinessOnly && !readiness {
+			continue
+		}
+		results = append(results, checkResult{name: check.Name})
+		errCh := make(chan error, 1)
+		done = append(done, errCh)
+		go func(check core.HealthCheck) {
+			errCh <- check.Check()
+		}(check)
+	}
+
+	timeout := time.NewTimer(healthCheckTimeout)
+	defer timeout.Stop()
+	for i, errCh := range done {
+		select {
+		case results[i].err = <-errCh:
+		case <-timeout.C:
+			// The remaining checks are timed out too.
+			for j := i; j < len(done); j++ {
+				select {
+				case results[j].err = <-done[j]:
+				default:
+					results[j].err = fmt.Errorf("timed out after %v", healthCheckTimeout)
+				}
+			}
+			return results
+		}
+	}
+	return results
+}
+
+// healthy returns whether all the liveness checks pass, logging the failed
+// ones.
+func (h *healthChecker) healthy() bool {
+	healthy := true
+	for _, result := range h.run(false) {
+		if result.err != nil {
+			logrus.Warnf("Health check %s failed: %v", result.name, result.err)
+			healthy = false
+		}
+	}
+	return healthy
+}
+
+// handler serves the result of the checks, of every check with the verbose
+// query parameter.
+func (h *healthChecker) handler(name string, readiness bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var report strings.Builder
+		failed := false
+		for _, result := range h.run(readiness) {
+			if result.err != nil {
+				failed = true
+				fmt.Fprintf(&report, "[-]%s failed: %v\n", result.name, result.err)
+			} else {
+				fmt.Fprintf(&report, "[+]%s ok\n", result.name)
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if failed {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		if _, verbose := r.URL.Query()["verbose"]; verbose {
+			fmt.Fprint(w, report.String())
+			if failed {
+				fmt.Fprintf(w, "%s check failed\n", name)
+			} else {
+				fmt.Fprintf(w, "%s check passed\n", name)
+			}
+			return
+		}
+		if failed {
+			fmt.Fprintf(w, "%s check failed\n", name)
+		} else {
+			fmt.Fprint(w, "ok")
+		}
+	}
+}
+
+// ServeHealth serves the /healthz and /readyz endpoints on addr in the
+// background. /healthz fails when cri-dockerd doesn't work, /readyz when it
+// can't run pods either. The metrics of cri-dockerd are served on /metrics.
+func (s *CriDockerService) ServeHealth(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("cri-dockerd failed to listen on %q for health checks: %v", addr, err)
+	}
+	server := &http.Server{
+		Handler:           s.healthHandler(),
+		ReadHeaderTimeout: healthCheckTimeout,
+	}
+	logrus.Infof("Serving health checks on %s", l.Addr())
+	go func() {
+		if err := server.Serve(l); err != nil {
+			logrus.Errorf("Failed to serve health checks: %v", err)
+		}
+	}()
+	return nil
+}
+
+// healthHandler returns the handler of the health and metrics endpoints.
+func (s *CriDockerService) healthHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", s.health.handler("healthz", false))
+	mux.Handle("/readyz", s.health.handler("readyz", true))
+	mux.Handle("/metrics", legacyregistry.Handler())
+	return mux
+}
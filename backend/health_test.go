@@ -0,0 +1,73 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Mirantis/cri-dockerd/core"
+	"github.com/Mirantis/cri-dockerd/metrics"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+	networkErr := fmt.Errorf("cni config uninitialized")
+	health := newHealthChecker([]core.HealthCheck{
+		{Name: "docker", Check: func() error { return nil }},
+		{Name: "network", ReadinessOnly: true, Check: func() error { return networkErr }},
+	})
+
+	for _, test := range []struct {
+		name      string
+		readiness bool
+		target    string
+		status    int
+		body      string
+	}{
+		{"healthz", false, "/healthz", http.StatusOK, "ok"},
+		{"healthz", false, "/healthz?verbose", http.StatusOK, "[+]docker ok\nhealthz check passed\n"},
+		{"readyz", true, "/readyz", http.StatusInternalServerError, "readyz check failed\n"},
+		{
+			"readyz", true, "/readyz?verbose", http.StatusInternalServerError,
+			"[+]docker ok\n[-]network failed: cni config uninitialized\nreadyz check failed\n",
+		},
+	} {
+		recorder := httptest.NewRecorder()
+		health.handler(test.name, test.readiness)(recorder, httptest.NewRequest(http.MethodGet, test.target, nil))
+		assert.Equal(t, test.status, recorder.Code, test.target)
+		assert.Equal(t, test.body, recorder.Body.String(), test.target)
+	}
+
+	assert.True(t, health.healthy())
+	networkErr = nil
+	assert.NoError(t, health.run(true)[1].err)
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	metrics.Register()
+	metrics.CRIRequestsPanics.WithLabelValues("/runtime.v1.RuntimeService/Version").Inc()
+	s := &CriDockerService{health: newHealthChecker(nil)}
+
+	recorder := httptest.NewRecorder()
+	s.healthHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "kubelet_cri_requests_panics_total")
+}
@@ -0,0 +1,230 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"context"
+	"runtime/debug"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"github.com/Mirantis/cri-dockerd/metrics"
+	"github.com/Mirantis/cri-dockerd/utils/logging"
+)
+
+// Interceptor wraps the handling of gRPC requests. Either field may be nil.
+type Interceptor struct {
+	Unary  grpc.UnaryServerInterceptor
+	Stream grpc.StreamServerInterceptor
+}
+
+// defaultInterceptors returns the interceptors every request goes through,
+// outermost first. Panics are recovered innermost so that the outer
+// interceptors observe them as codes.Internal errors.
+func defaultInterceptors(requestLogLevel logrus.Level) []Interceptor {
+	return []Interceptor{
+		loggingInterceptor(requestLogLevel),
+		metricsInterceptor(),
+		recoveryInterceptor(),
+	}
+}
+
+// chainInterceptors returns the server options installing the interceptors,
+// the first one being the outermost.
+func chainInterceptors(interceptors []Interceptor) []grpc.ServerOption {
+	var unary []grpc.UnaryServerInterceptor
+	var stream []grpc.StreamServerInterceptor
+	for _, i := range interceptors {
+		if i.Unary != nil {
+			unary = append(unary, i.Unary)
+		}
+		if i.Stream != nil {
+			stream = append(stream, i.Stream)
+		}
+	}
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(unary...),
+		grpc.ChainStreamInterceptor(stream...),
+	}
+}
+
+// recoveryInterceptor turns panics in handlers into codes.Internal errors
+// instead of crashing cri-dockerd.
+func recoveryInterceptor() Interceptor {
+	recoverPanic := func(method string, err *error) {
+		if r := recover(); r != nil {
+			metrics.CRIRequestsPanics.WithLabelValues(method).Inc()
+			logrus.Errorf("Recovered from panic in %s: %v\n%s", method, r, debug.Stack())
+			*err = status.Errorf(codes.Internal, "panic in %s: %v", method, r)
+		}
+	}
+	return Interceptor{
+		Unary: func(
+			ctx context.Context,
+			req interface{},
+			info *grpc.UnaryServerInfo,
+			handler grpc.UnaryHandler,
+		) (resp interface{}, err error) {
+			defer recoverPanic(info.FullMethod, &err)
+			return handler(ctx, req)
+		},
+		Stream: func(
+			srv interface{},
+			ss grpc.ServerStream,
+			info *grpc.StreamServerInfo,
+			handler grpc.StreamHandler,
+		) (err error) {
+			defer recoverPanic(info.FullMethod, &err)
+			return handler(srv, ss)
+		},
+	}
+}
+
+// metricsInterceptor records the latency of requests by method and status
+// code.
+func metricsInterceptor() Interceptor {
+	observe := func(method string, start time.Time, err error) {
+		metrics.CRIRequestsLatency.
+			WithLabelValues(method, status.Code(err).String()).
+			Observe(metrics.SinceInSeconds(start))
+	}
+	return Interceptor{
+		Unary: func(
+			ctx context.Context,
+			req interface{},
+			info *grpc.UnaryServerInfo,
+			handler grpc.UnaryHandler,
+		) (interface{}, error) {
+			start := time.Now()
+			resp, err := handler(ctx, req)
+			observe(info.FullMethod, start, err)
+			return resp, err
+		},
+		Stream: func(
+			srv interface{},
+			ss grpc.ServerStream,
+			info *grpc.StreamServerInfo,
+			handler grpc.StreamHandler,
+		) error {
+			start := time.Now()
+			err := handler(srv, ss)
+			observe(info.FullMethod, start, err)
+			return err
+		},
+	}
+}
+
+// contextStream is a server stream with the context of the request.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// loggingInterceptor gives every request a logger with its ID, method and
+// identifiers, and logs the request with it at the given level.
+func loggingInterceptor(level logrus.Level) Interceptor {
+	withLogger := func(ctx context.Context, method string, req interface{}) context.Context {
+		fields := requestFields(req)
+		fields[logging.RequestIDKey] = uuid.NewString()
+		fields[logging.MethodKey] = method
+		if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+			fields[logging.TraceIDKey] = span.TraceID().String()
+		}
+		return logging.WithEntry(ctx, logrus.WithFields(fields))
+	}
+	log := func(ctx context.Context, start time.Time, err error) {
+		if !logrus.IsLevelEnabled(level) {
+			return
+		}
+		entry := logging.FromContext(ctx).WithFields(logrus.Fields{
+			"duration": time.Since(start).String(),
+			"code":     status.Code(err).String(),
+		})
+		if err != nil {
+			entry = entry.WithError(err)
+		}
+		entry.Log(level, "CRI request")
+	}
+	return Interceptor{
+		Unary: func(
+			ctx context.Context,
+			req interface{},
+			info *grpc.UnaryServerInfo,
+			handler grpc.UnaryHandler,
+		) (interface{}, error) {
+			start := time.Now()
+			ctx = withLogger(ctx, info.FullMethod, req)
+			resp, err := handler(ctx, req)
+			log(ctx, start, err)
+			return resp, err
+		},
+		Stream: func(
+			srv interface{},
+			ss grpc.ServerStream,
+			info *grpc.StreamServerInfo,
+			handler grpc.StreamHandler,
+		) error {
+			start := time.Now()
+			ctx := withLogger(ss.Context(), info.FullMethod, nil)
+			err := handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+			log(ctx, start, err)
+			return err
+		},
+	}
+}
+
+// requestFields extracts the identifiers of the pod, container and image a
+// CRI request applies to.
+func requestFields(req interface{}) logrus.Fields {
+	fields := logrus.Fields{}
+	if r, ok := req.(interface{ GetPodSandboxId() string }); ok && r.GetPodSandboxId() != "" {
+		fields[logging.PodSandboxIDKey] = r.GetPodSandboxId()
+	}
+	if r, ok := req.(interface{ GetContainerId() string }); ok && r.GetContainerId() != "" {
+		fields[logging.ContainerIDKey] = r.GetContainerId()
+	}
+	if r, ok := req.(interface{ GetImage() *runtimeapi.ImageSpec }); ok && r.GetImage().GetImage() != "" {
+		fields[logging.ImageKey] = r.GetImage().GetImage()
+	}
+	var metadata *runtimeapi.PodSandboxMetadata
+	switch r := req.(type) {
+	case *runtimeapi.RunPodSandboxRequest:
+		metadata = r.GetConfig().GetMetadata()
+	case *runtimeapi.CreateContainerRequest:
+		metadata = r.GetSandboxConfig().GetMetadata()
+		if name := r.GetConfig().GetMetadata().GetName(); name != "" {
+			fields[logging.ContainerKey] = name
+		}
+	case *runtimeapi.PullImageRequest:
+		metadata = r.GetSandboxConfig().GetMetadata()
+	}
+	if metadata != nil {
+		fields[logging.PodKey] = metadata.GetNamespace() + "/" + metadata.GetName()
+	}
+	return fields
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Mirantis/cri-dockerd/utils/logging"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+const testMethod = "/runtime.v1.RuntimeService/StopPodSandbox"
+
+// runUnary runs the unary interceptors around handler, the first one being
+// the outermost, as grpc.ChainUnaryInterceptor does.
+func runUnary(interceptors []Interceptor, req interface{}, handler grpc.UnaryHandler) (interface{}, error) {
+	info := &grpc.UnaryServerInfo{FullMethod: testMethod}
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i].Unary, handler
+		handler = func(ctx context.Context, req interface{}) (interface{}, error) {
+			return interceptor(ctx, req, info, next)
+		}
+	}
+	return handler(context.Background(), req)
+}
+
+func TestRecoveryInterceptor(t *testing.T) {
+	var observed error
+	observer := Interceptor{
+		Unary: func(
+			ctx context.Context,
+			req interface{},
+			info *grpc.UnaryServerInfo,
+			handler grpc.UnaryHandler,
+		) (interface{}, error) {
+			resp, err := handler(ctx, req)
+			observed = err
+			return resp, err
+		},
+	}
+	interceptors := append(defaultInterceptors(logrus.DebugLevel), observer)
+	_, err := runUnary(interceptors, &runtimeapi.StopPodSandboxRequest{}, func(context.Context, interface{}) (interface{}, error) {
+		panic("boom")
+	})
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.Contains(t, err.Error(), "boom")
+	// The added interceptor runs inside the recovery, so the panic went
+	// through it.
+	assert.Nil(t, observed)
+
+	interceptors = append([]Interceptor{observer}, defaultInterceptors(logrus.DebugLevel)...)
+	_, err = runUnary(interceptors, &runtimeapi.StopPodSandboxRequest{}, func(context.Context, interface{}) (interface{}, error) {
+		panic("boom")
+	})
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.Equal(t, err, observed)
+}
+
+func TestInterceptorsPassThrough(t *testing.T) {
+	handlerErr := status.Error(codes.NotFound, "not found")
+	resp, err := runUnary(
+		defaultInterceptors(logrus.InfoLevel),
+		&runtimeapi.StopPodSandboxRequest{PodSandboxId: "id"},
+		func(context.Context, interface{}) (interface{}, error) {
+			return &runtimeapi.StopPodSandboxResponse{}, handlerErr
+		},
+	)
+	assert.Equal(t, &runtimeapi.StopPodSandboxResponse{}, resp)
+	assert.Equal(t, handlerErr, err)
+
+	_, err = runUnary(
+		defaultInterceptors(logrus.InfoLevel),
+		&runtimeapi.StopPodSandboxRequest{PodSandboxId: "id"},
+		func(context.Context, interface{}) (interface{}, error) {
+			return nil, errors.New("plain error")
+		},
+	)
+	assert.EqualError(t, err, "plain error")
+}
+
+func TestRequestFields(t *testing.T) {
+	for _, test := range []struct {
+		req    interface{}
+		fields logrus.Fields
+	}{
+		{
+			req:    &runtimeapi.StopPodSandboxRequest{PodSandboxId: "sandbox"},
+			fields: logrus.Fields{"podSandboxID": "sandbox"},
+		},
+		{
+			req:    &runtimeapi.ExecSyncRequest{ContainerId: "container"},
+			fields: logrus.Fields{"containerID": "container"},
+		},
+		{
+			req: &runtimeapi.RunPodSandboxRequest{Config: &runtimeapi.PodSandboxConfig{
+				Metadata: &runtimeapi.PodSandboxMetadata{Name: "foo", Namespace: "bar"},
+			}},
+			fields: logrus.Fields{"pod": "bar/foo"},
+		},
+		{
+			req: &runtimeapi.CreateContainerRequest{
+				PodSandboxId: "sandbox",
+				Config: &runtimeapi.ContainerConfig{
+					Metadata: &runtimeapi.ContainerMetadata{Name: "app"},
+				},
+				SandboxConfig: &runtimeapi.PodSandboxConfig{
+					Metadata: &runtimeapi.PodSandboxMetadata{Name: "foo", Namespace: "bar"},
+				},
+			},
+			fields: logrus.Fields{"podSandboxID": "sandbox", "container": "app", "pod": "bar/foo"},
+		},
+		{
+			req:    &runtimeapi.PullImageRequest{Image: &runtimeapi.ImageSpec{Image: "busybox"}},
+			fields: logrus.Fields{"image": "busybox"},
+		},
+		{
+			req:    &runtimeapi.VersionRequest{},
+			fields: logrus.Fields{},
+		},
+	} {
+		assert.Equal(t, test.fields, requestFields(test.req))
+	}
+}
+
+func TestLoggingInterceptorContext(t *testing.T) {
+	var fields []logrus.Fields
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		fields = append(fields, logging.FromContext(ctx).Data)
+		return &runtimeapi.StopPodSandboxResponse{}, nil
+	}
+	for i := 0; i < 2; i++ {
+		_, err := runUnary(
+			defaultInterceptors(logrus.InfoLevel),
+			&runtimeapi.StopPodSandboxRequest{PodSandboxId: "sandbox"},
+			handler,
+		)
+		assert.NoError(t, err)
+	}
+	assert.Len(t, fields, 2)
+	for _, f := range fields {
+		assert.Equal(t, testMethod, f[logging.MethodKey])
+		assert.Equal(t, "sandbox", f[logging.PodSandboxIDKey])
+		assert.NotEmpty(t, f[logging.RequestIDKey])
+		assert.NotContains(t, f, logging.TraceIDKey)
+	}
+	assert.NotEqual(t, fields[0][logging.RequestIDKey], fields[1][logging.RequestIDKey])
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"k8s.io/component-base/tracing"
+)
+
+// tracerName is the name of the tracer of the CRI requests.
+const tracerName = "github.com/Mirantis/cri-dockerd/backend"
+
+// metadataCarrier reads and writes the trace context in gRPC metadata.
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if values := metadata.MD(c).Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// tracingInterceptor starts a span for every request, as a child of the span
+// of the caller propagated in the request metadata.
+func tracingInterceptor(tp trace.TracerProvider) Interceptor {
+	tracer := tp.Tracer(tracerName)
+	start := func(ctx context.Context, method string, req interface{}) (context.Context, trace.Span) {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			ctx = tracing.Propagators().Extract(ctx, metadataCarrier(md))
+		}
+		service, name, _ := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+		attributes := []attribute.KeyValue{
+			attribute.String("rpc.system", "grpc"),
+			attribute.String("rpc.service", service),
+			attribute.String("rpc.method", name),
+		}
+		for key, value := range requestFields(req) {
+			attributes = append(attributes, attribute.String(key, fmt.Sprint(value)))
+		}
+		return tracer.Start(ctx, method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(attributes...),
+		)
+	}
+	end := func(span trace.Span, err error) {
+		code := status.Code(err)
+		span.SetAttributes(attribute.Int64("rpc.grpc.status_code", int64(code)))
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(otelcodes.Error, err.Error())
+		}
+		span.End()
+	}
+	return Interceptor{
+		Unary: func(
+			ctx context.Context,
+			req interface{},
+			info *grpc.UnaryServerInfo,
+			handler grpc.UnaryHandler,
+		) (interface{}, error) {
+			ctx, span := start(ctx, info.FullMethod, req)
+			resp, err := handler(ctx, req)
+			end(span, err)
+			return resp, err
+		},
+		Stream: func(
+			srv interface{},
+			ss grpc.ServerStream,
+			info *grpc.StreamServerInfo,
+			handler grpc.StreamHandler,
+		) error {
+			ctx, span := start(ss.Context(), info.FullMethod, nil)
+			err := handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+			end(span, err)
+			return err
+		},
+	}
+}
+
+// EnableTracing traces every request with tp, outside of the other
+// interceptors so that the span covers them. It must be called before Start.
+func (s *CriDockerService) EnableTracing(tp trace.TracerProvider) {
+	s.interceptors = append([]Interceptor{tracingInterceptor(tp)}, s.interceptors...)
+}
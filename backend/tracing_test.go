@@ -0,0 +1,91 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"github.com/Mirantis/cri-dockerd/libdocker"
+)
+
+func TestTracingInterceptor(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	defer otel.SetTracerProvider(previous)
+
+	client := libdocker.NewInstrumentedInterface(libdocker.NewFakeDockerClient())
+	// The span context of kubelet, sampled.
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		"traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	))
+	handlerErr := status.Error(codes.NotFound, "not found")
+	_, err := tracingInterceptor(tp).Unary(
+		ctx,
+		&runtimeapi.StopPodSandboxRequest{PodSandboxId: "id"},
+		&grpc.UnaryServerInfo{FullMethod: testMethod},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, libdocker.WithContext(ctx, client).StopContainer("id", 0)
+		},
+	)
+	require.NoError(t, err)
+	// Operations outside of requests aren't traced.
+	require.NoError(t, client.StopContainer("id", 0))
+	_, err = tracingInterceptor(tp).Unary(
+		context.Background(),
+		&runtimeapi.StopPodSandboxRequest{},
+		&grpc.UnaryServerInfo{FullMethod: testMethod},
+		func(context.Context, interface{}) (interface{}, error) {
+			return nil, handlerErr
+		},
+	)
+	assert.Equal(t, handlerErr, err)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+	docker, request, failed := spans[0], spans[1], spans[2]
+
+	assert.Equal(t, "docker.stop_container", docker.Name())
+	assert.Equal(t, request.SpanContext().SpanID(), docker.Parent().SpanID())
+
+	assert.Equal(t, testMethod, request.Name())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", request.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", request.Parent().SpanID().String())
+	assert.Contains(t, request.Attributes(), attribute.String("rpc.method", "StopPodSandbox"))
+	assert.Contains(t, request.Attributes(), attribute.String("podSandboxID", "id"))
+	assert.Contains(t, request.Attributes(), attribute.Int64("rpc.grpc.status_code", int64(codes.OK)))
+	assert.Equal(t, otelcodes.Unset, request.Status().Code)
+
+	assert.False(t, failed.Parent().IsValid())
+	assert.Contains(t, failed.Attributes(), attribute.Int64("rpc.grpc.status_code", int64(codes.NotFound)))
+	assert.Equal(t, otelcodes.Error, failed.Status().Code)
+}
@@ -34,6 +34,37 @@ type DockerCRIFlags struct {
 	config.ContainerRuntimeOptions
 	// remoteRuntimeEndpoint is the endpoint of backend runtime service
 	RemoteRuntimeEndpoint string
+	// RequestLogLevel is the log level at which every CRI request is logged.
+	RequestLogLevel string
+	// HealthBindAddr is the address to serve the health endpoints on, none if
+	// empty.
+	HealthBindAddr string
+	// TracingEndpoint is the OTLP gRPC endpoint to export the traces to,
+	// tracing is disabled if empty.
+	TracingEndpoint string
+	// TracingSamplingRatePerMillion is the number of requests sampled per
+	// million when kubelet didn't sample them.
+	TracingSamplingRatePerMillion int32
+	// AuditLogPath is the file the audit events are written to, none are if
+	// empty.
+	AuditLogPath string
+	// AuditLogMaxSize is the size in megabytes the audit log is rotated at.
+	AuditLogMaxSize int
+	// AuditLogMaxBackups is the number of rotated audit logs kept.
+	AuditLogMaxBackups int
+	// AuditLogMaxAge is the number of days rotated audit logs are kept.
+	AuditLogMaxAge int
+	// AuditPolicy is the audit level of each audited operation.
+	AuditPolicy string
+	// AuditLogHMACKeyFile is the file holding the key the chain of the audit
+	// events is signed with.
+	AuditLogHMACKeyFile string
+	// DebugSocket is the unix socket to serve the debug endpoints on, none
+	// if empty.
+	DebugSocket string
+	// DebugDumpDir is the directory the state is dumped to on SIGUSR1, the
+	// signal is not handled if empty.
+	DebugDumpDir string
 	// nonMasqueradeCIDR configures masquerading: traffic to IPs outside this range will use IP masquerade.
 	NonMasqueradeCIDR string
 }
@@ -51,6 +82,10 @@ func NewDockerCRIFlags() *DockerCRIFlags {
 		ContainerRuntimeOptions: *NewContainerRuntimeOptions(),
 		NonMasqueradeCIDR:       "10.0.0.0/8",
 		RemoteRuntimeEndpoint:   remoteRuntimeEndpoint,
+		RequestLogLevel:         "debug",
+		AuditLogMaxSize:         100,
+		AuditLogMaxBackups:      10,
+		AuditPolicy:             "metadata",
 	}
 }
 
@@ -87,6 +122,78 @@ func (f *DockerCRIFlags) AddFlags(mainfs *pflag.FlagSet) {
 		f.RemoteRuntimeEndpoint,
 		"The endpoint of backend runtime service. Currently unix socket and tcp endpoints are supported on Linux, while npipe and tcp endpoints are supported on windows.  Examples:'unix:///var/run/cri-dockerd.sock', 'npipe:////./pipe/cri-dockerd'",
 	)
+	fs.StringVar(
+		&f.RequestLogLevel,
+		"request-log-level",
+		f.RequestLogLevel,
+		"The log level at which every CRI request is logged, with its method, duration, status code and the pod, container and image it applies to (panic, fatal, error, warn, info, debug, trace).",
+	)
+	fs.StringVar(
+		&f.HealthBindAddr,
+		"health-bind-addr",
+		f.HealthBindAddr,
+		"The address to serve the /healthz and /readyz endpoints and the Prometheus /metrics endpoint on, e.g. 127.0.0.1:9560. Add the verbose query parameter to /healthz and /readyz for the result of every check. If not specified, the endpoints are not served.",
+	)
+	fs.StringVar(
+		&f.TracingEndpoint,
+		"tracing-endpoint",
+		f.TracingEndpoint,
+		"The OTLP gRPC endpoint to export the traces of CRI requests, docker operations and CNI plugin invocations to, e.g. localhost:4317. If not specified, tracing is disabled.",
+	)
+	fs.Int32Var(
+		&f.TracingSamplingRatePerMillion,
+		"tracing-sampling-rate-per-million",
+		f.TracingSamplingRatePerMillion,
+		"The number of CRI requests to trace per million, besides the ones traced by kubelet.",
+	)
+	fs.StringVar(
+		&f.AuditLogPath,
+		"audit-log-path",
+		f.AuditLogPath,
+		"The file to write the audit events of CreateContainer, Exec, ExecSync, Attach, PortForward, RemoveImage and PullImage requests to, as JSON lines. If not specified, the requests are not audited.",
+	)
+	fs.IntVar(
+		&f.AuditLogMaxSize,
+		"audit-log-maxsize",
+		f.AuditLogMaxSize,
+		"The size in megabytes the audit log is rotated at.",
+	)
+	fs.IntVar(
+		&f.AuditLogMaxBackups,
+		"audit-log-maxbackup",
+		f.AuditLogMaxBackups,
+		"The number of rotated audit logs to keep, 0 to keep them all.",
+	)
+	fs.IntVar(
+		&f.AuditLogMaxAge,
+		"audit-log-maxage",
+		f.AuditLogMaxAge,
+		"The number of days to keep rotated audit logs, 0 to keep them forever.",
+	)
+	fs.StringVar(
+		&f.AuditPolicy,
+		"audit-policy",
+		f.AuditPolicy,
+		"The audit level of the operations, one of none, metadata or request, with per operation overrides, e.g. metadata,ExecSync=request,PullImage=none. ExecSync, which the kubelet runs the exec probes through, is only audited when listed. Environment variable values and registry credentials are never audited.",
+	)
+	fs.StringVar(
+		&f.AuditLogHMACKeyFile,
+		"audit-log-hmac-key-file",
+		f.AuditLogHMACKeyFile,
+		"The file holding the key to sign the hash chain of the audit events with, as HMAC-SHA256. If not specified, the chain is a plain SHA-256 that only detects tampering when its last hash is kept off the node.",
+	)
+	fs.StringVar(
+		&f.DebugSocket,
+		"debug-socket",
+		f.DebugSocket,
+		"The unix socket to serve pprof under /debug/pprof/, the log level under /debug/loglevel (PUT to change it) and the internal state under /debug/state on. If not specified, the debug endpoints are not served.",
+	)
+	fs.StringVar(
+		&f.DebugDumpDir,
+		"debug-dump-dir",
+		f.DebugDumpDir,
+		"The directory to write the internal state and the goroutine stacks to on SIGUSR1. The dumps hold the pod metadata, so the directory should only be readable by root. If not specified, SIGUSR1 is not handled.",
+	)
 }
 
 const (
@@ -120,10 +227,20 @@ func NewContainerRuntimeOptions() *config.ContainerRuntimeOptions {
 		PodSandboxImage:           defaultPodSandboxImage,
 		ImagePullProgressDeadline: metav1.Duration{Duration: 1 * time.Minute},
 		NetworkPluginName:         "cni",
+		SandboxGCPeriod:           metav1.Duration{Duration: 1 * time.Minute},
+		SandboxGCGracePeriod:      metav1.Duration{Duration: 5 * time.Minute},
 
 		CNIBinDir:   cniBinDir,
 		CNIConfDir:  cniConfDir,
 		CNICacheDir: "/var/lib/cni/cache",
+		CNIGCPeriod: metav1.Duration{Duration: 10 * time.Minute},
+
+		SandboxNetworkCheckPeriod: metav1.Duration{Duration: 30 * time.Second},
+
+		SessionRecordingMaxSize:      100,
+		SessionRecordingMaxTotalSize: 10240,
+
+		ContainerIndexResyncPeriod: metav1.Duration{Duration: 1 * time.Minute},
 	}
 
 	if runtime.GOOS == "windows" {
@@ -17,6 +17,7 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"runtime"
 
@@ -25,7 +26,9 @@ import (
 	"github.com/Mirantis/cri-dockerd/cmd/version"
 	"github.com/Mirantis/cri-dockerd/config"
 	"github.com/Mirantis/cri-dockerd/core"
+	"github.com/Mirantis/cri-dockerd/imagepolicy"
 	"github.com/Mirantis/cri-dockerd/streaming"
+	"github.com/Mirantis/cri-dockerd/utils/logging"
 	"github.com/sirupsen/logrus"
 
 	"net"
@@ -34,7 +37,13 @@ import (
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"k8s.io/component-base/tracing"
+	tracingapi "k8s.io/component-base/tracing/api/v1"
 	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+	"k8s.io/kubernetes/pkg/credentialprovider/plugin"
 )
 
 const (
@@ -110,6 +119,18 @@ func NewDockerCRICommand(stopCh <-chan struct{}) *cobra.Command {
 				}
 				logrus.SetLevel(level)
 			}
+			logFormat, _ := cleanFlagSet.GetString("log-format")
+			logFile, _ := cleanFlagSet.GetString("log-file")
+			logFileMaxSize, _ := cleanFlagSet.GetInt("log-file-max-size")
+			logFileMaxBackups, _ := cleanFlagSet.GetInt("log-file-max-backups")
+			if err := logging.Configure(logging.Options{
+				Format:     logFormat,
+				File:       logFile,
+				MaxSize:    logFileMaxSize,
+				MaxBackups: logFileMaxBackups,
+			}); err != nil {
+				logrus.Fatal(err)
+			}
 
 			if err := RunCriDockerd(kubeletFlags, stopCh); err != nil {
 				logrus.Fatal(err)
@@ -123,6 +144,10 @@ func NewDockerCRICommand(stopCh <-chan struct{}) *cobra.Command {
 	cleanFlagSet.Bool("version", false, "Prints the version of cri-dockerd")
 	cleanFlagSet.Bool("buildinfo", false, "Prints the build information about cri-dockerd")
 	cleanFlagSet.String("log-level", "info", "The log level for cri-docker (panic, fatal, error, warn, info, debug, trace). Note: 'debug' and 'trace' levels enable Docker API logging")
+	cleanFlagSet.String("log-format", logging.FormatText, "The log format for cri-docker (text, json). The json format writes an object per line, with the request ID, the method and the pod, sandbox and container IDs of the CRI request it was written for")
+	cleanFlagSet.String("log-file", "", "The file to write the logs to besides stderr. If not specified, the logs are only written to stderr")
+	cleanFlagSet.Int("log-file-max-size", 100, "The size in megabytes the log file is rotated at")
+	cleanFlagSet.Int("log-file-max-backups", 5, "The number of rotated log files to keep, 0 to keep them all")
 
 	// ugly, but necessary, because Cobra's default UsageFunc and HelpFunc pollute the flagset with global flags
 	const usageFmt = "Usage:\n  %s\n\nFlags:\n%s"
@@ -161,12 +186,58 @@ func RunCriDockerd(f *options.DockerCRIFlags, stopCh <-chan struct{}) error {
 		PluginConfDir:      f.CNIConfDir,
 		PluginBinDirString: f.CNIBinDir,
 		PluginCacheDir:     f.CNICacheDir,
+		GCPeriod:           f.CNIGCPeriod.Duration,
+		SandboxCheckPeriod: f.SandboxNetworkCheckPeriod.Duration,
 		MTU:                int(f.NetworkPluginMTU),
 		NonMasqueradeCIDR:  f.NonMasqueradeCIDR,
 	}
 
 	config.IPv6DualStackEnabled = f.IPv6DualStackEnabled
 
+	if r.ImageCredentialProviderConfigFile != "" {
+		if err := plugin.RegisterCredentialProviderPlugins(
+			r.ImageCredentialProviderConfigFile,
+			r.ImageCredentialProviderBinDir,
+		); err != nil {
+			return fmt.Errorf("failed to register credential provider plugins: %v", err)
+		}
+	}
+
+	sandboxGCSettings := config.SandboxGCSettings{
+		Period:      r.SandboxGCPeriod.Duration,
+		GracePeriod: r.SandboxGCGracePeriod.Duration,
+		DryRun:      r.SandboxGCDryRun,
+	}
+
+	var runtimeHandlers *config.RuntimeHandlersConfig
+	if r.RuntimeHandlerConfigFile != "" {
+		var err error
+		runtimeHandlers, err = config.LoadRuntimeHandlersConfig(r.RuntimeHandlerConfigFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	var imagePolicy *imagepolicy.Verifier
+	if r.ImageSignaturePolicyFile != "" {
+		policy, err := imagepolicy.LoadPolicy(r.ImageSignaturePolicyFile)
+		if err != nil {
+			return err
+		}
+		if imagePolicy, err = imagepolicy.NewVerifier(policy); err != nil {
+			return fmt.Errorf("invalid image signature policy %q: %v", r.ImageSignaturePolicyFile, err)
+		}
+	}
+
+	var registryMirrors *config.RegistryMirrorsConfig
+	if r.RegistryMirrorsConfigFile != "" {
+		var err error
+		registryMirrors, err = config.LoadRegistryMirrorsConfig(r.RegistryMirrorsConfigFile)
+		if err != nil {
+			return err
+		}
+	}
+
 	var resolvedAddr string
 	if r.StreamingBindAddr != "" {
 		// See whether a port was specified as part of the declaration
@@ -191,6 +262,25 @@ func RunCriDockerd(f *options.DockerCRIFlags, stopCh <-chan struct{}) error {
 		StreamCreationTimeout:           streaming.DefaultConfig.StreamCreationTimeout,
 		SupportedRemoteCommandProtocols: streaming.DefaultConfig.SupportedRemoteCommandProtocols,
 		SupportedPortForwardProtocols:   streaming.DefaultConfig.SupportedPortForwardProtocols,
+		MaxSessionsPerContainer:         r.StreamingMaxSessionsPerContainer,
+		MaxSessions:                     r.StreamingMaxSessions,
+	}
+
+	var sessionRecorder *streaming.Recorder
+	if r.SessionRecordingDir != "" {
+		sink, err := streaming.NewDirectorySink(
+			r.SessionRecordingDir,
+			r.SessionRecordingMaxAge.Duration,
+			int64(r.SessionRecordingMaxTotalSize)*1024*1024,
+		)
+		if err != nil {
+			return err
+		}
+		sessionRecorder = streaming.NewRecorder(
+			sink,
+			int64(r.SessionRecordingMaxSize)*1024*1024,
+			r.SessionRecordingNamespaces,
+		)
 	}
 
 	// Standalone cri-dockerd will always start the local streaming backend.
@@ -198,10 +288,19 @@ func RunCriDockerd(f *options.DockerCRIFlags, stopCh <-chan struct{}) error {
 		dockerClientConfig,
 		r.PodSandboxImage,
 		streamingConfig,
+		sessionRecorder,
 		&pluginSettings,
 		f.RuntimeCgroups,
 		r.CgroupDriver,
 		r.CriDockerdRootDirectory,
+		&sandboxGCSettings,
+		r.ContainerIndexResyncPeriod.Duration,
+		runtimeHandlers,
+		imagePolicy,
+		registryMirrors,
+		r.MaxParallelImagePulls,
+		r.PinnedImages,
+		r.ContainerdRootDirectory,
 	)
 	if err != nil {
 		return err
@@ -217,12 +316,83 @@ func RunCriDockerd(f *options.DockerCRIFlags, stopCh <-chan struct{}) error {
 		return err
 	}
 
+	requestLogLevel, err := logrus.ParseLevel(f.RequestLogLevel)
+	if err != nil {
+		return fmt.Errorf("unknown request log level %q: %v", f.RequestLogLevel, err)
+	}
+
 	logrus.Info("Starting the GRPC backend for the Docker CRI interface.")
-	server := backend.NewCriDockerServer(f.RemoteRuntimeEndpoint, ds)
+	server := backend.NewCriDockerServer(f.RemoteRuntimeEndpoint, ds, requestLogLevel)
+	if f.AuditLogPath != "" {
+		policy, err := backend.ParseAuditPolicy(f.AuditPolicy)
+		if err != nil {
+			return fmt.Errorf("invalid audit policy %q: %v", f.AuditPolicy, err)
+		}
+		if err := server.EnableAudit(backend.AuditLogOptions{
+			Path:        f.AuditLogPath,
+			MaxSize:     f.AuditLogMaxSize,
+			MaxBackups:  f.AuditLogMaxBackups,
+			MaxAge:      f.AuditLogMaxAge,
+			Policy:      policy,
+			HMACKeyFile: f.AuditLogHMACKeyFile,
+		}); err != nil {
+			return err
+		}
+		logrus.Infof("Writing audit events to %s", f.AuditLogPath)
+	}
+	if f.TracingEndpoint != "" {
+		tp, err := newTracerProvider(f.TracingEndpoint, f.TracingSamplingRatePerMillion)
+		if err != nil {
+			return fmt.Errorf("failed to set up tracing: %v", err)
+		}
+		defer func() {
+			if err := tp.Shutdown(context.Background()); err != nil {
+				logrus.Errorf("Failed to flush the traces: %v", err)
+			}
+		}()
+		otel.SetTracerProvider(tp)
+		otel.SetTextMapPropagator(tracing.Propagators())
+		server.EnableTracing(tp)
+		logrus.Infof("Exporting traces to %s", f.TracingEndpoint)
+	}
 	if err := server.Start(); err != nil {
 		return err
 	}
+	if f.DebugDumpDir != "" {
+		if err := server.HandleDumpSignal(f.DebugDumpDir); err != nil {
+			return err
+		}
+	}
+	if f.DebugSocket != "" {
+		if err := server.ServeDebug(f.DebugSocket); err != nil {
+			return err
+		}
+	}
+	if f.HealthBindAddr != "" {
+		if err := server.ServeHealth(f.HealthBindAddr); err != nil {
+			return err
+		}
+	}
 
 	<-stopCh
 	return nil
 }
+
+// newTracerProvider returns the tracer provider exporting the traces to the
+// OTLP gRPC endpoint.
+func newTracerProvider(endpoint string, samplingRatePerMillion int32) (tracing.TracerProvider, error) {
+	return tracing.NewProvider(
+		context.Background(),
+		&tracingapi.TracingConfiguration{
+			Endpoint:               &endpoint,
+			SamplingRatePerMillion: &samplingRatePerMillion,
+		},
+		nil,
+		[]resource.Option{
+			resource.WithAttributes(
+				semconv.ServiceName(componentDockerCRI),
+				semconv.ServiceVersion(version.Version),
+			),
+		},
+	)
+}
@@ -40,10 +40,35 @@ type ContainerRuntimeOptions struct {
 	PodSandboxImage string
 	// DockerEndpoint is the path to the docker endpoint to communicate with.
 	DockerEndpoint string
+	// ContainerdRootDirectory is the root directory of the containerd docker
+	// stores images in with the containerd image store. Detected if unset.
+	ContainerdRootDirectory string
+	// RuntimeHandlerConfigFile is the path to the file configuring the runtime
+	// handlers RuntimeClasses refer to.
+	RuntimeHandlerConfigFile string
 	// If no pulling progress is made before the deadline imagePullProgressDeadline,
 	// the image pulling will be cancelled. Defaults to 1m0s.
 	// +optional
 	ImagePullProgressDeadline v1.Duration
+	// MaxParallelImagePulls is the maximum number of images pulled in
+	// parallel, the others being queued. No limit if not positive.
+	MaxParallelImagePulls int
+	// PinnedImages are the images always reported as pinned, so that the
+	// kubelet never garbage collects them.
+	PinnedImages []string
+	// ImageCredentialProviderConfigFile is the path to the
+	// CredentialProviderConfig file naming the exec plugins that provide
+	// credentials to pull images, as for the kubelet.
+	ImageCredentialProviderConfigFile string
+	// ImageCredentialProviderBinDir is the directory containing the binaries
+	// of the credential provider plugins.
+	ImageCredentialProviderBinDir string
+	// ImageSignaturePolicyFile is the path to the policy.json file the
+	// signatures of the pulled images are verified against.
+	ImageSignaturePolicyFile string
+	// RegistryMirrorsConfigFile is the path to the file rewriting the names
+	// of the pulled images to pull them from mirrors.
+	RegistryMirrorsConfigFile string
 	// runtimeRequestTimeout is the timeout for all runtime requests except long-running
 	// requests - pull, logs, exec and attach.
 	RuntimeRequestTimeout v1.Duration
@@ -54,6 +79,40 @@ type ContainerRuntimeOptions struct {
 	// StreamingBindAddr is the address to bind the CRI streaming server to.
 	// If not specified, it will bind to all addresses
 	StreamingBindAddr string
+	// StreamingMaxSessionsPerContainer is the maximum number of concurrent
+	// streaming sessions per container. No limit if not positive.
+	StreamingMaxSessionsPerContainer int
+	// StreamingMaxSessions is the maximum number of concurrent streaming
+	// sessions. No limit if not positive.
+	StreamingMaxSessions int
+
+	// SessionRecordingDir is the directory the exec and attach sessions are
+	// recorded to, none are if empty.
+	SessionRecordingDir string
+	// SessionRecordingNamespaces are the namespaces the sessions of all the
+	// pods of are recorded, besides the pods annotated to be.
+	SessionRecordingNamespaces []string
+	// SessionRecordingMaxSize is the size in megabytes a recording is
+	// truncated at.
+	SessionRecordingMaxSize int
+	// SessionRecordingMaxTotalSize is the size in megabytes over which the
+	// oldest recordings are removed.
+	SessionRecordingMaxTotalSize int
+	// SessionRecordingMaxAge is how long the recordings are kept.
+	SessionRecordingMaxAge v1.Duration
+
+	// SandboxGCPeriod is the interval between two reconciliations of sandbox
+	// checkpoints with sandbox containers. Set to 0 to disable.
+	SandboxGCPeriod v1.Duration
+	// SandboxGCGracePeriod is how long a sandbox must stay out of sync with
+	// its checkpoint before it is cleaned up.
+	SandboxGCGracePeriod v1.Duration
+	// SandboxGCDryRun only logs the actions of the sandbox garbage collector.
+	SandboxGCDryRun bool
+	// ContainerIndexResyncPeriod is the interval between two full resyncs of
+	// the container index answering the list requests. Set to 0 to disable the
+	// index.
+	ContainerIndexResyncPeriod v1.Duration
 
 	// Network plugin options.
 
@@ -78,6 +137,13 @@ type ContainerRuntimeOptions struct {
 	// CNICacheDir is the full path of the directory in which CNI should store
 	// cache files
 	CNICacheDir string
+	// CNIGCPeriod is the interval between two garbage collections of the
+	// resources held by the CNI plugins. Set to 0 to disable.
+	CNIGCPeriod v1.Duration
+	// SandboxNetworkCheckPeriod is the interval between two checks for
+	// sandboxes restarted by docker, whose network has to be set up again.
+	// Set to 0 to disable.
+	SandboxNetworkCheckPeriod v1.Duration
 	// HairpinMode is the mode used to allow endpoints of a Service to load
 	// balance back to themselves if they should try to access their own Service
 	HairpinMode HairpinMode
@@ -112,12 +178,60 @@ func (s *ContainerRuntimeOptions) AddFlags(fs *pflag.FlagSet) {
 		s.DockerEndpoint,
 		"Use this for the docker endpoint to communicate with.",
 	)
+	fs.StringVar(
+		&s.ContainerdRootDirectory,
+		"containerd-root-directory",
+		s.ContainerdRootDirectory,
+		"The root directory of the containerd instance Docker stores images in when it uses the containerd image store, to report the image filesystem. Detected from the containerd address if unset.",
+	)
+	fs.StringVar(
+		&s.RuntimeHandlerConfigFile,
+		"runtime-handler-config",
+		s.RuntimeHandlerConfigFile,
+		"The path to the file configuring the runtime handlers RuntimeClasses refer to: docker runtime, sandbox image, security options, default seccomp profile, privileged mode and annotations passed to the runtime.",
+	)
 	fs.DurationVar(
 		&s.ImagePullProgressDeadline.Duration,
 		"image-pull-progress-deadline",
 		s.ImagePullProgressDeadline.Duration,
 		"If no pulling progress is made before this deadline, the image pulling will be cancelled.",
 	)
+	fs.IntVar(
+		&s.MaxParallelImagePulls,
+		"max-parallel-image-pulls",
+		s.MaxParallelImagePulls,
+		"The maximum number of images pulled in parallel. The other pulls are queued, the sandbox image and the images of kube-system pods first. No limit if 0.",
+	)
+	fs.StringSliceVar(
+		&s.PinnedImages,
+		"pinned-images",
+		s.PinnedImages,
+		"The images always reported as pinned, so that the kubelet never garbage collects them: image references, pinning all the tags of the repository when untagged, or name prefixes ending with \"*\", e.g. busybox* or registry.example.com/agents/*. The sandbox images are always pinned. RemoveImage refuses to remove pinned images unless the image spec has annotation cri-dockerd.mirantis.com/force-remove=true.",
+	)
+	fs.StringVar(
+		&s.ImageCredentialProviderConfigFile,
+		"image-credential-provider-config",
+		s.ImageCredentialProviderConfigFile,
+		"The path to the credential provider plugin config file, as for the kubelet. The plugins it names are used to get the credentials to pull the sandbox image.",
+	)
+	fs.StringVar(
+		&s.ImageCredentialProviderBinDir,
+		"image-credential-provider-bin-dir",
+		s.ImageCredentialProviderBinDir,
+		"The path to the directory where credential provider plugin binaries are located.",
+	)
+	fs.StringVar(
+		&s.ImageSignaturePolicyFile,
+		"image-signature-policy",
+		s.ImageSignaturePolicyFile,
+		"The path to the policy.json file, in the format of containers/image, that the signatures of the pulled images are verified against. Supports sigstore (cosign) and simple signing signatures. Signatures are not verified if unset.",
+	)
+	fs.StringVar(
+		&s.RegistryMirrorsConfigFile,
+		"registry-mirrors-config",
+		s.RegistryMirrorsConfigFile,
+		"The path to the file mapping registry hosts or repository prefixes to the mirrors the images are pulled from, in order. The images keep being reported under their original names.",
+	)
 	fs.DurationVar(
 		&s.RuntimeRequestTimeout.Duration,
 		"runtime-request-timeout",
@@ -131,6 +245,72 @@ func (s *ContainerRuntimeOptions) AddFlags(fs *pflag.FlagSet) {
 		s.StreamingBindAddr,
 		"The address to bind the CRI streaming server to. If not specified, it will bind to all addresses.",
 	)
+	fs.IntVar(
+		&s.StreamingMaxSessionsPerContainer,
+		"streaming-max-sessions-per-container",
+		s.StreamingMaxSessionsPerContainer,
+		"The maximum number of concurrent exec and attach sessions per container, and of port forward sessions per pod. Sessions over the limit are rejected with a ResourceExhausted error. No limit if 0.",
+	)
+	fs.IntVar(
+		&s.StreamingMaxSessions,
+		"streaming-max-sessions",
+		s.StreamingMaxSessions,
+		"The maximum number of concurrent exec, attach and port forward sessions on the node. Sessions over the limit are rejected with a ResourceExhausted error. No limit if 0.",
+	)
+	fs.StringVar(
+		&s.SessionRecordingDir,
+		"session-recording-dir",
+		s.SessionRecordingDir,
+		"The directory to record the exec and attach sessions to, as asciicast v2 files. Only the sessions of the pods in the --session-recording-namespaces and of the pods with annotation cri-dockerd.mirantis.com/record-sessions=true are recorded. If not specified, no session is recorded.",
+	)
+	fs.StringSliceVar(
+		&s.SessionRecordingNamespaces,
+		"session-recording-namespaces",
+		s.SessionRecordingNamespaces,
+		"The namespaces to record the exec and attach sessions of all the pods of.",
+	)
+	fs.IntVar(
+		&s.SessionRecordingMaxSize,
+		"session-recording-max-size",
+		s.SessionRecordingMaxSize,
+		"The size in megabytes a session recording is truncated at. No limit if 0.",
+	)
+	fs.IntVar(
+		&s.SessionRecordingMaxTotalSize,
+		"session-recording-max-total-size",
+		s.SessionRecordingMaxTotalSize,
+		"The total size in megabytes of the session recordings over which the oldest ones are removed. No limit if 0.",
+	)
+	fs.DurationVar(
+		&s.SessionRecordingMaxAge.Duration,
+		"session-recording-max-age",
+		s.SessionRecordingMaxAge.Duration,
+		"How long to keep the session recordings. They are kept forever if 0.",
+	)
+	fs.DurationVar(
+		&s.SandboxGCPeriod.Duration,
+		"sandbox-gc-period",
+		s.SandboxGCPeriod.Duration,
+		"The interval between reconciliations of sandbox checkpoints with sandbox containers. Set to 0 to disable.",
+	)
+	fs.DurationVar(
+		&s.SandboxGCGracePeriod.Duration,
+		"sandbox-gc-grace-period",
+		s.SandboxGCGracePeriod.Duration,
+		"How long a sandbox checkpoint without container, or a sandbox container with a corrupt checkpoint, is kept before being removed.",
+	)
+	fs.BoolVar(
+		&s.SandboxGCDryRun,
+		"sandbox-gc-dry-run",
+		s.SandboxGCDryRun,
+		"Only log the actions of the sandbox garbage collector, without removing anything.",
+	)
+	fs.DurationVar(
+		&s.ContainerIndexResyncPeriod.Duration,
+		"container-index-resync-period",
+		s.ContainerIndexResyncPeriod.Duration,
+		"The interval between full resyncs of the in-memory container index, kept current from docker events, that answers the list requests. Set to 0 to disable the index and always ask docker.",
+	)
 	// Network plugin settings for Docker.
 	fs.StringVar(
 		&s.PodCIDR,
@@ -168,6 +348,18 @@ func (s *ContainerRuntimeOptions) AddFlags(fs *pflag.FlagSet) {
 		s.CNICacheDir,
 		"The full path of the directory in which CNI should store cache files.",
 	)
+	fs.DurationVar(
+		&s.CNIGCPeriod.Duration,
+		"cni-gc-period",
+		s.CNIGCPeriod.Duration,
+		"The interval between garbage collections of CNI resources left behind by removed sandboxes. Only used with CNI config version 1.1.0 or later. Set to 0 to disable.",
+	)
+	fs.DurationVar(
+		&s.SandboxNetworkCheckPeriod.Duration,
+		"sandbox-network-check-period",
+		s.SandboxNetworkCheckPeriod.Duration,
+		"The interval between checks for sandboxes restarted by docker, e.g. after a docker restart. The network of a restarted sandbox is set up again from its checkpoint, or the sandbox is reported as not ready. Set to 0 to disable.",
+	)
 	fs.Int32Var(
 		&s.NetworkPluginMTU,
 		"network-plugin-mtu",
@@ -0,0 +1,162 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/distribution/reference"
+	"sigs.k8s.io/yaml"
+)
+
+// RegistryMirrorsConfig is the content of the registry mirrors config file.
+// It rewrites the names of the pulled images to pull them from mirrors.
+//
+// Example:
+//
+//	rewrites:
+//	- prefix: docker.io
+//	  mirrors: ["mirror.example.com/dockerhub"]
+//	- prefix: registry.k8s.io/pause
+//	  mirrors: ["mirror.example.com/k8s/pause", "backup.example.com/pause"]
+//	  skipOriginal: true
+type RegistryMirrorsConfig struct {
+	// Rewrites are the rewrite rules, the one with the longest matching
+	// prefix applying to an image.
+	Rewrites []ImageRewrite `json:"rewrites"`
+}
+
+// ImageRewrite maps a registry host or repository prefix to mirrors.
+type ImageRewrite struct {
+	// Prefix is a registry host, e.g. "docker.io", or a repository prefix,
+	// e.g. "docker.io/library", of the normalized image names.
+	Prefix string `json:"prefix"`
+	// Mirrors replace the prefix in the image names. They are tried in order.
+	Mirrors []string `json:"mirrors"`
+	// SkipOriginal doesn't fall back to the original image when the image
+	// can't be pulled from any mirror.
+	SkipOriginal bool `json:"skipOriginal,omitempty"`
+}
+
+// rewrite returns the mirror image names of the normalized image name, nil
+// if the prefix doesn't match it.
+func (r *ImageRewrite) rewrite(name string) []string {
+	rest, ok := strings.CutPrefix(name, r.Prefix)
+	if !ok || (rest != "" && rest[0] != '/' && rest[0] != ':' && rest[0] != '@') {
+		return nil
+	}
+	images := make([]string, 0, len(r.Mirrors))
+	for _, mirror := range r.Mirrors {
+		images = append(images, mirror+rest)
+	}
+	return images
+}
+
+// rewriteFor returns the rule with the longest prefix matching the image.
+func (c *RegistryMirrorsConfig) rewriteFor(name string) *ImageRewrite {
+	if c == nil {
+		return nil
+	}
+	var match *ImageRewrite
+	for i := range c.Rewrites {
+		r := &c.Rewrites[i]
+		if r.rewrite(name) != nil && (match == nil || len(r.Prefix) > len(match.Prefix)) {
+			match = r
+		}
+	}
+	return match
+}
+
+// PullCandidates returns the names to pull the image from, in order: the
+// mirrors of the matching rule, then the image itself unless the rule skips
+// it. It is the image alone if no rule matches or c is nil.
+func (c *RegistryMirrorsConfig) PullCandidates(image string) []string {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return []string{image}
+	}
+	name := reference.TagNameOnly(named).String()
+	r := c.rewriteFor(name)
+	if r == nil {
+		return []string{image}
+	}
+	candidates := r.rewrite(name)
+	if !r.SkipOriginal {
+		candidates = append(candidates, image)
+	}
+	return candidates
+}
+
+// OriginalName returns the name a mirror image was pulled for, the
+// normalized name itself if it doesn't come from a mirror.
+func (c *RegistryMirrorsConfig) OriginalName(name string) string {
+	if c == nil {
+		return name
+	}
+	for _, r := range c.Rewrites {
+		for _, mirror := range r.Mirrors {
+			rest, ok := strings.CutPrefix(name, mirror)
+			if ok && (rest == "" || rest[0] == '/' || rest[0] == ':' || rest[0] == '@') {
+				return r.Prefix + rest
+			}
+		}
+	}
+	return name
+}
+
+// LoadRegistryMirrorsConfig reads and validates the registry mirrors config
+// file, in YAML or JSON.
+func LoadRegistryMirrorsConfig(file string) (*RegistryMirrorsConfig, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read registry mirrors config %q: %v", file, err)
+	}
+	c := &RegistryMirrorsConfig{}
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return nil, fmt.Errorf("failed to parse registry mirrors config %q: %v", file, err)
+	}
+	for _, r := range c.Rewrites {
+		if err := r.validate(); err != nil {
+			return nil, fmt.Errorf("invalid rewrite of %q in %q: %v", r.Prefix, file, err)
+		}
+	}
+	return c, nil
+}
+
+func (r *ImageRewrite) validate() error {
+	if _, err := reference.ParseNamed(r.Prefix); err != nil && !isRegistryHost(r.Prefix) {
+		return fmt.Errorf("prefix must be a registry host or a normalized repository name: %v", err)
+	}
+	if len(r.Mirrors) == 0 {
+		return fmt.Errorf("no mirror")
+	}
+	for _, mirror := range r.Mirrors {
+		if _, err := reference.ParseNamed(mirror); err != nil && !isRegistryHost(mirror) {
+			return fmt.Errorf("invalid mirror %q: %v", mirror, err)
+		}
+	}
+	return nil
+}
+
+// isRegistryHost returns whether s is a registry host, e.g. "docker.io" or
+// "localhost:5000", which aren't valid repository names alone.
+func isRegistryHost(s string) bool {
+	_, err := reference.ParseNamed(s + "/repository")
+	return err == nil && !strings.Contains(s, "/") && (strings.ContainsAny(s, ".:") || s == "localhost")
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// RuntimeHandlersConfig is the content of the runtime handler config file.
+// It configures the runtime handlers RuntimeClasses refer to.
+//
+// Example:
+//
+//	handlers:
+//	  kata:
+//	    runtime: kata-runtime
+//	    sandboxImage: registry.k8s.io/pause:3.10
+//	    allowPrivileged: false
+//	    defaultSeccompProfile: runtime/default
+//	    podAnnotations: ["io.katacontainers.*"]
+type RuntimeHandlersConfig struct {
+	// Handlers maps the runtime handler names to their configuration.
+	Handlers map[string]RuntimeHandlerConfig `json:"handlers"`
+}
+
+// RuntimeHandlerConfig is the configuration of a runtime handler.
+type RuntimeHandlerConfig struct {
+	// Runtime is the name of the docker runtime the handler uses. Defaults to
+	// the name of the handler.
+	Runtime string `json:"runtime,omitempty"`
+	// SandboxImage overrides the sandbox image for the pods of the handler.
+	SandboxImage string `json:"sandboxImage,omitempty"`
+	// SecurityOpts are added to the docker security options of the sandboxes
+	// and containers of the handler, e.g. "label=disable".
+	SecurityOpts []string `json:"securityOpts,omitempty"`
+	// DefaultSeccompProfile is the seccomp profile of the containers that
+	// don't ask for a confined one: "unconfined", "runtime/default" or
+	// "localhost/<absolute path>". The kubelet asks for Unconfined when the
+	// pod sets no seccomp profile, so an explicit Unconfined can't be told
+	// apart from none and is overridden by this profile too: workloads which
+	// must run unconfined need a handler without a default profile.
+	DefaultSeccompProfile string `json:"defaultSeccompProfile,omitempty"`
+	// AllowPrivileged allows privileged pods and containers. Defaults to true.
+	AllowPrivileged *bool `json:"allowPrivileged,omitempty"`
+	// PodAnnotations are the pod annotations passed to the runtime, for the
+	// sandbox and all containers of the pod. A trailing "*" matches any
+	// suffix.
+	PodAnnotations []string `json:"podAnnotations,omitempty"`
+	// ContainerAnnotations are the container annotations passed to the
+	// runtime, matched as PodAnnotations.
+	ContainerAnnotations []string `json:"containerAnnotations,omitempty"`
+	// Features overrides the features reported for the handler.
+	Features *RuntimeHandlerFeatures `json:"features,omitempty"`
+}
+
+// RuntimeHandlerFeatures are the features reported to the kubelet for a
+// runtime handler.
+type RuntimeHandlerFeatures struct {
+	RecursiveReadOnlyMounts *bool `json:"recursiveReadOnlyMounts,omitempty"`
+	UserNamespaces          *bool `json:"userNamespaces,omitempty"`
+}
+
+// GetHandlers returns the configured handlers, none if c is nil.
+func (c *RuntimeHandlersConfig) GetHandlers() map[string]RuntimeHandlerConfig {
+	if c == nil {
+		return nil
+	}
+	return c.Handlers
+}
+
+// Get returns the configuration of the handler, nil if it isn't configured.
+func (c *RuntimeHandlersConfig) Get(handler string) *RuntimeHandlerConfig {
+	h, ok := c.GetHandlers()[handler]
+	if !ok {
+		return nil
+	}
+	return &h
+}
+
+// DockerRuntime returns the name of the docker runtime of the handler.
+func (c *RuntimeHandlerConfig) DockerRuntime(handler string) string {
+	if c.Runtime != "" {
+		return c.Runtime
+	}
+	return handler
+}
+
+// PrivilegedAllowed returns whether the handler runs privileged pods and
+// containers.
+func (c *RuntimeHandlerConfig) PrivilegedAllowed() bool {
+	return c.AllowPrivileged == nil || *c.AllowPrivileged
+}
+
+// FilterAnnotations returns the annotations matching the patterns, a
+// trailing "*" in a pattern matching any suffix.
+func FilterAnnotations(annotations map[string]string, patterns []string) map[string]string {
+	filtered := make(map[string]string)
+	for k, v := range annotations {
+		for _, pattern := range patterns {
+			if prefix, ok := strings.CutSuffix(pattern, "*"); (ok && strings.HasPrefix(k, prefix)) || k == pattern {
+				filtered[k] = v
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// LoadRuntimeHandlersConfig reads and validates the runtime handler config
+// file, in YAML or JSON.
+func LoadRuntimeHandlersConfig(file string) (*RuntimeHandlersConfig, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read runtime handler config %q: %v", file, err)
+	}
+	c := &RuntimeHandlersConfig{}
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return nil, fmt.Errorf("failed to parse runtime handler config %q: %v", file, err)
+	}
+	for name, handler := range c.Handlers {
+		if err := handler.validate(); err != nil {
+			return nil, fmt.Errorf("invalid runtime handler %q in %q: %v", name, file, err)
+		}
+	}
+	return c, nil
+}
+
+func (c *RuntimeHandlerConfig) validate() error {
+	switch profile := c.DefaultSeccompProfile; {
+	case profile == "", profile == "unconfined", profile == "runtime/default":
+	case strings.HasPrefix(profile, "localhost/"):
+		if !filepath.IsAbs(strings.TrimPrefix(profile, "localhost/")) {
+			return fmt.Errorf("seccomp profile path must be absolute, but got %q", profile)
+		}
+	default:
+		return fmt.Errorf("unknown default seccomp profile %q", profile)
+	}
+	return nil
+}
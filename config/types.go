@@ -49,10 +49,30 @@ type NetworkPluginSettings struct {
 	PluginConfDir string
 	// PluginCacheDir is the directory in which CNI should store cache files.
 	PluginCacheDir string
+	// GCPeriod is the interval between two garbage collections of network
+	// resources. Zero disables garbage collection.
+	GCPeriod time.Duration
+	// SandboxCheckPeriod is the interval between two checks for sandbox
+	// containers restarted by docker, whose network has to be set up again.
+	// Zero disables the check.
+	SandboxCheckPeriod time.Duration
 	// MTU is the desired MTU for network devices created by the plugin.
 	MTU int
 }
 
+// SandboxGCSettings configures the reconciliation of sandbox checkpoints with
+// sandbox containers.
+type SandboxGCSettings struct {
+	// Period is the interval between two reconciliations. Zero disables the
+	// garbage collector.
+	Period time.Duration
+	// GracePeriod is how long a sandbox must stay out of sync with its
+	// checkpoint before it is cleaned up.
+	GracePeriod time.Duration
+	// DryRun only logs the actions the garbage collector would take.
+	DryRun bool
+}
+
 // enableIPv6DualStack allows dual-homed pods
 var IPv6DualStackEnabled bool
 
@@ -0,0 +1,455 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/opencontainers/runc/libcontainer/cgroups"
+	"github.com/sirupsen/logrus"
+	basemetrics "k8s.io/component-base/metrics"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"github.com/Mirantis/cri-dockerd/libdocker"
+	"github.com/Mirantis/cri-dockerd/metrics"
+)
+
+const (
+	// defaultCgroupRoot is where the cgroup hierarchies are mounted.
+	defaultCgroupRoot = "/sys/fs/cgroup"
+	// cgroupV1UnlimitedMemory is above the memory limits cgroup v1 reports
+	// for unlimited cgroups, which depend on the page size.
+	cgroupV1UnlimitedMemory = 1 << 62
+)
+
+// pressureResources are the resources the pressure stall information of
+// cgroup v2 is read for.
+var pressureResources = []string{"cpu", "memory", "io"}
+
+// cgroupStats are the stats of a container read from its cgroup.
+type cgroupStats struct {
+	cpuUsageNanoSeconds uint64
+
+	memoryUsage     uint64
+	workingSet      uint64
+	rss             uint64
+	pageFaults      uint64
+	majorPageFaults uint64
+	// memoryLimit is 0 for unlimited memory.
+	memoryLimit uint64
+
+	hasSwap   bool
+	swapUsage uint64
+	// swapLimit is 0 for unlimited swap.
+	swapLimit uint64
+}
+
+// pressureStall is the cumulative time in microseconds some or all of the
+// tasks of a cgroup stalled on a resource.
+type pressureStall struct {
+	some uint64
+	full uint64
+	// hasFull is false for the resources without full stalls, as cpu on old
+	// kernels.
+	hasFull bool
+}
+
+// cgroupStatsReader reads the stats of the containers from their cgroups,
+// which are looked up from the cgroup parent of the containers once.
+type cgroupStatsReader struct {
+	client       libdocker.DockerClientInterface
+	cgroupDriver string
+	// root is the cgroup v2 mount, or the directory of the cgroup v1
+	// hierarchies.
+	root    string
+	unified bool
+
+	lock sync.Mutex
+	// paths are the cgroup paths of the containers, relative to the
+	// hierarchy roots.
+	paths map[string]string
+}
+
+func newCgroupStatsReader(client libdocker.DockerClientInterface, cgroupDriver string) *cgroupStatsReader {
+	return &cgroupStatsReader{
+		client:       client,
+		cgroupDriver: cgroupDriver,
+		root:         defaultCgroupRoot,
+		unified:      cgroups.IsCgroup2UnifiedMode(),
+		paths:        make(map[string]string),
+	}
+}
+
+// initCgroupStats reads the container stats from the cgroups when they are
+// mounted.
+func (ds *dockerService) initCgroupStats() {
+	if _, err := os.Stat(defaultCgroupRoot); err != nil {
+		logrus.Infof("Reading container stats from docker, cgroups are not available: %v", err)
+		return
+	}
+	ds.cgroupStats = newCgroupStatsReader(ds.client, ds.cgroupDriver)
+	metrics.RegisterContainerPressure(&pressureCollector{reader: ds.cgroupStats})
+}
+
+// containerCgroupPath returns the cgroup path docker creates for the
+// container with the cgroup parent.
+func containerCgroupPath(cgroupDriver, cgroupParent, containerID string) (string, error) {
+	if cgroupDriver != "systemd" {
+		if cgroupParent == "" {
+			cgroupParent = "/docker"
+		}
+		return path.Join("/", cgroupParent, containerID), nil
+	}
+	if cgroupParent == "" {
+		cgroupParent = "system.slice"
+	}
+	slice, err := expandSlice(cgroupParent)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(slice, "docker-"+containerID+".scope"), nil
+}
+
+// expandSlice returns the cgroup path of the systemd slice, e.g.
+// "/kubepods.slice/kubepods-besteffort.slice" for "kubepods-besteffort.slice".
+func expandSlice(slice string) (string, error) {
+	name, ok := strings.CutSuffix(slice, ".slice")
+	if !ok || strings.Contains(name, "/") {
+		return "", fmt.Errorf("invalid systemd slice %q", slice)
+	}
+	if name == "-" {
+		return "/", nil
+	}
+	var expanded, prefix string
+	for _, component := range strings.Split(name, "-") {
+		if component == "" {
+			return "", fmt.Errorf("invalid systemd slice %q", slice)
+		}
+		expanded += "/" + prefix + component + ".slice"
+		prefix += component + "-"
+	}
+	return expanded, nil
+}
+
+// cgroupPath returns the cgroup path of the container.
+func (r *cgroupStatsReader) cgroupPath(containerID string) (string, error) {
+	r.lock.Lock()
+	cgroupPath, ok := r.paths[containerID]
+	r.lock.Unlock()
+	if ok {
+		return cgroupPath, nil
+	}
+
+	containerJSON, err := r.client.InspectContainer(containerID)
+	if err != nil {
+		return "", err
+	}
+	var cgroupParent string
+	if containerJSON.HostConfig != nil {
+		cgroupParent = containerJSON.HostConfig.CgroupParent
+	}
+	cgroupPath, err = containerCgroupPath(r.cgroupDriver, cgroupParent, containerID)
+	if err != nil {
+		return "", err
+	}
+	r.lock.Lock()
+	r.paths[containerID] = cgroupPath
+	r.lock.Unlock()
+	return cgroupPath, nil
+}
+
+// forget drops the cgroup path of the container, which is gone.
+func (r *cgroupStatsReader) forget(containerID string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.paths, containerID)
+}
+
+// prune forgets the cgroup paths of the containers not in containerIDs,
+// which are gone.
+func (r *cgroupStatsReader) prune(containerIDs map[string]bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for containerID := range r.paths {
+		if !containerIDs[containerID] {
+			delete(r.paths, containerID)
+		}
+	}
+}
+
+// containerPaths returns the cgroup paths of the containers whose stats were
+// read.
+func (r *cgroupStatsReader) containerPaths() map[string]string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	paths := make(map[string]string, len(r.paths))
+	for containerID, cgroupPath := range r.paths {
+		paths[containerID] = cgroupPath
+	}
+	return paths
+}
+
+// pruneCgroupStats forgets the cgroup paths of the containers which are not
+// in containers, the list of all the containers.
+func (ds *dockerService) pruneCgroupStats(containers []*runtimeapi.Container) {
+	if ds.cgroupStats == nil {
+		return
+	}
+	containerIDs := make(map[string]bool, len(containers))
+	for _, c := range containers {
+		containerIDs[c.Id] = true
+	}
+	ds.cgroupStats.prune(containerIDs)
+}
+
+// cgroupDir returns the directory of the cgroup, in the memory hierarchy on
+// cgroup v1.
+func (r *cgroupStatsReader) cgroupDir(cgroupPath string) string {
+	if r.unified {
+		return filepath.Join(r.root, cgroupPath)
+	}
+	return filepath.Join(r.root, "memory", cgroupPath)
+}
+
+// read returns the stats of the container.
+func (r *cgroupStatsReader) read(containerID string) (*cgroupStats, error) {
+	cgroupPath, err := r.cgroupPath(containerID)
+	if err != nil {
+		return nil, err
+	}
+	var stats *cgroupStats
+	if r.unified {
+		stats, err = readCgroupV2Stats(filepath.Join(r.root, cgroupPath))
+	} else {
+		stats, err = readCgroupV1Stats(r.root, cgroupPath)
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		// The container stopped, or docker put it somewhere else.
+		r.forget(containerID)
+	}
+	return stats, err
+}
+
+func readCgroupV2Stats(dir string) (*cgroupStats, error) {
+	cpuStat, err := readKeyValues(filepath.Join(dir, "cpu.stat"))
+	if err != nil {
+		return nil, err
+	}
+	memoryStat, err := readKeyValues(filepath.Join(dir, "memory.stat"))
+	if err != nil {
+		return nil, err
+	}
+	stats := &cgroupStats{
+		cpuUsageNanoSeconds: cpuStat["usage_usec"] * 1000,
+		rss:                 memoryStat["anon"],
+		pageFaults:          memoryStat["pgfault"],
+		majorPageFaults:     memoryStat["pgmajfault"],
+	}
+	if stats.memoryUsage, err = readUint(filepath.Join(dir, "memory.current")); err != nil {
+		return nil, err
+	}
+	stats.workingSet = workingSet(stats.memoryUsage, memoryStat["inactive_file"])
+	if stats.memoryLimit, err = readUint(filepath.Join(dir, "memory.max")); err != nil {
+		return nil, err
+	}
+	// Swap accounting may be disabled.
+	if swapUsage, err := readUint(filepath.Join(dir, "memory.swap.current")); err == nil {
+		stats.hasSwap = true
+		stats.swapUsage = swapUsage
+		stats.swapLimit, _ = readUint(filepath.Join(dir, "memory.swap.max"))
+	}
+	return stats, nil
+}
+
+func readCgroupV1Stats(root, cgroupPath string) (*cgroupStats, error) {
+	cpuDir := filepath.Join(root, "cpuacct", cgroupPath)
+	memoryDir := filepath.Join(root, "memory", cgroupPath)
+
+	stats := &cgroupStats{}
+	var err error
+	if stats.cpuUsageNanoSeconds, err = readUint(filepath.Join(cpuDir, "cpuacct.usage")); err != nil {
+		return nil, err
+	}
+	memoryStat, err := readKeyValues(filepath.Join(memoryDir, "memory.stat"))
+	if err != nil {
+		return nil, err
+	}
+	stats.rss = memoryStat["total_rss"]
+	stats.pageFaults = memoryStat["total_pgfault"]
+	stats.majorPageFaults = memoryStat["total_pgmajfault"]
+	if stats.memoryUsage, err = readUint(filepath.Join(memoryDir, "memory.usage_in_bytes")); err != nil {
+		return nil, err
+	}
+	stats.workingSet = workingSet(stats.memoryUsage, memoryStat["total_inactive_file"])
+	if stats.memoryLimit, err = readUint(filepath.Join(memoryDir, "memory.limit_in_bytes")); err != nil {
+		return nil, err
+	}
+	if stats.memoryLimit >= cgroupV1UnlimitedMemory {
+		stats.memoryLimit = 0
+	}
+	// The memsw files only exist with swap accounting, and count memory and
+	// swap together.
+	if memsw, err := readUint(filepath.Join(memoryDir, "memory.memsw.usage_in_bytes")); err == nil {
+		stats.hasSwap = true
+		if memsw > stats.memoryUsage {
+			stats.swapUsage = memsw - stats.memoryUsage
+		}
+		memswLimit, _ := readUint(filepath.Join(memoryDir, "memory.memsw.limit_in_bytes"))
+		if memswLimit < cgroupV1UnlimitedMemory && memswLimit > stats.memoryLimit {
+			stats.swapLimit = memswLimit - stats.memoryLimit
+		}
+	}
+	return stats, nil
+}
+
+// workingSet returns the working set the way cAdvisor computes it: the usage
+// minus the inactive file pages, which the kernel reclaims first.
+func workingSet(usage, inactiveFile uint64) uint64 {
+	if inactiveFile > usage {
+		return 0
+	}
+	return usage - inactiveFile
+}
+
+// readUint reads a file holding a single unsigned number, 0 for "max".
+func readUint(file string) (uint64, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "max" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %s: %v", file, err)
+	}
+	return n, nil
+}
+
+// readKeyValues reads a file of "key value" lines, as memory.stat.
+func readKeyValues(file string) (map[string]uint64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(map[string]uint64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		if n, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			values[fields[0]] = n
+		}
+	}
+	return values, scanner.Err()
+}
+
+// readPressure reads a cgroup v2 pressure file, as cpu.pressure:
+//
+//	some avg10=0.00 avg60=0.00 avg300=0.00 total=1234
+//	full avg10=0.00 avg60=0.00 avg300=0.00 total=567
+func readPressure(file string) (*pressureStall, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stall := &pressureStall{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		var total uint64
+		for _, field := range fields[1:] {
+			if value, ok := strings.CutPrefix(field, "total="); ok {
+				if total, err = strconv.ParseUint(value, 10, 64); err != nil {
+					return nil, fmt.Errorf("failed to parse %s: %v", file, err)
+				}
+			}
+		}
+		switch fields[0] {
+		case "some":
+			stall.some = total
+		case "full":
+			stall.full = total
+			stall.hasFull = true
+		}
+	}
+	return stall, scanner.Err()
+}
+
+// pressureCollector reports the pressure stall information of the
+// containers whose stats were read, which the CRI stats don't carry, as the
+// kubelet_container_pressure_stalled_seconds_total metric served on /metrics
+// with the health endpoints.
+type pressureCollector struct {
+	basemetrics.BaseStableCollector
+
+	reader *cgroupStatsReader
+}
+
+var _ basemetrics.StableCollector = &pressureCollector{}
+
+func (c *pressureCollector) DescribeWithStability(ch chan<- *basemetrics.Desc) {
+	ch <- metrics.ContainerPressure
+}
+
+func (c *pressureCollector) CollectWithStability(ch chan<- basemetrics.Metric) {
+	// cgroup v1 has no pressure stall information.
+	if !c.reader.unified {
+		return
+	}
+	for containerID, cgroupPath := range c.reader.containerPaths() {
+		for _, resource := range pressureResources {
+			stall, err := readPressure(filepath.Join(c.reader.root, cgroupPath, resource+".pressure"))
+			if errors.Is(err, fs.ErrNotExist) {
+				// The kernel has no PSI, or the container is gone.
+				continue
+			} else if err != nil {
+				logrus.Debugf("Failed to read %s pressure of container %s: %v", resource, containerID, err)
+				continue
+			}
+			ch <- basemetrics.NewLazyConstMetric(metrics.ContainerPressure, basemetrics.CounterValue,
+				float64(stall.some)/1e6, containerID, resource, "some")
+			if stall.hasFull {
+				ch <- basemetrics.NewLazyConstMetric(metrics.ContainerPressure, basemetrics.CounterValue,
+					float64(stall.full)/1e6, containerID, resource, "full")
+			}
+		}
+	}
+}
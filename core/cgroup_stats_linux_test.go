@@ -0,0 +1,185 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"github.com/Mirantis/cri-dockerd/libdocker"
+)
+
+func writeCgroupFiles(t *testing.T, dir string, files map[string]string) {
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+}
+
+func TestContainerCgroupPath(t *testing.T) {
+	for _, test := range []struct {
+		driver, parent, expected string
+	}{
+		{"cgroupfs", "", "/docker/c1"},
+		{"cgroupfs", "/kubepods/burstable/pod1", "/kubepods/burstable/pod1/c1"},
+		{"systemd", "", "/system.slice/docker-c1.scope"},
+		{
+			"systemd",
+			"kubepods-burstable-pod1.slice",
+			"/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod1.slice/docker-c1.scope",
+		},
+		{"systemd", "-.slice", "/docker-c1.scope"},
+	} {
+		cgroupPath, err := containerCgroupPath(test.driver, test.parent, "c1")
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, cgroupPath)
+	}
+	for _, parent := range []string{"kubepods", "kubepods--pod1.slice", "/kubepods.slice/pod1.slice"} {
+		_, err := containerCgroupPath("systemd", parent, "c1")
+		assert.Error(t, err, parent)
+	}
+}
+
+func TestReadCgroupV2Stats(t *testing.T) {
+	dir := t.TempDir()
+	writeCgroupFiles(t, dir, map[string]string{
+		"cpu.stat":            "usage_usec 1500\nuser_usec 1000\nsystem_usec 500\n",
+		"memory.current":      "10000\n",
+		"memory.max":          "max\n",
+		"memory.stat":         "anon 6000\nfile 4000\ninactive_file 3000\npgfault 70\npgmajfault 2\n",
+		"memory.swap.current": "100\n",
+		"memory.swap.max":     "1000\n",
+		"memory.pressure":     "some avg10=0.00 avg60=0.00 avg300=0.00 total=2500000\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=1000000\n",
+	})
+
+	stats, err := readCgroupV2Stats(dir)
+	require.NoError(t, err)
+	assert.Equal(t, &cgroupStats{
+		cpuUsageNanoSeconds: 1500000,
+		memoryUsage:         10000,
+		workingSet:          7000,
+		rss:                 6000,
+		pageFaults:          70,
+		majorPageFaults:     2,
+		hasSwap:             true,
+		swapUsage:           100,
+		swapLimit:           1000,
+	}, stats)
+
+	stall, err := readPressure(filepath.Join(dir, "memory.pressure"))
+	require.NoError(t, err)
+	assert.Equal(t, &pressureStall{some: 2500000, full: 1000000, hasFull: true}, stall)
+}
+
+func TestReadCgroupV1Stats(t *testing.T) {
+	root := t.TempDir()
+	writeCgroupFiles(t, filepath.Join(root, "cpuacct", "docker", "c1"), map[string]string{
+		"cpuacct.usage": "123456789\n",
+	})
+	writeCgroupFiles(t, filepath.Join(root, "memory", "docker", "c1"), map[string]string{
+		"memory.usage_in_bytes": "10000\n",
+		"memory.limit_in_bytes": "9223372036854771712\n",
+		"memory.stat":           "cache 4000\nrss 6000\ntotal_rss 6000\ntotal_inactive_file 12000\ntotal_pgfault 70\ntotal_pgmajfault 2\n",
+	})
+
+	stats, err := readCgroupV1Stats(root, "/docker/c1")
+	require.NoError(t, err)
+	assert.Equal(t, &cgroupStats{
+		cpuUsageNanoSeconds: 123456789,
+		memoryUsage:         10000,
+		workingSet:          0,
+		rss:                 6000,
+		pageFaults:          70,
+		majorPageFaults:     2,
+	}, stats)
+}
+
+func TestGetContainerStatsFromCgroup(t *testing.T) {
+	ds, fakeDocker, _ := newTestDockerService()
+	fakeDocker.SetFakeContainers([]*libdocker.FakeContainer{{
+		ID:         "c1",
+		Name:       "k8s_c1",
+		Running:    true,
+		HostConfig: &container.HostConfig{Resources: container.Resources{CgroupParent: "/kubepods/pod1"}},
+	}})
+	fakeDocker.InjectContainerStats(map[string]*container.StatsResponse{"c1": {}})
+	root := t.TempDir()
+	ds.cgroupStats = newCgroupStatsReader(fakeDocker, "cgroupfs")
+	ds.cgroupStats.root = root
+	ds.cgroupStats.unified = true
+	dir := filepath.Join(root, "kubepods", "pod1", "c1")
+	writeCgroupFiles(t, dir, map[string]string{
+		"cpu.stat":       "usage_usec 1500\n",
+		"memory.current": "10000\n",
+		"memory.max":     "20000\n",
+		"memory.stat":    "anon 6000\ninactive_file 3000\n",
+	})
+	running := &runtimeapi.Container{Id: "c1", State: runtimeapi.ContainerState_CONTAINER_RUNNING}
+
+	stats, err := ds.getContainerStats(getTestCTX(), running)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1500000), stats.Cpu.UsageCoreNanoSeconds.Value)
+	assert.Equal(t, uint64(7000), stats.Memory.WorkingSetBytes.Value)
+	assert.Equal(t, uint64(13000), stats.Memory.AvailableBytes.Value)
+	assert.Nil(t, stats.Swap)
+	assert.NoError(t, fakeDocker.AssertCalls([]string{"inspect_container"}))
+
+	// Docker is asked when the cgroup can't be read.
+	fakeDocker.ClearCalls()
+	require.NoError(t, os.RemoveAll(dir))
+	stats, err = ds.getContainerStats(getTestCTX(), running)
+	require.NoError(t, err)
+	assert.NotNil(t, stats.Cpu)
+	assert.NoError(t, fakeDocker.AssertCalls([]string{"get_container_stats"}))
+	assert.Empty(t, ds.cgroupStats.paths)
+}
+
+func TestListContainerStatsPrunesCgroupPaths(t *testing.T) {
+	ds, fakeDocker, _ := newTestDockerService()
+	fakeDocker.SetFakeContainers([]*libdocker.FakeContainer{{
+		ID:     "c1",
+		Name:   "k8s_c1_pod1_ns1_uid1_0",
+		Config: &container.Config{Labels: map[string]string{containerTypeLabelKey: containerTypeLabelContainer}},
+	}})
+	fakeDocker.InjectContainerStats(map[string]*container.StatsResponse{"c1": {}})
+	ds.cgroupStats = newCgroupStatsReader(fakeDocker, "cgroupfs")
+	ds.cgroupStats.root = t.TempDir()
+	ds.cgroupStats.paths["c1"] = "/docker/c1"
+	ds.cgroupStats.paths["c2"] = "/docker/c2"
+
+	// A filtered list doesn't tell which containers are gone.
+	_, err := ds.ListContainerStats(getTestCTX(), &runtimeapi.ListContainerStatsRequest{
+		Filter: &runtimeapi.ContainerStatsFilter{Id: "c1"},
+	})
+	require.NoError(t, err)
+	assert.Len(t, ds.cgroupStats.paths, 2)
+
+	_, err = ds.ListContainerStats(getTestCTX(), &runtimeapi.ListContainerStatsRequest{
+		Filter: &runtimeapi.ContainerStatsFilter{},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"c1": "/docker/c1"}, ds.cgroupStats.paths)
+}
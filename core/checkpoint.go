@@ -30,6 +30,10 @@ const (
 	protocolUDP          = config.Protocol("udp")
 	protocolSCTP         = config.Protocol("sctp")
 	schemaVersion        = "v1"
+	// stateSchemaVersion is the version of the CheckpointState schema. The
+	// schema version of the checkpoint itself stays schemaVersion so that
+	// older releases still read the checkpoints.
+	stateSchemaVersion = "v2"
 )
 
 // Checkpoint provides the process checkpoint data
@@ -43,14 +47,69 @@ type Checkpoint interface {
 type ContainerCheckpoint interface {
 	Checkpoint
 	GetData() (string, string, string, []*config.PortMapping, bool)
+	GetState() *CheckpointState
 }
 
-// CheckpointData contains all types of data that can be stored in the checkpoint.
+// CheckpointData contains the data known when the sandbox is created. The
+// checksum of the checkpoint is calculated on it alone, as by the releases
+// which don't know about the sandbox state, so it must be left unchanged for
+// them to read the checkpoints.
 type CheckpointData struct {
 	PortMappings []*config.PortMapping `json:"port_mappings,omitempty"`
 	HostNetwork  bool                  `json:"host_network,omitempty"`
 }
 
+// CheckpointState contains the state of the sandbox, stored so that it
+// survives restarts of cri-dockerd. It is empty for the v1 checkpoints written
+// by releases which don't know about it, and ignored by them.
+type CheckpointState struct {
+	// RuntimeHandler is the runtime handler the sandbox was created with.
+	RuntimeHandler string `json:"runtime_handler,omitempty"`
+	// DNSConfig is the DNS configuration of the sandbox.
+	DNSConfig *CheckpointDNSConfig `json:"dns_config,omitempty"`
+	// NetworkReady is whether the pod network is set up, nil if unknown.
+	NetworkReady *bool `json:"network_ready,omitempty"`
+	// CNIConfigName is the name of the network the pod was attached to.
+	CNIConfigName string `json:"cni_config_name,omitempty"`
+	// Interfaces are the names of the pod network interfaces.
+	Interfaces []string `json:"interfaces,omitempty"`
+	// IPs are the pod IPs, the primary IP first.
+	IPs []string `json:"ips,omitempty"`
+	// Pid and StartedAt identify the sandbox container process whose network
+	// namespace the network was set up in.
+	Pid       int    `json:"pid,omitempty"`
+	StartedAt string `json:"started_at,omitempty"`
+}
+
+// CheckpointDNSConfig is the DNS configuration of a sandbox.
+type CheckpointDNSConfig struct {
+	Servers  []string `json:"servers,omitempty"`
+	Searches []string `json:"searches,omitempty"`
+	Options  []string `json:"options,omitempty"`
+}
+
+// normalize replaces empty lists with nil, as they are omitted from the json
+// object and would otherwise not have the same checksum once read back.
+func (s *CheckpointState) normalize() {
+	if len(s.Interfaces) == 0 {
+		s.Interfaces = nil
+	}
+	if len(s.IPs) == 0 {
+		s.IPs = nil
+	}
+	if s.DNSConfig != nil {
+		if len(s.DNSConfig.Servers) == 0 {
+			s.DNSConfig.Servers = nil
+		}
+		if len(s.DNSConfig.Searches) == 0 {
+			s.DNSConfig.Searches = nil
+		}
+		if len(s.DNSConfig.Options) == 0 {
+			s.DNSConfig.Options = nil
+		}
+	}
+}
+
 // PodSandboxCheckpoint is the checkpoint structure for a sandbox
 type PodSandboxCheckpoint struct {
 	// Version of the pod sandbox checkpoint schema.
@@ -61,38 +120,96 @@ type PodSandboxCheckpoint struct {
 	Namespace string `json:"namespace"`
 	// Data to checkpoint for pod sandbox.
 	Data *CheckpointData `json:"data,omitempty"`
+	// State of the pod sandbox.
+	State *CheckpointState `json:"state,omitempty"`
 	// Checksum is calculated with fnv hash of the checkpoint object with checksum field set to be zero
 	Checksum store.Checksum `json:"checksum"`
+	// StateVersion is the version of the State schema, empty for v1
+	// checkpoints.
+	StateVersion string `json:"state_version,omitempty"`
+	// StateChecksum is calculated with fnv hash of State, separately from
+	// Checksum so that older releases can still verify the checkpoint.
+	StateChecksum store.Checksum `json:"state_checksum,omitempty"`
 }
 
 // NewPodSandboxCheckpoint inits a PodSandboxCheckpoint with the given args
 func NewPodSandboxCheckpoint(namespace, name string, data *CheckpointData) ContainerCheckpoint {
 	return &PodSandboxCheckpoint{
-		Version:   schemaVersion,
-		Namespace: namespace,
-		Name:      name,
-		Data:      data,
+		Version:      schemaVersion,
+		Namespace:    namespace,
+		Name:         name,
+		Data:         data,
+		State:        &CheckpointState{},
+		StateVersion: stateSchemaVersion,
 	}
 }
 
 // MarshalCheckpoint encodes the PodSandboxCheckpoint instance to a json object
 func (cp *PodSandboxCheckpoint) MarshalCheckpoint() ([]byte, error) {
 	cp.Checksum = store.NewChecksum(*cp.Data)
+	cp.StateVersion = ""
+	cp.StateChecksum = 0
+	if cp.State != nil {
+		cp.State.normalize()
+		cp.StateVersion = stateSchemaVersion
+		cp.StateChecksum = store.NewChecksum(*cp.State)
+	}
 	return json.Marshal(*cp)
 }
 
-// UnmarshalCheckpoint decodes the blob data to the PodSandboxCheckpoint instance
+// UnmarshalCheckpoint decodes the blob data to the PodSandboxCheckpoint
+// instance. A state whose schema version isn't the current one is dropped: it
+// may have fields this release doesn't know about, which would make its
+// checksum fail.
 func (cp *PodSandboxCheckpoint) UnmarshalCheckpoint(blob []byte) error {
-	return json.Unmarshal(blob, cp)
+	cp.State = nil
+	cp.StateVersion = ""
+	cp.StateChecksum = 0
+	if err := json.Unmarshal(blob, cp); err != nil {
+		return err
+	}
+	if cp.Data == nil {
+		cp.Data = &CheckpointData{}
+	}
+	if cp.StateVersion != stateSchemaVersion {
+		cp.State = nil
+		cp.StateChecksum = 0
+	}
+	return nil
 }
 
 // VerifyChecksum verifies whether the PodSandboxCheckpoint's data checksum is
-// the same as calculated checksum
+// the same as calculated checksum, and its state checksum if it has a state
+// of the current schema version.
 func (cp *PodSandboxCheckpoint) VerifyChecksum() error {
-	return cp.Checksum.Verify(*cp.Data)
+	if err := cp.Checksum.Verify(*cp.Data); err != nil {
+		return err
+	}
+	if cp.State == nil {
+		return nil
+	}
+	return cp.StateChecksum.Verify(*cp.State)
 }
 
 // GetData gets the PodSandboxCheckpoint's version and some net information
 func (cp *PodSandboxCheckpoint) GetData() (string, string, string, []*config.PortMapping, bool) {
 	return cp.Version, cp.Name, cp.Namespace, cp.Data.PortMappings, cp.Data.HostNetwork
 }
+
+// GetState gets the PodSandboxCheckpoint's sandbox state, migrated to the
+// current state schema version.
+func (cp *PodSandboxCheckpoint) GetState() *CheckpointState {
+	cp.migrateState()
+	return cp.State
+}
+
+// migrateState migrates the state read from the checkpoint to the current
+// state schema version. v1 checkpoints get an empty state, as do the
+// checkpoints whose state has a schema version this release doesn't know,
+// written by a newer release, since that state was dropped when read.
+func (cp *PodSandboxCheckpoint) migrateState() {
+	if cp.State == nil {
+		cp.State = &CheckpointState{}
+	}
+	cp.StateVersion = stateSchemaVersion
+}
@@ -17,9 +17,17 @@ limitations under the License.
 package core
 
 import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Mirantis/cri-dockerd/config"
+	"github.com/Mirantis/cri-dockerd/store"
 )
 
 func TestPodSandboxCheckpoint(t *testing.T) {
@@ -31,3 +39,139 @@ func TestPodSandboxCheckpoint(t *testing.T) {
 	assert.Equal(t, "sandbox1", name)
 	assert.Equal(t, true, hostNetwork)
 }
+
+func TestPodSandboxCheckpointRoundTrip(t *testing.T) {
+	checkpointManager, err := store.NewCheckpointManager(t.TempDir())
+	require.NoError(t, err)
+
+	ready := true
+	checkpoint := NewPodSandboxCheckpoint("ns1", "sandbox1", &CheckpointData{})
+	state := checkpoint.GetState()
+	state.RuntimeHandler = "runsc"
+	state.DNSConfig = &CheckpointDNSConfig{Servers: []string{"10.0.0.10"}, Options: []string{}}
+	state.NetworkReady = &ready
+	state.CNIConfigName = "cbr0"
+	state.Interfaces = []string{"eth0"}
+	state.IPs = []string{}
+	require.NoError(t, checkpointManager.CreateCheckpoint("id", checkpoint))
+
+	read := NewPodSandboxCheckpoint("", "", &CheckpointData{})
+	require.NoError(t, checkpointManager.GetCheckpoint("id", read))
+	version, name, namespace, _, _ := read.GetData()
+	assert.Equal(t, schemaVersion, version)
+	assert.Equal(t, "sandbox1", name)
+	assert.Equal(t, "ns1", namespace)
+	assert.Equal(t, &CheckpointState{
+		RuntimeHandler: "runsc",
+		DNSConfig:      &CheckpointDNSConfig{Servers: []string{"10.0.0.10"}},
+		NetworkReady:   &ready,
+		CNIConfigName:  "cbr0",
+		Interfaces:     []string{"eth0"},
+	}, read.GetState())
+}
+
+func TestPodSandboxCheckpointMigratesV1(t *testing.T) {
+	checkpointDir := t.TempDir()
+	checkpointManager, err := store.NewCheckpointManager(checkpointDir)
+	require.NoError(t, err)
+
+	hostPort, containerPort, proto := int32(8080), int32(80), protocolTCP
+	data := CheckpointData{PortMappings: []*config.PortMapping{{
+		HostPort:      &hostPort,
+		ContainerPort: &containerPort,
+		Protocol:      &proto,
+	}}}
+	blob, err := json.Marshal(map[string]interface{}{
+		"version":   schemaVersion,
+		"name":      "sandbox1",
+		"namespace": "ns1",
+		"data":      data,
+		"checksum":  store.NewChecksum(data),
+	})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(checkpointDir, "id"), blob, 0644))
+
+	// The checkpoints written by the releases without the sandbox state are
+	// read with an empty state.
+	checkpoint := &PodSandboxCheckpoint{Data: &CheckpointData{}}
+	require.NoError(t, checkpointManager.GetCheckpoint("id", checkpoint))
+	assert.Equal(t, data, *checkpoint.Data)
+	assert.Equal(t, &CheckpointState{}, checkpoint.GetState())
+
+	// Rewriting the checkpoint stores it with the current state schema
+	// version.
+	checkpoint.GetState().CNIConfigName = "cbr0"
+	require.NoError(t, checkpointManager.CreateCheckpoint("id", checkpoint))
+	blob, err = os.ReadFile(filepath.Join(checkpointDir, "id"))
+	require.NoError(t, err)
+	assert.Contains(t, string(blob), `"state_version":"v2"`)
+
+	checkpoint = &PodSandboxCheckpoint{Data: &CheckpointData{}}
+	require.NoError(t, checkpointManager.GetCheckpoint("id", checkpoint))
+	assert.Equal(t, data, *checkpoint.Data)
+	assert.Equal(t, "cbr0", checkpoint.GetState().CNIConfigName)
+}
+
+func TestPodSandboxCheckpointIgnoresUnknownStateVersion(t *testing.T) {
+	checkpointDir := t.TempDir()
+	checkpointManager, err := store.NewCheckpointManager(checkpointDir)
+	require.NoError(t, err)
+
+	// A state written by a newer release, with a field this release doesn't
+	// know about and a checksum calculated with it.
+	type newerState struct {
+		CheckpointState
+		FutureField string `json:"future_field,omitempty"`
+	}
+	data := CheckpointData{HostNetwork: true}
+	state := newerState{CheckpointState: CheckpointState{CNIConfigName: "cbr0"}, FutureField: "future"}
+	blob, err := json.Marshal(map[string]interface{}{
+		"version":        schemaVersion,
+		"name":           "sandbox1",
+		"namespace":      "ns1",
+		"data":           data,
+		"checksum":       store.NewChecksum(data),
+		"state":          state,
+		"state_version":  "v3",
+		"state_checksum": store.NewChecksum(state),
+	})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(checkpointDir, "id"), blob, 0644))
+
+	// The state is ignored, the checkpoint is still read.
+	read := &PodSandboxCheckpoint{Data: &CheckpointData{}}
+	require.NoError(t, checkpointManager.GetCheckpoint("id", read))
+	assert.NoError(t, read.VerifyChecksum())
+	assert.Equal(t, data, *read.Data)
+	assert.Equal(t, &CheckpointState{}, read.GetState())
+}
+
+func TestPodSandboxCheckpointReadableWithoutState(t *testing.T) {
+	checkpointDir := t.TempDir()
+	checkpointManager, err := store.NewCheckpointManager(checkpointDir)
+	require.NoError(t, err)
+
+	checkpoint := NewPodSandboxCheckpoint("ns1", "sandbox1", &CheckpointData{HostNetwork: true})
+	checkpoint.GetState().CNIConfigName = "cbr0"
+	require.NoError(t, checkpointManager.CreateCheckpoint("id", checkpoint))
+	blob, err := os.ReadFile(filepath.Join(checkpointDir, "id"))
+	require.NoError(t, err)
+
+	// The releases without the sandbox state verify the checksum of the data
+	// alone, and ignore the state.
+	var old struct {
+		Version  string          `json:"version"`
+		Data     *CheckpointData `json:"data"`
+		Checksum store.Checksum  `json:"checksum"`
+	}
+	require.NoError(t, json.Unmarshal(blob, &old))
+	assert.Equal(t, schemaVersion, old.Version)
+	assert.NoError(t, old.Checksum.Verify(*old.Data))
+
+	// A modified state is detected.
+	tampered := NewPodSandboxCheckpoint("", "", &CheckpointData{})
+	require.NoError(t, tampered.UnmarshalCheckpoint(
+		[]byte(strings.Replace(string(blob), `"cbr0"`, `"other"`, 1)),
+	))
+	assert.Error(t, tampered.VerifyChecksum())
+}
@@ -34,7 +34,7 @@ import (
 // Docker cannot store the log to an arbitrary location (yet), so we create an
 // symlink at LogPath, linking to the actual path of the log.
 func (ds *dockerService) CreateContainer(
-	_ context.Context,
+	ctx context.Context,
 	r *v1.CreateContainerRequest,
 ) (*v1.CreateContainerResponse, error) {
 	podSandboxID := r.PodSandboxId
@@ -69,7 +69,7 @@ func (ds *dockerService) CreateContainer(
 	mounts := config.GetMounts()
 	terminationMessagePath, _ := config.Annotations["io.kubernetes.container.terminationMessagePath"]
 
-	sandboxInfo, err := ds.client.InspectContainer(r.GetPodSandboxId())
+	sandboxInfo, err := ds.tracedClient(ctx).InspectContainer(r.GetPodSandboxId())
 	if err != nil {
 		return nil, fmt.Errorf("unable to get container's sandbox ID: %v", err)
 	}
@@ -77,10 +77,17 @@ func (ds *dockerService) CreateContainer(
 	if err != nil {
 		return nil, fmt.Errorf("unable to get container's runtime handlers: %v", err)
 	}
+	runtimeHandler := ds.sandboxRuntimeHandler(sandboxInfo)
+	handlerConfig := ds.runtimeHandlers.Get(runtimeHandler)
 	var rtHandler *v1.RuntimeHandler
-	for _, h := range rtHandlers {
-		if h.Name == sandboxInfo.HostConfig.Runtime {
-			rtHandler = h
+	for _, name := range []string{runtimeHandler, sandboxInfo.HostConfig.Runtime} {
+		for _, h := range rtHandlers {
+			if h.Name == name {
+				rtHandler = h
+				break
+			}
+		}
+		if rtHandler != nil {
 			break
 		}
 	}
@@ -173,7 +180,9 @@ func (ds *dockerService) CreateContainer(
 		securityContext := config.GetLinux().GetSecurityContext()
 		if securityContext != nil {
 			securityOpts, err = ds.getSecurityOpts(
-				securityContext.GetSeccomp(), securityContext.Privileged,
+				ctx,
+				handlerSeccompProfile(securityContext.GetSeccomp(), handlerConfig),
+				securityContext.Privileged,
 				securityOptSeparator,
 			)
 			if err != nil {
@@ -187,16 +196,28 @@ func (ds *dockerService) CreateContainer(
 	}
 
 	hc.SecurityOpt = append(hc.SecurityOpt, securityOpts...)
+	err = applyRuntimeHandlerConfig(
+		hc,
+		runtimeHandler,
+		handlerConfig,
+		config.GetLinux().GetSecurityContext().GetPrivileged(),
+		sandboxConfig.GetAnnotations(),
+		config.GetAnnotations(),
+	)
+	if err != nil {
+		return nil, err
+	}
 
 	cleanupInfo, err := ds.applyPlatformSpecificDockerConfig(r, &createConfig)
 	if err != nil {
 		return nil, err
 	}
 
-	createResp, createErr := ds.client.CreateContainer(createConfig)
+	createResp, createErr := ds.tracedClient(ctx).CreateContainer(createConfig)
 	if createErr != nil {
 		createResp, createErr = recoverFromCreationConflictIfNeeded(
-			ds.client,
+			ctx,
+			ds.tracedClient(ctx),
 			createConfig,
 			createErr,
 		)
@@ -204,6 +225,7 @@ func (ds *dockerService) CreateContainer(
 
 	if createResp != nil {
 		containerID := createResp.ID
+		ds.refreshIndexedContainer(containerID)
 
 		if cleanupInfo != nil {
 			// we don't perform the clean up just yet at that could destroy information
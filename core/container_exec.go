@@ -25,6 +25,7 @@ import (
 	"github.com/Mirantis/cri-dockerd/libdocker"
 	"github.com/Mirantis/cri-dockerd/streaming"
 	"github.com/Mirantis/cri-dockerd/utils"
+	dockertypes "github.com/docker/docker/api/types"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	v1 "k8s.io/cri-api/pkg/apis/runtime/v1"
@@ -81,3 +82,20 @@ func (ds *dockerService) Exec(
 	}
 	return ds.streamingServer.GetExec(req)
 }
+
+// podAnnotations returns the annotations of the pod of container, which are
+// in the labels of its sandbox.
+func (ds *dockerService) podAnnotations(
+	container *dockertypes.ContainerJSON,
+) (map[string]string, error) {
+	sandbox := container
+	if container.Config.Labels[containerTypeLabelKey] != containerTypeLabelSandbox {
+		var err error
+		sandbox, err = ds.client.InspectContainer(container.Config.Labels[sandboxIDLabelKey])
+		if err != nil {
+			return nil, err
+		}
+	}
+	_, annotations := extractLabels(sandbox.Config.Labels)
+	return annotations, nil
+}
@@ -0,0 +1,390 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Mirantis/cri-dockerd/libdocker"
+	"github.com/Mirantis/cri-dockerd/metrics"
+	dockertypes "github.com/docker/docker/api/types"
+	dockercontainer "github.com/docker/docker/api/types/container"
+	dockerevents "github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// containerIndex is an in-memory index of the containers and sandboxes
+// managed by cri-dockerd. It is built from a full list of the containers and
+// kept current from docker events, with periodic full resyncs to catch missed
+// events, so that the list requests of the kubelet don't reach dockerd.
+type containerIndex struct {
+	client       libdocker.DockerClientInterface
+	resyncPeriod time.Duration
+
+	lock sync.RWMutex
+	// synced is false until the first full list of the containers, and after
+	// the event stream failed until the next one.
+	synced     bool
+	containers map[string]*dockertypes.Container
+	// bySandbox maps the ID of a sandbox to the IDs of its containers.
+	bySandbox map[string]map[string]struct{}
+	// byType maps a container type to the IDs of the containers of that type.
+	byType map[string]map[string]struct{}
+
+	// execExits is given the exec_die events of the stream, if set.
+	execExits *execExitWatcher
+}
+
+func newContainerIndex(
+	client libdocker.DockerClientInterface,
+	resyncPeriod time.Duration,
+) *containerIndex {
+	return &containerIndex{
+		client:       client,
+		resyncPeriod: resyncPeriod,
+		containers:   make(map[string]*dockertypes.Container),
+		bySandbox:    make(map[string]map[string]struct{}),
+		byType:       make(map[string]map[string]struct{}),
+	}
+}
+
+// start keeps the index current in the background.
+func (idx *containerIndex) start() {
+	go wait.Forever(func() {
+		idx.run()
+		// Answer from docker until the next full list.
+		idx.setSynced(false)
+		idx.execExits.setWatching(false)
+	}, time.Second)
+}
+
+// run subscribes to the docker events, lists all containers and then applies
+// the events to the index until the event stream fails.
+func (idx *containerIndex) run() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	eventFilters := filters.NewArgs()
+	eventFilters.Add("type", string(dockerevents.ContainerEventType))
+	eventFilters.Add("label", containerTypeLabelKey)
+	// Subscribe before listing, so that no change is missed in between.
+	messages, errs := idx.client.Events(ctx, dockerevents.ListOptions{Filters: eventFilters})
+	if err := idx.resync(); err != nil {
+		logrus.Errorf("Failed to build the container index: %v", err)
+		return
+	}
+	idx.execExits.setWatching(true)
+
+	ticker := time.NewTicker(idx.resyncPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg := <-messages:
+			idx.handleEvent(msg)
+		case err := <-errs:
+			logrus.Errorf("Docker event stream of the container index failed: %v", err)
+			return
+		case <-ticker.C:
+			if err := idx.resync(); err != nil {
+				logrus.Errorf("Failed to resync the container index: %v", err)
+			}
+		}
+	}
+}
+
+func (idx *containerIndex) setSynced(synced bool) {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	idx.synced = synced
+}
+
+// resync replaces the content of the index with a full list of the containers.
+func (idx *containerIndex) resync() error {
+	opts := dockercontainer.ListOptions{All: true, Filters: filters.NewArgs()}
+	opts.Filters.Add("label", containerTypeLabelKey)
+	containers, err := idx.client.ListContainers(opts)
+	if err != nil {
+		return err
+	}
+
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	if idx.synced {
+		corrections := 0
+		seen := make(map[string]bool, len(containers))
+		for i := range containers {
+			seen[containers[i].ID] = true
+			if old, ok := idx.containers[containers[i].ID]; !ok || old.Status != containers[i].Status {
+				corrections++
+			}
+		}
+		for id := range idx.containers {
+			if !seen[id] {
+				corrections++
+			}
+		}
+		if corrections > 0 {
+			logrus.Debugf("Resync corrected %d entries of the container index", corrections)
+		}
+	}
+	idx.containers = make(map[string]*dockertypes.Container, len(containers))
+	idx.bySandbox = make(map[string]map[string]struct{})
+	idx.byType = make(map[string]map[string]struct{})
+	for i := range containers {
+		idx.addLocked(&containers[i])
+	}
+	idx.synced = true
+	metrics.ContainerIndexLag.Set(0)
+	return nil
+}
+
+// handleEvent updates the index entry of the container the event is about.
+func (idx *containerIndex) handleEvent(msg dockerevents.Message) {
+	if msg.Type != dockerevents.ContainerEventType || msg.Actor.ID == "" {
+		return
+	}
+	if msg.TimeNano != 0 {
+		metrics.ContainerIndexLag.Set(time.Since(time.Unix(0, msg.TimeNano)).Seconds())
+	}
+	switch {
+	case strings.HasPrefix(string(msg.Action), "exec_"):
+		// Exec sessions don't change the container.
+		idx.execExits.handleEvent(msg)
+		return
+	case msg.Action == dockerevents.ActionDestroy:
+		idx.remove(msg.Actor.ID)
+	default:
+		if err := idx.refresh(msg.Actor.ID); err != nil {
+			logrus.Debugf("Failed to refresh container %s in the container index: %v", msg.Actor.ID, err)
+		}
+	}
+}
+
+// refresh replaces the index entry of the container with its current state.
+// It is called after cri-dockerd changed the container, so that the change is
+// visible before the matching event is received.
+func (idx *containerIndex) refresh(id string) error {
+	if idx == nil {
+		return nil
+	}
+	opts := dockercontainer.ListOptions{All: true, Filters: filters.NewArgs()}
+	opts.Filters.Add("id", id)
+	containers, err := idx.client.ListContainers(opts)
+	if err != nil {
+		return err
+	}
+
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	idx.removeLocked(id)
+	for i := range containers {
+		if containers[i].ID == id {
+			idx.addLocked(&containers[i])
+		}
+	}
+	return nil
+}
+
+// remove drops the container from the index.
+func (idx *containerIndex) remove(id string) {
+	if idx == nil {
+		return
+	}
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	idx.removeLocked(id)
+}
+
+func (idx *containerIndex) addLocked(c *dockertypes.Container) {
+	containerType, ok := c.Labels[containerTypeLabelKey]
+	if !ok {
+		return
+	}
+	idx.containers[c.ID] = c
+	addToIndex(idx.byType, containerType, c.ID)
+	if sandboxID, ok := c.Labels[sandboxIDLabelKey]; ok {
+		addToIndex(idx.bySandbox, sandboxID, c.ID)
+	}
+}
+
+func (idx *containerIndex) removeLocked(id string) {
+	c, ok := idx.containers[id]
+	if !ok {
+		return
+	}
+	delete(idx.containers, id)
+	removeFromIndex(idx.byType, c.Labels[containerTypeLabelKey], id)
+	removeFromIndex(idx.bySandbox, c.Labels[sandboxIDLabelKey], id)
+}
+
+func addToIndex(index map[string]map[string]struct{}, key, id string) {
+	if index[key] == nil {
+		index[key] = make(map[string]struct{})
+	}
+	index[key][id] = struct{}{}
+}
+
+func removeFromIndex(index map[string]map[string]struct{}, key, id string) {
+	delete(index[key], id)
+	if len(index[key]) == 0 {
+		delete(index, key)
+	}
+}
+
+// list answers a docker list request from the index. It returns false if the
+// index isn't synced or can't answer the request, in which case docker has
+// to be asked.
+func (idx *containerIndex) list(opts dockercontainer.ListOptions) ([]dockertypes.Container, bool) {
+	if idx == nil || opts.Size || opts.Latest || opts.Since != "" || opts.Before != "" || opts.Limit != 0 {
+		return nil, false
+	}
+	for _, key := range opts.Filters.Keys() {
+		if key != "id" && key != "label" && key != "status" {
+			return nil, false
+		}
+	}
+	ids := opts.Filters.Get("id")
+	statuses := opts.Filters.Get("status")
+	labels := make(map[string]string)
+	for _, label := range opts.Filters.Get("label") {
+		key, value, ok := strings.Cut(label, "=")
+		if !ok {
+			return nil, false
+		}
+		if other, ok := labels[key]; ok && other != value {
+			return nil, true
+		}
+		labels[key] = value
+	}
+
+	idx.lock.RLock()
+	defer idx.lock.RUnlock()
+	if !idx.synced {
+		return nil, false
+	}
+	candidates := idx.candidatesLocked(labels)
+	result := []dockertypes.Container{}
+	for id := range candidates {
+		c := idx.containers[id]
+		if len(ids) != 0 && !matchesAnyPrefix(id, ids) {
+			continue
+		}
+		status := dockerStatus(c.Status)
+		if !opts.All && status != "running" && status != "paused" {
+			continue
+		}
+		if len(statuses) != 0 && !matchesAny(status, statuses) {
+			continue
+		}
+		if !matchesLabels(c.Labels, labels) {
+			continue
+		}
+		result = append(result, *c)
+	}
+	// Docker lists the most recent containers first.
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Created != result[j].Created {
+			return result[i].Created > result[j].Created
+		}
+		return result[i].ID < result[j].ID
+	})
+	return result, true
+}
+
+// candidatesLocked returns the IDs of the containers that may match the
+// labels, using the narrowest index available.
+func (idx *containerIndex) candidatesLocked(labels map[string]string) map[string]struct{} {
+	if sandboxID, ok := labels[sandboxIDLabelKey]; ok {
+		return idx.bySandbox[sandboxID]
+	}
+	if containerType, ok := labels[containerTypeLabelKey]; ok {
+		return idx.byType[containerType]
+	}
+	all := make(map[string]struct{}, len(idx.containers))
+	for id := range idx.containers {
+		all[id] = struct{}{}
+	}
+	return all
+}
+
+// dockerStatus returns the state docker filters on for the status of a
+// container as returned in list results.
+func dockerStatus(status string) string {
+	switch {
+	case strings.HasPrefix(status, libdocker.StatusRunningPrefix):
+		if strings.HasSuffix(status, "(Paused)") {
+			return "paused"
+		}
+		return "running"
+	case strings.HasPrefix(status, libdocker.StatusCreatedPrefix):
+		return "created"
+	case strings.HasPrefix(status, libdocker.StatusExitedPrefix):
+		return "exited"
+	default:
+		return "unknown"
+	}
+}
+
+func matchesAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAny(s string, values []string) bool {
+	for _, value := range values {
+		if s == value {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesLabels(labels, selector map[string]string) bool {
+	for k, v := range selector {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// listContainers lists containers from the container index if it can answer
+// the request, and from docker otherwise.
+func (ds *dockerService) listContainers(opts dockercontainer.ListOptions) ([]dockertypes.Container, error) {
+	if containers, ok := ds.containerIndex.list(opts); ok {
+		return containers, nil
+	}
+	return ds.client.ListContainers(opts)
+}
+
+// refreshIndexedContainer updates the container in the container index after
+// cri-dockerd changed it.
+func (ds *dockerService) refreshIndexedContainer(id string) {
+	if err := ds.containerIndex.refresh(id); err != nil {
+		logrus.Debugf("Failed to refresh container %s in the container index: %v", id, err)
+	}
+}
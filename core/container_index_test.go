@@ -0,0 +1,180 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	dockercontainer "github.com/docker/docker/api/types/container"
+	dockerevents "github.com/docker/docker/api/types/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// TestContainerIndexAnswersListRequests checks that the list requests are
+// answered from the index once synced, with the same results as docker.
+func TestContainerIndexAnswersListRequests(t *testing.T) {
+	ds, fDocker, _ := newTestDockerService()
+	ds.containerIndex = newContainerIndex(ds.client, time.Minute)
+
+	sandboxID := runTestSandbox(t, ds, "foo")
+	sConfig := makeSandboxConfig("foo", "bar", "foo", 0)
+	createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+		PodSandboxId:  sandboxID,
+		Config:        makeContainerConfig(sConfig, "app", "busybox", 0, map[string]string{"app": "x"}, nil),
+		SandboxConfig: sConfig,
+	})
+	require.NoError(t, err)
+	_, err = ds.StartContainer(getTestCTX(), &runtimeapi.StartContainerRequest{ContainerId: createResp.ContainerId})
+	require.NoError(t, err)
+	runTestSandbox(t, ds, "other")
+
+	requests := []*runtimeapi.ListContainersRequest{
+		{},
+		{Filter: &runtimeapi.ContainerFilter{PodSandboxId: sandboxID}},
+		{Filter: &runtimeapi.ContainerFilter{Id: createResp.ContainerId}},
+		{Filter: &runtimeapi.ContainerFilter{LabelSelector: map[string]string{"app": "y"}}},
+		{Filter: &runtimeapi.ContainerFilter{
+			State: &runtimeapi.ContainerStateValue{State: runtimeapi.ContainerState_CONTAINER_RUNNING},
+		}},
+	}
+	sandboxRequests := []*runtimeapi.ListPodSandboxRequest{
+		{},
+		{Filter: &runtimeapi.PodSandboxFilter{Id: sandboxID}},
+		{Filter: &runtimeapi.PodSandboxFilter{
+			State: &runtimeapi.PodSandboxStateValue{State: runtimeapi.PodSandboxState_SANDBOX_READY},
+		}},
+	}
+	var expected []*runtimeapi.ListContainersResponse
+	for _, req := range requests {
+		resp, err := ds.ListContainers(getTestCTX(), req)
+		require.NoError(t, err)
+		expected = append(expected, resp)
+	}
+	var expectedSandboxes []*runtimeapi.ListPodSandboxResponse
+	for _, req := range sandboxRequests {
+		resp, err := ds.ListPodSandbox(getTestCTX(), req)
+		require.NoError(t, err)
+		expectedSandboxes = append(expectedSandboxes, resp)
+	}
+
+	require.NoError(t, ds.containerIndex.resync())
+	fDocker.ClearCalls()
+	for i, req := range requests {
+		resp, err := ds.ListContainers(getTestCTX(), req)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, expected[i].Containers, resp.Containers, "request %v", req)
+	}
+	for i, req := range sandboxRequests {
+		resp, err := ds.ListPodSandbox(getTestCTX(), req)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, expectedSandboxes[i].Items, resp.Items, "request %v", req)
+	}
+	assert.NoError(t, fDocker.AssertCalls([]string{}))
+
+	// Changes made by cri-dockerd are visible without waiting for the events.
+	_, err = ds.StopContainer(getTestCTX(), &runtimeapi.StopContainerRequest{ContainerId: createResp.ContainerId})
+	require.NoError(t, err)
+	resp, err := ds.ListContainers(getTestCTX(), &runtimeapi.ListContainersRequest{
+		Filter: &runtimeapi.ContainerFilter{Id: createResp.ContainerId},
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Containers, 1)
+	assert.Equal(t, runtimeapi.ContainerState_CONTAINER_EXITED, resp.Containers[0].State)
+}
+
+// TestContainerIndexFollowsEvents checks that changes made behind the back of
+// cri-dockerd are applied from the docker events, and that docker is asked
+// again once the event stream failed.
+func TestContainerIndexFollowsEvents(t *testing.T) {
+	ds, fDocker, _ := newTestDockerService()
+	ds.containerIndex = newContainerIndex(ds.client, time.Minute)
+	id := runTestSandbox(t, ds, "foo")
+
+	done := make(chan struct{})
+	go func() {
+		ds.containerIndex.run()
+		close(done)
+	}()
+	assert.Eventually(t, func() bool {
+		_, ok := ds.containerIndex.list(dockercontainer.ListOptions{All: true})
+		return ok
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, fDocker.StopContainer(id, 0))
+	require.NoError(t, fDocker.RemoveContainer(id, dockercontainer.RemoveOptions{}))
+	fDocker.EmitEvent(dockerevents.Message{
+		Type:     dockerevents.ContainerEventType,
+		Action:   dockerevents.ActionDestroy,
+		Actor:    dockerevents.Actor{ID: id},
+		TimeNano: time.Now().UnixNano(),
+	})
+	assert.Eventually(t, func() bool {
+		containers, ok := ds.containerIndex.list(dockercontainer.ListOptions{All: true})
+		return ok && len(containers) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	fDocker.FailEvents(errors.New("connection reset"))
+	<-done
+	ds.containerIndex.setSynced(false)
+	_, ok := ds.containerIndex.list(dockercontainer.ListOptions{All: true})
+	assert.False(t, ok)
+}
+
+// TestContainerIndexDispatchesExecExits checks that the exec_die events of the
+// index event stream are given to the execs waiting for them.
+func TestContainerIndexDispatchesExecExits(t *testing.T) {
+	ds, fDocker, _ := newTestDockerService()
+	ds.containerIndex = newContainerIndex(ds.client, time.Minute)
+	exits := newExecExitWatcher()
+	ds.containerIndex.execExits = exits
+	id := runTestSandbox(t, ds, "foo")
+
+	done := make(chan struct{})
+	go func() {
+		ds.containerIndex.run()
+		close(done)
+	}()
+	var exit <-chan execExit
+	assert.Eventually(t, func() bool {
+		exit, _ = exits.watch("exec1")
+		return exit != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	fDocker.EmitEvent(dockerevents.Message{
+		Type:   dockerevents.ContainerEventType,
+		Action: dockerevents.ActionExecDie,
+		Actor: dockerevents.Actor{
+			ID:         id,
+			Attributes: map[string]string{"execID": "exec1", "exitCode": "2"},
+		},
+	})
+	assert.Equal(t, execExit{exitCode: 2, known: true}, <-exit)
+
+	// The execs waiting when the stream fails poll docker instead.
+	exit, _ = exits.watch("exec2")
+	require.NotNil(t, exit)
+	fDocker.FailEvents(errors.New("connection reset"))
+	<-done
+	exits.setWatching(false)
+	assert.Equal(t, execExit{}, <-exit)
+	exit, _ = exits.watch("exec3")
+	assert.Nil(t, exit)
+}
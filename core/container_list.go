@@ -20,15 +20,15 @@ import (
 	"context"
 
 	"github.com/Mirantis/cri-dockerd/libdocker"
+	"github.com/Mirantis/cri-dockerd/utils/logging"
 	dockercontainer "github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
-	"github.com/sirupsen/logrus"
 	v1 "k8s.io/cri-api/pkg/apis/runtime/v1"
 )
 
 // ListContainers lists all containers matching the filter.
 func (ds *dockerService) ListContainers(
-	_ context.Context,
+	ctx context.Context,
 	r *v1.ListContainersRequest,
 ) (*v1.ListContainersResponse, error) {
 	filter := r.GetFilter()
@@ -56,7 +56,7 @@ func (ds *dockerService) ListContainers(
 			}
 		}
 	}
-	containers, err := ds.client.ListContainers(opts)
+	containers, err := ds.listContainers(opts)
 	if err != nil && !libdocker.IsContainerNotFoundError(err) {
 		return nil, err
 	}
@@ -67,7 +67,8 @@ func (ds *dockerService) ListContainers(
 
 		converted, err := toRuntimeAPIContainer(&c)
 		if err != nil {
-			logrus.Infof("Unable to convert docker container %v to runtime API container: %v", c, err)
+			logging.FromContext(ctx).WithError(err).WithField(logging.ContainerIDKey, c.ID).
+				Info("Unable to convert docker container to runtime API container")
 			continue
 		}
 
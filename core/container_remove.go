@@ -29,7 +29,7 @@ import (
 
 // RemoveContainer removes the container.
 func (ds *dockerService) RemoveContainer(
-	_ context.Context,
+	ctx context.Context,
 	r *v1.RemoveContainerRequest,
 ) (*v1.RemoveContainerResponse, error) {
 	// Ideally, log lifecycle should be independent of container lifecycle.
@@ -47,10 +47,11 @@ func (ds *dockerService) RemoveContainer(
 			errors,
 		)
 	}
-	err = ds.client.RemoveContainer(
+	err = ds.tracedClient(ctx).RemoveContainer(
 		r.ContainerId,
 		dockercontainer.RemoveOptions{RemoveVolumes: true, Force: true},
 	)
+	ds.refreshIndexedContainer(r.ContainerId)
 	if err != nil {
 		return nil, fmt.Errorf("failed to remove container %q: %v", r.ContainerId, err)
 	}
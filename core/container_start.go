@@ -24,13 +24,14 @@ import (
 
 // StartContainer starts the container.
 func (ds *dockerService) StartContainer(
-	_ context.Context,
+	ctx context.Context,
 	r *v1.StartContainerRequest,
 ) (*v1.StartContainerResponse, error) {
-	err := ds.client.StartContainer(r.ContainerId)
+	err := ds.tracedClient(ctx).StartContainer(r.ContainerId)
+	ds.refreshIndexedContainer(r.ContainerId)
 
 	// Create container log symlink for all containers (including failed ones).
-	if linkError := ds.createContainerLogSymlink(r.ContainerId); linkError != nil {
+	if linkError := ds.createContainerLogSymlink(ctx, r.ContainerId); linkError != nil {
 		// Do not stop the container if we failed to create symlink because:
 		//   1. This is not a critical failure.
 		//   2. We don't have enough information to properly stop container here.
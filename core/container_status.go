@@ -20,13 +20,13 @@ import (
 	"context"
 	"fmt"
 	"github.com/Mirantis/cri-dockerd/libdocker"
-	"github.com/sirupsen/logrus"
+	"github.com/Mirantis/cri-dockerd/utils/logging"
 	v1 "k8s.io/cri-api/pkg/apis/runtime/v1"
 )
 
 // ContainerStatus inspects the docker container and returns the status.
 func (ds *dockerService) ContainerStatus(
-	_ context.Context,
+	ctx context.Context,
 	req *v1.ContainerStatusRequest,
 ) (*v1.ContainerStatusResponse, error) {
 	containerID := req.ContainerId
@@ -52,7 +52,8 @@ func (ds *dockerService) ContainerStatus(
 				err,
 			)
 		}
-		logrus.Debugf("Image %s not found while inspecting docker container %s: %v", r.Image, containerID, err)
+		logging.FromContext(ctx).WithError(err).WithField(logging.ImageKey, r.Image).
+			Debug("Image of the container not found")
 	}
 	imageID := toPullableImageID(r.Image, ir)
 
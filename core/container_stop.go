@@ -29,10 +29,11 @@ import (
 
 // StopContainer stops a running container with a grace period (i.e., timeout).
 func (ds *dockerService) StopContainer(
-	_ context.Context,
+	ctx context.Context,
 	r *v1.StopContainerRequest,
 ) (*v1.StopContainerResponse, error) {
-	err := ds.client.StopContainer(r.ContainerId, time.Duration(r.Timeout)*time.Second)
+	err := ds.tracedClient(ctx).StopContainer(r.ContainerId, time.Duration(r.Timeout)*time.Second)
+	ds.refreshIndexedContainer(r.ContainerId)
 	if err != nil {
 		if libdocker.IsContainerNotFoundError(err) {
 			err = status.Error(codes.NotFound, err.Error())
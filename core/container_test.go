@@ -25,6 +25,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/Mirantis/cri-dockerd/streaming"
 	dockerimage "github.com/docker/docker/api/types/image"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -424,7 +425,8 @@ func TestContainerCreationConflict(t *testing.T) {
 	randomError := fmt.Errorf("random error")
 
 	// sandBox run called "inspect_image", "pull", "create", "start", "inspect_container",
-	sandBoxCalls := []string{"inspect_image", "pull", "create", "start", "inspect_container"}
+	// followed by "inspect_container" when creating the container.
+	sandBoxCalls := []string{"inspect_image", "pull", "create", "start", "inspect_container", "inspect_container"}
 	for desc, test := range map[string]struct {
 		createError  error
 		removeError  error
@@ -493,3 +495,29 @@ func TestContainerCreationConflict(t *testing.T) {
 		}
 	}
 }
+
+func TestPodAnnotations(t *testing.T) {
+	ds, fDocker, _ := newTestDockerService()
+	annotations := map[string]string{streaming.RecordSessionsAnnotation: "true"}
+	sConfig := makeSandboxConfigWithLabelsAndAnnotations("foo", "bar", "1", 0, nil, annotations)
+	config := makeContainerConfig(sConfig, "pause", "iamimage", 0, nil, map[string]string{"container": "annotation"})
+
+	runSandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+		Config: sConfig,
+	})
+	require.NoError(t, err)
+	createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+		PodSandboxId:  runSandboxResp.PodSandboxId,
+		Config:        config,
+		SandboxConfig: sConfig,
+	})
+	require.NoError(t, err)
+
+	for _, id := range []string{runSandboxResp.PodSandboxId, createResp.ContainerId} {
+		container, err := fDocker.InspectContainer(id)
+		require.NoError(t, err)
+		podAnnotations, err := ds.podAnnotations(container)
+		require.NoError(t, err)
+		assert.Equal(t, annotations, podAnnotations)
+	}
+}
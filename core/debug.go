@@ -0,0 +1,106 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Mirantis/cri-dockerd/streaming"
+)
+
+// DebugState is the internal state of the docker service, for debugging.
+type DebugState struct {
+	// NetworkReady maps sandbox IDs to whether their network is set up.
+	NetworkReady map[string]bool `json:"networkReady"`
+	// ContainerCleanupInfos maps container IDs to what is cleaned up after
+	// them.
+	ContainerCleanupInfos map[string]string `json:"containerCleanupInfos"`
+	// ContainerStatsCache is the writable layer collection state.
+	ContainerStatsCache StatsCacheState `json:"containerStatsCache"`
+	// StreamingRequests are the requests waiting for their stream.
+	StreamingRequests []streaming.CachedRequest `json:"streamingRequests"`
+	// Network is the state of the network plugin.
+	Network map[string]interface{} `json:"network"`
+	// HostportChains are the iptables rules of the hostports by IP family,
+	// or why they couldn't be read.
+	HostportChains map[string]interface{} `json:"hostportChains,omitempty"`
+}
+
+// StatsCacheState is the state of the writable layer collection.
+type StatsCacheState struct {
+	LastProgress time.Time                  `json:"lastProgress"`
+	Entries      map[string]StatsEntryState `json:"entries"`
+}
+
+// StatsEntryState is the writable layer collection state of a container.
+type StatsEntryState struct {
+	RWLayerSize uint64    `json:"rwLayerSize"`
+	Initialized bool      `json:"initialized"`
+	NextCollect time.Time `json:"nextCollect"`
+	Backoff     string    `json:"backoff"`
+	Queued      bool      `json:"queued"`
+}
+
+// DebugState returns the internal state of the docker service.
+func (ds *dockerService) DebugState() *DebugState {
+	state := &DebugState{
+		NetworkReady:          make(map[string]bool),
+		ContainerCleanupInfos: make(map[string]string),
+		ContainerStatsCache:   ds.containerStatsCache.debugState(),
+		Network:               ds.network.DumpState(),
+		HostportChains:        dumpHostportChains(),
+	}
+
+	ds.networkReadyLock.Lock()
+	for id, ready := range ds.networkReady {
+		state.NetworkReady[id] = ready
+	}
+	ds.networkReadyLock.Unlock()
+
+	ds.cleanupInfosLock.RLock()
+	for id, info := range ds.containerCleanupInfos {
+		state.ContainerCleanupInfos[id] = fmt.Sprintf("%+v", info)
+	}
+	ds.cleanupInfosLock.RUnlock()
+
+	if ds.streamingServer != nil {
+		state.StreamingRequests = ds.streamingServer.CachedRequests()
+	}
+	return state
+}
+
+func (c *containerStatsCache) debugState() StatsCacheState {
+	c.RLock()
+	defer c.RUnlock()
+	state := StatsCacheState{
+		LastProgress: c.lastProgress,
+		Entries:      make(map[string]StatsEntryState, len(c.stats)),
+	}
+	for id, cs := range c.stats {
+		cs.Lock()
+		state.Entries[id] = StatsEntryState{
+			RWLayerSize: cs.rwLayerSize,
+			Initialized: cs.initialized,
+			NextCollect: cs.nextCollect,
+			Backoff:     cs.backoff.String(),
+			Queued:      cs.queued,
+		}
+		cs.Unlock()
+	}
+	return state
+}
@@ -0,0 +1,42 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	utiliptables "k8s.io/kubernetes/pkg/util/iptables"
+	utilexec "k8s.io/utils/exec"
+
+	"github.com/Mirantis/cri-dockerd/network/hostport"
+)
+
+// dumpHostportChains returns the hostport rules of both IP families.
+func dumpHostportChains() map[string]interface{} {
+	execer := utilexec.New()
+	chains := make(map[string]interface{})
+	for _, protocol := range []utiliptables.Protocol{utiliptables.ProtocolIPv4, utiliptables.ProtocolIPv6} {
+		lines, err := hostport.DumpChains(utiliptables.New(execer, protocol))
+		if err != nil {
+			chains[string(protocol)] = err.Error()
+			continue
+		}
+		chains[string(protocol)] = lines
+	}
+	return chains
+}
@@ -0,0 +1,44 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebugState(t *testing.T) {
+	ds, _, _ := newTestDockerService()
+	ds.setNetworkReady("sandbox", true)
+	now := time.Now()
+	ds.containerStatsCache.recordProgress(now)
+	ds.containerStatsCache.sync([]string{"c1"}, now)
+
+	state := ds.DebugState()
+	assert.Equal(t, map[string]bool{"sandbox": true}, state.NetworkReady)
+	assert.Equal(t, "kubernetes.io/no-op", state.Network["plugin"])
+	require.Contains(t, state.ContainerStatsCache.Entries, "c1")
+	assert.True(t, state.ContainerStatsCache.Entries["c1"].Queued)
+	assert.Equal(t, now, state.ContainerStatsCache.LastProgress)
+
+	_, err := json.Marshal(state)
+	assert.NoError(t, err)
+}
@@ -33,6 +33,7 @@ import (
 
 	"github.com/Mirantis/cri-dockerd/config"
 	"github.com/Mirantis/cri-dockerd/containermanager"
+	"github.com/Mirantis/cri-dockerd/imagepolicy"
 	"github.com/Mirantis/cri-dockerd/libdocker"
 	"github.com/Mirantis/cri-dockerd/metrics"
 	"github.com/Mirantis/cri-dockerd/network"
@@ -41,6 +42,7 @@ import (
 	"github.com/Mirantis/cri-dockerd/store"
 	"github.com/Mirantis/cri-dockerd/streaming"
 	"github.com/Mirantis/cri-dockerd/utils"
+	"github.com/Mirantis/cri-dockerd/utils/logging"
 	"github.com/blang/semver"
 	dockertypes "github.com/docker/docker/api/types"
 	dockersystem "github.com/docker/docker/api/types/system"
@@ -48,6 +50,7 @@ import (
 	"github.com/sirupsen/logrus"
 
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
 	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
 )
 
@@ -107,6 +110,12 @@ type serviceCommon interface {
 		name, namespace string,
 		containerID config.ContainerID,
 	) (string, error)
+
+	// HealthChecks returns the checks of the health of the service.
+	HealthChecks() []HealthCheck
+
+	// DebugState returns the internal state of the service.
+	DebugState() *DebugState
 }
 
 // DockerService is an interface that embeds the new RuntimeService and
@@ -123,16 +132,30 @@ func NewDockerService(
 	clientConfig *config.ClientConfig,
 	podSandboxImage string,
 	streamingConfig *streaming.Config,
+	sessionRecorder *streaming.Recorder,
 	pluginSettings *config.NetworkPluginSettings,
 	cgroupsName string,
 	kubeCgroupDriver string,
 	criDockerdRootDir string,
+	sandboxGCSettings *config.SandboxGCSettings,
+	containerIndexResyncPeriod time.Duration,
+	runtimeHandlers *config.RuntimeHandlersConfig,
+	imagePolicy *imagepolicy.Verifier,
+	registryMirrors *config.RegistryMirrorsConfig,
+	maxParallelImagePulls int,
+	pinnedImagePatterns []string,
+	containerdRootDir string,
 ) (DockerService, error) {
 
 	client := config.NewDockerClientFromConfig(clientConfig)
 
 	c := libdocker.NewInstrumentedInterface(client)
 
+	pinnedImages, err := newPinnedImages(pinnedImagePatterns)
+	if err != nil {
+		return nil, err
+	}
+
 	checkpointManager, err := store.NewCheckpointManager(
 		filepath.Join(criDockerdRootDir, sandboxCheckpointDir),
 	)
@@ -140,19 +163,39 @@ func NewDockerService(
 		return nil, err
 	}
 
+	execExits := newExecExitWatcher()
 	ds := &dockerService{
 		client:          c,
 		os:              config.RealOS{},
 		podSandboxImage: podSandboxImage,
 		streamingRuntime: &streaming.StreamingRuntime{
 			Client:      client,
-			ExecHandler: &NativeExecHandler{},
+			ExecHandler: &NativeExecHandler{exits: execExits},
+			Recorder:    sessionRecorder,
 		},
 		containerManager:      containermanager.NewContainerManager(cgroupsName, client),
 		checkpointManager:     checkpointManager,
 		networkReady:          make(map[string]bool),
+		sandboxInstances:      make(map[string]*sandboxInstance),
 		containerCleanupInfos: make(map[string]*containerCleanupInfo),
 		containerStatsCache:   newContainerStatsCache(),
+		runtimeHandlers:       runtimeHandlers,
+		imagePolicy:           imagePolicy,
+		registryMirrors:       registryMirrors,
+		pullScheduler:         newPullScheduler(maxParallelImagePulls),
+		pinnedImages:          pinnedImages,
+		execExits:             execExits,
+	}
+
+	ds.streamingRuntime.PodAnnotations = ds.podAnnotations
+
+	if containerIndexResyncPeriod > 0 {
+		ds.containerIndex = newContainerIndex(c, containerIndexResyncPeriod)
+		ds.containerIndex.execExits = execExits
+	}
+
+	if sandboxGCSettings != nil {
+		ds.sandboxGC = newSandboxGC(ds, *sandboxGCSettings)
 	}
 
 	// check docker version compatibility.
@@ -212,6 +255,8 @@ func NewDockerService(
 		"Docker cri networking managed by network plugin %s",
 		plug.Name(),
 	)
+	ds.networkGCPeriod = pluginSettings.GCPeriod
+	ds.sandboxCheckPeriod = pluginSettings.SandboxCheckPeriod
 
 	dockerInfo, err := ds.getDockerInfo()
 	if err != nil {
@@ -219,6 +264,12 @@ func NewDockerService(
 	}
 	logrus.Debugf("Docker Info: %+v", dockerInfo)
 	ds.dockerRootDir = dockerInfo.DockerRootDir
+	ds.storagePaths = detectStoragePaths(dockerInfo, containerdRootDir)
+	logrus.Infof(
+		"Docker stores images in %s and containers in %s",
+		ds.storagePaths.images,
+		ds.storagePaths.containers,
+	)
 
 	// skipping cgroup driver checks for Windows
 	if runtime.GOOS == "linux" {
@@ -240,6 +291,7 @@ func NewDockerService(
 		logrus.Infof("Setting cgroupDriver %s", cgroupDriver)
 		ds.cgroupDriver = cgroupDriver
 	}
+	ds.initCgroupStats()
 
 	// Register prometheus metrics.
 	metrics.Register()
@@ -263,6 +315,17 @@ type dockerService struct {
 	// Map of podSandboxID :: network-is-ready
 	networkReady     map[string]bool
 	networkReadyLock sync.Mutex
+	// Map of podSandboxID :: sandbox container process the network was set up
+	// for, used to detect sandboxes restarted by docker.
+	sandboxInstances     map[string]*sandboxInstance
+	sandboxInstancesLock sync.Mutex
+	sandboxCheckPeriod   time.Duration
+	// networkGCPeriod is the period of the garbage collection of the network
+	// plugin, disabled if zero.
+	networkGCPeriod time.Duration
+	// sandboxStateLock serializes the updates of the sandbox state stored in
+	// checkpoints.
+	sandboxStateLock sync.Mutex
 
 	containerManager containermanager.ContainerManager
 	// cgroup driver used by Docker runtime.
@@ -275,8 +338,38 @@ type dockerService struct {
 	// docker root directory
 	dockerRootDir string
 
+	// storagePaths are the directories docker stores the images and the
+	// containers in.
+	storagePaths storagePaths
+
 	containerStatsCache *containerStatsCache
 
+	// cgroupStats reads the CPU and memory stats of the containers, docker
+	// is asked when nil or when it fails.
+	cgroupStats *cgroupStatsReader
+
+	// runtimeHandlers configures the runtime handlers, may be nil.
+	runtimeHandlers *config.RuntimeHandlersConfig
+
+	// imagePolicy verifies the signatures of the pulled images, may be nil.
+	imagePolicy *imagepolicy.Verifier
+
+	// registryMirrors rewrites the names of the pulled images, may be nil.
+	registryMirrors *config.RegistryMirrorsConfig
+
+	// pullScheduler queues and merges the image pulls, may be nil.
+	pullScheduler *pullScheduler
+
+	// pinnedImages are reported as pinned besides the sandbox image, may be
+	// nil.
+	pinnedImages *pinnedImages
+
+	// containerIndex answers the list requests when set.
+	containerIndex *containerIndex
+	// execExits reports the exits of the execs, from the event stream of the
+	// container index when it is set.
+	execExits *execExitWatcher
+
 	// containerCleanupInfos maps container IDs to the `containerCleanupInfo` structs
 	// needed to clean up after containers have been removed.
 	// (see `applyPlatformSpecificDockerConfig` and `performPlatformSpecificContainerCleanup`
@@ -284,6 +377,9 @@ type dockerService struct {
 	containerCleanupInfos map[string]*containerCleanupInfo
 	cleanupInfosLock      sync.RWMutex
 
+	// sandboxGC reconciles sandbox checkpoints with sandbox containers.
+	sandboxGC *sandboxGC
+
 	// runtimeInfoLock sync.RWMutex
 }
 
@@ -304,6 +400,15 @@ func (ds *dockerService) Version(
 	}, nil
 }
 
+// tracerName is the name of the tracer of the CRI request steps.
+const tracerName = "github.com/Mirantis/cri-dockerd/core"
+
+// tracedClient returns the docker client tracing its operations in the span
+// of the request context.
+func (ds *dockerService) tracedClient(ctx context.Context) libdocker.DockerClientInterface {
+	return libdocker.WithContext(ctx, ds.client)
+}
+
 // getDockerVersion gets the version information from docker.
 func (ds *dockerService) getDockerVersion() (*dockertypes.Version, error) {
 	res, err := ds.systemInfoCache.Memoize("docker_version", systemInfoCacheMinTTL, func() (interface{}, error) {
@@ -345,7 +450,7 @@ func (ds *dockerService) getRuntimeHandlers() ([]*runtimeapi.RuntimeHandler, err
 		return nil, err
 	}
 	handlersX, err := ds.systemInfoCache.Memoize("docker_info_handlers", systemInfoCacheMinTTL, func() (interface{}, error) {
-		return getRuntimeHandlers(info)
+		return getRuntimeHandlers(info, ds.runtimeHandlers)
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get runtime handlers: %v", err)
@@ -353,8 +458,12 @@ func (ds *dockerService) getRuntimeHandlers() ([]*runtimeapi.RuntimeHandler, err
 	return handlersX.([]*runtimeapi.RuntimeHandler), nil
 }
 
-func getRuntimeHandlers(info *dockersystem.Info) ([]*runtimeapi.RuntimeHandler, error) {
+func getRuntimeHandlers(
+	info *dockersystem.Info,
+	handlersConfig *config.RuntimeHandlersConfig,
+) ([]*runtimeapi.RuntimeHandler, error) {
 	var handlers []*runtimeapi.RuntimeHandler
+	runtimeFeatures := make(map[string]*runtimeapi.RuntimeHandlerFeatures)
 	for dockerName, dockerRT := range info.Runtimes {
 		var rro bool
 		if kernelSupportsRRO {
@@ -372,6 +481,11 @@ func getRuntimeHandlers(info *dockersystem.Info) ([]*runtimeapi.RuntimeHandler,
 			RecursiveReadOnlyMounts: rro,
 			UserNamespaces:          false, // TODO
 		}
+		runtimeFeatures[dockerName] = features
+		if _, ok := handlersConfig.GetHandlers()[dockerName]; ok {
+			// Reported below with the features of its configuration.
+			continue
+		}
 		handlers = append(handlers, &runtimeapi.RuntimeHandler{
 			Name:     dockerName,
 			Features: features,
@@ -385,6 +499,34 @@ func getRuntimeHandlers(info *dockersystem.Info) ([]*runtimeapi.RuntimeHandler,
 			}, handlers...)
 		}
 	}
+	for name, handlerConfig := range handlersConfig.GetHandlers() {
+		features := &runtimeapi.RuntimeHandlerFeatures{}
+		if detected, ok := runtimeFeatures[handlerConfig.DockerRuntime(name)]; ok {
+			*features = runtimeapi.RuntimeHandlerFeatures{
+				RecursiveReadOnlyMounts: detected.RecursiveReadOnlyMounts,
+				UserNamespaces:          detected.UserNamespaces,
+			}
+		}
+		if f := handlerConfig.Features; f != nil {
+			if f.RecursiveReadOnlyMounts != nil {
+				features.RecursiveReadOnlyMounts = *f.RecursiveReadOnlyMounts
+			}
+			if f.UserNamespaces != nil {
+				features.UserNamespaces = *f.UserNamespaces
+			}
+		}
+		handlers = append(handlers, &runtimeapi.RuntimeHandler{
+			Name:     name,
+			Features: features,
+		})
+		if _, ok := runtimeFeatures[name]; ok && name == info.DefaultRuntime {
+			// The default runtime, skipped above, is also the "" handler.
+			handlers = append(handlers, &runtimeapi.RuntimeHandler{
+				Name:     "",
+				Features: features,
+			})
+		}
+	}
 	// info.Runtimes is unmarshalized as a map in Go, so we cannot preserve the original ordering
 	slices.SortStableFunc(handlers, func(a, b *runtimeapi.RuntimeHandler) int {
 		return cmp.Compare(a.Name, b.Name)
@@ -394,7 +536,7 @@ func getRuntimeHandlers(info *dockersystem.Info) ([]*runtimeapi.RuntimeHandler,
 
 // UpdateRuntimeConfig updates the runtime config. Currently only handles podCIDR updates.
 func (ds *dockerService) UpdateRuntimeConfig(
-	_ context.Context,
+	ctx context.Context,
 	r *runtimeapi.UpdateRuntimeConfigRequest,
 ) (*runtimeapi.UpdateRuntimeConfigResponse, error) {
 	runtimeConfig := r.GetRuntimeConfig()
@@ -402,7 +544,8 @@ func (ds *dockerService) UpdateRuntimeConfig(
 		return &runtimeapi.UpdateRuntimeConfigResponse{}, nil
 	}
 
-	logrus.Infof("Docker cri received runtime config %+v", runtimeConfig)
+	logging.FromContext(ctx).WithField("podCIDR", runtimeConfig.GetNetworkConfig().GetPodCidr()).
+		Info("Received runtime config")
 	if ds.network != nil && runtimeConfig.NetworkConfig.PodCidr != "" {
 		event := make(map[string]interface{})
 		event[network.NET_PLUGIN_EVENT_POD_CIDR_CHANGE_DETAIL_CIDR] = runtimeConfig.NetworkConfig.PodCidr
@@ -415,6 +558,21 @@ func (ds *dockerService) UpdateRuntimeConfig(
 // Start initializes and starts components in dockerService.
 func (ds *dockerService) Start() error {
 	ds.initCleanup()
+	ds.restoreSandboxStates()
+
+	if ds.containerIndex != nil {
+		ds.containerIndex.start()
+	} else {
+		ds.execExits.start(ds.client)
+	}
+	if ds.sandboxGC != nil {
+		ds.sandboxGC.start()
+	}
+	if ds.sandboxCheckPeriod > 0 {
+		go wait.Forever(ds.checkSandboxNetworks, ds.sandboxCheckPeriod)
+	}
+	ds.network.StartGC(&sandboxLister{ds}, ds.networkGCPeriod)
+	go ds.prePullPinnedImages()
 
 	go func() {
 		if err := ds.streamingServer.Start(true); err != nil {
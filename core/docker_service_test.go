@@ -38,6 +38,7 @@ import (
 	containertest "k8s.io/kubernetes/pkg/kubelet/container/testing"
 	clock "k8s.io/utils/clock/testing"
 
+	"github.com/Mirantis/cri-dockerd/config"
 	"github.com/Mirantis/cri-dockerd/libdocker"
 	"github.com/Mirantis/cri-dockerd/network"
 	nettest "github.com/Mirantis/cri-dockerd/network/testing"
@@ -70,7 +71,11 @@ func (ckm *mockCheckpointManager) GetCheckpoint(
 ) error {
 	ckm.lock.Lock()
 	defer ckm.lock.Unlock()
-	*(checkpoint.(*PodSandboxCheckpoint)) = *(ckm.checkpoint[checkpointKey])
+	stored, ok := ckm.checkpoint[checkpointKey]
+	if !ok {
+		return store.ErrCheckpointNotFound
+	}
+	*(checkpoint.(*PodSandboxCheckpoint)) = *stored
 	return nil
 }
 
@@ -119,6 +124,7 @@ func newTestDockerService() (*dockerService, *libdocker.FakeDockerClient, *clock
 		network:             pm,
 		checkpointManager:   ckm,
 		networkReady:        make(map[string]bool),
+		sandboxInstances:    make(map[string]*sandboxInstance),
 		dockerRootDir:       "/docker/root/dir",
 		containerStatsCache: newContainerStatsCache(),
 	}, c, fakeClock
@@ -248,7 +254,7 @@ func TestGetRuntimeHandlers(t *testing.T) {
 		DefaultRuntime: "runc",
 	}
 
-	handlers, err := getRuntimeHandlers(info)
+	handlers, err := getRuntimeHandlers(info, nil)
 	assert.NoError(t, err)
 
 	expectedHandlers := []runtimeapi.RuntimeHandler{
@@ -283,4 +289,26 @@ func TestGetRuntimeHandlers(t *testing.T) {
 		assert.Equal(t, expectedHandlers[i].Features, f.Features)
 		// ignore protobuf fields
 	}
+
+	// The default runtime is still the "" handler when it's configured.
+	userNamespaces := true
+	handlers, err = getRuntimeHandlers(info, &config.RuntimeHandlersConfig{
+		Handlers: map[string]config.RuntimeHandlerConfig{
+			"runc": {Features: &config.RuntimeHandlerFeatures{UserNamespaces: &userNamespaces}},
+		},
+	})
+	assert.NoError(t, err)
+	expectedFeatures := &runtimeapi.RuntimeHandlerFeatures{
+		RecursiveReadOnlyMounts: true,
+		UserNamespaces:          true,
+	}
+	assert.Len(t, handlers, len(expectedHandlers))
+	for i, f := range handlers {
+		assert.Equal(t, expectedHandlers[i].Name, f.Name)
+		if f.Name == "" || f.Name == "runc" {
+			assert.Equal(t, expectedFeatures, f.Features, f.Name)
+		} else {
+			assert.Equal(t, expectedHandlers[i].Features, f.Features, f.Name)
+		}
+	}
 }
@@ -26,13 +26,21 @@ import (
 	"k8s.io/client-go/tools/remotecommand"
 
 	dockercontainer "github.com/docker/docker/api/types/container"
-	"github.com/sirupsen/logrus"
 
 	"github.com/Mirantis/cri-dockerd/libdocker"
 
 	"k8s.io/apimachinery/pkg/util/runtime"
 )
 
+var (
+	// execExitTimeout is how long the process of an exec may keep running
+	// after its streams were closed before its exit code is given up on.
+	execExitTimeout = 30 * time.Second
+	// execExitPollPeriod is the period InspectExec is polled at for the exit
+	// of an exec when the docker events are not available.
+	execExitPollPeriod = 500 * time.Millisecond
+)
+
 type dockerExitError struct {
 	Inspect *dockercontainer.ExecInspect
 }
@@ -71,10 +79,13 @@ func handleResizing(resize <-chan remotecommand.TerminalSize, resizeFunc func(si
 }
 
 // NativeExecHandler executes commands in Docker containers using Docker's exec API.
-type NativeExecHandler struct{}
+type NativeExecHandler struct {
+	// exits reports the exits of the execs from the docker events, if set.
+	exits *execExitWatcher
+}
 
 // ExecInContainer executes the cmd in container using the Docker's exec API
-func (*NativeExecHandler) ExecInContainer(
+func (h *NativeExecHandler) ExecInContainer(
 	ctx context.Context,
 	client libdocker.DockerClientInterface,
 	container *dockertypes.ContainerJSON,
@@ -135,6 +146,10 @@ func (*NativeExecHandler) ExecInContainer(
 		defer cancel()
 	}
 
+	// Watch the exit of the exec from before it starts, not to miss it.
+	exits, stopWatching := h.exits.watch(execObj.ID)
+	defer stopWatching()
+
 	// StartExec is a blocking call, so we need to run it concurrently and catch
 	// its error in a channel
 	execErr := make(chan error, 1)
@@ -151,36 +166,70 @@ func (*NativeExecHandler) ExecInContainer(
 		}
 	}
 
-	// InspectExec may not always return latest state of exec, so call it a few times until
-	// it returns an exec inspect that shows that the process is no longer running.
-	retries := 0
-	maxRetries := 5
-	ticker := time.NewTicker(2 * time.Second)
-	defer ticker.Stop()
+	exitCode, err := waitExecExit(ctx, client, execObj.ID, exits)
+	if err != nil {
+		return fmt.Errorf("failed to get the exit code of exec %s in container %s: %w", execObj.ID, container.ID, err)
+	}
+	if exitCode != 0 {
+		return &dockerExitError{&dockercontainer.ExecInspect{
+			ExecID:      execObj.ID,
+			ContainerID: container.ID,
+			ExitCode:    exitCode,
+		}}
+	}
+	return nil
+}
+
+// waitExecExit returns the exit code of the exec once its process exited,
+// which may be after its streams were closed. The process exit is sent to
+// exits from the exec_die events, InspectExec is polled instead if exits is
+// nil or the exit code isn't in the event.
+func waitExecExit(
+	ctx context.Context,
+	client libdocker.DockerClientInterface,
+	execID string,
+	exits <-chan execExit,
+) (int, error) {
+	deadline := time.NewTimer(execExitTimeout)
+	defer deadline.Stop()
+	var ticker *time.Ticker
+	var poll <-chan time.Time
+	startPolling := func() {
+		if ticker == nil {
+			ticker = time.NewTicker(execExitPollPeriod)
+			poll = ticker.C
+		}
+	}
+	defer func() {
+		if ticker != nil {
+			ticker.Stop()
+		}
+	}()
+	if exits == nil {
+		startPolling()
+	}
 	for {
-		inspect, err := client.InspectExec(execObj.ID)
+		inspect, err := client.InspectExec(execID)
 		if err != nil {
-			return err
+			return 0, err
 		}
-
 		if !inspect.Running {
-			if inspect.ExitCode != 0 {
-				return &dockerExitError{inspect}
-			}
-
-			return nil
+			return inspect.ExitCode, nil
 		}
 
-		retries++
-		if retries == maxRetries {
-			logrus.Errorf(
-				"Exec session in the container terminated but process still running! Session %s | Container %s",
-				execObj.ID,
-				container.ID,
-			)
-			return nil
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-deadline.C:
+			return 0, fmt.Errorf("the process is still running %v after the exec ended", execExitTimeout)
+		case <-poll:
+		case exit := <-exits:
+			if exit.known {
+				return exit.exitCode, nil
+			}
+			// InspectExec reports the exit code once it caught up.
+			exits = nil
+			startPolling()
 		}
-
-		<-ticker.C
 	}
 }
@@ -0,0 +1,144 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Mirantis/cri-dockerd/libdocker"
+	dockerevents "github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// execExit is the exit of the process of an exec, as reported by docker.
+type execExit struct {
+	// exitCode is the exit code of the process, if known.
+	exitCode int
+	// known is false when the exit code isn't in the event, or when the
+	// event may have been missed, and InspectExec has to be asked.
+	known bool
+}
+
+// execExitWatcher dispatches the exec_die events of one docker event stream
+// to the execs waiting for them, so that every exec doesn't subscribe to the
+// events on its own. The stream is the one of the container index when it is
+// enabled.
+type execExitWatcher struct {
+	lock sync.Mutex
+	// watching is true while the event stream is up, the execs poll
+	// InspectExec otherwise.
+	watching bool
+	waiters  map[string]chan execExit
+}
+
+func newExecExitWatcher() *execExitWatcher {
+	return &execExitWatcher{waiters: make(map[string]chan execExit)}
+}
+
+// start keeps a stream of the exec_die events in the background, for when
+// the container index doesn't.
+func (w *execExitWatcher) start(client libdocker.DockerClientInterface) {
+	go wait.Forever(func() {
+		w.run(client)
+		w.setWatching(false)
+	}, time.Second)
+}
+
+func (w *execExitWatcher) run(client libdocker.DockerClientInterface) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	eventFilters := filters.NewArgs(
+		filters.Arg("type", string(dockerevents.ContainerEventType)),
+		filters.Arg("event", string(dockerevents.ActionExecDie)),
+	)
+	messages, errs := client.Events(ctx, dockerevents.ListOptions{Filters: eventFilters})
+	w.setWatching(true)
+	for {
+		select {
+		case msg := <-messages:
+			w.handleEvent(msg)
+		case err := <-errs:
+			logrus.Errorf("Docker event stream of the exec exits failed: %v", err)
+			return
+		}
+	}
+}
+
+// watch returns the channel the exit of the exec is sent to, nil if the
+// events aren't watched, and the function to call once it isn't waited for
+// anymore. It must be called before the exec is started.
+func (w *execExitWatcher) watch(execID string) (<-chan execExit, func()) {
+	if w == nil {
+		return nil, func() {}
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if !w.watching {
+		return nil, func() {}
+	}
+	exits := make(chan execExit, 1)
+	w.waiters[execID] = exits
+	return exits, func() {
+		w.lock.Lock()
+		defer w.lock.Unlock()
+		delete(w.waiters, execID)
+	}
+}
+
+// setWatching records whether the event stream is up. The execs waiting for
+// an exit when it goes down are told to poll InspectExec, since their event
+// may be missed.
+func (w *execExitWatcher) setWatching(watching bool) {
+	if w == nil {
+		return
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.watching = watching
+	if watching {
+		return
+	}
+	for execID, exits := range w.waiters {
+		exits <- execExit{}
+		delete(w.waiters, execID)
+	}
+}
+
+// handleEvent sends the exit of an exec_die event to the exec waiting for
+// it, if any.
+func (w *execExitWatcher) handleEvent(msg dockerevents.Message) {
+	if w == nil || msg.Action != dockerevents.ActionExecDie {
+		return
+	}
+	execID := msg.Actor.Attributes["execID"]
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	exits, ok := w.waiters[execID]
+	if !ok {
+		return
+	}
+	delete(w.waiters, execID)
+	// Docker before 20.10 doesn't report the exit code in the event.
+	exitCode, err := strconv.Atoi(msg.Actor.Attributes["exitCode"])
+	exits <- execExit{exitCode: exitCode, known: err == nil}
+}
@@ -18,6 +18,7 @@ package core
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"testing"
@@ -25,10 +26,13 @@ import (
 
 	dockertypes "github.com/docker/docker/api/types"
 	dockercontainer "github.com/docker/docker/api/types/container"
+	dockerevents "github.com/docker/docker/api/types/events"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"k8s.io/client-go/tools/remotecommand"
 
+	"github.com/Mirantis/cri-dockerd/libdocker"
 	mockclient "github.com/Mirantis/cri-dockerd/libdocker/testing"
 )
 
@@ -85,7 +89,9 @@ func TestExecInContainer(t *testing.T) {
 		returnStartExec:    nil,
 		returnInspectExec1: nil,
 		returnInspectExec2: fmt.Errorf("error in InspectExec()"),
-		expectError:        fmt.Errorf("error in InspectExec()"),
+		expectError: fmt.Errorf(
+			"failed to get the exit code of exec 12345678 in container 12345678: error in InspectExec()",
+		),
 	}, {
 		description:       "ExecInContainer returns context DeadlineExceeded",
 		timeout:           1 * time.Second,
@@ -146,7 +152,138 @@ func TestExecInContainer(t *testing.T) {
 			resize,
 			tc.timeout,
 		)
-		assert.Equal(t, tc.expectError, err)
+		if tc.expectError == nil {
+			assert.NoError(t, err)
+		} else {
+			// The errors of waiting for the exit are wrapped.
+			assert.EqualError(t, err, tc.expectError.Error())
+		}
+	}
+}
+
+func TestExecInContainerExit(t *testing.T) {
+	defer func(timeout, period time.Duration) {
+		execExitTimeout, execExitPollPeriod = timeout, period
+	}(execExitTimeout, execExitPollPeriod)
+	execExitTimeout = time.Second
+	execExitPollPeriod = 10 * time.Millisecond
+
+	running := &dockercontainer.ExecInspect{ExecID: "12345678", ContainerID: "12345678", Running: true}
+	exited := &dockercontainer.ExecInspect{ExecID: "12345678", ContainerID: "12345678", ExitCode: 3}
+	exitEvent := func(execID, exitCode string) dockerevents.Message {
+		attributes := map[string]string{"execID": execID}
+		if exitCode != "" {
+			attributes["exitCode"] = exitCode
+		}
+		return dockerevents.Message{
+			Type:   dockerevents.ContainerEventType,
+			Action: dockerevents.ActionExecDie,
+			Actor:  dockerevents.Actor{ID: "12345678", Attributes: attributes},
+		}
+	}
+
+	testcases := []struct {
+		description  string
+		inspects     []*dockercontainer.ExecInspect
+		events       []dockerevents.Message
+		streamFails  bool
+		notWatching  bool
+		timeout      time.Duration
+		expectedCode int
+		expectError  error
+	}{{
+		description:  "exit code of the exec_die event",
+		inspects:     []*dockercontainer.ExecInspect{running},
+		events:       []dockerevents.Message{exitEvent("87654321", "1"), exitEvent("12345678", "3")},
+		expectedCode: 3,
+	}, {
+		description:  "exit code of InspectExec after an exec_die event without one",
+		inspects:     []*dockercontainer.ExecInspect{running, running, exited},
+		events:       []dockerevents.Message{exitEvent("12345678", "")},
+		expectedCode: 3,
+	}, {
+		description:  "polling InspectExec when the event stream fails",
+		inspects:     []*dockercontainer.ExecInspect{running, running, exited},
+		streamFails:  true,
+		expectedCode: 3,
+	}, {
+		description:  "polling InspectExec when the events aren't watched",
+		inspects:     []*dockercontainer.ExecInspect{running, running, exited},
+		notWatching:  true,
+		expectedCode: 3,
+	}, {
+		description: "process still running",
+		inspects:    []*dockercontainer.ExecInspect{running},
+		expectError: fmt.Errorf("the process is still running"),
+	}, {
+		description: "timeout while the process is still running",
+		inspects:    []*dockercontainer.ExecInspect{running},
+		timeout:     100 * time.Millisecond,
+		expectError: context.DeadlineExceeded,
+	}}
+
+	ctrl := gomock.NewController(t)
+	for _, tc := range testcases {
+		t.Run(tc.description, func(t *testing.T) {
+			exits := newExecExitWatcher()
+			exits.setWatching(!tc.notWatching)
+			eh := &NativeExecHandler{exits: exits}
+			mockClient := mockclient.NewMockDockerClientInterface(ctrl)
+			mockClient.EXPECT().CreateExec(gomock.Any(), gomock.Any()).Return(
+				&dockertypes.IDResponse{ID: "12345678"}, nil)
+			// The exec exits once it is started.
+			mockClient.EXPECT().StartExec(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+				func(string, dockercontainer.ExecStartOptions, libdocker.StreamOptions) error {
+					for _, event := range tc.events {
+						exits.handleEvent(event)
+					}
+					if tc.streamFails {
+						exits.setWatching(false)
+					}
+					return nil
+				})
+			inspects := tc.inspects
+			mockClient.EXPECT().InspectExec("12345678").DoAndReturn(
+				func(string) (*dockercontainer.ExecInspect, error) {
+					inspect := inspects[0]
+					if len(inspects) > 1 {
+						inspects = inspects[1:]
+					}
+					return inspect, nil
+				}).AnyTimes()
+
+			timeout := tc.timeout
+			if timeout == 0 {
+				timeout = time.Minute
+			}
+			err := eh.ExecInContainer(
+				context.Background(),
+				mockClient,
+				getFakeContainerJSON(),
+				[]string{"/bin/bash"},
+				nil,
+				nil,
+				nil,
+				false,
+				nil,
+				timeout,
+			)
+			if tc.expectError != nil {
+				require.Error(t, err)
+				if errors.Is(tc.expectError, context.DeadlineExceeded) {
+					// ExecSync reports it as such to the kubelet.
+					assert.ErrorIs(t, err, context.DeadlineExceeded)
+				} else {
+					assert.Contains(t, err.Error(), tc.expectError.Error())
+				}
+				return
+			}
+			exitErr, ok := err.(*dockerExitError)
+			if assert.True(t, ok, "unexpected error %v", err) {
+				assert.Equal(t, tc.expectedCode, exitErr.ExitStatus())
+			}
+			assert.Empty(t, exits.waiters)
+		})
 	}
 }
 
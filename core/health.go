@@ -0,0 +1,87 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	// maxStatsCollectionStaleness is how long the writable layer collection
+	// may go without making progress before cri-dockerd is unhealthy.
+	maxStatsCollectionStaleness = 10 * time.Minute
+	// streamingDialTimeout bounds the connection to the streaming server.
+	streamingDialTimeout = time.Second
+)
+
+// HealthCheck checks a part of cri-dockerd, for the health endpoints and the
+// systemd watchdog.
+type HealthCheck struct {
+	// Name identifies the check in the reports.
+	Name string
+	// ReadinessOnly checks fail when the node can't run pods but cri-dockerd
+	// works, e.g. while dockerd restarts. They don't make it unhealthy, nor
+	// starve the systemd watchdog.
+	ReadinessOnly bool
+	// Check returns why the check fails.
+	Check func() error
+}
+
+// HealthChecks returns the health checks of the docker service.
+func (ds *dockerService) HealthChecks() []HealthCheck {
+	checks := []HealthCheck{
+		{Name: "docker", ReadinessOnly: true, Check: ds.checkDockerConnection},
+		{Name: "docker-version", ReadinessOnly: true, Check: ds.checkVersionCompatibility},
+		{Name: "network", ReadinessOnly: true, Check: ds.network.Status},
+		{Name: "stats", Check: ds.checkStatsCollection},
+	}
+	if ds.streamingServer != nil {
+		checks = append(checks, HealthCheck{Name: "streaming", Check: ds.checkStreamingServer})
+	}
+	return checks
+}
+
+// checkDockerConnection asks dockerd for its version, bypassing the cache.
+func (ds *dockerService) checkDockerConnection() error {
+	if _, err := ds.client.Version(); err != nil {
+		return fmt.Errorf("failed to get docker version from dockerd: %v", err)
+	}
+	return nil
+}
+
+// checkStreamingServer connects to the streaming server.
+func (ds *dockerService) checkStreamingServer() error {
+	addr := ds.streamingServer.Addr()
+	if addr == "" {
+		return fmt.Errorf("the streaming server is not listening yet")
+	}
+	conn, err := net.DialTimeout("tcp", addr, streamingDialTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect to the streaming server: %v", err)
+	}
+	return conn.Close()
+}
+
+// checkStatsCollection checks that the writable layer collection isn't stuck.
+func (ds *dockerService) checkStatsCollection() error {
+	if staleness := time.Since(ds.containerStatsCache.lastProgressTime()); staleness > maxStatsCollectionStaleness {
+		return fmt.Errorf("the container stats collection last ran %v ago", staleness.Round(time.Second))
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthChecks(t *testing.T) {
+	ds, fakeDocker, _ := newTestDockerService()
+	checks := make(map[string]HealthCheck)
+	for _, check := range ds.HealthChecks() {
+		checks[check.Name] = check
+	}
+	assert.True(t, checks["network"].ReadinessOnly)
+	// The systemd watchdog must not restart cri-dockerd while dockerd is down.
+	assert.True(t, checks["docker"].ReadinessOnly)
+	assert.True(t, checks["docker-version"].ReadinessOnly)
+	assert.False(t, checks["stats"].ReadinessOnly)
+
+	assert.NoError(t, checks["docker"].Check())
+	fakeDocker.InjectError("version", fmt.Errorf("connection refused"))
+	assert.ErrorContains(t, checks["docker"].Check(), "connection refused")
+
+	assert.NoError(t, checks["stats"].Check())
+	ds.containerStatsCache.recordProgress(time.Now().Add(-time.Hour))
+	assert.ErrorContains(t, checks["stats"].Check(), "stats collection last ran 1h0m0s ago")
+
+	// Finishing a measurement is progress, even within a long pass.
+	due := ds.containerStatsCache.sync([]string{"c1"}, time.Now())
+	require.Len(t, due, 1)
+	ds.containerStatsCache.update(due[0], 1, nil, time.Now())
+	assert.NoError(t, checks["stats"].Check())
+}
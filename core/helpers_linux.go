@@ -20,6 +20,7 @@ limitations under the License.
 package core
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/blang/semver"
@@ -85,7 +86,7 @@ func (ds *dockerService) updateCreateConfig(
 	return nil
 }
 
-func (ds *dockerService) determinePodIPBySandboxID(uid string) []string {
+func (ds *dockerService) determinePodIPBySandboxID(_ context.Context, uid string) []string {
 	return nil
 }
 
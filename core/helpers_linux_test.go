@@ -62,7 +62,7 @@ func TestGetSeccompSecurityOpts(t *testing.T) {
 	}}
 
 	for i, test := range tests {
-		opts, err := getSeccompSecurityOpts(test.seccompProfile, false, '=')
+		opts, err := getSeccompSecurityOpts(getTestCTX(), test.seccompProfile, false, '=')
 		assert.NoError(t, err, "TestCase[%d]: %s", i, test.msg)
 		assert.Len(t, opts, len(test.expectedOpts), "TestCase[%d]: %s", i, test.msg)
 		for _, opt := range test.expectedOpts {
@@ -139,7 +139,7 @@ func TestLoadSeccompLocalhostProfiles(t *testing.T) {
 	}}
 
 	for i, test := range tests {
-		opts, err := getSeccompSecurityOpts(test.seccompProfile, test.privileged, '=')
+		opts, err := getSeccompSecurityOpts(getTestCTX(), test.seccompProfile, test.privileged, '=')
 		if test.expectErr {
 			assert.Error(t, err, fmt.Sprintf("TestCase[%d]: %s", i, test.msg))
 			continue
@@ -20,7 +20,11 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/Mirantis/cri-dockerd/config"
@@ -35,6 +39,7 @@ import (
 
 	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
 	crierrors "k8s.io/cri-api/pkg/errors"
+	"k8s.io/kubernetes/pkg/credentialprovider/plugin"
 )
 
 func TestLabelsAndAnnotationsRoundTrip(t *testing.T) {
@@ -50,16 +55,37 @@ func TestLabelsAndAnnotationsRoundTrip(t *testing.T) {
 
 // TestGetApparmorSecurityOpts tests the logic of generating container apparmor options from sandbox annotations.
 func TestGetApparmorSecurityOpts(t *testing.T) {
+	profilesPath := filepath.Join(t.TempDir(), "profiles")
+	require.NoError(t, os.WriteFile(
+		profilesPath,
+		[]byte("docker-default (enforce)\nfoo (enforce)\nbar baz (complain)\n"),
+		0o644,
+	))
+	defer func(path string) { apparmorProfilesPath = path }(apparmorProfilesPath)
+	apparmorProfilesPath = profilesPath
+
 	makeConfig := func(profile string) *runtimeapi.LinuxContainerSecurityContext {
 		return &runtimeapi.LinuxContainerSecurityContext{
 			ApparmorProfile: profile,
 		}
 	}
+	makeProfileConfig := func(
+		profileType runtimeapi.SecurityProfile_ProfileType,
+		localhostRef string,
+	) *runtimeapi.LinuxContainerSecurityContext {
+		return &runtimeapi.LinuxContainerSecurityContext{
+			Apparmor: &runtimeapi.SecurityProfile{
+				ProfileType:  profileType,
+				LocalhostRef: localhostRef,
+			},
+		}
+	}
 
 	tests := []struct {
 		msg          string
 		config       *runtimeapi.LinuxContainerSecurityContext
 		expectedOpts []string
+		expectErr    bool
 	}{{
 		msg:          "No AppArmor options",
 		config:       makeConfig(""),
@@ -72,16 +98,58 @@ func TestGetApparmorSecurityOpts(t *testing.T) {
 		msg:          "AppArmor local profile",
 		config:       makeConfig(config.AppArmorBetaProfileNamePrefix + "foo"),
 		expectedOpts: []string{"apparmor=foo"},
+	}, {
+		msg:       "AppArmor local profile not loaded",
+		config:    makeConfig(config.AppArmorBetaProfileNamePrefix + "missing"),
+		expectErr: true,
+	}, {
+		msg:          "AppArmor RuntimeDefault profile",
+		config:       makeProfileConfig(runtimeapi.SecurityProfile_RuntimeDefault, ""),
+		expectedOpts: nil,
+	}, {
+		msg:          "AppArmor Unconfined profile",
+		config:       makeProfileConfig(runtimeapi.SecurityProfile_Unconfined, ""),
+		expectedOpts: []string{"apparmor=unconfined"},
+	}, {
+		msg:          "AppArmor Localhost profile",
+		config:       makeProfileConfig(runtimeapi.SecurityProfile_Localhost, "bar baz"),
+		expectedOpts: []string{"apparmor=bar baz"},
+	}, {
+		msg: "AppArmor Localhost profile over the deprecated profile",
+		config: &runtimeapi.LinuxContainerSecurityContext{
+			Apparmor: &runtimeapi.SecurityProfile{
+				ProfileType:  runtimeapi.SecurityProfile_Localhost,
+				LocalhostRef: "foo",
+			},
+			ApparmorProfile: config.AppArmorBetaProfileNameUnconfined,
+		},
+		expectedOpts: []string{"apparmor=foo"},
+	}, {
+		msg:       "AppArmor Localhost profile not loaded",
+		config:    makeProfileConfig(runtimeapi.SecurityProfile_Localhost, "docker"),
+		expectErr: true,
+	}, {
+		msg:       "AppArmor Localhost profile without a name",
+		config:    makeProfileConfig(runtimeapi.SecurityProfile_Localhost, ""),
+		expectErr: true,
 	}}
 
 	for i, test := range tests {
 		opts, err := getApparmorSecurityOpts(test.config, '=')
+		if test.expectErr {
+			assert.Error(t, err, "TestCase[%d]: %s", i, test.msg)
+			continue
+		}
 		assert.NoError(t, err, "TestCase[%d]: %s", i, test.msg)
 		assert.Len(t, opts, len(test.expectedOpts), "TestCase[%d]: %s", i, test.msg)
 		for _, opt := range test.expectedOpts {
 			assert.Contains(t, opts, opt, "TestCase[%d]: %s", i, test.msg)
 		}
 	}
+
+	apparmorProfilesPath = filepath.Join(t.TempDir(), "missing")
+	_, err := getApparmorSecurityOpts(makeProfileConfig(runtimeapi.SecurityProfile_Localhost, "foo"), '=')
+	assert.Error(t, err, "AppArmor not enabled on the node")
 }
 
 // TestGetUserFromImageUser tests the logic of getting image uid or user name of image user.
@@ -177,7 +245,7 @@ func TestEnsureSandboxImageExists(t *testing.T) {
 		},
 	} {
 		t.Logf("TestCase: %q", desc)
-		_, fakeDocker, _ := newTestDockerService()
+		ds, fakeDocker, _ := newTestDockerService()
 		if test.injectImage {
 			images := []dockerimage.Summary{{ID: sandboxImage}}
 			fakeDocker.InjectImages(images)
@@ -187,12 +255,75 @@ func TestEnsureSandboxImageExists(t *testing.T) {
 		}
 		fakeDocker.InjectError("inspect_image", test.injectErr)
 
-		err := ensureSandboxImageExists(fakeDocker, sandboxImage)
+		err := ds.ensureSandboxImageExists(getTestCTX(), sandboxImage)
 		assert.NoError(t, fakeDocker.AssertCalls(test.calls))
 		assert.Equal(t, test.err, err != nil)
 	}
 }
 
+// credentialProviderTestRuns counts the runs of
+// TestEnsureSandboxImageExistsWithCredentialProvider. The credential provider
+// plugins are registered for the whole process and can't be registered twice,
+// so every run registers a plugin of its own, for a registry of its own not to
+// be used by the other tests.
+var credentialProviderTestRuns atomic.Int32
+
+// TestEnsureSandboxImageExistsWithCredentialProvider checks that the
+// credentials of the registered credential provider plugins are used to pull
+// the sandbox image, and cached as the plugin asks.
+func TestEnsureSandboxImageExistsWithCredentialProvider(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the stub plugin is a shell script")
+	}
+	run := credentialProviderTestRuns.Add(1)
+	providerName := fmt.Sprintf("stub-provider-%d", run)
+	registry := fmt.Sprintf("registry-%d.example.com", run)
+	sandboxImage := registry + "/pause:3.10"
+	binDir := t.TempDir()
+	callsFile := filepath.Join(binDir, "calls")
+	stubPlugin := `#!/bin/sh
+cat >/dev/null
+echo call >>"$CALLS_FILE"
+echo '{
+  "kind": "CredentialProviderResponse",
+  "apiVersion": "credentialprovider.kubelet.k8s.io/v1",
+  "cacheKeyType": "Registry",
+  "cacheDuration": "1h",
+  "auth": {"%s": {"username": "user", "password": "pass"}}
+}'
+`
+	stubPlugin = fmt.Sprintf(stubPlugin, registry)
+	require.NoError(t, os.WriteFile(filepath.Join(binDir, providerName), []byte(stubPlugin), 0o755))
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	pluginConfig := fmt.Sprintf(`apiVersion: kubelet.config.k8s.io/v1
+kind: CredentialProviderConfig
+providers:
+- name: %s
+  apiVersion: credentialprovider.kubelet.k8s.io/v1
+  matchImages: [%q]
+  defaultCacheDuration: 1m
+  env:
+  - name: CALLS_FILE
+    value: %s
+`, providerName, registry, callsFile)
+	require.NoError(t, os.WriteFile(configFile, []byte(pluginConfig), 0o644))
+	require.NoError(t, plugin.RegisterCredentialProviderPlugins(configFile, binDir))
+
+	for i := 0; i < 2; i++ {
+		ds, fakeDocker, _ := newTestDockerService()
+		images := []dockerimage.Summary{{ID: sandboxImage}}
+		fakeDocker.InjectImages(images)
+		fakeDocker.MakeImagesPrivate(images, dockerregistry.AuthConfig{Username: "user", Password: "pass"})
+		fakeDocker.InjectError("inspect_image", libdocker.ImageNotFoundError{ID: sandboxImage})
+
+		require.NoError(t, ds.ensureSandboxImageExists(getTestCTX(), sandboxImage))
+		assert.NoError(t, fakeDocker.AssertCalls([]string{"inspect_image", "pull"}))
+	}
+	calls, err := os.ReadFile(callsFile)
+	require.NoError(t, err)
+	assert.Equal(t, "call\n", string(calls), "credentials not cached")
+}
+
 func TestMakePortsAndBindings(t *testing.T) {
 	for desc, test := range map[string]struct {
 		pm           []*runtimeapi.PortMapping
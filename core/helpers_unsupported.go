@@ -20,6 +20,7 @@ limitations under the License.
 package core
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/blang/semver"
@@ -27,6 +28,8 @@ import (
 	dockerbackend "github.com/docker/docker/api/types/backend"
 	"github.com/sirupsen/logrus"
 	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"github.com/Mirantis/cri-dockerd/utils/logging"
 )
 
 // DefaultMemorySwap always returns -1 for no memory swap in a sandbox
@@ -35,10 +38,11 @@ func DefaultMemorySwap() int64 {
 }
 
 func (ds *dockerService) getSecurityOpts(
+	ctx context.Context,
 	seccompProfile *runtimeapi.SecurityProfile, privileged bool,
 	separator rune,
 ) ([]string, error) {
-	logrus.Info("getSecurityOpts is unsupported in this build")
+	logging.FromContext(ctx).Info("getSecurityOpts is unsupported in this build")
 	return nil, nil
 }
 
@@ -56,8 +60,8 @@ func (ds *dockerService) updateCreateConfig(
 	return nil
 }
 
-func (ds *dockerService) determinePodIPBySandboxID(uid string) []string {
-	logrus.Info("determinePodIPBySandboxID is unsupported in this build")
+func (ds *dockerService) determinePodIPBySandboxID(ctx context.Context, uid string) []string {
+	logging.FromContext(ctx).Info("determinePodIPBySandboxID is unsupported in this build")
 	return nil
 }
 
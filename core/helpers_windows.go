@@ -20,6 +20,7 @@ limitations under the License.
 package core
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
@@ -79,7 +80,7 @@ func (ds *dockerService) updateCreateConfig(
 	return nil
 }
 
-func (ds *dockerService) determinePodIPBySandboxID(sandboxID string) []string {
+func (ds *dockerService) determinePodIPBySandboxID(ctx context.Context, sandboxID string) []string {
 	opts := dockercontainer.ListOptions{
 		All:     true,
 		Filters: dockerfilters.NewArgs(),
@@ -126,10 +127,10 @@ func (ds *dockerService) determinePodIPBySandboxID(sandboxID string) []string {
 			// Windows 1709 and 1803 doesn't have the Namespace support, so getIP() is called
 			// to replicate the DNS registry key to the Workload container (IP/Gateway/MAC is
 			// set separately than DNS).
-			ds.getIPs(sandboxID, r)
+			ds.getIPs(ctx, sandboxID, r)
 		} else {
 			// ds.getIP will call the CNI plugin to fetch the IP
-			if containerIPs := ds.getIPs(c.ID, r); len(containerIPs) != 0 {
+			if containerIPs := ds.getIPs(ctx, c.ID, r); len(containerIPs) != 0 {
 				return containerIPs
 			}
 		}
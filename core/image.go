@@ -28,10 +28,12 @@ import (
 	dockerregistry "github.com/docker/docker/api/types/registry"
 	"github.com/docker/docker/pkg/jsonmessage"
 
-	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
 
 	"github.com/Mirantis/cri-dockerd/libdocker"
+	"github.com/Mirantis/cri-dockerd/utils/logging"
 )
 
 // This file implements methods in ImageManagerService.
@@ -45,20 +47,9 @@ func (ds *dockerService) sandboxImage() string {
 	return image
 }
 
-func isPinned(image string, tags []string) bool {
-	pinned := false
-	for _, tag := range tags {
-		if tag == image {
-			pinned = true
-			break
-		}
-	}
-	return pinned
-}
-
 // ListImages lists existing images.
 func (ds *dockerService) ListImages(
-	_ context.Context,
+	ctx context.Context,
 	r *runtimeapi.ListImagesRequest,
 ) (*runtimeapi.ListImagesResponse, error) {
 	filter := r.GetFilter()
@@ -74,16 +65,16 @@ func (ds *dockerService) ListImages(
 	if err != nil {
 		return nil, err
 	}
-	image := ds.sandboxImage()
-
 	result := make([]*runtimeapi.Image, 0, len(images))
 	for _, i := range images {
-		pinned := isPinned(image, i.RepoTags)
+		pinned := ds.isImagePinned(i.RepoTags, i.RepoDigests)
 		apiImage, err := imageToRuntimeAPIImage(&i, pinned)
 		if err != nil {
-			logrus.Infof("Failed to convert docker API image %v to runtime API image: %v", i, err)
+			logging.FromContext(ctx).WithError(err).WithField(logging.ImageKey, i.ID).
+				Info("Failed to convert docker API image to runtime API image")
 			continue
 		}
+		apiImage.RepoDigests = originalRepoDigests(ds.registryMirrors, apiImage.RepoDigests)
 		result = append(result, apiImage)
 	}
 	return &runtimeapi.ListImagesResponse{Images: result}, nil
@@ -102,6 +93,9 @@ func (ds *dockerService) ImageStatus(
 			return nil, err
 		}
 		imageInspect, err = ds.client.InspectImageByID(image.Image)
+		if libdocker.IsImageNotFoundError(err) {
+			imageInspect, err = inspectMirroredImage(ds.client, ds.registryMirrors, image.Image)
+		}
 		if err != nil {
 			if libdocker.IsImageNotFoundError(err) {
 				return &runtimeapi.ImageStatusResponse{}, nil
@@ -110,11 +104,12 @@ func (ds *dockerService) ImageStatus(
 		}
 	}
 
-	pinned := isPinned(ds.sandboxImage(), imageInspect.RepoTags)
+	pinned := ds.isImagePinned(imageInspect.RepoTags, imageInspect.RepoDigests)
 	imageStatus, err := imageInspectToRuntimeAPIImage(imageInspect, pinned)
 	if err != nil {
 		return nil, err
 	}
+	imageStatus.RepoDigests = originalRepoDigests(ds.registryMirrors, imageStatus.RepoDigests)
 
 	res := runtimeapi.ImageStatusResponse{Image: imageStatus}
 	if r.GetVerbose() {
@@ -133,7 +128,7 @@ func (ds *dockerService) ImageStatus(
 
 // PullImage pulls an image with authentication config.
 func (ds *dockerService) PullImage(
-	_ context.Context,
+	ctx context.Context,
 	r *runtimeapi.PullImageRequest,
 ) (*runtimeapi.PullImageResponse, error) {
 	image := r.GetImage()
@@ -147,18 +142,48 @@ func (ds *dockerService) PullImage(
 		authConfig.IdentityToken = auth.IdentityToken
 		authConfig.RegistryToken = auth.RegistryToken
 	}
-	err := ds.client.PullImage(image.Image,
-		authConfig,
-		dockerimage.PullOptions{},
-	)
+	verifiedDigest, err := ds.verifyImageSignature(ctx, image.Image, authConfig)
+	if err != nil {
+		return nil, err
+	}
+	// The image whose signatures were verified is pulled by digest, so that
+	// moving the tag meanwhile can't swap it for another one.
+	pullRef, err := pinnedImageReference(image.Image, verifiedDigest)
+	if err != nil {
+		return nil, err
+	}
+	key, priority := pullKey(pullRef, authConfig), pullPriorityFor(r.GetSandboxConfig())
+	pulledImage, err := ds.pullScheduler.schedule(ctx, key, priority, func() (string, error) {
+		return pullImageFromMirrors(ctx, ds.client, ds.registryMirrors, pullRef, func(candidate string) error {
+			if candidate != pullRef {
+				// The credentials of the original registry aren't sent to mirrors.
+				return pullImageWithKeyring(ctx, ds.client, candidate)
+			}
+			return ds.tracedClient(ctx).PullImage(pullRef,
+				authConfig,
+				dockerimage.PullOptions{},
+			)
+		})
+	})
 	if err != nil {
 		return nil, filterHTTPError(err, image.Image)
 	}
+	if err := ds.checkPulledImageDigest(ctx, image.Image, pulledImage, verifiedDigest); err != nil {
+		return nil, err
+	}
+	if pullRef != image.Image {
+		if err := ds.client.TagImage(pulledImage, image.Image); err != nil {
+			return nil, fmt.Errorf("failed to tag verified image %q as %q: %v", pulledImage, image.Image, err)
+		}
+		pulledImage = image.Image
+	}
 
-	imageRef, err := getImageRef(ds.client, image.Image)
+	imageRef, err := getImageRef(ds.client, pulledImage)
 	if err != nil {
 		return nil, err
 	}
+	// Images pulled from a mirror are reported under their original name.
+	imageRef = originalRepoDigest(ds.registryMirrors, imageRef)
 
 	return &runtimeapi.PullImageResponse{ImageRef: imageRef}, nil
 }
@@ -184,6 +209,16 @@ func (ds *dockerService) RemoveImage(
 		return &runtimeapi.RemoveImageResponse{}, nil
 	}
 
+	pinned := ds.isImagePinned(imageInspect.RepoTags, imageInspect.RepoDigests)
+	if pinned && image.GetAnnotations()[forceRemoveImageAnnotation] != "true" {
+		return nil, status.Errorf(
+			codes.FailedPrecondition,
+			"image %q is pinned, set annotation %s=true to remove it",
+			image.Image,
+			forceRemoveImageAnnotation,
+		)
+	}
+
 	// An image can have different numbers of RepoTags and RepoDigests.
 	// Iterating over both of them plus the image ID ensures the image really got removed.
 	// It also prevents images from being deleted, which actually are deletable using this approach.
@@ -0,0 +1,152 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/distribution/reference"
+	dockertypes "github.com/docker/docker/api/types"
+	dockerimage "github.com/docker/docker/api/types/image"
+	"k8s.io/apimachinery/pkg/util/errors"
+
+	"github.com/Mirantis/cri-dockerd/config"
+	"github.com/Mirantis/cri-dockerd/libdocker"
+	"github.com/Mirantis/cri-dockerd/utils/logging"
+)
+
+// pullImageFromMirrors pulls the image from the mirrors of the rewrite rules
+// in order, falling back to the image itself unless the rule skips it. It
+// returns the name the pulled image is found under. Images pulled by tag from
+// a mirror are tagged with the original name, so that they keep being found
+// under it.
+func pullImageFromMirrors(
+	ctx context.Context,
+	client libdocker.DockerClientInterface,
+	mirrors *config.RegistryMirrorsConfig,
+	image string,
+	pull func(candidate string) error,
+) (string, error) {
+	candidates := mirrors.PullCandidates(image)
+	if len(candidates) == 1 && candidates[0] == image {
+		return image, pull(image)
+	}
+
+	var pullErrs []error
+	for _, candidate := range candidates {
+		err := pull(candidate)
+		if err != nil {
+			logging.FromContext(ctx).WithError(err).WithField(logging.ImageKey, image).
+				Infof("Failed to pull image as %q", candidate)
+			pullErrs = append(pullErrs, err)
+			continue
+		}
+		if candidate == image {
+			return image, nil
+		}
+		return tagMirroredImage(ctx, client, candidate, image)
+	}
+	return "", fmt.Errorf("failed to pull image %q from any mirror: %v", image, errors.NewAggregate(pullErrs))
+}
+
+// tagMirroredImage tags the image pulled from a mirror with its original
+// name, and removes the mirror tag. It returns the name the image is found
+// under.
+func tagMirroredImage(
+	ctx context.Context,
+	client libdocker.DockerClientInterface,
+	mirrored, image string,
+) (string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := named.(reference.Digested); ok {
+		// Digests can't be tagged, the image is found by the digest of the
+		// mirror instead.
+		return mirrored, nil
+	}
+	if err := client.TagImage(mirrored, image); err != nil {
+		return "", fmt.Errorf("failed to tag image %q pulled from mirror as %q: %v", image, mirrored, err)
+	}
+	if _, err := client.RemoveImage(mirrored, dockerimage.RemoveOptions{}); err != nil {
+		logging.FromContext(ctx).WithError(err).WithField(logging.ImageKey, image).
+			Infof("Failed to remove the mirror tag %q", mirrored)
+	}
+	return image, nil
+}
+
+// inspectMirroredImage inspects the image under the names of its mirrors, for
+// the images pulled by digest from a mirror.
+func inspectMirroredImage(
+	client libdocker.DockerClientInterface,
+	mirrors *config.RegistryMirrorsConfig,
+	image string,
+) (*dockertypes.ImageInspect, error) {
+	for _, candidate := range mirrors.PullCandidates(image) {
+		if candidate == image {
+			continue
+		}
+		inspect, err := client.InspectImageByRef(candidate)
+		if err == nil {
+			return inspect, nil
+		}
+		if !libdocker.IsImageNotFoundError(err) {
+			return nil, err
+		}
+	}
+	return nil, libdocker.ImageNotFoundError{ID: image}
+}
+
+// originalRepoDigests adds the original names of the repo digests of images
+// pulled from mirrors to them.
+func originalRepoDigests(mirrors *config.RegistryMirrorsConfig, repoDigests []string) []string {
+	if mirrors == nil {
+		return repoDigests
+	}
+	result := repoDigests
+	for _, repoDigest := range repoDigests {
+		original := originalRepoDigest(mirrors, repoDigest)
+		if !slices.Contains(result, original) {
+			result = append(result[:len(result):len(result)], original)
+		}
+	}
+	return result
+}
+
+// originalRepoDigest returns the original name of the repo digest of an
+// image pulled from a mirror, the repo digest itself otherwise. Image IDs are
+// returned as is.
+func originalRepoDigest(mirrors *config.RegistryMirrorsConfig, repoDigest string) string {
+	if mirrors == nil {
+		return repoDigest
+	}
+	named, err := reference.ParseNormalizedNamed(repoDigest)
+	if err != nil {
+		return repoDigest
+	}
+	original := mirrors.OriginalName(named.String())
+	if original == named.String() {
+		return repoDigest
+	}
+	if originalNamed, err := reference.ParseNormalizedNamed(original); err == nil {
+		original = reference.FamiliarString(originalNamed)
+	}
+	return original
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	dockerimage "github.com/docker/docker/api/types/image"
+	dockerregistry "github.com/docker/docker/api/types/registry"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"github.com/Mirantis/cri-dockerd/config"
+	"github.com/Mirantis/cri-dockerd/libdocker"
+	"github.com/Mirantis/cri-dockerd/utils/logging"
+)
+
+func newTestRegistryMirrors() *config.RegistryMirrorsConfig {
+	return &config.RegistryMirrorsConfig{
+		Rewrites: []config.ImageRewrite{
+			{
+				Prefix:  "docker.io",
+				Mirrors: []string{"mirror.example.com/dockerhub", "backup.example.com/dockerhub"},
+			},
+			{
+				Prefix:       "registry.k8s.io/pause",
+				Mirrors:      []string{"mirror.example.com/k8s/pause"},
+				SkipOriginal: true,
+			},
+		},
+	}
+}
+
+func TestPullCandidates(t *testing.T) {
+	mirrors := newTestRegistryMirrors()
+	for image, expected := range map[string][]string{
+		"busybox": {
+			"mirror.example.com/dockerhub/library/busybox:latest",
+			"backup.example.com/dockerhub/library/busybox:latest",
+			"busybox",
+		},
+		"registry.k8s.io/pause:3.10":   {"mirror.example.com/k8s/pause:3.10"},
+		"registry.k8s.io/pause-amd64":  {"registry.k8s.io/pause-amd64"},
+		"registry.k8s.io/kube-proxy:1": {"registry.k8s.io/kube-proxy:1"},
+		"quay.io/team/app:v1":          {"quay.io/team/app:v1"},
+	} {
+		assert.Equal(t, expected, mirrors.PullCandidates(image), image)
+	}
+	assert.Equal(t, []string{"busybox"}, (*config.RegistryMirrorsConfig)(nil).PullCandidates("busybox"))
+}
+
+func TestPullImageFromMirror(t *testing.T) {
+	ds, fakeDocker, _ := newTestDockerService()
+	ds.registryMirrors = newTestRegistryMirrors()
+
+	// The first mirror fails, the image is pulled from the second one.
+	fakeDocker.InjectError("pull", fmt.Errorf("mirror unavailable"))
+	_, err := ds.PullImage(getTestCTX(), &runtimeapi.PullImageRequest{
+		Image: &runtimeapi.ImageSpec{Image: "busybox:1.36"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"backup.example.com/dockerhub/library/busybox:1.36"}, fakeDocker.ImagesPulled)
+
+	// The image is reported under its original name only.
+	statusResp, err := ds.ImageStatus(getTestCTX(), &runtimeapi.ImageStatusRequest{
+		Image: &runtimeapi.ImageSpec{Image: "busybox:1.36"},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, statusResp.Image)
+	assert.Equal(t, []string{"busybox:1.36"}, statusResp.Image.RepoTags)
+
+	listResp, err := ds.ListImages(getTestCTX(), &runtimeapi.ListImagesRequest{})
+	require.NoError(t, err)
+	require.Len(t, listResp.Images, 1)
+	assert.Equal(t, []string{"busybox:1.36"}, listResp.Images[0].RepoTags)
+}
+
+func TestPullImageFromMirrorLogsRequestFields(t *testing.T) {
+	var out bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&out)
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	ctx := logging.WithEntry(context.Background(), logrus.NewEntry(logger).WithField(logging.RequestIDKey, "r1"))
+
+	_, fakeDocker, _ := newTestDockerService()
+	pulled, err := pullImageFromMirrors(ctx, fakeDocker, newTestRegistryMirrors(), "busybox:1.36",
+		func(candidate string) error {
+			if candidate == "mirror.example.com/dockerhub/library/busybox:1.36" {
+				return fmt.Errorf("mirror unavailable")
+			}
+			return fakeDocker.PullImage(candidate, dockerregistry.AuthConfig{}, dockerimage.PullOptions{})
+		})
+	require.NoError(t, err)
+	assert.Equal(t, "busybox:1.36", pulled)
+
+	var line map[string]interface{}
+	require.NoError(t, json.NewDecoder(&out).Decode(&line))
+	assert.Equal(t, "r1", line[logging.RequestIDKey])
+	assert.Equal(t, "busybox:1.36", line[logging.ImageKey])
+	assert.Equal(t, `Failed to pull image as "mirror.example.com/dockerhub/library/busybox:1.36"`, line["msg"])
+}
+
+func TestPullImageFromMirrorFailsWithoutOriginal(t *testing.T) {
+	ds, fakeDocker, _ := newTestDockerService()
+	ds.registryMirrors = newTestRegistryMirrors()
+
+	fakeDocker.InjectError("pull", fmt.Errorf("mirror unavailable"))
+	_, err := ds.PullImage(getTestCTX(), &runtimeapi.PullImageRequest{
+		Image: &runtimeapi.ImageSpec{Image: "registry.k8s.io/pause:3.10"},
+	})
+	assert.ErrorContains(t, err, "mirror unavailable")
+	assert.Empty(t, fakeDocker.ImagesPulled)
+}
+
+func TestPullImageByDigestFromMirror(t *testing.T) {
+	ds, fakeDocker, _ := newTestDockerService()
+	ds.registryMirrors = newTestRegistryMirrors()
+	digest := "sha256:b5d6fe0712636ceb7430189de28819e195e8966372edfc2d9409d79402a0dc16"
+	image := "registry.k8s.io/pause@" + digest
+	mirrored := "mirror.example.com/k8s/pause@" + digest
+
+	resp, err := ds.PullImage(getTestCTX(), &runtimeapi.PullImageRequest{
+		Image: &runtimeapi.ImageSpec{Image: image},
+	})
+	require.NoError(t, err)
+	// The image is reported under its original name.
+	assert.Equal(t, image, resp.ImageRef)
+	// Digests can't be tagged with the original name.
+	assert.NoError(t, fakeDocker.AssertCalls([]string{"pull", "inspect_image"}))
+
+	statusResp, err := ds.ImageStatus(getTestCTX(), &runtimeapi.ImageStatusRequest{
+		Image: &runtimeapi.ImageSpec{Image: image},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, statusResp.Image)
+	assert.Equal(t, mirrored, statusResp.Image.Id)
+
+	assert.Equal(t,
+		[]string{mirrored, image},
+		originalRepoDigests(ds.registryMirrors, []string{mirrored}),
+	)
+}
+
+func TestEnsureSandboxImageExistsFromMirror(t *testing.T) {
+	ds, fakeDocker, _ := newTestDockerService()
+	ds.registryMirrors = newTestRegistryMirrors()
+	sandboxImage := "registry.k8s.io/pause:3.10"
+	fakeDocker.InjectError("inspect_image", libdocker.ImageNotFoundError{ID: sandboxImage})
+
+	require.NoError(t, ds.ensureSandboxImageExists(getTestCTX(), sandboxImage))
+	assert.Equal(t, []string{"mirror.example.com/k8s/pause:3.10"}, fakeDocker.ImagesPulled)
+	_, err := fakeDocker.InspectImageByRef(sandboxImage)
+	assert.NoError(t, err)
+}
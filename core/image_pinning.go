@@ -0,0 +1,180 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/sirupsen/logrus"
+
+	"github.com/Mirantis/cri-dockerd/utils/logging"
+)
+
+// forceRemoveImageAnnotation is the annotation of the image spec of
+// RemoveImage requests that removes pinned images.
+const forceRemoveImageAnnotation = "cri-dockerd.mirantis.com/force-remove"
+
+// pinnedImages matches the images that are always reported as pinned, so
+// that the kubelet never garbage collects them.
+type pinnedImages struct {
+	// repositories pin all the tags and digests of the repositories.
+	repositories map[string]bool
+	// references pin the tags and digests.
+	references map[string]bool
+	// prefixes pin the images whose normalized or familiar names start with
+	// them.
+	prefixes []string
+}
+
+// newPinnedImages parses the pinned image patterns: image references, which
+// pin all the tags and digests of the repository without tag or digest, or
+// name prefixes ending with "*", e.g. "registry.example.com/agents/*" or
+// "busybox*".
+func newPinnedImages(patterns []string) (*pinnedImages, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	p := &pinnedImages{
+		repositories: make(map[string]bool),
+		references:   make(map[string]bool),
+	}
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			p.prefixes = append(p.prefixes, prefix)
+			if normalized := normalizePrefix(prefix); normalized != prefix {
+				p.prefixes = append(p.prefixes, normalized)
+			}
+			continue
+		}
+		named, err := reference.ParseNormalizedNamed(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pinned image %q: %v", pattern, err)
+		}
+		if reference.IsNameOnly(named) {
+			p.repositories[named.Name()] = true
+		} else {
+			p.references[named.String()] = true
+		}
+	}
+	return p, nil
+}
+
+// matches returns whether any of the image references is pinned.
+func (p *pinnedImages) matches(refs []string) bool {
+	if p == nil {
+		return false
+	}
+	for _, ref := range refs {
+		named, err := reference.ParseNormalizedNamed(ref)
+		if err != nil {
+			continue
+		}
+		if p.repositories[named.Name()] || p.references[named.String()] {
+			return true
+		}
+		for _, prefix := range p.prefixes {
+			if strings.HasPrefix(named.String(), prefix) ||
+				strings.HasPrefix(reference.FamiliarString(named), prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// images returns the pinned references with a tag or digest, the only
+// patterns naming an image that can be pulled.
+func (p *pinnedImages) images() []string {
+	if p == nil {
+		return nil
+	}
+	images := make([]string, 0, len(p.references))
+	for ref := range p.references {
+		images = append(images, ref)
+	}
+	sort.Strings(images)
+	return images
+}
+
+// prePullPinnedImages pulls the pinned images that are not present with the
+// credentials of the docker keyring, so that they're available before the
+// kubelet asks for them.
+func (ds *dockerService) prePullPinnedImages() {
+	for _, image := range ds.pinnedImages.images() {
+		ctx := logging.WithFields(context.Background(), logrus.Fields{logging.ImageKey: image})
+		err := ds.ensureSandboxImageExists(ctx, image)
+		if err != nil {
+			logging.FromContext(ctx).WithError(err).Warn("Failed to pre-pull the pinned image")
+		}
+	}
+}
+
+// normalizePrefix returns the normalized form of a name prefix, e.g.
+// "docker.io/library/busybox" for "busybox", or the prefix itself if it
+// isn't a valid name.
+func normalizePrefix(prefix string) string {
+	name := strings.TrimSuffix(prefix, "/")
+	named, err := reference.ParseNormalizedNamed(name)
+	if err != nil {
+		return prefix
+	}
+	return named.String() + prefix[len(name):]
+}
+
+// sandboxImages returns the sandbox images of the pods, the default one and
+// the ones of the runtime handlers.
+func (ds *dockerService) sandboxImages() []string {
+	images := []string{ds.sandboxImage()}
+	for _, handler := range ds.runtimeHandlers.GetHandlers() {
+		if handler.SandboxImage != "" {
+			images = append(images, handler.SandboxImage)
+		}
+	}
+	return images
+}
+
+// isSandboxImage returns whether any of the image references is one of the
+// sandbox images, both normalized.
+func (ds *dockerService) isSandboxImage(refs []string) bool {
+	for _, image := range ds.sandboxImages() {
+		sandboxImage, err := reference.ParseNormalizedNamed(image)
+		if err != nil {
+			continue
+		}
+		sandboxImage = reference.TagNameOnly(sandboxImage)
+		for _, ref := range refs {
+			named, err := reference.ParseNormalizedNamed(ref)
+			if err == nil && reference.TagNameOnly(named).String() == sandboxImage.String() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isImagePinned returns whether the image with the tags and digests is
+// pinned: the sandbox images and the configured pinned images are.
+func (ds *dockerService) isImagePinned(repoTags, repoDigests []string) bool {
+	return ds.isSandboxImage(repoTags) ||
+		ds.isSandboxImage(repoDigests) ||
+		ds.pinnedImages.matches(repoTags) ||
+		ds.pinnedImages.matches(repoDigests)
+}
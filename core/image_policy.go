@@ -0,0 +1,130 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/distribution/reference"
+	dockerimage "github.com/docker/docker/api/types/image"
+	dockerregistry "github.com/docker/docker/api/types/registry"
+	"github.com/opencontainers/go-digest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	crierrors "k8s.io/cri-api/pkg/errors"
+
+	"github.com/Mirantis/cri-dockerd/imagepolicy"
+	"github.com/Mirantis/cri-dockerd/utils/logging"
+)
+
+// verifyImageSignature checks the image against the signature policy before
+// it is pulled. It returns the digest the pulled image must have, empty if
+// there is no policy or it doesn't look at the signatures of the image.
+func (ds *dockerService) verifyImageSignature(
+	ctx context.Context,
+	image string,
+	authConfig dockerregistry.AuthConfig,
+) (digest.Digest, error) {
+	if ds.imagePolicy == nil {
+		return "", nil
+	}
+	dgst, err := ds.imagePolicy.Verify(ctx, image, imagepolicy.Credentials{
+		Username:      authConfig.Username,
+		Password:      authConfig.Password,
+		RegistryToken: authConfig.RegistryToken,
+	})
+	if imagepolicy.IsRejected(err) {
+		return "", signatureValidationError(err)
+	} else if err != nil {
+		return "", fmt.Errorf("%v: %v", crierrors.ErrRegistryUnavailable, err)
+	}
+	return dgst, nil
+}
+
+// pinnedImageReference returns the reference to pull the image with the
+// verified digest by, the image itself if there is no verified digest or it
+// is already referenced by digest.
+func pinnedImageReference(image string, verified digest.Digest) (string, error) {
+	if verified == "" {
+		return image, nil
+	}
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse image %q: %v", image, err)
+	}
+	if _, ok := named.(reference.Digested); ok {
+		return image, nil
+	}
+	pinned, err := reference.WithDigest(reference.TrimNamed(named), verified)
+	if err != nil {
+		return "", err
+	}
+	return pinned.String(), nil
+}
+
+// checkPulledImageDigest checks that the image pulled for requested is the
+// one whose signatures were verified, and not one the tag was moved to
+// meanwhile. The pulled image is removed if it isn't.
+func (ds *dockerService) checkPulledImageDigest(
+	ctx context.Context,
+	requested, image string,
+	verified digest.Digest,
+) error {
+	if verified == "" {
+		return nil
+	}
+	img, err := ds.client.InspectImageByRef(image)
+	if err != nil {
+		return err
+	}
+	// The image may have been pulled from a mirror, under another name, but
+	// not from an unrelated repository.
+	repositories := map[string]bool{}
+	for _, candidate := range ds.registryMirrors.PullCandidates(requested) {
+		if named, err := reference.ParseNormalizedNamed(candidate); err == nil {
+			repositories[named.Name()] = true
+		}
+	}
+	for _, repoDigest := range img.RepoDigests {
+		ref, err := reference.ParseNormalizedNamed(repoDigest)
+		if err != nil || !repositories[ref.Name()] {
+			continue
+		}
+		if canonical, ok := ref.(reference.Canonical); ok && canonical.Digest() == verified {
+			return nil
+		}
+	}
+	if _, err := ds.client.RemoveImage(image, dockerimage.RemoveOptions{}); err != nil {
+		logging.FromContext(ctx).WithError(err).WithField(logging.ImageKey, image).
+			Errorf("Failed to remove the image which doesn't have the verified digest %s", verified)
+	}
+	reason := fmt.Errorf("the pulled image doesn't have the verified digest %s", verified)
+	if len(img.RepoDigests) == 0 {
+		reason = fmt.Errorf("the pulled image has no digest to check against the verified digest %s", verified)
+	}
+	return signatureValidationError(&imagepolicy.RejectedError{
+		Image:  image,
+		Reason: reason,
+	})
+}
+
+// signatureValidationError returns the error of the rejections by the
+// signature policy, for the kubelet to report them as such.
+func signatureValidationError(err error) error {
+	return status.Errorf(codes.PermissionDenied, "%v: %v", crierrors.ErrSignatureValidationFailed, err)
+}
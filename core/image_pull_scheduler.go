@@ -0,0 +1,215 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	dockerregistry "github.com/docker/docker/api/types/registry"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"github.com/Mirantis/cri-dockerd/metrics"
+)
+
+// pullPriority is the priority of an image pull in the pull queue.
+type pullPriority int
+
+const (
+	pullPriorityNormal pullPriority = iota
+	// pullPriorityHigh is the priority of the sandbox image and of the images
+	// of kube-system pods.
+	pullPriorityHigh
+)
+
+// highPriorityNamespace is the namespace of the pods whose images are pulled
+// with high priority.
+const highPriorityNamespace = "kube-system"
+
+func (p pullPriority) String() string {
+	if p == pullPriorityHigh {
+		return "high"
+	}
+	return "normal"
+}
+
+// pullPriorityFor returns the priority of the images of the pod.
+func pullPriorityFor(sandboxConfig *runtimeapi.PodSandboxConfig) pullPriority {
+	if sandboxConfig.GetMetadata().GetNamespace() == highPriorityNamespace {
+		return pullPriorityHigh
+	}
+	return pullPriorityNormal
+}
+
+// pullKey identifies the pulls of the image with the credentials, which are
+// merged.
+func pullKey(image string, auth dockerregistry.AuthConfig) string {
+	data, _ := json.Marshal(auth)
+	sum := sha256.Sum256(data)
+	return image + "@" + hex.EncodeToString(sum[:])
+}
+
+// imagePull is a queued or running image pull, shared by the requests merged
+// into it.
+type imagePull struct {
+	key      string
+	priority pullPriority
+	pull     func() (string, error)
+	queued   time.Time
+	waiters  int
+	started  bool
+	done     chan struct{}
+	result   string
+	err      error
+}
+
+// pullScheduler bounds the number of parallel image pulls, queueing the
+// others by priority, and merges the pulls of the same image.
+type pullScheduler struct {
+	// maxParallel is the maximum number of parallel pulls, no limit if not
+	// positive.
+	maxParallel int
+
+	lock    sync.Mutex
+	running int
+	// queues are the queued pulls by priority, in FIFO order.
+	queues [pullPriorityHigh + 1][]*imagePull
+	pulls  map[string]*imagePull
+}
+
+func newPullScheduler(maxParallel int) *pullScheduler {
+	return &pullScheduler{
+		maxParallel: maxParallel,
+		pulls:       make(map[string]*imagePull),
+	}
+}
+
+// schedule runs pull as the pull identified by key once a pull slot is free,
+// and returns its result. Requests with the key of a queued or running pull
+// wait for it instead. The pull goes on when ctx is done if other requests
+// wait for it or it already started. A nil scheduler runs pull right away.
+func (s *pullScheduler) schedule(
+	ctx context.Context,
+	key string,
+	priority pullPriority,
+	pull func() (string, error),
+) (string, error) {
+	if s == nil {
+		return pull()
+	}
+
+	s.lock.Lock()
+	p, ok := s.pulls[key]
+	if ok {
+		metrics.ImagePullsMerged.Inc()
+		if !p.started && priority > p.priority {
+			s.dequeue(p)
+			p.priority = priority
+			s.enqueue(p)
+		}
+	} else {
+		p = &imagePull{
+			key:      key,
+			priority: priority,
+			pull:     pull,
+			queued:   time.Now(),
+			done:     make(chan struct{}),
+		}
+		s.pulls[key] = p
+		s.enqueue(p)
+	}
+	p.waiters++
+	s.dispatch()
+	s.lock.Unlock()
+
+	select {
+	case <-p.done:
+		return p.result, p.err
+	case <-ctx.Done():
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		p.waiters--
+		if p.waiters == 0 && !p.started {
+			s.dequeue(p)
+			delete(s.pulls, key)
+		}
+		return "", ctx.Err()
+	}
+}
+
+func (s *pullScheduler) enqueue(p *imagePull) {
+	s.queues[p.priority] = append(s.queues[p.priority], p)
+	s.updateQueueLength()
+}
+
+func (s *pullScheduler) dequeue(p *imagePull) {
+	queue := s.queues[p.priority]
+	for i := range queue {
+		if queue[i] == p {
+			s.queues[p.priority] = append(queue[:i:i], queue[i+1:]...)
+			break
+		}
+	}
+	s.updateQueueLength()
+}
+
+func (s *pullScheduler) updateQueueLength() {
+	length := 0
+	for _, queue := range s.queues {
+		length += len(queue)
+	}
+	metrics.ImagePullQueueLength.Set(float64(length))
+}
+
+// dispatch starts the queued pulls, high priority first, while there are
+// free pull slots. The lock must be held.
+func (s *pullScheduler) dispatch() {
+	for s.maxParallel <= 0 || s.running < s.maxParallel {
+		var next *imagePull
+		for priority := pullPriorityHigh; priority >= pullPriorityNormal; priority-- {
+			if len(s.queues[priority]) > 0 {
+				next = s.queues[priority][0]
+				break
+			}
+		}
+		if next == nil {
+			return
+		}
+		s.dequeue(next)
+		next.started = true
+		s.running++
+		metrics.ImagePullQueueDuration.WithLabelValues(next.priority.String()).
+			Observe(metrics.SinceInSeconds(next.queued))
+		go s.run(next)
+	}
+}
+
+func (s *pullScheduler) run(p *imagePull) {
+	result, err := p.pull()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	p.result, p.err = result, err
+	s.running--
+	delete(s.pulls, p.key)
+	close(p.done)
+	s.dispatch()
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// waitQueued waits for the scheduler to have n queued pulls.
+func waitQueued(t *testing.T, s *pullScheduler, n int) {
+	require.Eventually(t, func() bool {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		return len(s.queues[pullPriorityNormal])+len(s.queues[pullPriorityHigh]) == n
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestPullSchedulerPriority(t *testing.T) {
+	s := newPullScheduler(1)
+	release := make(chan struct{})
+	var lock sync.Mutex
+	var order []string
+	pull := func(image string) func() (string, error) {
+		return func() (string, error) {
+			lock.Lock()
+			order = append(order, image)
+			lock.Unlock()
+			<-release
+			return image, nil
+		}
+	}
+
+	var wg sync.WaitGroup
+	schedule := func(image string, priority pullPriority) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := s.schedule(context.Background(), image, priority, pull(image))
+			assert.NoError(t, err)
+			assert.Equal(t, image, result)
+		}()
+	}
+	schedule("app:1", pullPriorityNormal)
+	require.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(order) == 1
+	}, 5*time.Second, time.Millisecond)
+	schedule("app:2", pullPriorityNormal)
+	waitQueued(t, s, 1)
+	schedule("pause:3.10", pullPriorityHigh)
+	waitQueued(t, s, 2)
+
+	close(release)
+	wg.Wait()
+	assert.Equal(t, []string{"app:1", "pause:3.10", "app:2"}, order)
+}
+
+func TestPullSchedulerMergesDuplicatePulls(t *testing.T) {
+	s := newPullScheduler(1)
+	release := make(chan struct{})
+	pulls := 0
+	pull := func() (string, error) {
+		pulls++
+		<-release
+		return "busybox", nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := s.schedule(context.Background(), "busybox", pullPriorityNormal, pull)
+			assert.NoError(t, err)
+			assert.Equal(t, "busybox", result)
+		}()
+	}
+	require.Eventually(t, func() bool {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		p, ok := s.pulls["busybox"]
+		return ok && p.waiters == 3
+	}, 5*time.Second, time.Millisecond)
+
+	close(release)
+	wg.Wait()
+	assert.Equal(t, 1, pulls)
+	assert.Empty(t, s.pulls)
+}
+
+func TestPullSchedulerCancelQueuedPull(t *testing.T) {
+	s := newPullScheduler(1)
+	release := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.schedule(context.Background(), "app:1", pullPriorityNormal, func() (string, error) {
+			<-release
+			return "", nil
+		})
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error)
+	go func() {
+		_, err := s.schedule(ctx, "app:2", pullPriorityNormal, func() (string, error) {
+			t.Error("cancelled pull ran")
+			return "", nil
+		})
+		errs <- err
+	}()
+	waitQueued(t, s, 1)
+	cancel()
+	assert.ErrorIs(t, <-errs, context.Canceled)
+	waitQueued(t, s, 0)
+
+	close(release)
+	<-done
+}
@@ -23,11 +23,17 @@ import (
 	dockertypes "github.com/docker/docker/api/types"
 	dockerimage "github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/opencontainers/go-digest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+	crierrors "k8s.io/cri-api/pkg/errors"
 
+	"github.com/Mirantis/cri-dockerd/config"
+	"github.com/Mirantis/cri-dockerd/imagepolicy"
 	"github.com/Mirantis/cri-dockerd/libdocker"
 )
 
@@ -169,3 +175,236 @@ func TestPullWithJSONError(t *testing.T) {
 		assert.Contains(t, err.Error(), test.expectedError)
 	}
 }
+
+func TestPullImageRejectedBySignaturePolicy(t *testing.T) {
+	ds, fakeDocker, _ := newTestDockerService()
+	verifier, err := imagepolicy.NewVerifier(&imagepolicy.Policy{
+		Default: []imagepolicy.Requirement{{Type: imagepolicy.TypeInsecureAcceptAnything}},
+		Transports: map[string]map[string][]imagepolicy.Requirement{
+			"docker": {"registry.example.com/untrusted": {{Type: imagepolicy.TypeReject}}},
+		},
+	})
+	require.NoError(t, err)
+	ds.imagePolicy = verifier
+
+	_, err = ds.PullImage(getTestCTX(), &runtimeapi.PullImageRequest{
+		Image: &runtimeapi.ImageSpec{Image: "registry.example.com/untrusted/app:v1"},
+	})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.Contains(t, err.Error(), crierrors.ErrSignatureValidationFailed.Error())
+	assert.NoError(t, fakeDocker.AssertCalls([]string{}))
+
+	_, err = ds.PullImage(getTestCTX(), &runtimeapi.PullImageRequest{
+		Image: &runtimeapi.ImageSpec{Image: "registry.example.com/trusted/app:v1"},
+	})
+	assert.NoError(t, err)
+}
+
+func TestSandboxImageRejectedBySignaturePolicy(t *testing.T) {
+	ds, fakeDocker, _ := newTestDockerService()
+	verifier, err := imagepolicy.NewVerifier(&imagepolicy.Policy{
+		Default: []imagepolicy.Requirement{{Type: imagepolicy.TypeReject}},
+	})
+	require.NoError(t, err)
+	ds.imagePolicy = verifier
+	fakeDocker.InjectError("inspect_image", libdocker.ImageNotFoundError{ID: defaultSandboxImage})
+
+	err = ds.ensureSandboxImageExists(getTestCTX(), defaultSandboxImage)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.Contains(t, err.Error(), crierrors.ErrSignatureValidationFailed.Error())
+	assert.NoError(t, fakeDocker.AssertCalls([]string{"inspect_image"}))
+	assert.Empty(t, fakeDocker.ImagesPulled)
+}
+
+func TestCheckPulledImageDigest(t *testing.T) {
+	ds, fakeDocker, _ := newTestDockerService()
+	image := "registry.example.com/app:v1"
+	verified := digest.FromString("verified")
+	fakeDocker.InjectImageInspects([]dockertypes.ImageInspect{{
+		ID:          image,
+		RepoTags:    []string{image},
+		RepoDigests: []string{"registry.example.com/app@" + verified.String()},
+	}})
+	assert.NoError(t, ds.checkPulledImageDigest(getTestCTX(), image, image, verified))
+	assert.NoError(t, ds.checkPulledImageDigest(getTestCTX(), image, image, ""))
+
+	fakeDocker.InjectImageInspects([]dockertypes.ImageInspect{{
+		ID:          image,
+		RepoTags:    []string{image},
+		RepoDigests: []string{"registry.example.com/app@" + digest.FromString("moved").String()},
+	}})
+	fakeDocker.ClearCalls()
+	err := ds.checkPulledImageDigest(getTestCTX(), image, image, verified)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	// The rejected image isn't left behind for later containers to use.
+	assert.NoError(t, fakeDocker.AssertCallDetails(
+		libdocker.NewCalledDetail("inspect_image", nil),
+		libdocker.NewCalledDetail("remove_image", []interface{}{image, dockerimage.RemoveOptions{}}),
+	))
+
+	// Images without a digest can't be checked, and are rejected.
+	fakeDocker.InjectImageInspects([]dockertypes.ImageInspect{{ID: image, RepoTags: []string{image}}})
+	err = ds.checkPulledImageDigest(getTestCTX(), image, image, verified)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// Only the digests of the requested repository and of its mirrors count.
+	ds.registryMirrors = &config.RegistryMirrorsConfig{Rewrites: []config.ImageRewrite{{
+		Prefix:  "registry.example.com",
+		Mirrors: []string{"mirror.example.com/example"},
+	}}}
+	fakeDocker.InjectImageInspects([]dockertypes.ImageInspect{{
+		ID:          image,
+		RepoTags:    []string{image},
+		RepoDigests: []string{"registry.example.com/other@" + verified.String()},
+	}})
+	err = ds.checkPulledImageDigest(getTestCTX(), image, image, verified)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	fakeDocker.InjectImageInspects([]dockertypes.ImageInspect{{
+		ID:          image,
+		RepoTags:    []string{image},
+		RepoDigests: []string{"mirror.example.com/example/app@" + verified.String()},
+	}})
+	assert.NoError(t, ds.checkPulledImageDigest(getTestCTX(), image, image, verified))
+}
+
+func TestPinnedImageReference(t *testing.T) {
+	verified := digest.FromString("verified")
+	for image, expected := range map[string]string{
+		"registry.example.com/app:v1": "registry.example.com/app@" + verified.String(),
+		"busybox":                     "docker.io/library/busybox@" + verified.String(),
+		"registry.example.com/app@" + verified.String(): "registry.example.com/app@" + verified.String(),
+	} {
+		pinned, err := pinnedImageReference(image, verified)
+		require.NoError(t, err)
+		assert.Equal(t, expected, pinned, image)
+	}
+	pinned, err := pinnedImageReference("busybox", "")
+	require.NoError(t, err)
+	assert.Equal(t, "busybox", pinned)
+}
+
+func TestPinnedImages(t *testing.T) {
+	ds, fakeDocker, _ := newTestDockerService()
+	pinnedImages, err := newPinnedImages([]string{
+		"registry.example.com/agents/*",
+		"busybox",
+		"debian:12",
+	})
+	require.NoError(t, err)
+	ds.pinnedImages = pinnedImages
+	fakeDocker.InjectImages([]dockerimage.Summary{
+		{ID: "agent", RepoTags: []string{"registry.example.com/agents/monitor:v2"}},
+		{ID: "busybox", RepoTags: []string{"busybox:1.36"}},
+		{ID: "debian", RepoTags: []string{"debian:12"}},
+		{ID: "debian-old", RepoTags: []string{"debian:11"}},
+		{ID: "app", RepoTags: []string{"registry.example.com/app:v1"}},
+	})
+
+	resp, err := ds.ListImages(getTestCTX(), &runtimeapi.ListImagesRequest{})
+	require.NoError(t, err)
+	pinned := map[string]bool{}
+	for _, image := range resp.Images {
+		pinned[image.Id] = image.Pinned
+	}
+	assert.Equal(t, map[string]bool{
+		"agent":      true,
+		"busybox":    true,
+		"debian":     true,
+		"debian-old": false,
+		"app":        false,
+	}, pinned)
+
+	statusResp, err := ds.ImageStatus(getTestCTX(), &runtimeapi.ImageStatusRequest{
+		Image: &runtimeapi.ImageSpec{Image: "agent"},
+	})
+	require.NoError(t, err)
+	assert.True(t, statusResp.Image.Pinned)
+
+	_, err = ds.RemoveImage(getTestCTX(), &runtimeapi.RemoveImageRequest{
+		Image: &runtimeapi.ImageSpec{Image: "agent"},
+	})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	_, err = ds.RemoveImage(getTestCTX(), &runtimeapi.RemoveImageRequest{
+		Image: &runtimeapi.ImageSpec{
+			Image:       "agent",
+			Annotations: map[string]string{forceRemoveImageAnnotation: "true"},
+		},
+	})
+	assert.NoError(t, err)
+	_, err = ds.RemoveImage(getTestCTX(), &runtimeapi.RemoveImageRequest{
+		Image: &runtimeapi.ImageSpec{Image: "app"},
+	})
+	assert.NoError(t, err)
+
+	_, err = newPinnedImages([]string{"Invalid:Reference:"})
+	assert.Error(t, err)
+}
+
+func TestPrePullPinnedImages(t *testing.T) {
+	ds, fakeDocker, _ := newTestDockerService()
+	pinnedImages, err := newPinnedImages([]string{
+		"registry.example.com/agents/*",
+		"busybox",
+		"debian:12",
+		"registry.example.com/monitor:v2",
+	})
+	require.NoError(t, err)
+	ds.pinnedImages = pinnedImages
+	fakeDocker.InjectImages([]dockerimage.Summary{{ID: "registry.example.com/monitor:v2"}})
+
+	ds.prePullPinnedImages()
+	assert.Equal(t, []string{"docker.io/library/debian:12"}, fakeDocker.ImagesPulled)
+}
+
+func TestPinnedImagesMatchNormalizedPrefixes(t *testing.T) {
+	for pattern, matching := range map[string]map[string]bool{
+		"busybox*": {
+			"busybox:latest":                   true,
+			"docker.io/library/busybox:latest": true,
+			"busybox-extra:1":                  true,
+			"quay.io/busybox:1":                false,
+		},
+		"docker.io/library/busybox*": {
+			"busybox:1.36": true,
+			"debian:12":    false,
+		},
+		"registry.example.com/agents/*": {
+			"registry.example.com/agents/monitor:v2": true,
+			"registry.example.com/app:v1":            false,
+		},
+	} {
+		p, err := newPinnedImages([]string{pattern})
+		require.NoError(t, err)
+		for ref, expected := range matching {
+			assert.Equal(t, expected, p.matches([]string{ref}), "%s %s", pattern, ref)
+		}
+	}
+}
+
+func TestSandboxImagesArePinned(t *testing.T) {
+	ds, fakeDocker, _ := newTestDockerService()
+	ds.runtimeHandlers = &config.RuntimeHandlersConfig{Handlers: map[string]config.RuntimeHandlerConfig{
+		"kata": {SandboxImage: "registry.example.com/kata/pause"},
+	}}
+	fakeDocker.InjectImages([]dockerimage.Summary{
+		{ID: "pause", RepoTags: []string{defaultSandboxImage}},
+		{ID: "kata-pause", RepoTags: []string{"registry.example.com/kata/pause:latest"}},
+		{ID: "app", RepoTags: []string{"registry.example.com/app:v1"}},
+	})
+
+	resp, err := ds.ListImages(getTestCTX(), &runtimeapi.ListImagesRequest{})
+	require.NoError(t, err)
+	pinned := map[string]bool{}
+	for _, image := range resp.Images {
+		pinned[image.Id] = image.Pinned
+	}
+	assert.Equal(t, map[string]bool{"pause": true, "kata-pause": true, "app": false}, pinned)
+
+	for _, image := range []string{"pause", "kata-pause"} {
+		_, err = ds.RemoveImage(getTestCTX(), &runtimeapi.RemoveImageRequest{
+			Image: &runtimeapi.ImageSpec{Image: image},
+		})
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err), image)
+	}
+}
@@ -34,12 +34,12 @@ const imageFsStatsMinTTL = 30 * time.Second
 
 // ImageFsInfo returns information of the filesystem that is used to store images.
 func (ds *dockerService) ImageFsInfo(
-	_ context.Context,
+	ctx context.Context,
 	_ *runtimeapi.ImageFsInfoRequest,
 ) (*runtimeapi.ImageFsInfoResponse, error) {
 
 	res, err := ImageFsStatsCache.Memoize("imagefs", imageFsStatsMinTTL, func() (interface{}, error) {
-		return ds.imageFsInfo()
+		return ds.imageFsInfo(ctx)
 	})
 	if err != nil {
 		return nil, err
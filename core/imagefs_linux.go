@@ -20,32 +20,98 @@ limitations under the License.
 package core
 
 import (
+	"context"
+	"path/filepath"
 	"syscall"
 	"time"
 
 	"github.com/docker/docker/api/types/image"
-	"github.com/sirupsen/logrus"
+	"github.com/moby/sys/mountinfo"
 
 	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"github.com/Mirantis/cri-dockerd/utils/logging"
 )
 
-// ImageFsInfo returns information of the filesystem of docker data root.
-func (ds *dockerService) imageFsInfo() (*runtimeapi.ImageFsInfoResponse, error) {
-	// collect info of the filesystem on which docker root resides
+// filesystemInfo is the mount point and the inode usage of a filesystem.
+type filesystemInfo struct {
+	mountPoint string
+	inodesUsed uint64
+}
+
+// getFilesystemInfo returns the info of the filesystem the directory is on.
+func getFilesystemInfo(ctx context.Context, dir string) (*filesystemInfo, error) {
+	log := logging.FromContext(ctx)
 	stat := &syscall.Statfs_t{}
-	err := syscall.Statfs(ds.dockerRootDir, stat)
+	if err := syscall.Statfs(dir, stat); err != nil {
+		log.Errorf("Failed to get filesystem info for %s: %v", dir, err)
+		return nil, err
+	}
+	info := &filesystemInfo{
+		mountPoint: findMountPoint(ctx, dir),
+		inodesUsed: stat.Files - stat.Ffree,
+	}
+	log.Debugf(
+		"Filesystem containing '%s' mounted at '%s': usedBytes=%v, iNodesUsed=%v",
+		dir, info.mountPoint, (stat.Blocks-stat.Bfree)*uint64(stat.Bsize), info.inodesUsed,
+	)
+	return info, nil
+}
+
+// findMountPoint returns the mount point of the filesystem the directory is
+// on, the directory itself if it can't be found.
+func findMountPoint(ctx context.Context, dir string) string {
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return dir
+	}
+	mounts, err := mountinfo.GetMounts(mountinfo.ParentsFilter(resolved))
+	if err != nil {
+		logging.FromContext(ctx).Debugf("Failed to get the mounts of %s: %v", resolved, err)
+		return dir
+	}
+	mountPoint := ""
+	for _, m := range mounts {
+		if len(m.Mountpoint) > len(mountPoint) {
+			mountPoint = m.Mountpoint
+		}
+	}
+	if mountPoint == "" {
+		return dir
+	}
+	return mountPoint
+}
+
+// existingDir returns the directory, or the docker root directory if it
+// doesn't exist.
+func (ds *dockerService) existingDir(ctx context.Context, dir string) string {
+	if dir == "" {
+		return ds.dockerRootDir
+	}
+	if _, err := filepath.EvalSymlinks(dir); err != nil {
+		logging.FromContext(ctx).Debugf("Storage directory %s not found, using %s", dir, ds.dockerRootDir)
+		return ds.dockerRootDir
+	}
+	return dir
+}
+
+// ImageFsInfo returns information of the filesystems docker stores the images
+// and the containers on.
+func (ds *dockerService) imageFsInfo(ctx context.Context) (*runtimeapi.ImageFsInfoResponse, error) {
+	log := logging.FromContext(ctx)
+	imageFs, err := getFilesystemInfo(ctx, ds.existingDir(ctx, ds.storagePaths.images))
+	if err != nil {
+		return nil, err
+	}
+	containerFs, err := getFilesystemInfo(ctx, ds.existingDir(ctx, ds.storagePaths.containers))
 	if err != nil {
-		logrus.Errorf("Failed to get filesystem info for %s: %v", ds.dockerRootDir, err)
 		return nil, err
 	}
-	usedBytes := (stat.Blocks - stat.Bfree) * uint64(stat.Bsize)
-	iNodesUsed := stat.Files - stat.Ffree
-	logrus.Debugf("Filesystem usage containing '%s': usedBytes=%v, iNodesUsed=%v", ds.dockerRootDir, usedBytes, iNodesUsed)
 
 	// compute total used bytes by docker images
 	images, err := ds.client.ListImages(image.ListOptions{All: true, SharedSize: true})
 	if err != nil {
-		logrus.Errorf("Failed to get image list from docker: %v", err)
+		log.WithError(err).Error("Failed to get image list from docker")
 		return nil, err
 	}
 	var totalImageSize uint64
@@ -61,31 +127,34 @@ func (ds *dockerService) imageFsInfo() (*runtimeapi.ImageFsInfoResponse, error)
 	for k := range sharedSizeMap {
 		totalImageSize += uint64(k)
 	}
-	logrus.Debugf("Total used bytes by docker images: %v", totalImageSize)
+	log.Debugf("Total used bytes by docker images: %v", totalImageSize)
 
 	return &runtimeapi.ImageFsInfoResponse{
 		ImageFilesystems: []*runtimeapi.FilesystemUsage{
 			{
 				Timestamp: time.Now().UnixNano(),
 				FsId: &runtimeapi.FilesystemIdentifier{
-					Mountpoint: ds.dockerRootDir,
+					Mountpoint: imageFs.mountPoint,
 				},
 				UsedBytes: &runtimeapi.UInt64Value{
 					Value: totalImageSize,
 				},
 				InodesUsed: &runtimeapi.UInt64Value{
-					Value: iNodesUsed,
+					Value: imageFs.inodesUsed,
 				},
 			}},
 		ContainerFilesystems: []*runtimeapi.FilesystemUsage{
 			{
 				Timestamp: time.Now().UnixNano(),
 				FsId: &runtimeapi.FilesystemIdentifier{
-					Mountpoint: ds.dockerRootDir,
+					Mountpoint: containerFs.mountPoint,
 				},
 				UsedBytes: &runtimeapi.UInt64Value{
 					Value: ds.containerStatsCache.getWriteableLayer(),
 				},
+				InodesUsed: &runtimeapi.UInt64Value{
+					Value: containerFs.inodesUsed,
+				},
 			}},
 	}, nil
 }
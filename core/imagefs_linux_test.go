@@ -0,0 +1,52 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImageFsInfoReportsMountPoints(t *testing.T) {
+	ds, _, _ := newTestDockerService()
+	ds.dockerRootDir = t.TempDir()
+	ds.storagePaths = storagePaths{
+		images:     filepath.Join(ds.dockerRootDir, "overlay2"),
+		containers: filepath.Join(ds.dockerRootDir, "containers"),
+	}
+	require.NoError(t, os.Mkdir(ds.storagePaths.images, 0o755))
+
+	resp, err := ds.imageFsInfo(getTestCTX())
+	require.NoError(t, err)
+	require.Len(t, resp.ImageFilesystems, 1)
+	require.Len(t, resp.ContainerFilesystems, 1)
+
+	mountPoint := findMountPoint(getTestCTX(), ds.dockerRootDir)
+	assert.NotEqual(t, ds.dockerRootDir, mountPoint, "the mount point of the temporary directory")
+	assert.Equal(t, mountPoint, resp.ImageFilesystems[0].FsId.Mountpoint)
+	// The missing containers directory falls back to the docker root.
+	assert.Equal(t, mountPoint, resp.ContainerFilesystems[0].FsId.Mountpoint)
+	assert.NotZero(t, resp.ImageFilesystems[0].InodesUsed.Value)
+	assert.NotZero(t, resp.ContainerFilesystems[0].InodesUsed.Value)
+}
@@ -20,20 +20,21 @@ limitations under the License.
 package core
 
 import (
+	"context"
 	"time"
 
-	"github.com/sirupsen/logrus"
-
 	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
 	"k8s.io/kubernetes/pkg/kubelet/winstats"
+
+	"github.com/Mirantis/cri-dockerd/utils/logging"
 )
 
 // ImageFsInfo returns information of the filesystem that is used to store images.
-func (ds *dockerService) imageFsInfo() (*runtimeapi.ImageFsInfoResponse, error) {
+func (ds *dockerService) imageFsInfo(ctx context.Context) (*runtimeapi.ImageFsInfoResponse, error) {
 	statsClient := &winstats.StatsClient{}
 	fsinfo, err := statsClient.GetDirFsInfo(ds.dockerRootDir)
 	if err != nil {
-		logrus.Errorf("Failed to get fsInfo for dockerRootDir %s: %v", ds.dockerRootDir, err)
+		logging.FromContext(ctx).Errorf("Failed to get fsInfo for dockerRootDir %s: %v", ds.dockerRootDir, err)
 		return nil, err
 	}
 
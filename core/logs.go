@@ -30,13 +30,13 @@ import (
 	"github.com/armon/circbuf"
 	dockercontainer "github.com/docker/docker/api/types/container"
 
-	"github.com/sirupsen/logrus"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	kubetypes "k8s.io/apimachinery/pkg/types"
 	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
 
 	"github.com/Mirantis/cri-dockerd/libdocker"
+	"github.com/Mirantis/cri-dockerd/utils/logging"
 )
 
 // ReopenContainerLog reopens the container log file.
@@ -49,7 +49,7 @@ func (ds *dockerService) ReopenContainerLog(
 
 // GetContainerLogs get container logs directly from docker daemon.
 func (ds *dockerService) GetContainerLogs(
-	_ context.Context,
+	ctx context.Context,
 	pod *v1.Pod,
 	containerID config.ContainerID,
 	logOptions *v1.PodLogOptions,
@@ -92,7 +92,8 @@ func (ds *dockerService) GetContainerLogs(
 	}
 	err = ds.client.Logs(containerID.ID, opts, sopts)
 	if errors.Is(err, errMaximumWrite) {
-		logrus.Debugf("Finished logs, hit byte limit: %d", *logOptions.LimitBytes)
+		logging.FromContext(ctx).WithField(logging.ContainerIDKey, containerID.ID).
+			Debugf("Finished logs, hit byte limit: %d", *logOptions.LimitBytes)
 		err = nil
 	}
 	return err
@@ -159,14 +160,15 @@ func (ds *dockerService) getContainerLogPath(containerID string) (string, string
 }
 
 // createContainerLogSymlink creates the symlink for docker container log.
-func (ds *dockerService) createContainerLogSymlink(containerID string) error {
+func (ds *dockerService) createContainerLogSymlink(ctx context.Context, containerID string) error {
 	path, realPath, err := ds.getContainerLogPath(containerID)
 	if err != nil {
 		return fmt.Errorf("failed to get container %q log path: %v", containerID, err)
 	}
 
+	log := logging.FromContext(ctx).WithField(logging.ContainerIDKey, containerID)
 	if path == "" {
-		logrus.Debugf("Container log path for Container ID %s isn't specified, will not create symlink", containerID)
+		log.Debug("Container log path isn't specified, will not create symlink")
 		return nil
 	}
 
@@ -174,7 +176,7 @@ func (ds *dockerService) createContainerLogSymlink(containerID string) error {
 		// Only create the symlink when container log path is specified and log file exists.
 		// Delete possibly existing file first
 		if err = ds.os.Remove(path); err == nil {
-			logrus.Debugf("Deleted previously existing symlink file: %s", path)
+			log.Debugf("Deleted previously existing symlink file: %s", path)
 		}
 		if err = ds.os.Symlink(realPath, path); err != nil {
 			return fmt.Errorf(
@@ -188,14 +190,14 @@ func (ds *dockerService) createContainerLogSymlink(containerID string) error {
 	} else {
 		supported, err := ds.IsCRISupportedLogDriver()
 		if err != nil {
-			logrus.Errorf("Failed to check supported logging driver for CRI: %v", err)
+			log.WithError(err).Error("Failed to check supported logging driver for CRI")
 			return nil
 		}
 
 		if supported {
-			logrus.Info("Cannot create symbolic link because container log file doesn't exist!")
+			log.Info("Cannot create symbolic link because container log file doesn't exist!")
 		} else {
-			logrus.Debug("Unsupported logging driver by CRI")
+			log.Debug("Unsupported logging driver by CRI")
 		}
 	}
 
@@ -0,0 +1,114 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Mirantis/cri-dockerd/config"
+	dockertypes "github.com/docker/docker/api/types"
+	dockercontainer "github.com/docker/docker/api/types/container"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// dockerRuntimeForHandler returns the docker runtime a sandbox of the runtime
+// handler runs with, empty for docker's default runtime.
+func (ds *dockerService) dockerRuntimeForHandler(runtimeHandler string) (string, error) {
+	dockerRuntime := runtimeHandler
+	if handlerConfig := ds.runtimeHandlers.Get(runtimeHandler); handlerConfig != nil {
+		dockerRuntime = handlerConfig.DockerRuntime(runtimeHandler)
+	}
+	// k8s RuntimeClass.handler=docker will use docker's default runtime
+	if dockerRuntime == "" || dockerRuntime == runtimeName {
+		return "", nil
+	}
+	if err := ds.IsRuntimeConfigured(dockerRuntime); err != nil {
+		return "", err
+	}
+	return dockerRuntime, nil
+}
+
+// sandboxRuntimeHandler returns the runtime handler the sandbox was created
+// with.
+func (ds *dockerService) sandboxRuntimeHandler(sandbox *dockertypes.ContainerJSON) string {
+	if state, err := ds.getSandboxState(sandbox.ID); err == nil && state.RuntimeHandler != "" {
+		return state.RuntimeHandler
+	}
+	// Sandboxes created before the runtime handler was recorded.
+	return sandbox.HostConfig.Runtime
+}
+
+// applyRuntimeHandlerConfig applies the configuration of the runtime handler
+// to the host config of a sandbox or container. containerAnnotations is nil
+// for sandboxes.
+func applyRuntimeHandlerConfig(
+	hostConfig *dockercontainer.HostConfig,
+	runtimeHandler string,
+	handlerConfig *config.RuntimeHandlerConfig,
+	privileged bool,
+	podAnnotations, containerAnnotations map[string]string,
+) error {
+	if handlerConfig == nil {
+		return nil
+	}
+	if privileged && !handlerConfig.PrivilegedAllowed() {
+		return fmt.Errorf("privileged mode is not allowed with runtime handler %q", runtimeHandler)
+	}
+	hostConfig.SecurityOpt = append(hostConfig.SecurityOpt, handlerConfig.SecurityOpts...)
+
+	annotations := config.FilterAnnotations(podAnnotations, handlerConfig.PodAnnotations)
+	for k, v := range config.FilterAnnotations(containerAnnotations, handlerConfig.ContainerAnnotations) {
+		annotations[k] = v
+	}
+	if len(annotations) > 0 {
+		if hostConfig.Annotations == nil {
+			hostConfig.Annotations = make(map[string]string)
+		}
+		for k, v := range annotations {
+			hostConfig.Annotations[k] = v
+		}
+	}
+	return nil
+}
+
+// handlerSeccompProfile returns the seccomp profile of a container, the
+// default one of the runtime handler if the container doesn't ask for a
+// confined one. Unconfined is overridden as well as no profile, since it is
+// what the kubelet asks for when the pod sets none.
+func handlerSeccompProfile(
+	seccomp *runtimeapi.SecurityProfile,
+	handlerConfig *config.RuntimeHandlerConfig,
+) *runtimeapi.SecurityProfile {
+	if handlerConfig == nil || handlerConfig.DefaultSeccompProfile == "" {
+		return seccomp
+	}
+	if seccomp != nil && seccomp.GetProfileType() != runtimeapi.SecurityProfile_Unconfined {
+		return seccomp
+	}
+	switch profile := handlerConfig.DefaultSeccompProfile; {
+	case profile == "runtime/default":
+		return &runtimeapi.SecurityProfile{ProfileType: runtimeapi.SecurityProfile_RuntimeDefault}
+	case strings.HasPrefix(profile, "localhost/"):
+		return &runtimeapi.SecurityProfile{
+			ProfileType:  runtimeapi.SecurityProfile_Localhost,
+			LocalhostRef: strings.TrimPrefix(profile, "localhost/"),
+		}
+	default:
+		return &runtimeapi.SecurityProfile{ProfileType: runtimeapi.SecurityProfile_Unconfined}
+	}
+}
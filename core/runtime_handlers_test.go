@@ -0,0 +1,163 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"runtime"
+	"testing"
+
+	dockersystem "github.com/docker/docker/api/types/system"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"github.com/Mirantis/cri-dockerd/config"
+	"github.com/Mirantis/cri-dockerd/libdocker"
+)
+
+func newTestRuntimeHandlers() *config.RuntimeHandlersConfig {
+	allowPrivileged := false
+	userNamespaces := true
+	return &config.RuntimeHandlersConfig{
+		Handlers: map[string]config.RuntimeHandlerConfig{
+			"kata": {
+				Runtime:               "kata-runtime",
+				SandboxImage:          "registry.example.com/kata-pause:1.0",
+				SecurityOpts:          []string{"label=disable"},
+				DefaultSeccompProfile: "runtime/default",
+				AllowPrivileged:       &allowPrivileged,
+				PodAnnotations:        []string{"io.katacontainers.*"},
+				ContainerAnnotations:  []string{"io.katacontainers.container.*"},
+				Features: &config.RuntimeHandlerFeatures{
+					UserNamespaces: &userNamespaces,
+				},
+			},
+		},
+	}
+}
+
+func newTestDockerServiceWithRuntimeHandlers() (*dockerService, *libdocker.FakeDockerClient) {
+	ds, fDocker, _ := newTestDockerService()
+	ds.runtimeHandlers = newTestRuntimeHandlers()
+	fDocker.Information.Runtimes["kata-runtime"] = dockersystem.RuntimeWithStatus{
+		Runtime: dockersystem.Runtime{Path: "kata-runtime"},
+	}
+	return ds, fDocker
+}
+
+func TestRunPodSandboxWithRuntimeHandlerConfig(t *testing.T) {
+	ds, fDocker := newTestDockerServiceWithRuntimeHandlers()
+
+	sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+	sConfig.Annotations = map[string]string{
+		"io.katacontainers.config.hypervisor.kernel": "/kernel",
+		"other": "value",
+	}
+	resp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+		Config:         sConfig,
+		RuntimeHandler: "kata",
+	})
+	require.NoError(t, err)
+
+	sandbox, err := fDocker.InspectContainer(resp.PodSandboxId)
+	require.NoError(t, err)
+	assert.Equal(t, "kata-runtime", sandbox.HostConfig.Runtime)
+	assert.Equal(t, "registry.example.com/kata-pause:1.0", sandbox.Config.Image)
+	assert.Contains(t, sandbox.HostConfig.SecurityOpt, "label=disable")
+	assert.Equal(t, map[string]string{
+		"io.katacontainers.config.hypervisor.kernel": "/kernel",
+	}, sandbox.HostConfig.Annotations)
+
+	handlers, err := ds.getRuntimeHandlers()
+	require.NoError(t, err)
+	var kata *runtimeapi.RuntimeHandler
+	for _, h := range handlers {
+		if h.Name == "kata" {
+			kata = h
+		}
+	}
+	require.NotNil(t, kata)
+	assert.True(t, kata.Features.UserNamespaces)
+}
+
+func TestRunPodSandboxRejectsPrivilegedWithRuntimeHandlerConfig(t *testing.T) {
+	ds, _ := newTestDockerServiceWithRuntimeHandlers()
+
+	sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+	sConfig.Linux = &runtimeapi.LinuxPodSandboxConfig{
+		SecurityContext: &runtimeapi.LinuxSandboxSecurityContext{Privileged: true},
+	}
+	_, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+		Config:         sConfig,
+		RuntimeHandler: "kata",
+	})
+	assert.ErrorContains(t, err, "privileged mode is not allowed")
+
+	// Handlers without configuration run privileged pods.
+	_, err = ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{Config: sConfig})
+	assert.NoError(t, err)
+}
+
+func TestCreateContainerWithRuntimeHandlerConfig(t *testing.T) {
+	ds, fDocker := newTestDockerServiceWithRuntimeHandlers()
+
+	sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+	sConfig.Annotations = map[string]string{"io.katacontainers.config.hypervisor.kernel": "/kernel"}
+	sandboxResp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+		Config:         sConfig,
+		RuntimeHandler: "kata",
+	})
+	require.NoError(t, err)
+
+	cConfig := makeContainerConfig(sConfig, "app", "busybox", 0, nil, map[string]string{
+		"io.katacontainers.container.resource.swappiness": "0",
+		"io.katacontainers.config.hypervisor.kernel":      "ignored",
+	})
+	cConfig.Linux = &runtimeapi.LinuxContainerConfig{
+		SecurityContext: &runtimeapi.LinuxContainerSecurityContext{
+			Seccomp: &runtimeapi.SecurityProfile{ProfileType: runtimeapi.SecurityProfile_Unconfined},
+		},
+	}
+	createResp, err := ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+		PodSandboxId:  sandboxResp.PodSandboxId,
+		Config:        cConfig,
+		SandboxConfig: sConfig,
+	})
+	require.NoError(t, err)
+
+	container, err := fDocker.InspectContainer(createResp.ContainerId)
+	require.NoError(t, err)
+	assert.Equal(t, "kata-runtime", container.HostConfig.Runtime)
+	assert.Contains(t, container.HostConfig.SecurityOpt, "label=disable")
+	assert.Equal(t, map[string]string{
+		"io.katacontainers.config.hypervisor.kernel":      "/kernel",
+		"io.katacontainers.container.resource.swappiness": "0",
+	}, container.HostConfig.Annotations)
+	if runtime.GOOS == "linux" {
+		// The default profile of the handler replaces unconfined.
+		assert.NotContains(t, container.HostConfig.SecurityOpt, "seccomp=unconfined")
+	}
+
+	cConfig.Metadata.Name = "privileged"
+	cConfig.Linux.SecurityContext.Privileged = true
+	_, err = ds.CreateContainer(getTestCTX(), &runtimeapi.CreateContainerRequest{
+		PodSandboxId:  sandboxResp.PodSandboxId,
+		Config:        cConfig,
+		SandboxConfig: sConfig,
+	})
+	assert.ErrorContains(t, err, "privileged mode is not allowed")
+}
@@ -0,0 +1,210 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"context"
+	"time"
+
+	"github.com/Mirantis/cri-dockerd/config"
+	"github.com/Mirantis/cri-dockerd/store"
+	"github.com/Mirantis/cri-dockerd/utils/logging"
+	dockercontainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/wait"
+	v1 "k8s.io/cri-api/pkg/apis/runtime/v1"
+	"k8s.io/utils/clock"
+)
+
+// sandboxGC periodically reconciles the sandbox checkpoints with the sandbox
+// containers known to docker. Checkpoints left without a container, e.g.
+// because the container was removed outside of cri-dockerd, are removed
+// along with their networking, and sandbox containers whose checkpoint is
+// corrupt are removed. Nothing is done until a sandbox has been out of sync
+// for longer than the grace period.
+type sandboxGC struct {
+	ds       *dockerService
+	settings config.SandboxGCSettings
+	clock    clock.Clock
+
+	// suspects maps the IDs of the sandboxes found out of sync to the time
+	// they were first seen in that state.
+	suspects map[string]time.Time
+}
+
+func newSandboxGC(ds *dockerService, settings config.SandboxGCSettings) *sandboxGC {
+	return &sandboxGC{
+		ds:       ds,
+		settings: settings,
+		clock:    clock.RealClock{},
+		suspects: make(map[string]time.Time),
+	}
+}
+
+// start runs the reconciliation every period until the process exits.
+func (gc *sandboxGC) start() {
+	if gc.settings.Period <= 0 {
+		return
+	}
+	logrus.Infof(
+		"Starting sandbox garbage collector with period %v, grace period %v, dry-run %v",
+		gc.settings.Period,
+		gc.settings.GracePeriod,
+		gc.settings.DryRun,
+	)
+	go wait.Forever(gc.reconcile, gc.settings.Period)
+}
+
+// reconcile runs a single garbage collection pass.
+func (gc *sandboxGC) reconcile() {
+	// List the checkpoints first so that a sandbox being created right now
+	// is never seen as a checkpoint without a container.
+	checkpoints, err := gc.ds.checkpointManager.ListCheckpoints()
+	if err != nil {
+		logrus.Errorf("Sandbox garbage collection failed to list checkpoints: %v", err)
+		return
+	}
+
+	opts := dockercontainer.ListOptions{All: true}
+	opts.Filters = filters.NewArgs()
+	f := NewDockerFilter(&opts.Filters)
+	f.AddLabel(containerTypeLabelKey, containerTypeLabelSandbox)
+	containers, err := gc.ds.client.ListContainers(opts)
+	if err != nil {
+		logrus.Errorf("Sandbox garbage collection failed to list sandbox containers: %v", err)
+		return
+	}
+
+	now := gc.clock.Now()
+	outOfSync := make(map[string]bool)
+	hasContainer := make(map[string]bool, len(containers))
+	for _, c := range containers {
+		hasContainer[c.ID] = true
+	}
+
+	for _, id := range checkpoints {
+		if hasContainer[id] {
+			continue
+		}
+		checkpoint := NewPodSandboxCheckpoint("", "", &CheckpointData{})
+		err := gc.ds.checkpointManager.GetCheckpoint(id, checkpoint)
+		if err == store.ErrCheckpointNotFound {
+			// Removed since it was listed.
+			continue
+		}
+		outOfSync[id] = true
+		if !gc.gracePeriodExpired(id, now) {
+			continue
+		}
+		if err != nil {
+			logrus.WithError(err).WithField(logging.PodSandboxIDKey, id).
+				Info("Checkpoint of sandbox without container is corrupt")
+			checkpoint = nil
+		}
+		gc.removeOrphanedCheckpoint(id, checkpoint)
+	}
+
+	for _, c := range containers {
+		checkpoint := NewPodSandboxCheckpoint("", "", &CheckpointData{})
+		err := gc.ds.checkpointManager.GetCheckpoint(c.ID, checkpoint)
+		if err == nil || err == store.ErrCheckpointNotFound {
+			continue
+		}
+		outOfSync[c.ID] = true
+		if !gc.gracePeriodExpired(c.ID, now) {
+			continue
+		}
+		gc.removeCorruptSandbox(c.ID, err)
+	}
+
+	// Forget the sandboxes which got back in sync or were removed.
+	for id := range gc.suspects {
+		if !outOfSync[id] {
+			delete(gc.suspects, id)
+		}
+	}
+}
+
+// gracePeriodExpired returns whether the sandbox has been out of sync for
+// longer than the grace period, recording it as out of sync if it is new.
+func (gc *sandboxGC) gracePeriodExpired(podSandboxID string, now time.Time) bool {
+	first, ok := gc.suspects[podSandboxID]
+	if !ok {
+		logrus.WithField(logging.PodSandboxIDKey, podSandboxID).Debug("Sandbox is out of sync with its checkpoint")
+		gc.suspects[podSandboxID] = now
+		first = now
+	}
+	return now.Sub(first) >= gc.settings.GracePeriod
+}
+
+// removeOrphanedCheckpoint tears down the networking left behind by a sandbox
+// whose container is gone and removes its checkpoint. A nil checkpoint means
+// it could not be read, so there is no networking to tear down.
+func (gc *sandboxGC) removeOrphanedCheckpoint(podSandboxID string, checkpoint ContainerCheckpoint) {
+	ctx := logging.WithFields(context.Background(), logrus.Fields{logging.PodSandboxIDKey: podSandboxID})
+	log := logging.FromContext(ctx)
+	if checkpoint != nil {
+		_, name, namespace, _, hostNetwork := checkpoint.GetData()
+		if !hostNetwork {
+			netLog := log.WithField(logging.PodKey, namespace+"/"+name)
+			if gc.settings.DryRun {
+				netLog.Info("Dry-run: would tear down network of orphaned sandbox")
+			} else {
+				netLog.Info("Tearing down network of orphaned sandbox")
+				if err := gc.ds.tearDownSandboxNetwork(ctx, podSandboxID, namespace, name); err != nil {
+					// Keep the checkpoint, the teardown is retried on the next pass.
+					netLog.WithError(err).Error("Failed to tear down network of orphaned sandbox")
+					return
+				}
+			}
+		}
+	}
+
+	if gc.settings.DryRun {
+		log.Info("Dry-run: would remove checkpoint of sandbox without container")
+		return
+	}
+	log.WithField("gracePeriod", gc.settings.GracePeriod).
+		Info("Removing checkpoint of sandbox without container for more than the grace period")
+	if err := gc.ds.checkpointManager.RemoveCheckpoint(podSandboxID); err != nil {
+		log.WithError(err).Error("Failed to remove checkpoint of sandbox")
+		return
+	}
+	gc.ds.clearNetworkReady(podSandboxID)
+	delete(gc.suspects, podSandboxID)
+}
+
+// removeCorruptSandbox stops and removes a sandbox whose checkpoint is corrupt.
+func (gc *sandboxGC) removeCorruptSandbox(podSandboxID string, checkpointErr error) {
+	ctx := logging.WithFields(context.Background(), logrus.Fields{logging.PodSandboxIDKey: podSandboxID})
+	log := logging.FromContext(ctx)
+	if gc.settings.DryRun {
+		log.WithError(checkpointErr).Info("Dry-run: would remove sandbox with corrupt checkpoint")
+		return
+	}
+	log.WithError(checkpointErr).Info("Removing sandbox with corrupt checkpoint")
+	if _, err := gc.ds.StopPodSandbox(ctx, &v1.StopPodSandboxRequest{PodSandboxId: podSandboxID}); err != nil {
+		log.WithError(err).Error("Failed to stop sandbox with corrupt checkpoint")
+		return
+	}
+	if _, err := gc.ds.RemovePodSandbox(ctx, &v1.RemovePodSandboxRequest{PodSandboxId: podSandboxID}); err != nil {
+		log.WithError(err).Error("Failed to remove sandbox with corrupt checkpoint")
+		return
+	}
+	delete(gc.suspects, podSandboxID)
+}
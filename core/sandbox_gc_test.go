@@ -0,0 +1,132 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	dockercontainer "github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+	clock "k8s.io/utils/clock/testing"
+
+	"github.com/Mirantis/cri-dockerd/config"
+	"github.com/Mirantis/cri-dockerd/libdocker"
+	"github.com/Mirantis/cri-dockerd/store"
+)
+
+const testSandboxGCGracePeriod = 5 * time.Minute
+
+func newTestSandboxGC(t *testing.T, dryRun bool) (*sandboxGC, *libdocker.FakeDockerClient, *clock.FakeClock, string) {
+	ds, fDocker, _ := newTestDockerService()
+	checkpointDir := t.TempDir()
+	checkpointManager, err := store.NewCheckpointManager(checkpointDir)
+	require.NoError(t, err)
+	ds.checkpointManager = checkpointManager
+
+	fakeClock := clock.NewFakeClock(time.Now())
+	gc := newSandboxGC(ds, config.SandboxGCSettings{
+		Period:      time.Minute,
+		GracePeriod: testSandboxGCGracePeriod,
+		DryRun:      dryRun,
+	})
+	gc.clock = fakeClock
+	return gc, fDocker, fakeClock, checkpointDir
+}
+
+func runTestSandbox(t *testing.T, ds *dockerService, name string) string {
+	resp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+		Config: makeSandboxConfig(name, "bar", name, 0),
+	})
+	require.NoError(t, err)
+	return resp.PodSandboxId
+}
+
+func TestSandboxGCRemovesOrphanedCheckpoint(t *testing.T) {
+	for _, dryRun := range []bool{false, true} {
+		gc, fDocker, fakeClock, _ := newTestSandboxGC(t, dryRun)
+		id := runTestSandbox(t, gc.ds, "foo")
+
+		// Remove the sandbox container behind cri-dockerd's back.
+		require.NoError(t, fDocker.StopContainer(id, 0))
+		require.NoError(t, fDocker.RemoveContainer(id, dockercontainer.RemoveOptions{}))
+
+		gc.reconcile()
+		checkpoints, err := gc.ds.checkpointManager.ListCheckpoints()
+		require.NoError(t, err)
+		assert.Equal(t, []string{id}, checkpoints, "checkpoint removed before the grace period")
+
+		fakeClock.Step(testSandboxGCGracePeriod)
+		gc.reconcile()
+		checkpoints, err = gc.ds.checkpointManager.ListCheckpoints()
+		require.NoError(t, err)
+		if dryRun {
+			assert.Equal(t, []string{id}, checkpoints, "checkpoint removed in dry-run mode")
+		} else {
+			assert.Empty(t, checkpoints)
+			assert.Empty(t, gc.suspects)
+		}
+	}
+}
+
+func TestSandboxGCRemovesSandboxWithCorruptCheckpoint(t *testing.T) {
+	for _, dryRun := range []bool{false, true} {
+		gc, fDocker, fakeClock, checkpointDir := newTestSandboxGC(t, dryRun)
+		id := runTestSandbox(t, gc.ds, "foo")
+		healthy := runTestSandbox(t, gc.ds, "healthy")
+
+		require.NoError(t, os.WriteFile(filepath.Join(checkpointDir, id), []byte("{corrupt"), 0644))
+
+		gc.reconcile()
+		_, err := fDocker.InspectContainer(id)
+		require.NoError(t, err, "sandbox removed before the grace period")
+
+		fakeClock.Step(testSandboxGCGracePeriod)
+		gc.reconcile()
+		_, err = fDocker.InspectContainer(id)
+		if dryRun {
+			assert.NoError(t, err, "sandbox removed in dry-run mode")
+		} else {
+			assert.Error(t, err, "sandbox with a corrupt checkpoint was not removed")
+		}
+
+		_, err = fDocker.InspectContainer(healthy)
+		assert.NoError(t, err, "sandbox with a valid checkpoint was removed")
+	}
+}
+
+func TestSandboxGCForgetsSandboxesBackInSync(t *testing.T) {
+	gc, fDocker, fakeClock, checkpointDir := newTestSandboxGC(t, false)
+	id := runTestSandbox(t, gc.ds, "foo")
+
+	valid, err := os.ReadFile(filepath.Join(checkpointDir, id))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(checkpointDir, id), []byte("{corrupt"), 0644))
+	gc.reconcile()
+	assert.Contains(t, gc.suspects, id)
+
+	require.NoError(t, os.WriteFile(filepath.Join(checkpointDir, id), valid, 0644))
+	fakeClock.Step(testSandboxGCGracePeriod)
+	gc.reconcile()
+	assert.Empty(t, gc.suspects)
+	_, err = fDocker.InspectContainer(id)
+	assert.NoError(t, err)
+}
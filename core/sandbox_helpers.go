@@ -17,6 +17,7 @@ limitations under the License.
 package core
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strings"
@@ -25,6 +26,7 @@ import (
 	"github.com/Mirantis/cri-dockerd/libdocker"
 	"github.com/Mirantis/cri-dockerd/utils"
 	"github.com/Mirantis/cri-dockerd/utils/errors"
+	"github.com/Mirantis/cri-dockerd/utils/logging"
 	"k8s.io/kubernetes/pkg/credentialprovider"
 
 	"github.com/Mirantis/cri-dockerd/config"
@@ -98,7 +100,10 @@ func (ds *dockerService) clearNetworkReady(podSandboxID string) {
 }
 
 // getIPsFromPlugin interrogates the network plugin for sandbox IPs.
-func (ds *dockerService) getIPsFromPlugin(sandbox *dockertypes.ContainerJSON) ([]string, error) {
+func (ds *dockerService) getIPsFromPlugin(
+	ctx context.Context,
+	sandbox *dockertypes.ContainerJSON,
+) ([]string, error) {
 	metadata, err := parseSandboxName(sandbox.Name)
 	if err != nil {
 		return nil, err
@@ -109,7 +114,7 @@ func (ds *dockerService) getIPsFromPlugin(sandbox *dockertypes.ContainerJSON) ([
 		metadata.Name,
 	)
 	cID := config.BuildContainerID(runtimeName, sandbox.ID)
-	networkStatus, err := ds.network.GetPodNetworkStatus(metadata.Namespace, metadata.Name, cID)
+	networkStatus, err := ds.network.GetPodNetworkStatus(ctx, metadata.Namespace, metadata.Name, cID)
 	if err != nil {
 		return nil, err
 	}
@@ -131,7 +136,11 @@ func (ds *dockerService) getIPsFromPlugin(sandbox *dockertypes.ContainerJSON) ([
 // getIPs returns the ip given the output of `docker inspect` on a pod sandbox,
 // first interrogating any registered plugins, then simply trusting the ip
 // in the sandbox itself. We look for an ipv4 address before ipv6.
-func (ds *dockerService) getIPs(podSandboxID string, sandbox *dockertypes.ContainerJSON) []string {
+func (ds *dockerService) getIPs(
+	ctx context.Context,
+	podSandboxID string,
+	sandbox *dockertypes.ContainerJSON,
+) []string {
 	if sandbox.NetworkSettings == nil {
 		return nil
 	}
@@ -147,7 +156,12 @@ func (ds *dockerService) getIPs(podSandboxID string, sandbox *dockertypes.Contai
 		return nil
 	}
 
-	ips, err := ds.getIPsFromPlugin(sandbox)
+	// Trust the IPs recorded when the network was set up.
+	if state, err := ds.getSandboxState(podSandboxID); err == nil && len(state.IPs) > 0 {
+		return state.IPs
+	}
+
+	ips, err := ds.getIPsFromPlugin(ctx, sandbox)
 	if err == nil {
 		return ips
 	}
@@ -166,7 +180,8 @@ func (ds *dockerService) getIPs(podSandboxID string, sandbox *dockertypes.Contai
 
 	// If all else fails, warn but don't return an error, as pod status
 	// should generally not return anything except fatal errors
-	logrus.Infof("Failed to read pod IP from plugin/docker: %v", err)
+	logging.FromContext(ctx).WithError(err).WithField(logging.PodSandboxIDKey, podSandboxID).
+		Info("Failed to read pod IP from plugin/docker")
 	return ips
 }
 
@@ -310,6 +325,7 @@ func ipcNamespaceMode(container *dockertypes.ContainerJSON) runtimeapi.Namespace
 
 func constructPodSandboxCheckpoint(
 	sandboxConfig *runtimeapi.PodSandboxConfig,
+	runtimeHandler string,
 ) Checkpoint {
 	data := CheckpointData{}
 	for _, pm := range sandboxConfig.GetPortMappings() {
@@ -324,7 +340,11 @@ func constructPodSandboxCheckpoint(
 	if sandboxConfig.GetLinux().GetSecurityContext().GetNamespaceOptions().GetNetwork() == runtimeapi.NamespaceMode_NODE {
 		data.HostNetwork = true
 	}
-	return NewPodSandboxCheckpoint(sandboxConfig.Metadata.Namespace, sandboxConfig.Metadata.Name, &data)
+	checkpoint := NewPodSandboxCheckpoint(sandboxConfig.Metadata.Namespace, sandboxConfig.Metadata.Name, &data)
+	state := checkpoint.GetState()
+	state.RuntimeHandler = runtimeHandler
+	state.DNSConfig = newCheckpointDNSConfig(sandboxConfig.GetDnsConfig())
+	return checkpoint
 }
 
 func toCheckpointProtocol(protocol runtimeapi.Protocol) config.Protocol {
@@ -342,13 +362,15 @@ func toCheckpointProtocol(protocol runtimeapi.Protocol) config.Protocol {
 
 // rewriteResolvFile rewrites resolv.conf file generated by docker.
 func rewriteResolvFile(
+	ctx context.Context,
 	resolvFilePath string,
 	dns []string,
 	dnsSearch []string,
 	dnsOptions []string,
 ) error {
+	log := logging.FromContext(ctx)
 	if len(resolvFilePath) == 0 {
-		logrus.Error("ResolvConfPath is empty.")
+		log.Error("ResolvConfPath is empty.")
 		return nil
 	}
 
@@ -373,9 +395,9 @@ func rewriteResolvFile(
 		resolvFileContentStr := strings.Join(resolvFileContent, "\n")
 		resolvFileContentStr += "\n"
 
-		logrus.Infof("Will attempt to re-write config file %s as %v", resolvFilePath, resolvFileContent)
+		log.Infof("Will attempt to re-write config file %s as %v", resolvFilePath, resolvFileContent)
 		if err := rewriteFile(resolvFilePath, resolvFileContentStr); err != nil {
-			logrus.Errorf("Resolv.conf could not be updated: %v", err)
+			log.WithError(err).Error("Resolv.conf could not be updated")
 			return err
 		}
 	}
@@ -395,6 +417,7 @@ func rewriteFile(filePath, stringToWrite string) error {
 }
 
 func recoverFromCreationConflictIfNeeded(
+	ctx context.Context,
 	client libdocker.DockerClientInterface,
 	createConfig dockerbackend.ContainerCreateConfig,
 	err error,
@@ -405,13 +428,14 @@ func recoverFromCreationConflictIfNeeded(
 	}
 
 	id := matches[1]
-	logrus.Infof("Unable to create pod sandbox due to conflict. Attempting to remove sandbox. Container %v", id)
+	log := logging.FromContext(ctx).WithField(logging.ContainerIDKey, id)
+	log.Info("Unable to create pod sandbox due to conflict. Attempting to remove sandbox")
 	rmErr := client.RemoveContainer(id, dockercontainer.RemoveOptions{RemoveVolumes: true})
 	if rmErr == nil {
-		logrus.Infof("Successfully removed conflicting container: %v", id)
+		log.Info("Successfully removed conflicting container")
 		return nil, err
 	}
-	logrus.Errorf("Failed to remove the conflicting container (%v): %v", id, rmErr)
+	log.WithError(rmErr).Error("Failed to remove the conflicting container")
 	// Return if the error is not container not found error.
 	if !libdocker.IsContainerNotFoundError(rmErr) {
 		return nil, err
@@ -419,20 +443,68 @@ func recoverFromCreationConflictIfNeeded(
 
 	// randomize the name to avoid conflict.
 	createConfig.Name = randomizeName(createConfig.Name)
-	logrus.Debugf("Creating a container with a randomized name: %s", createConfig.Name)
+	log.Debugf("Creating a container with a randomized name: %s", createConfig.Name)
 	return client.CreateContainer(createConfig)
 }
 
-// ensureSandboxImageExists pulls the sandbox image when it's not present.
-func ensureSandboxImageExists(client libdocker.DockerClientInterface, image string) error {
+// ensureSandboxImageExists pulls the sandbox image, or a pinned image, when
+// it's not present. The image is checked against the signature policy as the
+// images of PullImage are.
+func (ds *dockerService) ensureSandboxImageExists(ctx context.Context, image string) error {
+	client := ds.tracedClient(ctx)
 	_, err := client.InspectImageByRef(image)
 	if err == nil {
 		return nil
 	}
 	if !libdocker.IsImageNotFoundError(err) {
-		return fmt.Errorf("failed to inspect sandbox image %q: %v", image, err)
+		return fmt.Errorf("failed to inspect image %q: %v", image, err)
+	}
+
+	verifiedDigest, err := ds.verifyImageSignature(ctx, image, keyringAuthConfig(image))
+	if err != nil {
+		return err
+	}
+	pullRef, err := pinnedImageReference(image, verifiedDigest)
+	if err != nil {
+		return err
+	}
+	key := pullKey(pullRef, dockerregistry.AuthConfig{})
+	pulledImage, err := ds.pullScheduler.schedule(ctx, key, pullPriorityHigh, func() (string, error) {
+		return pullImageFromMirrors(ctx, client, ds.registryMirrors, pullRef, func(candidate string) error {
+			return pullImageWithKeyring(ctx, client, candidate)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	if err := ds.checkPulledImageDigest(ctx, image, pulledImage, verifiedDigest); err != nil {
+		return err
 	}
+	if pullRef != image {
+		if err := client.TagImage(pulledImage, image); err != nil {
+			return fmt.Errorf("failed to tag verified image %q as %q: %v", pulledImage, image, err)
+		}
+	}
+	return nil
+}
+
+// keyringAuthConfig returns the first credentials of the docker keyring and
+// credential provider plugins for the image, to look its signatures up with.
+func keyringAuthConfig(image string) dockerregistry.AuthConfig {
+	repoToPull, _, _, err := utils.ParseImageName(image)
+	if err != nil {
+		return dockerregistry.AuthConfig{}
+	}
+	creds, ok := credentialprovider.NewDockerKeyring().Lookup(repoToPull)
+	if !ok || len(creds) == 0 {
+		return dockerregistry.AuthConfig{}
+	}
+	return dockerregistry.AuthConfig(creds[0])
+}
 
+// pullImageWithKeyring pulls the image with the credentials of the docker
+// keyring and credential provider plugins.
+func pullImageWithKeyring(ctx context.Context, client libdocker.DockerClientInterface, image string) error {
 	repoToPull, _, _, err := utils.ParseImageName(image)
 	if err != nil {
 		return err
@@ -441,7 +513,7 @@ func ensureSandboxImageExists(client libdocker.DockerClientInterface, image stri
 	keyring := credentialprovider.NewDockerKeyring()
 	creds, withCredentials := keyring.Lookup(repoToPull)
 	if !withCredentials {
-		logrus.Infof("Pulling the image without credentials. Image: %v", image)
+		logging.FromContext(ctx).WithField(logging.ImageKey, image).Info("Pulling the image without credentials")
 
 		err := client.PullImage(image, dockerregistry.AuthConfig{}, dockerimage.PullOptions{})
 		if err != nil {
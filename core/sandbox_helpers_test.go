@@ -207,6 +207,24 @@ func TestSandboxHasLeastPrivilegesConfig(t *testing.T) {
 	)
 }
 
+// TestSandboxApparmorProfile tests that the apparmor profile of the sandbox
+// security context is applied to the sandbox.
+func TestSandboxApparmorProfile(t *testing.T) {
+	ds, _, _ := newTestDockerService()
+	sandboxConfig := makeSandboxConfig("foo", "bar", "1", 0)
+	sandboxConfig.Linux = &runtimeapi.LinuxPodSandboxConfig{
+		SecurityContext: &runtimeapi.LinuxSandboxSecurityContext{
+			Apparmor: &runtimeapi.SecurityProfile{
+				ProfileType: runtimeapi.SecurityProfile_Unconfined,
+			},
+		},
+	}
+
+	createConfig, err := ds.makeSandboxDockerConfig(sandboxConfig, defaultSandboxImage)
+	require.NoError(t, err)
+	assert.Contains(t, createConfig.HostConfig.SecurityOpt, "apparmor=unconfined")
+}
+
 // TestSandboxStatusAfterRestart tests that retrieving sandbox status returns
 // an IP address even if RunPodSandbox() was not yet called for this pod, as
 // would happen on kubelet restart
@@ -281,7 +299,10 @@ func TestNetworkPluginInvocation(t *testing.T) {
 
 	mockPlugin.EXPECT().Name().Return("mockNetworkPlugin").AnyTimes()
 	setup := mockPlugin.EXPECT().SetUpPod(ns, name, cID)
-	mockPlugin.EXPECT().TearDownPod(ns, name, cID).After(setup)
+	// The IPs are recorded in the checkpoint once the network is set up.
+	status := mockPlugin.EXPECT().GetPodNetworkStatus(ns, name, cID).
+		Return(&network.PodNetworkStatus{IP: net.ParseIP("10.0.0.2")}, nil).After(setup)
+	mockPlugin.EXPECT().TearDownPod(ns, name, cID).After(status)
 
 	_, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{Config: c})
 	require.NoError(t, err)
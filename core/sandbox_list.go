@@ -19,24 +19,25 @@ package core
 import (
 	"context"
 
+	"github.com/Mirantis/cri-dockerd/config"
 	"github.com/Mirantis/cri-dockerd/libdocker"
 	"github.com/Mirantis/cri-dockerd/store"
+	"github.com/Mirantis/cri-dockerd/utils/logging"
 	dockercontainer "github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
-	"github.com/sirupsen/logrus"
 	v1 "k8s.io/cri-api/pkg/apis/runtime/v1"
 )
 
 // ListPodSandbox returns a list of Sandbox.
 func (ds *dockerService) ListPodSandbox(
-	_ context.Context,
+	ctx context.Context,
 	r *v1.ListPodSandboxRequest,
 ) (*v1.ListPodSandboxResponse, error) {
 	filter := r.GetFilter()
+	log := logging.FromContext(ctx)
 
 	// By default, list all containers whether they are running or not.
 	opts := dockercontainer.ListOptions{All: true}
-	filterOutReadySandboxes := false
 
 	opts.Filters = filters.NewArgs()
 	f := NewDockerFilter(&opts.Filters)
@@ -51,14 +52,12 @@ func (ds *dockerService) ListPodSandbox(
 			if filter.GetState().State == v1.PodSandboxState_SANDBOX_READY {
 				// Only list running containers.
 				opts.All = false
-			} else {
-				// runtimeapi.PodSandboxState_SANDBOX_NOTREADY can mean the
-				// container is in any of the non-running state (e.g., created,
-				// exited). We can't tell docker to filter out running
-				// containers directly, so we'll need to filter them out
-				// ourselves after getting the results.
-				filterOutReadySandboxes = true
 			}
+			// runtimeapi.PodSandboxState_SANDBOX_NOTREADY can mean the
+			// container is in any of the non-running state (e.g., created,
+			// exited), or running but with its network lost. We can't tell
+			// docker to filter on that directly, so we'll need to filter
+			// ourselves after getting the results.
 		}
 
 		if filter.LabelSelector != nil {
@@ -75,11 +74,11 @@ func (ds *dockerService) ListPodSandbox(
 	if filter == nil {
 		checkpoints, err = ds.checkpointManager.ListCheckpoints()
 		if err != nil {
-			logrus.Errorf("Failed to list checkpoints: %v", err)
+			log.WithError(err).Error("Failed to list checkpoints")
 		}
 	}
 
-	containers, err := ds.client.ListContainers(opts)
+	containers, err := ds.listContainers(opts)
 	if err != nil && !libdocker.IsContainerNotFoundError(err) {
 		return nil, err
 	}
@@ -92,10 +91,13 @@ func (ds *dockerService) ListPodSandbox(
 		c := containers[i]
 		converted, err := containerToRuntimeAPISandbox(&c)
 		if err != nil {
-			logrus.Infof("Unable to convert docker container(s) %v to runtime API sandbox: %v", c.Names, err)
+			log.WithError(err).WithField(logging.PodSandboxIDKey, c.ID).Info("Unable to convert docker container to runtime API sandbox")
 			continue
 		}
-		if filterOutReadySandboxes && converted.State == v1.PodSandboxState_SANDBOX_READY {
+		if converted.State == v1.PodSandboxState_SANDBOX_READY && ds.isSandboxNetworkLost(converted.Id) {
+			converted.State = v1.PodSandboxState_SANDBOX_NOTREADY
+		}
+		if filter.GetState() != nil && converted.State != filter.GetState().State {
 			continue
 		}
 		sandboxIDs[converted.Id] = true
@@ -112,11 +114,11 @@ func (ds *dockerService) ListPodSandbox(
 		checkpoint := NewPodSandboxCheckpoint("", "", &CheckpointData{})
 		err := ds.checkpointManager.GetCheckpoint(id, checkpoint)
 		if err != nil {
-			logrus.Errorf("Failed to retrieve checkpoint for sandbox %s: %v", id, err)
+			log.WithError(err).WithField(logging.PodSandboxIDKey, id).Error("Failed to retrieve sandbox checkpoint")
 			if err == store.ErrCorruptCheckpoint {
 				err = ds.checkpointManager.RemoveCheckpoint(id)
 				if err != nil {
-					logrus.Errorf("Failed to delete corrupt checkpoint for sandbox %s: %v", id, err)
+					log.WithError(err).WithField(logging.PodSandboxIDKey, id).Error("Failed to delete corrupt sandbox checkpoint")
 				}
 			}
 			continue
@@ -126,3 +128,39 @@ func (ds *dockerService) ListPodSandbox(
 
 	return &v1.ListPodSandboxResponse{Items: result}, nil
 }
+
+// sandboxLister is a wrapper around the dockerService that implements
+// the network.SandboxLister interface.
+type sandboxLister struct {
+	ds *dockerService
+}
+
+// ListSandboxIDs returns the IDs of all sandbox containers and of all
+// sandboxes that only exist as a checkpoint.
+func (l *sandboxLister) ListSandboxIDs() ([]config.ContainerID, error) {
+	// ListPodSandbox only logs checkpoint listing errors, so list them
+	// separately to never report a partial set.
+	checkpoints, err := l.ds.checkpointManager.ListCheckpoints()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := l.ds.ListPodSandbox(context.Background(), &v1.ListPodSandboxRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(resp.Items)+len(checkpoints))
+	ids := make([]config.ContainerID, 0, len(resp.Items)+len(checkpoints))
+	for _, id := range checkpoints {
+		seen[id] = true
+		ids = append(ids, config.BuildContainerID(runtimeName, id))
+	}
+	for _, sandbox := range resp.Items {
+		if seen[sandbox.Id] {
+			continue
+		}
+		seen[sandbox.Id] = true
+		ids = append(ids, config.BuildContainerID(runtimeName, sandbox.Id))
+	}
+	return ids, nil
+}
@@ -0,0 +1,272 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/Mirantis/cri-dockerd/config"
+	dockertypes "github.com/docker/docker/api/types"
+	dockercontainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/sirupsen/logrus"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"github.com/Mirantis/cri-dockerd/utils/logging"
+)
+
+// sandboxInstance identifies the sandbox container process whose network
+// namespace holds the pod network. When docker restarts a sandbox container,
+// e.g. after a dockerd restart without live-restore, the process and its
+// network namespace are replaced and the pod network has to be set up again.
+type sandboxInstance struct {
+	pid       int
+	startedAt string
+	// recovering is set while the network is being set up again in the new
+	// network namespace, in the background.
+	recovering bool
+	// networkLost is set if setting up the network again failed.
+	networkLost bool
+}
+
+func newSandboxInstance(r *dockertypes.ContainerJSON) *sandboxInstance {
+	if r.State == nil {
+		return &sandboxInstance{}
+	}
+	return &sandboxInstance{
+		pid:       r.State.Pid,
+		startedAt: r.State.StartedAt,
+	}
+}
+
+// matches returns whether r is still the same sandbox container process.
+func (i *sandboxInstance) matches(r *dockertypes.ContainerJSON) bool {
+	return r.State != nil && i.pid == r.State.Pid && i.startedAt == r.State.StartedAt
+}
+
+func (ds *dockerService) setSandboxInstance(podSandboxID string, instance *sandboxInstance) {
+	ds.sandboxInstancesLock.Lock()
+	defer ds.sandboxInstancesLock.Unlock()
+	ds.sandboxInstances[podSandboxID] = instance
+}
+
+func (ds *dockerService) clearSandboxInstance(podSandboxID string) {
+	ds.sandboxInstancesLock.Lock()
+	defer ds.sandboxInstancesLock.Unlock()
+	delete(ds.sandboxInstances, podSandboxID)
+}
+
+// checkpointedSandboxInstance returns the sandbox container process the
+// network was set up for according to the checkpoint of the sandbox, nil if
+// it isn't recorded.
+func (ds *dockerService) checkpointedSandboxInstance(ctx context.Context, podSandboxID string) *sandboxInstance {
+	state, err := ds.getSandboxState(podSandboxID)
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).WithField(logging.PodSandboxIDKey, podSandboxID).
+			Debug("Failed to get checkpoint of sandbox to check its network")
+		return nil
+	}
+	if state.StartedAt == "" {
+		return nil
+	}
+	return &sandboxInstance{pid: state.Pid, startedAt: state.StartedAt}
+}
+
+// isSandboxNetworkLost returns whether the sandbox lost its network and must
+// be reported as not ready.
+func (ds *dockerService) isSandboxNetworkLost(podSandboxID string) bool {
+	ds.sandboxInstancesLock.Lock()
+	defer ds.sandboxInstancesLock.Unlock()
+	instance, ok := ds.sandboxInstances[podSandboxID]
+	return ok && instance.networkLost
+}
+
+// checkSandboxNetwork detects whether the running sandbox container r was
+// restarted since its network was set up and, if so, sets up the network
+// again in the background. It returns whether the sandbox network is usable;
+// if it isn't, the sandbox must be reported as not ready so that the kubelet
+// recreates it. While the network is being set up again, the sandbox is
+// reported as ready, not to have the kubelet recreate it in the meantime, but
+// without IPs, since those of the previous network namespace are gone.
+func (ds *dockerService) checkSandboxNetwork(ctx context.Context, r *dockertypes.ContainerJSON) bool {
+	if r.State == nil || !r.State.Running || networkNamespaceMode(r) == runtimeapi.NamespaceMode_NODE {
+		return true
+	}
+
+	ds.sandboxInstancesLock.Lock()
+	_, known := ds.sandboxInstances[r.ID]
+	ds.sandboxInstancesLock.Unlock()
+	var checkpointed *sandboxInstance
+	if !known {
+		// The network was set up before cri-dockerd started, compare with the
+		// process recorded in the checkpoint then. The checkpoint is read
+		// without the lock, not to hold up the checks of the other sandboxes.
+		checkpointed = ds.checkpointedSandboxInstance(ctx, r.ID)
+	}
+
+	ds.sandboxInstancesLock.Lock()
+	defer ds.sandboxInstancesLock.Unlock()
+	instance, ok := ds.sandboxInstances[r.ID]
+	if !ok {
+		// The checkpoints written by older releases don't have the process,
+		// assume the network lives in the current network namespace for
+		// those.
+		instance = checkpointed
+		if instance == nil {
+			instance = newSandboxInstance(r)
+		}
+		ds.sandboxInstances[r.ID] = instance
+	}
+	if instance.matches(r) {
+		return !instance.networkLost
+	}
+	logging.FromContext(ctx).WithField(logging.PodSandboxIDKey, r.ID).Infof(
+		"Sandbox was restarted (pid %d started at %s, was pid %d started at %s), setting up its network again",
+		r.State.Pid,
+		r.State.StartedAt,
+		instance.pid,
+		instance.startedAt,
+	)
+	// Record the new instance right away, so that concurrent checks don't
+	// start a second recovery.
+	instance = newSandboxInstance(r)
+	instance.recovering = true
+	ds.sandboxInstances[r.ID] = instance
+	// The IPs of the previous network namespace are gone, don't report them
+	// until the network is set up again.
+	ds.setNetworkReady(r.ID, false)
+	// The network is set up again even if the request which noticed the
+	// restart is over, not to leave it half set up.
+	go ds.recoverSandboxInstanceNetwork(context.WithoutCancel(ctx), r, instance)
+	return true
+}
+
+// recoverSandboxInstanceNetwork sets up the network of the restarted sandbox
+// instance again, and records whether that succeeded.
+func (ds *dockerService) recoverSandboxInstanceNetwork(
+	ctx context.Context,
+	r *dockertypes.ContainerJSON,
+	instance *sandboxInstance,
+) {
+	log := logging.FromContext(ctx).WithField(logging.PodSandboxIDKey, r.ID)
+	err := ds.recoverSandboxNetwork(ctx, r)
+
+	ds.sandboxInstancesLock.Lock()
+	current, ok := ds.sandboxInstances[r.ID]
+	stopped := !ok || current != instance
+	instance.recovering = false
+	instance.networkLost = err != nil
+	ds.sandboxInstancesLock.Unlock()
+
+	if err != nil {
+		log.WithError(err).Error("Failed to set up network of restarted sandbox, reporting it as not ready")
+		return
+	}
+	if stopped {
+		// The sandbox was stopped while its network was being set up again,
+		// don't leave the new network behind.
+		log.Info("Sandbox was stopped while its network was being set up again")
+		ds.tearDownRecoveredSandboxNetwork(ctx, r.ID)
+		return
+	}
+	log.Info("Network of restarted sandbox was set up again")
+}
+
+// tearDownRecoveredSandboxNetwork tears down the network set up again for a
+// sandbox stopped in the meantime.
+func (ds *dockerService) tearDownRecoveredSandboxNetwork(ctx context.Context, podSandboxID string) {
+	log := logging.FromContext(ctx).WithField(logging.PodSandboxIDKey, podSandboxID)
+	checkpoint := NewPodSandboxCheckpoint("", "", &CheckpointData{})
+	if err := ds.checkpointManager.GetCheckpoint(podSandboxID, checkpoint); err != nil {
+		log.WithError(err).Error("Failed to get checkpoint of stopped sandbox")
+		return
+	}
+	_, name, namespace, _, _ := checkpoint.GetData()
+	if err := ds.tearDownSandboxNetwork(ctx, podSandboxID, namespace, name); err != nil {
+		log.WithError(err).Error("Failed to tear down network of stopped sandbox")
+		return
+	}
+	ds.setNetworkReady(podSandboxID, false)
+	ds.recordSandboxNetworkTornDown(ctx, podSandboxID)
+}
+
+// recoverSandboxNetwork sets up the pod network of a restarted sandbox again,
+// using its checkpoint so that port mappings are restored.
+func (ds *dockerService) recoverSandboxNetwork(ctx context.Context, r *dockertypes.ContainerJSON) error {
+	log := logging.FromContext(ctx).WithField(logging.PodSandboxIDKey, r.ID)
+	checkpoint := NewPodSandboxCheckpoint("", "", &CheckpointData{})
+	if err := ds.checkpointManager.GetCheckpoint(r.ID, checkpoint); err != nil {
+		return fmt.Errorf("failed to get checkpoint: %v", err)
+	}
+	_, name, namespace, _, _ := checkpoint.GetData()
+	cID := config.BuildContainerID(runtimeName, r.ID)
+	networkOptions := make(map[string]string)
+	if dnsConfig := checkpoint.GetState().DNSConfig; dnsConfig != nil {
+		dnsOption, err := json.Marshal(dnsConfig.toRuntimeAPIDNSConfig())
+		if err != nil {
+			return fmt.Errorf("failed to marshal dns config: %v", err)
+		}
+		networkOptions["dns"] = string(dnsOption)
+	}
+
+	// Release what the plugin allocated in the previous network namespace.
+	// That namespace is gone, so this is best effort.
+	if err := ds.tearDownSandboxNetwork(ctx, r.ID, namespace, name); err != nil {
+		log.WithError(err).Info("Failed to tear down previous network of restarted sandbox")
+	}
+
+	var annotations map[string]string
+	if r.Config != nil {
+		_, annotations = extractLabels(r.Config.Labels)
+	}
+	if err := ds.network.SetUpPod(ctx, namespace, name, cID, annotations, networkOptions); err != nil {
+		// Ensure network resources are cleaned up even if the plugin
+		// succeeded partially, as RunPodSandbox does.
+		if tearDownErr := ds.network.TearDownPod(ctx, namespace, name, cID); tearDownErr != nil {
+			log.WithError(tearDownErr).Error("Failed to clean up network of restarted sandbox")
+		} else {
+			ds.recordSandboxNetworkTornDown(ctx, r.ID)
+		}
+		return err
+	}
+	ds.setNetworkReady(r.ID, true)
+	ds.recordSandboxNetworkSetUp(ctx, r)
+	return nil
+}
+
+// checkSandboxNetworks checks the network of every running sandbox.
+func (ds *dockerService) checkSandboxNetworks() {
+	opts := dockercontainer.ListOptions{}
+	opts.Filters = filters.NewArgs()
+	f := NewDockerFilter(&opts.Filters)
+	f.AddLabel(containerTypeLabelKey, containerTypeLabelSandbox)
+	containers, err := ds.client.ListContainers(opts)
+	if err != nil {
+		logrus.Errorf("Failed to list sandboxes to check their network: %v", err)
+		return
+	}
+	for _, c := range containers {
+		r, err := ds.client.InspectContainer(c.ID)
+		if err != nil {
+			logrus.Debugf("Failed to inspect sandbox %s to check its network: %v", c.ID, err)
+			continue
+		}
+		ds.checkSandboxNetwork(context.Background(), r)
+	}
+}
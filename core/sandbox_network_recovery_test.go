@@ -0,0 +1,250 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"github.com/Mirantis/cri-dockerd/config"
+	"github.com/Mirantis/cri-dockerd/libdocker"
+	"github.com/Mirantis/cri-dockerd/network"
+	nettest "github.com/Mirantis/cri-dockerd/network/testing"
+	"github.com/Mirantis/cri-dockerd/store"
+)
+
+func newTestSandboxRecovery(t *testing.T) (*dockerService, *libdocker.FakeDockerClient, *nettest.MockNetworkPlugin) {
+	ds, fDocker, _ := newTestDockerService()
+	checkpointManager, err := store.NewCheckpointManager(t.TempDir())
+	require.NoError(t, err)
+	ds.checkpointManager = checkpointManager
+
+	mockPlugin := newTestNetworkPlugin(t)
+	ds.network = network.NewPluginManager(mockPlugin)
+	mockPlugin.EXPECT().Name().Return("mockNetworkPlugin").AnyTimes()
+	mockPlugin.EXPECT().GetPodNetworkStatus(gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(nil, errors.New("no network status")).AnyTimes()
+	return ds, fDocker, mockPlugin
+}
+
+// restartTestSandbox simulates docker restarting the sandbox container.
+func restartTestSandbox(fDocker *libdocker.FakeDockerClient, podSandboxID string) {
+	fDocker.Lock()
+	defer fDocker.Unlock()
+	state := fDocker.ContainerMap[podSandboxID].State
+	state.Pid++
+	state.StartedAt = time.Now().Add(time.Minute).Format(time.RFC3339Nano)
+}
+
+func getTestSandboxState(t *testing.T, ds *dockerService, podSandboxID string) runtimeapi.PodSandboxState {
+	resp, err := ds.PodSandboxStatus(
+		getTestCTX(),
+		&runtimeapi.PodSandboxStatusRequest{PodSandboxId: podSandboxID},
+	)
+	require.NoError(t, err)
+	return resp.Status.State
+}
+
+func listTestSandboxIDs(t *testing.T, ds *dockerService, state runtimeapi.PodSandboxState) []string {
+	resp, err := ds.ListPodSandbox(getTestCTX(), &runtimeapi.ListPodSandboxRequest{
+		Filter: &runtimeapi.PodSandboxFilter{
+			State: &runtimeapi.PodSandboxStateValue{State: state},
+		},
+	})
+	require.NoError(t, err)
+	ids := []string{}
+	for _, s := range resp.Items {
+		ids = append(ids, s.Id)
+	}
+	return ids
+}
+
+// waitForSandboxNetworkRecovery waits for the network of the sandbox to be
+// set up again in the background.
+func waitForSandboxNetworkRecovery(t *testing.T, ds *dockerService, podSandboxID string) {
+	assert.Eventually(t, func() bool {
+		ds.sandboxInstancesLock.Lock()
+		defer ds.sandboxInstancesLock.Unlock()
+		instance, ok := ds.sandboxInstances[podSandboxID]
+		return ok && !instance.recovering
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestRestartedSandboxNetworkIsSetUpAgain(t *testing.T) {
+	ds, fDocker, mockPlugin := newTestSandboxRecovery(t)
+	defer mockPlugin.Finish()
+
+	mockPlugin.EXPECT().SetUpPod("bar", "foo", gomock.Any()).Return(nil)
+	id := runTestSandbox(t, ds, "foo")
+	cID := config.BuildContainerID(runtimeName, id)
+	assert.Equal(t, runtimeapi.PodSandboxState_SANDBOX_READY, getTestSandboxState(t, ds, id))
+
+	restartTestSandbox(fDocker, id)
+	teardown := mockPlugin.EXPECT().TearDownPod("bar", "foo", cID).Return(nil)
+	mockPlugin.EXPECT().SetUpPod("bar", "foo", cID).Return(nil).After(teardown)
+	ds.checkSandboxNetworks()
+	waitForSandboxNetworkRecovery(t, ds, id)
+
+	assert.Equal(t, runtimeapi.PodSandboxState_SANDBOX_READY, getTestSandboxState(t, ds, id))
+	ready, _ := ds.getNetworkReady(id)
+	assert.True(t, ready)
+}
+
+// blockingSetUpPlugin blocks the set ups of pods until release is closed.
+type blockingSetUpPlugin struct {
+	network.NetworkPlugin
+	started chan struct{}
+	release chan struct{}
+}
+
+func (p *blockingSetUpPlugin) SetUpPod(
+	namespace, name string,
+	id config.ContainerID,
+	annotations, options map[string]string,
+) error {
+	close(p.started)
+	<-p.release
+	return p.NetworkPlugin.SetUpPod(namespace, name, id, annotations, options)
+}
+
+func TestRecoveringSandboxIsReportedWithoutIPs(t *testing.T) {
+	ds, fDocker, mockPlugin := newTestSandboxRecovery(t)
+	defer mockPlugin.Finish()
+
+	mockPlugin.EXPECT().SetUpPod("bar", "foo", gomock.Any()).Return(nil)
+	id := runTestSandbox(t, ds, "foo")
+	cID := config.BuildContainerID(runtimeName, id)
+	require.NoError(t, ds.updateSandboxState(id, func(state *CheckpointState) {
+		state.IPs = []string{"10.0.0.5"}
+	}))
+	resp, err := ds.PodSandboxStatus(getTestCTX(), &runtimeapi.PodSandboxStatusRequest{PodSandboxId: id})
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.5", resp.Status.Network.Ip)
+
+	plugin := &blockingSetUpPlugin{
+		NetworkPlugin: mockPlugin,
+		started:       make(chan struct{}),
+		release:       make(chan struct{}),
+	}
+	ds.network = network.NewPluginManager(plugin)
+	restartTestSandbox(fDocker, id)
+	teardown := mockPlugin.EXPECT().TearDownPod("bar", "foo", cID).Return(nil)
+	mockPlugin.EXPECT().SetUpPod("bar", "foo", cID).Return(nil).After(teardown)
+
+	// The sandbox stays ready while its network is set up again, but the IPs
+	// of the previous network namespace are not reported.
+	resp, err = ds.PodSandboxStatus(getTestCTX(), &runtimeapi.PodSandboxStatusRequest{PodSandboxId: id})
+	require.NoError(t, err)
+	assert.Equal(t, runtimeapi.PodSandboxState_SANDBOX_READY, resp.Status.State)
+	assert.Empty(t, resp.Status.Network.Ip)
+	<-plugin.started
+	resp, err = ds.PodSandboxStatus(getTestCTX(), &runtimeapi.PodSandboxStatusRequest{PodSandboxId: id})
+	require.NoError(t, err)
+	assert.Equal(t, runtimeapi.PodSandboxState_SANDBOX_READY, resp.Status.State)
+	assert.Empty(t, resp.Status.Network.Ip)
+
+	close(plugin.release)
+	waitForSandboxNetworkRecovery(t, ds, id)
+	ready, _ := ds.getNetworkReady(id)
+	assert.True(t, ready)
+}
+
+func TestRestartedSandboxIsNotReadyWhenNetworkSetupFails(t *testing.T) {
+	ds, fDocker, mockPlugin := newTestSandboxRecovery(t)
+	defer mockPlugin.Finish()
+
+	mockPlugin.EXPECT().SetUpPod("bar", gomock.Any(), gomock.Any()).Return(nil).Times(2)
+	id := runTestSandbox(t, ds, "foo")
+	healthy := runTestSandbox(t, ds, "healthy")
+	cID := config.BuildContainerID(runtimeName, id)
+
+	restartTestSandbox(fDocker, id)
+	teardown := mockPlugin.EXPECT().TearDownPod("bar", "foo", cID).Return(nil)
+	setup := mockPlugin.EXPECT().SetUpPod("bar", "foo", cID).Return(errors.New("setup failed")).After(teardown)
+	mockPlugin.EXPECT().TearDownPod("bar", "foo", cID).Return(nil).After(setup)
+	// The sandbox is reported as ready while its network is set up again in
+	// the background, and as not ready once that failed.
+	assert.Equal(t, runtimeapi.PodSandboxState_SANDBOX_READY, getTestSandboxState(t, ds, id))
+	waitForSandboxNetworkRecovery(t, ds, id)
+	assert.Equal(t, runtimeapi.PodSandboxState_SANDBOX_NOTREADY, getTestSandboxState(t, ds, id))
+
+	// The failed recovery is not retried until the sandbox is restarted again.
+	assert.Equal(t, []string{healthy}, listTestSandboxIDs(t, ds, runtimeapi.PodSandboxState_SANDBOX_READY))
+	assert.Equal(t, []string{id}, listTestSandboxIDs(t, ds, runtimeapi.PodSandboxState_SANDBOX_NOTREADY))
+
+	// The network was already torn down after the failed setup.
+	_, err := ds.StopPodSandbox(getTestCTX(), &runtimeapi.StopPodSandboxRequest{PodSandboxId: id})
+	require.NoError(t, err)
+	assert.False(t, ds.isSandboxNetworkLost(id))
+}
+
+func TestSandboxNetworkCheckAdoptsUnknownSandboxes(t *testing.T) {
+	ds, _, mockPlugin := newTestSandboxRecovery(t)
+	defer mockPlugin.Finish()
+
+	mockPlugin.EXPECT().SetUpPod("bar", "foo", gomock.Any()).Return(nil)
+	id := runTestSandbox(t, ds, "foo")
+
+	// As after a restart of cri-dockerd: the running sandbox is the one
+	// recorded in its checkpoint, so it still has its network.
+	ds.clearSandboxInstance(id)
+	ds.checkSandboxNetworks()
+	assert.Contains(t, ds.sandboxInstances, id)
+	assert.Equal(t, runtimeapi.PodSandboxState_SANDBOX_READY, getTestSandboxState(t, ds, id))
+
+	// The checkpoints of older releases don't record the sandbox process,
+	// the running one is assumed to have the network.
+	ds.clearSandboxInstance(id)
+	require.NoError(t, ds.updateSandboxState(id, func(state *CheckpointState) {
+		state.Pid = 0
+		state.StartedAt = ""
+	}))
+	ds.checkSandboxNetworks()
+	assert.Contains(t, ds.sandboxInstances, id)
+	assert.Equal(t, runtimeapi.PodSandboxState_SANDBOX_READY, getTestSandboxState(t, ds, id))
+}
+
+func TestSandboxRestartedWithCriDockerdNetworkIsSetUpAgain(t *testing.T) {
+	ds, fDocker, mockPlugin := newTestSandboxRecovery(t)
+	defer mockPlugin.Finish()
+
+	mockPlugin.EXPECT().SetUpPod("bar", "foo", gomock.Any()).Return(nil)
+	id := runTestSandbox(t, ds, "foo")
+	cID := config.BuildContainerID(runtimeName, id)
+
+	// As after a restart of cri-dockerd together with dockerd: the restart of
+	// the sandbox is detected from the process recorded in its checkpoint.
+	ds.clearSandboxInstance(id)
+	restartTestSandbox(fDocker, id)
+	teardown := mockPlugin.EXPECT().TearDownPod("bar", "foo", cID).Return(nil)
+	mockPlugin.EXPECT().SetUpPod("bar", "foo", cID).Return(nil).After(teardown)
+	ds.checkSandboxNetworks()
+	waitForSandboxNetworkRecovery(t, ds, id)
+
+	state, err := ds.getSandboxState(id)
+	require.NoError(t, err)
+	fDocker.Lock()
+	assert.Equal(t, fDocker.ContainerMap[id].State.Pid, state.Pid)
+	assert.Equal(t, fDocker.ContainerMap[id].State.StartedAt, state.StartedAt)
+	fDocker.Unlock()
+}
@@ -24,6 +24,7 @@ import (
 	"github.com/Mirantis/cri-dockerd/network/hostport"
 	"github.com/Mirantis/cri-dockerd/store"
 	"github.com/Mirantis/cri-dockerd/streaming"
+	"github.com/Mirantis/cri-dockerd/utils/logging"
 	"github.com/sirupsen/logrus"
 	v1 "k8s.io/cri-api/pkg/apis/runtime/v1"
 )
@@ -45,9 +46,10 @@ func (n *namespaceGetter) GetNetNS(containerID string) (string, error) {
 }
 
 func (p *portMappingGetter) GetPodPortMappings(
+	ctx context.Context,
 	containerID string,
 ) ([]*hostport.PortMapping, error) {
-	return p.ds.GetPodPortMappings(containerID)
+	return p.ds.GetPodPortMappings(ctx, containerID)
 }
 
 // dockerNetworkHost implements network.Host by wrapping the legacy host passed in by the kubelet
@@ -74,11 +76,8 @@ func effectiveHairpinMode(s *config.NetworkPluginSettings) error {
 			// This is not a valid combination, since promiscuous-bridge only works on kubenet. Users might be using the
 			// default values (from before the hairpin-mode flag existed) and we
 			// should keep the old behavior.
-			logrus.Info(
-				"Hairpin mode is set but kubenet is not enabled, falling back to HairpinVeth",
-				"hairpinMode",
-				s.HairpinMode,
-			)
+			logrus.WithField("hairpinMode", s.HairpinMode).
+				Info("Hairpin mode is set but kubenet is not enabled, falling back to HairpinVeth")
 			s.HairpinMode = config.HairpinVeth
 			return nil
 		}
@@ -115,7 +114,10 @@ func (ds *dockerService) GetNetNS(podSandboxID string) (string, error) {
 }
 
 // GetPodPortMappings returns the port mappings of the given podSandbox ID.
-func (ds *dockerService) GetPodPortMappings(podSandboxID string) ([]*hostport.PortMapping, error) {
+func (ds *dockerService) GetPodPortMappings(
+	ctx context.Context,
+	podSandboxID string,
+) ([]*hostport.PortMapping, error) {
 	checkpoint := NewPodSandboxCheckpoint("", "", &CheckpointData{})
 	err := ds.checkpointManager.GetCheckpoint(podSandboxID, checkpoint)
 	// Return empty portMappings if checkpoint is not found
@@ -125,12 +127,8 @@ func (ds *dockerService) GetPodPortMappings(podSandboxID string) ([]*hostport.Po
 		}
 		errRem := ds.checkpointManager.RemoveCheckpoint(podSandboxID)
 		if errRem != nil {
-			logrus.Error(
-				errRem,
-				"Failed to delete corrupt checkpoint for sandbox",
-				"podSandboxID",
-				podSandboxID,
-			)
+			logging.FromContext(ctx).WithError(errRem).WithField(logging.PodSandboxIDKey, podSandboxID).
+				Error("Failed to delete corrupt checkpoint for sandbox")
 		}
 		return nil, err
 	}
@@ -41,7 +41,7 @@ func (ds *dockerService) RemovePodSandbox(
 	f := NewDockerFilter(&opts.Filters)
 	f.AddLabel(sandboxIDLabelKey, podSandboxID)
 
-	containers, err := ds.client.ListContainers(opts)
+	containers, err := ds.tracedClient(ctx).ListContainers(opts)
 	if err != nil {
 		errs = append(errs, err)
 	}
@@ -55,14 +55,16 @@ func (ds *dockerService) RemovePodSandbox(
 	}
 
 	// Remove the sandbox container.
-	err = ds.client.RemoveContainer(
+	err = ds.tracedClient(ctx).RemoveContainer(
 		podSandboxID,
 		dockercontainer.RemoveOptions{RemoveVolumes: true, Force: true},
 	)
+	ds.refreshIndexedContainer(podSandboxID)
 	if err == nil || libdocker.IsContainerNotFoundError(err) {
 		// Only clear network ready when the sandbox has actually been
 		// removed from docker or doesn't exist
 		ds.clearNetworkReady(podSandboxID)
+		ds.clearSandboxInstance(podSandboxID)
 	} else {
 		errs = append(errs, err)
 	}
@@ -23,6 +23,7 @@ import (
 
 	"github.com/Mirantis/cri-dockerd/config"
 	"github.com/Mirantis/cri-dockerd/utils/errors"
+	"go.opentelemetry.io/otel"
 	v1 "k8s.io/cri-api/pkg/apis/runtime/v1"
 )
 
@@ -36,6 +37,9 @@ func (ds *dockerService) RunPodSandbox(
 	r *v1.RunPodSandboxRequest,
 ) (*v1.RunPodSandboxResponse, error) {
 	containerConfig := r.GetConfig()
+	runtimeHandler := r.GetRuntimeHandler()
+	handlerConfig := ds.runtimeHandlers.Get(runtimeHandler)
+	client := ds.tracedClient(ctx)
 
 	// Step 1: Pull the image for the sandbox.
 	image := defaultSandboxImage
@@ -43,11 +47,14 @@ func (ds *dockerService) RunPodSandbox(
 	if len(podSandboxImage) != 0 {
 		image = podSandboxImage
 	}
+	if handlerConfig != nil && handlerConfig.SandboxImage != "" {
+		image = handlerConfig.SandboxImage
+	}
 
 	// NOTE: To use a custom sandbox image in a private repository, users need to configure the nodes with credentials properly.
 	// see: http://kubernetes.io/docs/user-guide/images/#configuring-nodes-to-authenticate-to-a-private-repository
 	// Only pull sandbox image when it's not present - v1.PullIfNotPresent.
-	if err := ensureSandboxImageExists(ds.client, image); err != nil {
+	if err := ds.ensureSandboxImageExists(ctx, image); err != nil {
 		return nil, err
 	}
 
@@ -60,18 +67,25 @@ func (ds *dockerService) RunPodSandbox(
 			err,
 		)
 	}
-	// k8s RuntimeClass.handler=docker will use docker's default runtime
-	runtimeHandler := r.GetRuntimeHandler()
-	if runtimeHandler != "" && runtimeHandler != runtimeName {
-		err = ds.IsRuntimeConfigured(runtimeHandler)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get sandbox runtime: %v", err)
-		}
-		createConfig.HostConfig.Runtime = runtimeHandler
+	dockerRuntime, err := ds.dockerRuntimeForHandler(runtimeHandler)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sandbox runtime: %v", err)
 	}
-	createResp, err := ds.client.CreateContainer(*createConfig)
+	createConfig.HostConfig.Runtime = dockerRuntime
+	err = applyRuntimeHandlerConfig(
+		createConfig.HostConfig,
+		runtimeHandler,
+		handlerConfig,
+		containerConfig.GetLinux().GetSecurityContext().GetPrivileged(),
+		containerConfig.GetAnnotations(),
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	createResp, err := client.CreateContainer(*createConfig)
 	if err != nil {
-		createResp, err = recoverFromCreationConflictIfNeeded(ds.client, *createConfig, err)
+		createResp, err = recoverFromCreationConflictIfNeeded(ctx, client, *createConfig, err)
 	}
 
 	if err != nil || createResp == nil {
@@ -82,6 +96,7 @@ func (ds *dockerService) RunPodSandbox(
 		)
 	}
 	resp := &v1.RunPodSandboxResponse{PodSandboxId: createResp.ID}
+	ds.refreshIndexedContainer(createResp.ID)
 
 	ds.setNetworkReady(createResp.ID, false)
 	defer func(e *error) {
@@ -93,14 +108,15 @@ func (ds *dockerService) RunPodSandbox(
 	}(&err)
 
 	// Step 3: Create Sandbox Checkpoint.
-	if err = ds.checkpointManager.CreateCheckpoint(createResp.ID, constructPodSandboxCheckpoint(containerConfig)); err != nil {
+	if err = ds.checkpointManager.CreateCheckpoint(createResp.ID, constructPodSandboxCheckpoint(containerConfig, runtimeHandler)); err != nil {
 		return nil, err
 	}
 
 	// Step 4: Start the sandbox container.
 	// Assume kubelet's garbage collector would remove the sandbox later, if
 	// startContainer failed.
-	err = ds.client.StartContainer(createResp.ID)
+	err = client.StartContainer(createResp.ID)
+	ds.refreshIndexedContainer(createResp.ID)
 	if err != nil {
 		return nil, fmt.Errorf(
 			"failed to start sandbox container for pod %q: %v",
@@ -109,6 +125,15 @@ func (ds *dockerService) RunPodSandbox(
 		)
 	}
 
+	containerInfo, err := client.InspectContainer(createResp.ID)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"failed to inspect sandbox container for pod %q: %v",
+			containerConfig.Metadata.Name,
+			err,
+		)
+	}
+
 	// Rewrite resolv.conf file generated by docker.
 	// NOTE: cluster dns settings aren't passed anymore to docker api in all cases,
 	// not only for pods with host network: the resolver conf will be overwritten
@@ -116,16 +141,10 @@ func (ds *dockerService) RunPodSandbox(
 	// file is shared by all containers of the same pod, and needs to be modified
 	// only once per pod.
 	if dnsConfig := containerConfig.GetDnsConfig(); dnsConfig != nil {
-		containerInfo, err := ds.client.InspectContainer(createResp.ID)
+		_, span := otel.Tracer(tracerName).Start(ctx, "rewriteResolvFile")
+		err := rewriteResolvFile(ctx, containerInfo.ResolvConfPath, dnsConfig.Servers, dnsConfig.Searches, dnsConfig.Options)
+		span.End()
 		if err != nil {
-			return nil, fmt.Errorf(
-				"failed to inspect sandbox container for pod %q: %v",
-				containerConfig.Metadata.Name,
-				err,
-			)
-		}
-
-		if err := rewriteResolvFile(containerInfo.ResolvConfPath, dnsConfig.Servers, dnsConfig.Searches, dnsConfig.Options); err != nil {
 			return nil, fmt.Errorf(
 				"rewrite resolv.conf failed for pod %q: %v",
 				containerConfig.Metadata.Name,
@@ -139,6 +158,10 @@ func (ds *dockerService) RunPodSandbox(
 		return resp, nil
 	}
 
+	// Remember the sandbox container process the network is set up for, so
+	// that a restart of the sandbox by docker is detected.
+	ds.setSandboxInstance(createResp.ID, newSandboxInstance(containerInfo))
+
 	// Step 5: Setup networking for the sandbox.
 	// All pod networking is setup by a CNI plugin discovered at startup time.
 	// This plugin assigns the pod ip, sets up routes inside the sandbox,
@@ -161,6 +184,7 @@ func (ds *dockerService) RunPodSandbox(
 		networkOptions["dns"] = string(dnsOption)
 	}
 	err = ds.network.SetUpPod(
+		ctx,
 		containerConfig.GetMetadata().Namespace,
 		containerConfig.GetMetadata().Name,
 		cID,
@@ -179,7 +203,7 @@ func (ds *dockerService) RunPodSandbox(
 
 		// Ensure network resources are cleaned up even if the plugin
 		// succeeded but an error happened between that success and here.
-		err = ds.network.TearDownPod(containerConfig.GetMetadata().Namespace, containerConfig.GetMetadata().Name, cID)
+		err = ds.network.TearDownPod(ctx, containerConfig.GetMetadata().Namespace, containerConfig.GetMetadata().Name, cID)
 		if err != nil {
 			errList = append(
 				errList,
@@ -192,7 +216,8 @@ func (ds *dockerService) RunPodSandbox(
 			)
 		}
 
-		err = ds.client.StopContainer(createResp.ID, defaultSandboxGracePeriod)
+		err = client.StopContainer(createResp.ID, defaultSandboxGracePeriod)
+		ds.refreshIndexedContainer(createResp.ID)
 		if err != nil {
 			errList = append(
 				errList,
@@ -208,5 +233,6 @@ func (ds *dockerService) RunPodSandbox(
 		return resp, errors.NewAggregate(errList)
 	}
 
+	ds.recordSandboxNetworkSetUp(ctx, containerInfo)
 	return resp, nil
 }
@@ -0,0 +1,156 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"context"
+
+	"github.com/Mirantis/cri-dockerd/config"
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/sirupsen/logrus"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"github.com/Mirantis/cri-dockerd/utils/logging"
+)
+
+// getSandboxState returns the state stored in the checkpoint of the sandbox.
+func (ds *dockerService) getSandboxState(podSandboxID string) (*CheckpointState, error) {
+	checkpoint := NewPodSandboxCheckpoint("", "", &CheckpointData{})
+	if err := ds.checkpointManager.GetCheckpoint(podSandboxID, checkpoint); err != nil {
+		return nil, err
+	}
+	return checkpoint.GetState(), nil
+}
+
+// updateSandboxState applies update to the state stored in the checkpoint of
+// the sandbox and writes the checkpoint back.
+func (ds *dockerService) updateSandboxState(podSandboxID string, update func(*CheckpointState)) error {
+	ds.sandboxStateLock.Lock()
+	defer ds.sandboxStateLock.Unlock()
+	checkpoint := NewPodSandboxCheckpoint("", "", &CheckpointData{})
+	if err := ds.checkpointManager.GetCheckpoint(podSandboxID, checkpoint); err != nil {
+		return err
+	}
+	update(checkpoint.GetState())
+	return ds.checkpointManager.CreateCheckpoint(podSandboxID, checkpoint)
+}
+
+// newCheckpointDNSConfig converts the DNS configuration of a sandbox.
+func newCheckpointDNSConfig(dnsConfig *runtimeapi.DNSConfig) *CheckpointDNSConfig {
+	if dnsConfig == nil {
+		return nil
+	}
+	return &CheckpointDNSConfig{
+		Servers:  dnsConfig.Servers,
+		Searches: dnsConfig.Searches,
+		Options:  dnsConfig.Options,
+	}
+}
+
+// toRuntimeAPIDNSConfig converts the DNS configuration of a sandbox back.
+func (c *CheckpointDNSConfig) toRuntimeAPIDNSConfig() *runtimeapi.DNSConfig {
+	if c == nil {
+		return nil
+	}
+	return &runtimeapi.DNSConfig{
+		Servers:  c.Servers,
+		Searches: c.Searches,
+		Options:  c.Options,
+	}
+}
+
+// recordSandboxNetworkSetUp records the network set up by the network plugin
+// for the sandbox in its checkpoint, so that it can be reported without asking
+// the plugin again.
+func (ds *dockerService) recordSandboxNetworkSetUp(ctx context.Context, sandbox *dockertypes.ContainerJSON) {
+	log := logging.FromContext(ctx).WithField(logging.PodSandboxIDKey, sandbox.ID)
+	ips, err := ds.getIPsFromPlugin(ctx, sandbox)
+	if err != nil {
+		log.WithError(err).Debug("Failed to get IPs of sandbox from network plugin")
+	}
+	networkName := ds.network.NetworkName()
+	interfaces, err := ds.network.GetPodInterfaces(config.BuildContainerID(runtimeName, sandbox.ID), networkName)
+	if err != nil {
+		log.WithError(err).Debug("Failed to get interfaces of sandbox from network plugin")
+	}
+	err = ds.updateSandboxState(sandbox.ID, func(state *CheckpointState) {
+		ready := true
+		state.NetworkReady = &ready
+		state.CNIConfigName = networkName
+		state.Interfaces = interfaces
+		state.IPs = ips
+		if sandbox.State != nil {
+			state.Pid = sandbox.State.Pid
+			state.StartedAt = sandbox.State.StartedAt
+		}
+	})
+	if err != nil {
+		log.WithError(err).Error("Failed to record network of sandbox in its checkpoint")
+	}
+}
+
+// tearDownSandboxNetwork tears the sandbox down from the network recorded in
+// its checkpoint, which may not be the one new sandboxes are attached to
+// anymore.
+func (ds *dockerService) tearDownSandboxNetwork(
+	ctx context.Context,
+	podSandboxID, namespace, name string,
+) error {
+	var networkName string
+	if state, err := ds.getSandboxState(podSandboxID); err == nil {
+		networkName = state.CNIConfigName
+	}
+	cID := config.BuildContainerID(runtimeName, podSandboxID)
+	return ds.network.TearDownPodFromNetwork(ctx, namespace, name, cID, networkName)
+}
+
+// recordSandboxNetworkTornDown records in the checkpoint of the sandbox that
+// its network was torn down.
+func (ds *dockerService) recordSandboxNetworkTornDown(ctx context.Context, podSandboxID string) {
+	err := ds.updateSandboxState(podSandboxID, func(state *CheckpointState) {
+		ready := false
+		state.NetworkReady = &ready
+		state.Interfaces = nil
+		state.IPs = nil
+		state.Pid = 0
+		state.StartedAt = ""
+	})
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).WithField(logging.PodSandboxIDKey, podSandboxID).
+			Debug("Failed to record network teardown of sandbox in its checkpoint")
+	}
+}
+
+// restoreSandboxStates restores the network readiness recorded in the
+// checkpoints.
+func (ds *dockerService) restoreSandboxStates() {
+	ids, err := ds.checkpointManager.ListCheckpoints()
+	if err != nil {
+		logrus.Errorf("Failed to list checkpoints to restore sandbox states: %v", err)
+		return
+	}
+	for _, id := range ids {
+		checkpoint := &PodSandboxCheckpoint{Data: &CheckpointData{}}
+		if err := ds.checkpointManager.GetCheckpoint(id, checkpoint); err != nil {
+			logrus.Errorf("Failed to read checkpoint of sandbox %s: %v", id, err)
+			continue
+		}
+		if ready := checkpoint.GetState().NetworkReady; ready != nil {
+			ds.setNetworkReady(id, *ready)
+		}
+	}
+}
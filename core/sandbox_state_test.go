@@ -0,0 +1,84 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"net"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"github.com/Mirantis/cri-dockerd/config"
+	"github.com/Mirantis/cri-dockerd/network"
+	"github.com/Mirantis/cri-dockerd/store"
+)
+
+// TestSandboxStateSurvivesRestart checks that the network state recorded in
+// the checkpoint is used once cri-dockerd restarted, instead of asking the
+// network plugin again.
+func TestSandboxStateSurvivesRestart(t *testing.T) {
+	ds, _, _ := newTestDockerService()
+	checkpointManager, err := store.NewCheckpointManager(t.TempDir())
+	require.NoError(t, err)
+	ds.checkpointManager = checkpointManager
+	mockPlugin := newTestNetworkPlugin(t)
+	ds.network = network.NewPluginManager(mockPlugin)
+	defer mockPlugin.Finish()
+
+	mockPlugin.EXPECT().Name().Return("mockNetworkPlugin").AnyTimes()
+	setup := mockPlugin.EXPECT().SetUpPod("bar", "foo", gomock.Any()).Return(nil)
+	mockPlugin.EXPECT().GetPodNetworkStatus("bar", "foo", gomock.Any()).
+		Return(&network.PodNetworkStatus{IP: net.ParseIP("10.0.0.2")}, nil).After(setup)
+	sConfig := makeSandboxConfig("foo", "bar", "1", 0)
+	sConfig.DnsConfig = &runtimeapi.DNSConfig{Servers: []string{"10.0.0.10"}}
+	resp, err := ds.RunPodSandbox(getTestCTX(), &runtimeapi.RunPodSandboxRequest{
+		Config:         sConfig,
+		RuntimeHandler: runtimeName,
+	})
+	require.NoError(t, err)
+	id := resp.PodSandboxId
+
+	state, err := ds.getSandboxState(id)
+	require.NoError(t, err)
+	assert.Equal(t, runtimeName, state.RuntimeHandler)
+	assert.Equal(t, []string{"10.0.0.10"}, state.DNSConfig.Servers)
+	assert.Equal(t, []string{network.DefaultInterfaceName}, state.Interfaces)
+	assert.Equal(t, []string{"10.0.0.2"}, state.IPs)
+
+	// Restart cri-dockerd.
+	ds.networkReady = make(map[string]bool)
+	ds.restoreSandboxStates()
+	statusResp, err := ds.PodSandboxStatus(
+		getTestCTX(),
+		&runtimeapi.PodSandboxStatusRequest{PodSandboxId: id},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.2", statusResp.Status.Network.Ip)
+
+	mockPlugin.EXPECT().TearDownPod("bar", "foo", config.BuildContainerID(runtimeName, id)).Return(nil)
+	_, err = ds.StopPodSandbox(getTestCTX(), &runtimeapi.StopPodSandboxRequest{PodSandboxId: id})
+	require.NoError(t, err)
+
+	// The network is not torn down again after another restart.
+	ds.networkReady = make(map[string]bool)
+	ds.restoreSandboxStates()
+	_, err = ds.StopPodSandbox(getTestCTX(), &runtimeapi.StopPodSandboxRequest{PodSandboxId: id})
+	require.NoError(t, err)
+}
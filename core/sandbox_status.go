@@ -44,14 +44,16 @@ func (ds *dockerService) PodSandboxStatus(
 
 	// Translate container to sandbox state.
 	state := v1.PodSandboxState_SANDBOX_NOTREADY
-	if r.State.Running {
+	// A running sandbox which lost its network after being restarted by docker
+	// is not ready, so that the kubelet recreates it.
+	if r.State.Running && ds.checkSandboxNetwork(ctx, r) {
 		state = v1.PodSandboxState_SANDBOX_READY
 	}
 
 	var ips []string
 	// This is a workaround for windows, where sandbox is not in use, and pod IP is determined through containers belonging to the Pod.
-	if ips = ds.determinePodIPBySandboxID(podSandboxID); len(ips) == 0 {
-		ips = ds.getIPs(podSandboxID, r)
+	if ips = ds.determinePodIPBySandboxID(ctx, podSandboxID); len(ips) == 0 {
+		ips = ds.getIPs(ctx, podSandboxID, r)
 	}
 
 	// ip is primary ips
@@ -19,11 +19,10 @@ package core
 import (
 	"context"
 	"fmt"
-	"github.com/Mirantis/cri-dockerd/config"
 	"github.com/Mirantis/cri-dockerd/libdocker"
 	"github.com/Mirantis/cri-dockerd/store"
 	"github.com/Mirantis/cri-dockerd/utils/errors"
-	"github.com/sirupsen/logrus"
+	"github.com/Mirantis/cri-dockerd/utils/logging"
 	v1 "k8s.io/cri-api/pkg/apis/runtime/v1"
 )
 
@@ -40,6 +39,7 @@ func (ds *dockerService) StopPodSandbox(
 
 	podSandboxID := r.PodSandboxId
 	resp := &v1.StopPodSandboxResponse{}
+	log := logging.FromContext(ctx)
 
 	// Try to retrieve minimal sandbox information from docker daemon or sandbox checkpoint.
 	inspectResult, metadata, statusErr := ds.getPodSandboxDetails(podSandboxID)
@@ -58,13 +58,12 @@ func (ds *dockerService) StopPodSandbox(
 			if checkpointErr != store.ErrCheckpointNotFound {
 				err := ds.checkpointManager.RemoveCheckpoint(podSandboxID)
 				if err != nil {
-					logrus.Errorf("Failed to delete corrupt checkpoint for sandbox %s: %v", podSandboxID, err)
+					log.WithError(err).Error("Failed to delete corrupt sandbox checkpoint")
 				}
 			}
 			if libdocker.IsContainerNotFoundError(statusErr) {
-				logrus.Infof(
-					"Both sandbox container and checkpoint could not be found with id %q. "+
-						"Proceed without further sandbox information.", podSandboxID)
+				log.Info("Both sandbox container and checkpoint could not be found, " +
+					"proceeding without further sandbox information")
 			} else {
 				return nil, errors.NewAggregate([]error{
 					fmt.Errorf("failed to get checkpoint for sandbox %q: %v", podSandboxID, checkpointErr),
@@ -84,21 +83,25 @@ func (ds *dockerService) StopPodSandbox(
 	// since it is stopped. With empty network namespace, CNI bridge plugin will conduct best
 	// effort clean up and will not return error.
 	errList := []error{}
+	// Stop watching for restarts of the sandbox before tearing down its network.
+	ds.clearSandboxInstance(podSandboxID)
 	ready, ok := ds.getNetworkReady(podSandboxID)
 	if !hostNetwork && (ready || !ok) {
 		// Only tear down the pod network if we haven't done so already
-		cID := config.BuildContainerID(runtimeName, podSandboxID)
-		err := ds.network.TearDownPod(namespace, name, cID)
+		err := ds.tearDownSandboxNetwork(ctx, podSandboxID, namespace, name)
 		if err == nil {
 			ds.setNetworkReady(podSandboxID, false)
+			ds.recordSandboxNetworkTornDown(ctx, podSandboxID)
 		} else {
 			errList = append(errList, err)
 		}
 	}
-	if err := ds.client.StopContainer(podSandboxID, defaultSandboxGracePeriod); err != nil {
+	err := ds.tracedClient(ctx).StopContainer(podSandboxID, defaultSandboxGracePeriod)
+	ds.refreshIndexedContainer(podSandboxID)
+	if err != nil {
 		// Do not return error if the container does not exist
 		if !libdocker.IsContainerNotFoundError(err) {
-			logrus.Errorf("Failed to stop sandbox %s: %v", podSandboxID, err)
+			log.WithError(err).Error("Failed to stop sandbox")
 			errList = append(errList, err)
 		} else {
 			// remove the checkpoint for any sandbox that is not found in the runtime
@@ -18,6 +18,7 @@ package core
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
@@ -27,7 +28,7 @@ import (
 	"strings"
 
 	"github.com/Mirantis/cri-dockerd/config"
-	"github.com/sirupsen/logrus"
+	"github.com/Mirantis/cri-dockerd/utils/logging"
 
 	dockercontainer "github.com/docker/docker/api/types/container"
 
@@ -60,6 +61,7 @@ func applySandboxSecurityContext(
 			SelinuxOptions:     lc.SecurityContext.SelinuxOptions,
 			NamespaceOptions:   lc.SecurityContext.NamespaceOptions,
 			Privileged:         lc.SecurityContext.Privileged,
+			Apparmor:           lc.SecurityContext.Apparmor,
 		}
 	}
 
@@ -252,7 +254,11 @@ func modifyHostOptionsForContainer(
 	}
 }
 
-func getSeccompDockerOpts(seccomp *runtimeapi.SecurityProfile, privileged bool) ([]DockerOpt, error) {
+func getSeccompDockerOpts(
+	ctx context.Context,
+	seccomp *runtimeapi.SecurityProfile,
+	privileged bool,
+) ([]DockerOpt, error) {
 
 	if seccomp == nil || seccomp.GetProfileType() == runtimeapi.SecurityProfile_Unconfined {
 		// return early the default
@@ -292,7 +298,7 @@ func getSeccompDockerOpts(seccomp *runtimeapi.SecurityProfile, privileged bool)
 		var filteredSyscallNames []string
 		for _, name := range scall.Names {
 			if privileged && name == "sethostname" && scall.Action == specs.ActErrno {
-				logrus.Info("Ignore the seccomp rule that blocks setting hostname when privileged ")
+				logging.FromContext(ctx).Info("Ignore the seccomp rule that blocks setting hostname when privileged ")
 				continue
 			}
 			filteredSyscallNames = append(filteredSyscallNames, name)
@@ -324,24 +330,40 @@ func getSeccompDockerOpts(seccomp *runtimeapi.SecurityProfile, privileged bool)
 
 // getSeccompSecurityOpts gets container seccomp options from container seccomp profile.
 // It is an experimental feature and may be promoted to official runtime api in the future.
-func getSeccompSecurityOpts(seccompProfile *runtimeapi.SecurityProfile, privileged bool, separator rune) ([]string, error) {
-	seccompOpts, err := getSeccompDockerOpts(seccompProfile, privileged)
+func getSeccompSecurityOpts(
+	ctx context.Context,
+	seccompProfile *runtimeapi.SecurityProfile,
+	privileged bool,
+	separator rune,
+) ([]string, error) {
+	seccompOpts, err := getSeccompDockerOpts(ctx, seccompProfile, privileged)
 	if err != nil {
 		return nil, err
 	}
 	return FmtDockerOpts(seccompOpts, separator), nil
 }
 
-// getApparmorSecurityOpts gets apparmor options from container config.
+// apparmorProfilesPath lists the apparmor profiles loaded in the kernel.
+var apparmorProfilesPath = "/sys/kernel/security/apparmor/profiles"
+
+// getApparmorSecurityOpts gets apparmor options from container config, the
+// structured Apparmor profile taking precedence over the deprecated
+// ApparmorProfile.
 func getApparmorSecurityOpts(
 	sc *runtimeapi.LinuxContainerSecurityContext,
 	separator rune,
 ) ([]string, error) {
-	if sc == nil || sc.ApparmorProfile == "" {
+	if sc == nil {
 		return nil, nil
 	}
 
-	appArmorOpts, err := getAppArmorOpts(sc.ApparmorProfile)
+	var appArmorOpts []DockerOpt
+	var err error
+	if sc.Apparmor != nil {
+		appArmorOpts, err = getAppArmorProfileOpts(sc.Apparmor)
+	} else if sc.ApparmorProfile != "" {
+		appArmorOpts, err = getAppArmorOpts(sc.ApparmorProfile)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -350,6 +372,20 @@ func getApparmorSecurityOpts(
 	return fmtOpts, nil
 }
 
+func getAppArmorProfileOpts(profile *runtimeapi.SecurityProfile) ([]DockerOpt, error) {
+	switch profile.GetProfileType() {
+	case runtimeapi.SecurityProfile_RuntimeDefault:
+		// The docker applies the default profile by default.
+		return nil, nil
+	case runtimeapi.SecurityProfile_Unconfined:
+		return []DockerOpt{{"apparmor", config.AppArmorBetaProfileNameUnconfined, ""}}, nil
+	case runtimeapi.SecurityProfile_Localhost:
+		return getAppArmorLocalhostOpts(profile.GetLocalhostRef())
+	default:
+		return nil, fmt.Errorf("unknown apparmor profile type: %s", profile.GetProfileType())
+	}
+}
+
 func getAppArmorOpts(profile string) ([]DockerOpt, error) {
 	if profile == "" || profile == config.AppArmorBetaProfileRuntimeDefault {
 		// The docker applies the default profile by default.
@@ -361,7 +397,42 @@ func getAppArmorOpts(profile string) ([]DockerOpt, error) {
 		return []DockerOpt{{"apparmor", config.AppArmorBetaProfileNameUnconfined, ""}}, nil
 	}
 
-	// Assume validation has already happened.
+	return getAppArmorLocalhostOpts(profile)
+}
+
+// getAppArmorLocalhostOpts returns the options of the profile loaded on the
+// node named profile, with or without the localhost/ prefix.
+func getAppArmorLocalhostOpts(profile string) ([]DockerOpt, error) {
 	profileName := strings.TrimPrefix(profile, config.AppArmorBetaProfileNamePrefix)
+	if profileName == "" {
+		return nil, fmt.Errorf("apparmor profile type is localhost but no profile name is given")
+	}
+	loaded, err := isAppArmorProfileLoaded(profileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check whether apparmor profile %q is loaded: %v", profileName, err)
+	}
+	if !loaded {
+		return nil, fmt.Errorf("apparmor profile %q is not loaded on the node", profileName)
+	}
 	return []DockerOpt{{"apparmor", profileName, ""}}, nil
 }
+
+// isAppArmorProfileLoaded returns whether the apparmor profile named
+// profileName is loaded in the kernel. The profiles are listed one per line
+// as "<name> (<mode>)".
+func isAppArmorProfileLoaded(profileName string) (bool, error) {
+	data, err := os.ReadFile(apparmorProfilesPath)
+	if err != nil {
+		return false, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		name := line
+		if i := strings.LastIndex(line, " ("); i >= 0 {
+			name = line[:i]
+		}
+		if name == profileName {
+			return true, nil
+		}
+	}
+	return false, nil
+}
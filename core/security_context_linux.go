@@ -19,14 +19,20 @@ limitations under the License.
 package core
 
 import (
+	"context"
 	"fmt"
 
 	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
 )
 
-func (ds *dockerService) getSecurityOpts(seccomp *runtimeapi.SecurityProfile, privileged bool, separator rune) ([]string, error) {
+func (ds *dockerService) getSecurityOpts(
+	ctx context.Context,
+	seccomp *runtimeapi.SecurityProfile,
+	privileged bool,
+	separator rune,
+) ([]string, error) {
 	// Apply seccomp options.
-	seccompSecurityOpts, err := getSeccompSecurityOpts(seccomp, privileged, separator)
+	seccompSecurityOpts, err := getSeccompSecurityOpts(ctx, seccomp, privileged, separator)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate seccomp security options for container: %v", err)
 	}
@@ -20,18 +20,22 @@ limitations under the License.
 package core
 
 import (
+	"context"
+
 	"github.com/docker/docker/api/types/container"
-	"github.com/sirupsen/logrus"
 	v1 "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"github.com/Mirantis/cri-dockerd/utils/logging"
 )
 
 func (ds *dockerService) getSecurityOpts(
+	ctx context.Context,
 	seccompProfile *v1.SecurityProfile,
 	privileged bool,
 	separator rune,
 ) ([]string, error) {
 	if seccompProfile != nil {
-		logrus.Info("seccomp annotations are not supported on windows")
+		logging.FromContext(ctx).Info("seccomp annotations are not supported on windows")
 	}
 	return nil, nil
 }
@@ -18,91 +18,65 @@ package core
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"runtime"
 	"sync"
 	"time"
 
-	"github.com/sirupsen/logrus"
-
 	"golang.org/x/sync/errgroup"
 
 	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"github.com/Mirantis/cri-dockerd/utils/logging"
 )
 
+// cstats is the writable layer usage of a container. The scheduling fields
+// are guarded by the lock of the containerStatsCache, the upper dir fields
+// are only used by the worker measuring the container.
 type cstats struct {
 	sync.Mutex
-	ds          *dockerService
 	containerID string
-	stopCh      chan struct{}
 	rwLayerSize uint64
 	initialized bool
+
+	// nextCollect is when the writable layer is measured next.
+	nextCollect time.Time
+	backoff     time.Duration
+	// queued is set while the container is queued or measured.
+	queued bool
+
+	// upperDir is the overlay2 upper dir of the container, empty if it
+	// can't be read directly.
+	upperDir         string
+	upperDirResolved bool
 }
 
 type containerStatsCache struct {
 	sync.RWMutex
 	stats map[string]*cstats
-	clist chan []*runtimeapi.Container
+	// lastProgress is when the collection last started a pass or finished
+	// measuring a container, or the cache was created.
+	lastProgress time.Time
 }
 
-func newCstats(cid string, ds *dockerService) *cstats {
+func newCstats(cid string, now time.Time) *cstats {
 	return &cstats{
 		containerID: cid,
-		ds:          ds,
-		stopCh:      make(chan struct{}),
+		nextCollect: now,
+		backoff:     minCollectInterval,
 	}
 }
 
 func newContainerStatsCache() *containerStatsCache {
 	return &containerStatsCache{
-		stats: make(map[string]*cstats),
-		clist: make(chan []*runtimeapi.Container, 1),
+		stats:        make(map[string]*cstats),
+		lastProgress: time.Now(),
 	}
 }
 
 const maxBackoffDuration = 20 * time.Minute
 const minCollectInterval = time.Minute
 
-func (cs *cstats) startCollect() {
-	backoffDuration := minCollectInterval
-	for {
-		var sleepTime time.Duration
-		// time consuming operation
-		start := time.Now()
-		containerJSON, err := cs.ds.client.InspectContainerWithSize(cs.containerID)
-		logrus.Debugf("Get RW layer size for container ID '%s', time taken %v", cs.containerID, time.Since(start))
-		if err != nil {
-			logrus.Errorf("error getting RW layer size for container ID '%s': %v", cs.containerID, err)
-			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
-				backoffDuration = backoffDuration * 2
-				if backoffDuration >= maxBackoffDuration {
-					backoffDuration = maxBackoffDuration
-				}
-			}
-			logrus.Errorf("Set backoffDuration to : %v for container ID '%s'", backoffDuration, cs.containerID)
-			sleepTime = backoffDuration
-		} else {
-			cs.Lock()
-			cs.rwLayerSize = uint64(*containerJSON.SizeRw)
-			cs.initialized = true
-			cs.Unlock()
-			backoffDuration = minCollectInterval
-			logrus.Debugf("RW layer size for container ID '%s': %v", cs.containerID, cs.rwLayerSize)
-			sleepTime = minCollectInterval
-		}
-		select {
-		case <-cs.stopCh:
-			return
-		case <-time.After(sleepTime):
-		}
-	}
-}
-
-func (cs *cstats) stopCollect() {
-	cs.stopCh <- struct{}{}
-}
-
 func (cs *cstats) isInitialized() bool {
 	cs.Lock()
 	defer cs.Unlock()
@@ -121,7 +95,7 @@ func (c *containerStatsCache) getWriteableLayer() uint64 {
 
 	var totalLayerSize uint64
 	for _, stat := range c.stats {
-		totalLayerSize += stat.rwLayerSize
+		totalLayerSize += stat.getContainerRWSize()
 	}
 	return totalLayerSize
 }
@@ -135,30 +109,70 @@ func (c *containerStatsCache) getStats(containerID string) *cstats {
 	return c.stats[containerID]
 }
 
-func (ds *dockerService) startStatsCollection() {
-	c := ds.containerStatsCache
-	for clist := range c.clist {
-		c.Lock()
-		containerIDMap := make(map[string]struct{}, len(clist))
-		for _, container := range clist {
-			cid := container.Id
-			containerIDMap[cid] = struct{}{}
-			// add new container
-			if _, exist := c.stats[cid]; !exist {
-				cs := newCstats(cid, ds)
-				c.stats[cid] = cs
-				go cs.startCollect()
-			}
+// lastProgressTime returns when the collection last made progress.
+func (c *containerStatsCache) lastProgressTime() time.Time {
+	c.RLock()
+	defer c.RUnlock()
+	return c.lastProgress
+}
+
+// recordProgress records that the collection started a pass.
+func (c *containerStatsCache) recordProgress(now time.Time) {
+	c.Lock()
+	defer c.Unlock()
+	c.lastProgress = now
+}
+
+// sync tracks the containers, evicting the ones that are gone, and returns
+// the containers due for measuring, which are marked as queued.
+func (c *containerStatsCache) sync(containerIDs []string, now time.Time) []*cstats {
+	c.Lock()
+	defer c.Unlock()
+
+	containerIDMap := make(map[string]struct{}, len(containerIDs))
+	for _, cid := range containerIDs {
+		containerIDMap[cid] = struct{}{}
+		if _, exist := c.stats[cid]; !exist {
+			c.stats[cid] = newCstats(cid, now)
 		}
-		// cleanup the containers that are not in latest container list
-		for k, cs := range c.stats {
-			if _, exist := containerIDMap[k]; !exist {
-				delete(c.stats, k)
-				go cs.stopCollect()
-			}
+	}
+	var due []*cstats
+	for cid, cs := range c.stats {
+		if _, exist := containerIDMap[cid]; !exist {
+			delete(c.stats, cid)
+			continue
+		}
+		if !cs.queued && !now.Before(cs.nextCollect) {
+			cs.queued = true
+			due = append(due, cs)
 		}
-		c.Unlock()
 	}
+	return due
+}
+
+// update records the outcome of measuring the writable layer of the
+// container and schedules the next measurement, backing off on errors.
+func (c *containerStatsCache) update(cs *cstats, size uint64, err error, now time.Time) {
+	if err == nil {
+		cs.Lock()
+		cs.rwLayerSize = size
+		cs.initialized = true
+		cs.Unlock()
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.lastProgress = now
+	cs.queued = false
+	if err != nil {
+		cs.backoff *= 2
+		if cs.backoff > maxBackoffDuration {
+			cs.backoff = maxBackoffDuration
+		}
+	} else {
+		cs.backoff = minCollectInterval
+	}
+	cs.nextCollect = now.Add(cs.backoff)
 }
 
 // ContainerStats returns stats for a container stats request based on container id.
@@ -176,7 +190,7 @@ func (ds *dockerService) ContainerStats(
 	if len(listResp.Containers) != 1 {
 		return nil, fmt.Errorf("container with id %s not found", r.ContainerId)
 	}
-	stats, err := ds.getContainerStats(listResp.Containers[0])
+	stats, err := ds.getContainerStats(ctx, listResp.Containers[0])
 	if err != nil {
 		return nil, err
 	}
@@ -192,6 +206,7 @@ func (ds *dockerService) ListContainerStats(
 	r *runtimeapi.ListContainerStatsRequest,
 ) (*runtimeapi.ListContainerStatsResponse, error) {
 	start := time.Now()
+	log := logging.FromContext(ctx)
 	containerStatsFilter := r.GetFilter()
 	filter := &runtimeapi.ContainerFilter{}
 
@@ -203,14 +218,17 @@ func (ds *dockerService) ListContainerStats(
 
 	res, err := ds.ListContainers(ctx, &runtimeapi.ListContainersRequest{Filter: filter})
 	if err != nil {
-		logrus.Errorf("Error listing containers with filter: %+v", filter)
-		logrus.Errorf("Error listing containers error: %s", err)
+		log.WithError(err).Errorf("Error listing containers with filter: %+v", filter)
 		return nil, err
 	}
 	containers := res.Containers
-	ds.containerStatsCache.clist <- containers
+	if containerStatsFilter.GetId() == "" && containerStatsFilter.GetPodSandboxId() == "" &&
+		len(containerStatsFilter.GetLabelSelector()) == 0 {
+		// All the containers are listed, forget the others.
+		ds.pruneCgroupStats(containers)
+	}
 	numContainers := len(containers)
-	logrus.Debugf("Number of pod containers: %v", numContainers)
+	log.Debugf("Number of pod containers: %v", numContainers)
 	if numContainers == 0 {
 		return &runtimeapi.ListContainerStatsResponse{}, nil
 	}
@@ -219,7 +237,8 @@ func (ds *dockerService) ListContainerStats(
 	results := make([]*runtimeapi.ContainerStats, 0, len(containers))
 
 	g, ctx := errgroup.WithContext(ctx)
-	// The `getContainerStats` may take some time. When there are many containers,
+	// The `getContainerStats` may take some time when it asks docker, which it
+	// does when the cgroups of the containers can't be read. When there are many containers,
 	// the whole `ListContainerStats` may have long delays if the number of workers is
 	// small. So we want to set a bigger value for the number of workers to avoid
 	// too long delays before the issue mentioned in https://github.com/moby/moby/pull/46448
@@ -240,9 +259,10 @@ func (ds *dockerService) ListContainerStats(
 			if ctx.Err() != nil {
 				return ctx.Err()
 			}
-			stats, err := ds.getContainerStats(c)
+			stats, err := ds.getContainerStats(ctx, c)
 			if err != nil {
-				logrus.Errorf("error collecting stats for container '%s': %v", c.Metadata.Name, err)
+				log.WithError(err).WithField(logging.ContainerIDKey, c.Id).
+					Errorf("error collecting stats for container '%s'", c.Metadata.Name)
 				return nil
 			}
 			mu.Lock()
@@ -256,11 +276,11 @@ func (ds *dockerService) ListContainerStats(
 
 	// wait for workers to finish
 	if err := g.Wait(); err != nil {
-		logrus.Errorf("Error ListContainerStats. %v", err)
+		log.Errorf("Error ListContainerStats. %v", err)
 		return nil, err
 	}
 
-	logrus.Debugf("Number of stats:%v, Time taken: %v", len(results), time.Since(start))
+	log.Debugf("Number of stats:%v, Time taken: %v", len(results), time.Since(start))
 
 	return &runtimeapi.ListContainerStatsResponse{Stats: results}, nil
 }
@@ -20,19 +20,19 @@ limitations under the License.
 package core
 
 import (
+	"context"
 	"time"
 
 	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"github.com/Mirantis/cri-dockerd/utils/logging"
 )
 
-func (ds *dockerService) getContainerStats(container *runtimeapi.Container) (*runtimeapi.ContainerStats, error) {
+func (ds *dockerService) getContainerStats(
+	ctx context.Context,
+	container *runtimeapi.Container,
+) (*runtimeapi.ContainerStats, error) {
 	containerID := container.Id
-	statsJSON, err := ds.client.GetContainerStats(containerID)
-	if err != nil {
-		return nil, err
-	}
-
-	dockerStats := statsJSON.Stats
 	timestamp := time.Now().UnixNano()
 	containerStats := &runtimeapi.ContainerStats{
 		Attributes: &runtimeapi.ContainerAttributes{
@@ -41,18 +41,36 @@ func (ds *dockerService) getContainerStats(container *runtimeapi.Container) (*ru
 			Labels:      container.Labels,
 			Annotations: container.Annotations,
 		},
-		Cpu: &runtimeapi.CpuUsage{
+	}
+
+	// Only running containers have cgroups.
+	if ds.cgroupStats != nil && container.State == runtimeapi.ContainerState_CONTAINER_RUNNING {
+		stats, err := ds.cgroupStats.read(containerID)
+		if err != nil {
+			logging.FromContext(ctx).WithError(err).WithField(logging.ContainerIDKey, containerID).
+				Debug("Failed to read the cgroup stats of container, asking docker")
+		} else {
+			setCgroupStats(containerStats, stats, timestamp)
+		}
+	}
+	if containerStats.Cpu == nil {
+		statsJSON, err := ds.client.GetContainerStats(containerID)
+		if err != nil {
+			return nil, err
+		}
+		dockerStats := statsJSON.Stats
+		containerStats.Cpu = &runtimeapi.CpuUsage{
 			Timestamp: timestamp,
 			UsageCoreNanoSeconds: &runtimeapi.UInt64Value{
 				Value: dockerStats.CPUStats.CPUUsage.TotalUsage,
 			},
-		},
-		Memory: &runtimeapi.MemoryUsage{
+		}
+		containerStats.Memory = &runtimeapi.MemoryUsage{
 			Timestamp: timestamp,
 			WorkingSetBytes: &runtimeapi.UInt64Value{
 				Value: dockerStats.MemoryStats.Usage,
 			},
-		},
+		}
 	}
 
 	cstat := ds.containerStatsCache.getStats(containerID)
@@ -65,3 +83,32 @@ func (ds *dockerService) getContainerStats(container *runtimeapi.Container) (*ru
 	}
 	return containerStats, nil
 }
+
+// setCgroupStats sets the CPU, memory and swap stats read from the cgroup of
+// the container.
+func setCgroupStats(containerStats *runtimeapi.ContainerStats, stats *cgroupStats, timestamp int64) {
+	containerStats.Cpu = &runtimeapi.CpuUsage{
+		Timestamp:            timestamp,
+		UsageCoreNanoSeconds: &runtimeapi.UInt64Value{Value: stats.cpuUsageNanoSeconds},
+	}
+	containerStats.Memory = &runtimeapi.MemoryUsage{
+		Timestamp:       timestamp,
+		WorkingSetBytes: &runtimeapi.UInt64Value{Value: stats.workingSet},
+		UsageBytes:      &runtimeapi.UInt64Value{Value: stats.memoryUsage},
+		RssBytes:        &runtimeapi.UInt64Value{Value: stats.rss},
+		PageFaults:      &runtimeapi.UInt64Value{Value: stats.pageFaults},
+		MajorPageFaults: &runtimeapi.UInt64Value{Value: stats.majorPageFaults},
+	}
+	if stats.memoryLimit > stats.workingSet {
+		containerStats.Memory.AvailableBytes = &runtimeapi.UInt64Value{Value: stats.memoryLimit - stats.workingSet}
+	}
+	if stats.hasSwap {
+		containerStats.Swap = &runtimeapi.SwapUsage{
+			Timestamp:      timestamp,
+			SwapUsageBytes: &runtimeapi.UInt64Value{Value: stats.swapUsage},
+		}
+		if stats.swapLimit > stats.swapUsage {
+			containerStats.Swap.SwapAvailableBytes = &runtimeapi.UInt64Value{Value: stats.swapLimit - stats.swapUsage}
+		}
+	}
+}
@@ -20,11 +20,15 @@ limitations under the License.
 package core
 
 import (
+	"context"
 	"fmt"
 
 	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
 )
 
-func (ds *dockerService) getContainerStats(c *runtimeapi.Container) (*runtimeapi.ContainerStats, error) {
+func (ds *dockerService) getContainerStats(
+	_ context.Context,
+	c *runtimeapi.Container,
+) (*runtimeapi.ContainerStats, error) {
 	return nil, fmt.Errorf("not implemented")
 }
@@ -20,15 +20,21 @@ limitations under the License.
 package core
 
 import (
+	"context"
 	"strings"
 	"time"
 
 	"github.com/Microsoft/hcsshim"
-	"github.com/sirupsen/logrus"
 	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"github.com/Mirantis/cri-dockerd/utils/logging"
 )
 
-func (ds *dockerService) getContainerStats(container *runtimeapi.Container) (*runtimeapi.ContainerStats, error) {
+func (ds *dockerService) getContainerStats(
+	ctx context.Context,
+	container *runtimeapi.Container,
+) (*runtimeapi.ContainerStats, error) {
+	log := logging.FromContext(ctx)
 	containerID := container.Id
 	hcsshimContainer, err := hcsshim.OpenContainer(containerID)
 	if err != nil {
@@ -36,14 +42,14 @@ func (ds *dockerService) getContainerStats(container *runtimeapi.Container) (*ru
 		// That will typically happen with init-containers in Exited state. Docker still knows about them but the HCS does not.
 		// As we don't want to block stats retrieval for other containers, we only log errors.
 		if !hcsshim.IsNotExist(err) && !hcsshim.IsAlreadyStopped(err) {
-			logrus.Info("Error opening container for ID: %d (stats will be missing): %v", containerID, err)
+			log.Info("Error opening container for ID: %d (stats will be missing): %v", containerID, err)
 		}
 		return nil, nil
 	}
 	defer func() {
 		closeErr := hcsshimContainer.Close()
 		if closeErr != nil {
-			logrus.Errorf("Error closing container %d: %v", containerID, err)
+			log.Errorf("Error closing container %d: %v", containerID, err)
 		}
 	}()
 
@@ -56,7 +62,7 @@ func (ds *dockerService) getContainerStats(container *runtimeapi.Container) (*ru
 			// These hcs errors do not have helpers exposed in public package so need to query for the known codes
 			// https://github.com/microsoft/hcsshim/blob/master/internal/hcs/errors.go
 			// PR to expose helpers in hcsshim: https://github.com/microsoft/hcsshim/pull/933
-			logrus.Info(
+			log.Info(
 				"Container %dis not in a state that stats can be accessed. This occurs when the container is created but not started: %v",
 				containerID,
 				err,
@@ -0,0 +1,88 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"path/filepath"
+	"strings"
+
+	dockersystem "github.com/docker/docker/api/types/system"
+)
+
+const (
+	// containerdSnapshotterDriverType is the driver type docker reports when
+	// it uses the containerd image store.
+	containerdSnapshotterDriverType = "io.containerd.snapshotter.v1"
+	// defaultContainerdRootDir is the root directory of a system containerd.
+	defaultContainerdRootDir = "/var/lib/containerd"
+)
+
+// storagePaths are the directories docker stores the images and the
+// containers in.
+type storagePaths struct {
+	// images holds the image layers: the directory of the graph driver, or
+	// the one of the snapshotter with the containerd image store.
+	images string
+	// containers holds the writable layers of the containers, next to the
+	// image layers they are on top of.
+	containers string
+}
+
+// driverStatus returns the value of the key of the status of the storage
+// driver, empty if there is none.
+func driverStatus(info *dockersystem.Info, key string) string {
+	for _, status := range info.DriverStatus {
+		if status[0] == key {
+			return status[1]
+		}
+	}
+	return ""
+}
+
+// usesContainerdImageStore returns whether docker stores its images in
+// containerd.
+func usesContainerdImageStore(info *dockersystem.Info) bool {
+	return driverStatus(info, "driver-type") == containerdSnapshotterDriverType
+}
+
+// detectStoragePaths returns the storage directories of docker, from its info.
+// containerdRootDir overrides the root directory of containerd, detected from
+// its address otherwise.
+func detectStoragePaths(info *dockersystem.Info, containerdRootDir string) storagePaths {
+	layers := info.DockerRootDir
+	switch {
+	case usesContainerdImageStore(info):
+		if containerdRootDir == "" {
+			containerdRootDir = defaultContainerdRootDir
+			// dockerd runs its own containerd, under its data root, when it
+			// isn't given the address of one.
+			if info.Containerd != nil && strings.Contains(info.Containerd.Address, "/docker/containerd/") {
+				containerdRootDir = filepath.Join(info.DockerRootDir, "containerd", "daemon")
+			}
+		}
+		layers = filepath.Join(containerdRootDir, containerdSnapshotterDriverType+"."+info.Driver)
+	case driverStatus(info, "Root Dir") != "":
+		// Some graph drivers, e.g. btrfs and zfs, report where they are.
+		layers = driverStatus(info, "Root Dir")
+	case info.Driver != "":
+		layers = filepath.Join(info.DockerRootDir, info.Driver)
+	}
+	// The writable layers of the containers, e.g. the upper directories of
+	// overlay2, are stored by the graph driver or the snapshotter along the
+	// image layers, not with the configs and logs of the containers.
+	return storagePaths{images: layers, containers: layers}
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"path/filepath"
+	"testing"
+
+	dockersystem "github.com/docker/docker/api/types/system"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectStoragePaths(t *testing.T) {
+	root := filepath.FromSlash("/data/docker")
+	snapshotter := [][2]string{{"driver-type", containerdSnapshotterDriverType}}
+	for name, test := range map[string]struct {
+		info              dockersystem.Info
+		containerdRootDir string
+		images            string
+	}{
+		"graph driver": {
+			info:   dockersystem.Info{DockerRootDir: root, Driver: "overlay2"},
+			images: filepath.Join(root, "overlay2"),
+		},
+		"containerd image store with system containerd": {
+			info: dockersystem.Info{
+				DockerRootDir: root,
+				Driver:        "overlayfs",
+				DriverStatus:  snapshotter,
+				Containerd:    &dockersystem.ContainerdInfo{Address: "/run/containerd/containerd.sock"},
+			},
+			images: filepath.Join(defaultContainerdRootDir, "io.containerd.snapshotter.v1.overlayfs"),
+		},
+		"containerd image store with containerd of dockerd": {
+			info: dockersystem.Info{
+				DockerRootDir: root,
+				Driver:        "overlayfs",
+				DriverStatus:  snapshotter,
+				Containerd:    &dockersystem.ContainerdInfo{Address: "/var/run/docker/containerd/containerd.sock"},
+			},
+			images: filepath.Join(root, "containerd", "daemon", "io.containerd.snapshotter.v1.overlayfs"),
+		},
+		"containerd image store with configured root": {
+			info: dockersystem.Info{
+				DockerRootDir: root,
+				Driver:        "overlayfs",
+				DriverStatus:  snapshotter,
+			},
+			containerdRootDir: filepath.FromSlash("/mnt/containerd"),
+			images:            filepath.Join(filepath.FromSlash("/mnt/containerd"), "io.containerd.snapshotter.v1.overlayfs"),
+		},
+		"graph driver with root dir": {
+			info: dockersystem.Info{
+				DockerRootDir: root,
+				Driver:        "btrfs",
+				DriverStatus:  [][2]string{{"Root Dir", filepath.FromSlash("/mnt/btrfs")}},
+			},
+			images: filepath.FromSlash("/mnt/btrfs"),
+		},
+	} {
+		paths := detectStoragePaths(&test.info, test.containerdRootDir)
+		assert.Equal(t, test.images, paths.images, name)
+		// The writable layers are next to the image layers.
+		assert.Equal(t, test.images, paths.containers, name)
+	}
+}
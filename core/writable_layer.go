@@ -0,0 +1,143 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+
+	dockertypes "github.com/docker/docker/api/types"
+	dockercontainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/Mirantis/cri-dockerd/libdocker"
+)
+
+const (
+	// writableLayerWorkers is the number of writable layers measured in
+	// parallel. It bounds the disk I/O of the directory walks and of the
+	// sizes computed by dockerd.
+	writableLayerWorkers = 4
+	// writableLayerSchedulePeriod is how often the containers due for
+	// measuring are looked for.
+	writableLayerSchedulePeriod = 10 * time.Second
+)
+
+// errWritableLayerUnsupported is returned when the writable layer can't be
+// measured without dockerd on the platform.
+var errWritableLayerUnsupported = errors.New("measuring writable layers is not supported on this platform")
+
+// startStatsCollection measures the writable layers of the containers in the
+// background, each one every minCollectInterval at most, with a fixed pool of
+// workers.
+func (ds *dockerService) startStatsCollection() {
+	jobs := make(chan *cstats)
+	for i := 0; i < writableLayerWorkers; i++ {
+		go func() {
+			for cs := range jobs {
+				size, err := measureWritableLayer(ds.client, cs)
+				if err != nil {
+					logrus.Errorf("Error getting RW layer size for container ID '%s': %v", cs.containerID, err)
+				} else {
+					logrus.Debugf("RW layer size for container ID '%s': %v", cs.containerID, size)
+				}
+				ds.containerStatsCache.update(cs, size, err, time.Now())
+			}
+		}()
+	}
+
+	wait.Forever(func() {
+		// Progress is recorded per pass and per measurement, a pass waiting
+		// for busy workers to take its jobs isn't stuck.
+		ds.containerStatsCache.recordProgress(time.Now())
+		containerIDs, err := ds.listContainerIDs()
+		if err != nil {
+			logrus.Errorf("Error listing containers to collect RW layer sizes: %v", err)
+			return
+		}
+		for _, cs := range ds.containerStatsCache.sync(containerIDs, time.Now()) {
+			jobs <- cs
+		}
+	}, writableLayerSchedulePeriod)
+}
+
+// listContainerIDs returns the IDs of all the containers, sandboxes excluded.
+func (ds *dockerService) listContainerIDs() ([]string, error) {
+	opts := dockercontainer.ListOptions{All: true, Filters: filters.NewArgs()}
+	NewDockerFilter(&opts.Filters).AddLabel(containerTypeLabelKey, containerTypeLabelContainer)
+	containers, err := ds.listContainers(opts)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(containers))
+	for _, c := range containers {
+		ids = append(ids, c.ID)
+	}
+	return ids, nil
+}
+
+// measureWritableLayer returns the disk usage of the writable layer of the
+// container. The overlay2 upper dir is measured directly where possible, the
+// size computed by dockerd is the fallback.
+func measureWritableLayer(client libdocker.DockerClientInterface, cs *cstats) (uint64, error) {
+	if !cs.upperDirResolved {
+		containerJSON, err := client.InspectContainer(cs.containerID)
+		if err != nil {
+			return 0, err
+		}
+		cs.upperDir = overlayUpperDir(containerJSON)
+		cs.upperDirResolved = true
+	}
+
+	if cs.upperDir != "" {
+		start := time.Now()
+		size, err := directoryUsage(cs.upperDir)
+		if err == nil {
+			logrus.Debugf("Measured upper dir of container ID '%s', time taken %v", cs.containerID, time.Since(start))
+			return size, nil
+		}
+		logrus.Debugf("Failed to measure upper dir %s of container ID '%s', asking docker: %v", cs.upperDir, cs.containerID, err)
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, errWritableLayerUnsupported) {
+			// The upper dir isn't visible to cri-dockerd, don't try again.
+			cs.upperDir = ""
+		}
+	}
+
+	start := time.Now()
+	containerJSON, err := client.InspectContainerWithSize(cs.containerID)
+	logrus.Debugf("Get RW layer size for container ID '%s', time taken %v", cs.containerID, time.Since(start))
+	if err != nil {
+		return 0, err
+	}
+	if containerJSON.SizeRw == nil {
+		return 0, fmt.Errorf("docker didn't report the RW layer size")
+	}
+	return uint64(*containerJSON.SizeRw), nil
+}
+
+// overlayUpperDir returns the upper dir of the container if its storage
+// driver is overlay2.
+func overlayUpperDir(containerJSON *dockertypes.ContainerJSON) string {
+	if containerJSON.ContainerJSONBase == nil || containerJSON.GraphDriver.Name != "overlay2" {
+		return ""
+	}
+	return containerJSON.GraphDriver.Data["UpperDir"]
+}
@@ -0,0 +1,140 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// fsIocFsGetXattr is FS_IOC_FSGETXATTR with the generic ioctl encoding.
+	// The other architectures fail it with ENOTTY and walk the directory.
+	fsIocFsGetXattr = 0x801c581f
+	// qGetProjectQuota is QCMD(Q_GETQUOTA, PRJQUOTA).
+	qGetProjectQuota = 0x800007<<8 | 2
+)
+
+// fsxattr is struct fsxattr of linux/fs.h.
+type fsxattr struct {
+	xflags     uint32
+	extsize    uint32
+	nextents   uint32
+	projid     uint32
+	cowextsize uint32
+	pad        [8]byte
+}
+
+// ifDqblk is struct if_dqblk of linux/quota.h.
+type ifDqblk struct {
+	bhardlimit uint64
+	bsoftlimit uint64
+	curspace   uint64
+	ihardlimit uint64
+	isoftlimit uint64
+	curinodes  uint64
+	btime      uint64
+	itime      uint64
+	valid      uint32
+}
+
+// directoryUsage returns the disk usage of the directory: the usage of its
+// project quota if it has one, as dockerd sets up for the storage-opt size of
+// containers, the sum of its files otherwise.
+func directoryUsage(dir string) (uint64, error) {
+	if usage, ok := projectQuotaUsage(dir); ok {
+		return usage, nil
+	}
+	return walkDirectoryUsage(dir)
+}
+
+// projectQuotaUsage returns the usage of the project quota of the directory,
+// and false if the directory has no project or its filesystem doesn't account
+// for it.
+func projectQuotaUsage(dir string) (uint64, bool) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+
+	var attr fsxattr
+	if _, _, errno := unix.Syscall(
+		unix.SYS_IOCTL, f.Fd(), fsIocFsGetXattr, uintptr(unsafe.Pointer(&attr)),
+	); errno != 0 || attr.projid == 0 {
+		return 0, false
+	}
+	var quota ifDqblk
+	if _, _, errno := unix.Syscall6(
+		unix.SYS_QUOTACTL_FD, f.Fd(), qGetProjectQuota, uintptr(attr.projid),
+		uintptr(unsafe.Pointer(&quota)), 0, 0,
+	); errno != 0 {
+		// ENOSYS before Linux 5.14, ESRCH without project quota accounting.
+		return 0, false
+	}
+	return quota.curspace, true
+}
+
+// walkDirectoryUsage returns the disk usage of the files in the directory,
+// counting hard links once.
+func walkDirectoryUsage(dir string) (uint64, error) {
+	type inode struct {
+		dev, ino uint64
+	}
+	seen := make(map[inode]struct{})
+	var usage uint64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Files are removed while the container runs.
+			if errors.Is(err, fs.ErrNotExist) && path != dir {
+				return nil
+			}
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			usage += uint64(info.Size())
+			return nil
+		}
+		if stat.Nlink > 1 {
+			key := inode{dev: uint64(stat.Dev), ino: stat.Ino}
+			if _, counted := seen[key]; counted {
+				return nil
+			}
+			seen[key] = struct{}{}
+		}
+		usage += uint64(stat.Blocks) * 512
+		return nil
+	})
+	return usage, err
+}
@@ -0,0 +1,67 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Mirantis/cri-dockerd/libdocker"
+)
+
+func TestMeasureWritableLayerReadsUpperDir(t *testing.T) {
+	upperDir := t.TempDir()
+	data := make([]byte, 1<<20)
+	for i := range data {
+		data[i] = 1
+	}
+	require.NoError(t, os.MkdirAll(filepath.Join(upperDir, "var/log"), 0o755))
+	file := filepath.Join(upperDir, "var/log/app.log")
+	require.NoError(t, os.WriteFile(file, data, 0o644))
+	require.NoError(t, os.Link(file, filepath.Join(upperDir, "app.log")))
+
+	fakeDocker := libdocker.NewFakeDockerClient()
+	fakeDocker.SetFakeContainers([]*libdocker.FakeContainer{{ID: "c1", Name: "k8s_c1"}})
+	fakeDocker.ContainerMap["c1"].GraphDriver = types.GraphDriverData{
+		Name: "overlay2",
+		Data: map[string]string{"UpperDir": upperDir},
+	}
+
+	size, err := measureWritableLayer(fakeDocker, newCstats("c1", time.Now()))
+	require.NoError(t, err)
+	// The hard link is counted once.
+	assert.GreaterOrEqual(t, size, uint64(len(data)))
+	assert.Less(t, size, uint64(2*len(data)))
+	assert.NoError(t, fakeDocker.AssertCalls([]string{"inspect_container"}))
+
+	// Upper dirs that can't be read fall back to docker.
+	require.NoError(t, os.RemoveAll(upperDir))
+	cs := newCstats("c1", time.Now())
+	size, err = measureWritableLayer(fakeDocker, cs)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(40), size)
+	assert.Empty(t, cs.upperDir)
+}
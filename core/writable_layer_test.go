@@ -0,0 +1,81 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Mirantis/cri-dockerd/libdocker"
+)
+
+func cstatsIDs(stats []*cstats) []string {
+	ids := make([]string, 0, len(stats))
+	for _, cs := range stats {
+		ids = append(ids, cs.containerID)
+	}
+	return ids
+}
+
+func TestContainerStatsCacheScheduling(t *testing.T) {
+	c := newContainerStatsCache()
+	now := time.Now()
+
+	due := c.sync([]string{"a", "b"}, now)
+	assert.ElementsMatch(t, []string{"a", "b"}, cstatsIDs(due))
+	// Queued containers aren't scheduled twice.
+	assert.Empty(t, c.sync([]string{"a", "b"}, now))
+
+	c.update(c.getStats("a"), 100, nil, now)
+	c.update(c.getStats("b"), 0, fmt.Errorf("inspect failed"), now)
+	assert.True(t, c.getStats("a").isInitialized())
+	assert.False(t, c.getStats("b").isInitialized())
+	assert.Equal(t, uint64(100), c.getWriteableLayer())
+
+	assert.Empty(t, c.sync([]string{"a", "b"}, now.Add(time.Second)))
+	assert.Equal(t, []string{"a"}, cstatsIDs(c.sync([]string{"a", "b"}, now.Add(minCollectInterval))))
+	// Errors back off.
+	assert.Equal(t, []string{"b"}, cstatsIDs(c.sync([]string{"a", "b"}, now.Add(2*minCollectInterval))))
+
+	// Removed containers are evicted, and updates of evicted entries ignored.
+	a := c.getStats("a")
+	c.sync([]string{"b"}, now.Add(3*minCollectInterval))
+	assert.Nil(t, c.getStats("a"))
+	c.update(a, 100, nil, now.Add(3*minCollectInterval))
+	assert.Equal(t, uint64(0), c.getWriteableLayer())
+}
+
+func TestMeasureWritableLayerFallsBackToDocker(t *testing.T) {
+	fakeDocker := libdocker.NewFakeDockerClient()
+	fakeDocker.SetFakeContainers([]*libdocker.FakeContainer{{ID: "c1", Name: "k8s_c1"}})
+
+	cs := newCstats("c1", time.Now())
+	size, err := measureWritableLayer(fakeDocker, cs)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(40), size)
+	assert.NoError(t, fakeDocker.AssertCalls([]string{"inspect_container", "inspect_container_withsize"}))
+
+	// The storage driver is only looked up once.
+	fakeDocker.ClearCalls()
+	_, err = measureWritableLayer(fakeDocker, cs)
+	require.NoError(t, err)
+	assert.NoError(t, fakeDocker.AssertCalls([]string{"inspect_container_withsize"}))
+}
@@ -0,0 +1,372 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package imagepolicy verifies the signatures of images before they are
+// pulled, following a policy in the format of the policy.json file of
+// containers/image.
+package imagepolicy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+)
+
+// Requirement types.
+const (
+	// TypeInsecureAcceptAnything accepts any image.
+	TypeInsecureAcceptAnything = "insecureAcceptAnything"
+	// TypeReject rejects any image.
+	TypeReject = "reject"
+	// TypeSignedBy requires a containers/image simple signing signature made
+	// with one of the GPG keys.
+	TypeSignedBy = "signedBy"
+	// TypeSigstoreSigned requires a cosign signature made with one of the
+	// public keys.
+	TypeSigstoreSigned = "sigstoreSigned"
+)
+
+// Identity types, telling how the identity in a signature must match the
+// pulled image.
+const (
+	// MatchRepoDigestOrExact requires the same repository for images pulled
+	// by digest, and the same repository and tag otherwise. This is the
+	// default.
+	MatchRepoDigestOrExact = "matchRepoDigestOrExact"
+	// MatchRepository requires the same repository.
+	MatchRepository = "matchRepository"
+	// MatchExact requires the same repository and tag or digest.
+	MatchExact = "matchExact"
+)
+
+// dockerTransport is the only transport of the policy cri-dockerd uses.
+const dockerTransport = "docker"
+
+// Policy is the content of a policy.json file.
+type Policy struct {
+	// Default are the requirements of the images no scope applies to.
+	Default []Requirement `json:"default"`
+	// Transports maps the transports to the requirements by scope. Scopes are
+	// repositories, namespaces or registries, e.g. "docker.io/library/busybox",
+	// "quay.io/myorg" or "registry.example.com:5000".
+	Transports map[string]map[string][]Requirement `json:"transports,omitempty"`
+}
+
+// Requirement is a policy requirement. All the requirements of a scope must
+// be satisfied.
+type Requirement struct {
+	Type string `json:"type"`
+	// KeyType is the type of the keys of signedBy requirements, only
+	// "GPGKeys" is supported.
+	KeyType string `json:"keyType,omitempty"`
+	// KeyPath is a file containing the keys.
+	KeyPath string `json:"keyPath,omitempty"`
+	// KeyPaths are files containing the keys, any of which may have made
+	// the signature.
+	KeyPaths []string `json:"keyPaths,omitempty"`
+	// SignedIdentity tells how the identity in a signature must match the
+	// pulled image.
+	SignedIdentity *SignedIdentity `json:"signedIdentity,omitempty"`
+	// Lookaside is the URL of the signature storage of signedBy requirements,
+	// as configured in registries.d for containers/image, e.g.
+	// "file:///var/lib/containers/sigstore" or "https://sigstore.example.com".
+	Lookaside string `json:"lookaside,omitempty"`
+}
+
+// SignedIdentity tells how the identity in a signature must match the pulled
+// image.
+type SignedIdentity struct {
+	Type string `json:"type"`
+}
+
+// Credentials authenticate to the registry the signatures are fetched from.
+type Credentials struct {
+	Username      string
+	Password      string
+	RegistryToken string
+}
+
+// RejectedError is returned for the images the policy rejects.
+type RejectedError struct {
+	Image  string
+	Reason error
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("image %q rejected by the signature policy: %v", e.Image, e.Reason)
+}
+
+func (e *RejectedError) Unwrap() error {
+	return e.Reason
+}
+
+// IsRejected returns whether the error is a rejection by the policy.
+func IsRejected(err error) bool {
+	var rejected *RejectedError
+	return errors.As(err, &rejected)
+}
+
+// LoadPolicy reads the policy file.
+func LoadPolicy(file string) (*Policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image signature policy %q: %v", file, err)
+	}
+	p := &Policy{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("failed to parse image signature policy %q: %v", file, err)
+	}
+	return p, nil
+}
+
+// requirement is a compiled policy requirement.
+type requirement interface {
+	// needsSignature returns whether the requirement looks at signatures,
+	// false if it accepts or rejects any image.
+	needsSignature() bool
+	verify(ctx context.Context, repo *repository, image reference.Named, dgst digest.Digest) error
+}
+
+// Verifier verifies images against a policy.
+type Verifier struct {
+	defaultRequirements []requirement
+	scopes              map[string][]requirement
+	httpClient          *http.Client
+}
+
+// NewVerifier compiles the policy, loading its keys.
+func NewVerifier(p *Policy) (*Verifier, error) {
+	v := &Verifier{
+		scopes:     make(map[string][]requirement),
+		httpClient: &http.Client{Timeout: time.Minute},
+	}
+	if len(p.Default) == 0 {
+		return nil, fmt.Errorf("the image signature policy has no default requirement")
+	}
+	var err error
+	if v.defaultRequirements, err = v.compile(p.Default); err != nil {
+		return nil, fmt.Errorf("invalid default requirements: %v", err)
+	}
+	for transport, scopes := range p.Transports {
+		if transport != dockerTransport {
+			continue
+		}
+		for scope, requirements := range scopes {
+			if len(requirements) == 0 {
+				return nil, fmt.Errorf("no requirement for scope %q", scope)
+			}
+			if v.scopes[scope], err = v.compile(requirements); err != nil {
+				return nil, fmt.Errorf("invalid requirements for scope %q: %v", scope, err)
+			}
+		}
+	}
+	return v, nil
+}
+
+func (v *Verifier) compile(requirements []Requirement) ([]requirement, error) {
+	var compiled []requirement
+	for _, r := range requirements {
+		identity := MatchRepoDigestOrExact
+		if r.SignedIdentity != nil {
+			identity = r.SignedIdentity.Type
+		}
+		switch identity {
+		case MatchRepoDigestOrExact, MatchRepository, MatchExact:
+		default:
+			return nil, fmt.Errorf("unsupported signed identity %q", identity)
+		}
+		keyPaths := r.KeyPaths
+		if r.KeyPath != "" {
+			keyPaths = append([]string{r.KeyPath}, keyPaths...)
+		}
+
+		switch r.Type {
+		case TypeInsecureAcceptAnything:
+			compiled = append(compiled, acceptRequirement{})
+		case TypeReject:
+			compiled = append(compiled, rejectRequirement{})
+		case TypeSignedBy:
+			if r.KeyType != "GPGKeys" {
+				return nil, fmt.Errorf("unsupported key type %q", r.KeyType)
+			}
+			req, err := newSignedByRequirement(keyPaths, r.Lookaside, identity, v)
+			if err != nil {
+				return nil, err
+			}
+			compiled = append(compiled, req)
+		case TypeSigstoreSigned:
+			req, err := newSigstoreRequirement(keyPaths, identity)
+			if err != nil {
+				return nil, err
+			}
+			compiled = append(compiled, req)
+		default:
+			return nil, fmt.Errorf("unsupported requirement type %q", r.Type)
+		}
+	}
+	return compiled, nil
+}
+
+// requirementsFor returns the requirements of the most specific scope
+// matching the image.
+func (v *Verifier) requirementsFor(image reference.Named) []requirement {
+	if requirements, ok := v.scopes[image.String()]; ok {
+		return requirements
+	}
+	for scope := image.Name(); ; {
+		if requirements, ok := v.scopes[scope]; ok {
+			return requirements
+		}
+		i := strings.LastIndex(scope, "/")
+		if i < 0 {
+			return v.defaultRequirements
+		}
+		scope = scope[:i]
+	}
+}
+
+// Verify checks the image against the policy. It returns the manifest digest
+// the signatures were verified for, which the pulled image must have, or an
+// empty digest if the policy accepts the image without looking at signatures.
+func (v *Verifier) Verify(ctx context.Context, image string, creds Credentials) (digest.Digest, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse image name %q: %v", image, err)
+	}
+	named = reference.TagNameOnly(named)
+
+	requirements := v.requirementsFor(named)
+	needsSignature := false
+	for _, r := range requirements {
+		if _, ok := r.(rejectRequirement); ok {
+			return "", &RejectedError{Image: image, Reason: errors.New("images from this scope are rejected")}
+		}
+		needsSignature = needsSignature || r.needsSignature()
+	}
+	if !needsSignature {
+		return "", nil
+	}
+
+	repo := newRepository(v.httpClient, named, creds)
+	var dgst digest.Digest
+	if digested, ok := named.(reference.Digested); ok {
+		dgst = digested.Digest()
+	} else if dgst, err = repo.resolve(ctx, named.(reference.Tagged).Tag()); err != nil {
+		return "", fmt.Errorf("failed to resolve the digest of image %q: %v", image, err)
+	}
+	for _, r := range requirements {
+		if err := r.verify(ctx, repo, named, dgst); err != nil {
+			return "", &RejectedError{Image: image, Reason: err}
+		}
+	}
+	return dgst, nil
+}
+
+type acceptRequirement struct{}
+
+func (acceptRequirement) needsSignature() bool {
+	return false
+}
+
+func (acceptRequirement) verify(context.Context, *repository, reference.Named, digest.Digest) error {
+	return nil
+}
+
+type rejectRequirement struct{}
+
+func (rejectRequirement) needsSignature() bool {
+	return false
+}
+
+func (rejectRequirement) verify(context.Context, *repository, reference.Named, digest.Digest) error {
+	return errors.New("images from this scope are rejected")
+}
+
+// signaturePayload is the payload of simple signing signatures, which cosign
+// also uses.
+type signaturePayload struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+}
+
+// check verifies that the payload, of the signature type, was signed for the
+// image. Unknown critical fields are rejected, as the format requires, while
+// the optional ones are ignored.
+func (p *signaturePayload) check(
+	data []byte,
+	signatureType, identity string,
+	image reference.Named,
+	dgst digest.Digest,
+) error {
+	var payload struct {
+		Critical json.RawMessage `json:"critical"`
+	}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return fmt.Errorf("invalid signature payload: %v", err)
+	}
+	if len(payload.Critical) == 0 {
+		return errors.New("invalid signature payload: no critical section")
+	}
+	decoder := json.NewDecoder(bytes.NewReader(payload.Critical))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&p.Critical); err != nil {
+		return fmt.Errorf("invalid signature payload: %v", err)
+	}
+	if p.Critical.Type != signatureType {
+		return fmt.Errorf("signature is of type %q, not %q", p.Critical.Type, signatureType)
+	}
+	if p.Critical.Image.DockerManifestDigest != dgst.String() {
+		return fmt.Errorf("signature is for digest %s, not %s", p.Critical.Image.DockerManifestDigest, dgst)
+	}
+	signed, err := reference.ParseNormalizedNamed(p.Critical.Identity.DockerReference)
+	if err != nil {
+		return fmt.Errorf("invalid signed identity %q: %v", p.Critical.Identity.DockerReference, err)
+	}
+	if !matchIdentity(identity, image, signed) {
+		return fmt.Errorf("signature is for %q, not %q", signed, image)
+	}
+	return nil
+}
+
+func matchIdentity(identity string, image, signed reference.Named) bool {
+	switch identity {
+	case MatchRepository:
+		return image.Name() == signed.Name()
+	case MatchExact:
+		return image.String() == signed.String()
+	default:
+		if _, ok := image.(reference.Digested); ok {
+			return image.Name() == signed.Name()
+		}
+		return image.String() == signed.String()
+	}
+}
@@ -0,0 +1,327 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package imagepolicy
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testRegistry is a stand-in for an OCI registry, serving manifests and
+// blobs from memory.
+type testRegistry struct {
+	server    *httptest.Server
+	manifests map[string][]byte
+	blobs     map[digest.Digest][]byte
+}
+
+func newTestRegistry(t *testing.T) *testRegistry {
+	r := &testRegistry{
+		manifests: make(map[string][]byte),
+		blobs:     make(map[digest.Digest][]byte),
+	}
+	r.server = httptest.NewTLSServer(http.HandlerFunc(r.serve))
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+func (r *testRegistry) serve(w http.ResponseWriter, req *http.Request) {
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	if i := strings.LastIndex(path, "/manifests/"); i >= 0 {
+		data, ok := r.manifests[path[:i]+":"+path[i+len("/manifests/"):]]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(data).String())
+		w.Write(data)
+		return
+	}
+	if i := strings.LastIndex(path, "/blobs/"); i >= 0 {
+		data, ok := r.blobs[digest.Digest(path[i+len("/blobs/"):])]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write(data)
+		return
+	}
+	http.NotFound(w, req)
+}
+
+func (r *testRegistry) host() string {
+	u, _ := url.Parse(r.server.URL)
+	return u.Host
+}
+
+// pushImage stores a manifest for repo:tag and returns its digest.
+func (r *testRegistry) pushImage(repo, tag string) digest.Digest {
+	manifest, _ := json.Marshal(ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    ocispec.Descriptor{MediaType: ocispec.MediaTypeImageConfig, Digest: digest.FromString(repo + tag)},
+	})
+	r.manifests[repo+":"+tag] = manifest
+	dgst := digest.FromBytes(manifest)
+	r.manifests[repo+":"+dgst.String()] = manifest
+	return dgst
+}
+
+// pushCosignSignature stores a cosign signature of the digest made with key.
+func (r *testRegistry) pushCosignSignature(t *testing.T, repo string, dgst digest.Digest, key *ecdsa.PrivateKey) {
+	payload := testPayload(cosignSignatureType, r.host()+"/"+repo, dgst)
+	hash := sha256.Sum256(payload)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+	require.NoError(t, err)
+	r.blobs[digest.FromBytes(payload)] = payload
+	manifest, _ := json.Marshal(ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Layers: []ocispec.Descriptor{{
+			MediaType: "application/vnd.dev.cosign.simplesigning.v1+json",
+			Digest:    digest.FromBytes(payload),
+			Size:      int64(len(payload)),
+			Annotations: map[string]string{
+				cosignSignatureAnnotation: base64.StdEncoding.EncodeToString(sig),
+			},
+		}},
+	})
+	r.manifests[repo+":"+strings.Replace(dgst.String(), ":", "-", 1)+".sig"] = manifest
+}
+
+func testPayload(signatureType, dockerReference string, dgst digest.Digest) []byte {
+	return []byte(fmt.Sprintf(
+		`{"critical":{"identity":{"docker-reference":%q},"image":{"docker-manifest-digest":%q},"type":%q},"optional":null}`,
+		dockerReference, dgst, signatureType))
+}
+
+func writeECDSAPublicKey(t *testing.T, key *ecdsa.PrivateKey) string {
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "cosign.pub")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o644))
+	return path
+}
+
+func newTestVerifier(t *testing.T, registry *testRegistry, policy *Policy) *Verifier {
+	v, err := NewVerifier(policy)
+	require.NoError(t, err)
+	v.httpClient = registry.server.Client()
+	return v
+}
+
+func TestVerifySigstoreSignature(t *testing.T) {
+	registry := newTestRegistry(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	signed := registry.pushImage("team/signed", "v1")
+	registry.pushCosignSignature(t, "team/signed", signed, key)
+	registry.pushImage("team/unsigned", "v1")
+	wrongKey := registry.pushImage("team/wrongkey", "v1")
+	registry.pushCosignSignature(t, "team/wrongkey", wrongKey, otherKey)
+
+	v := newTestVerifier(t, registry, &Policy{
+		Default: []Requirement{{Type: TypeInsecureAcceptAnything}},
+		Transports: map[string]map[string][]Requirement{
+			"docker": {
+				registry.host() + "/team": {{
+					Type:           TypeSigstoreSigned,
+					KeyPath:        writeECDSAPublicKey(t, key),
+					SignedIdentity: &SignedIdentity{Type: MatchRepository},
+				}},
+				registry.host() + "/team/forbidden": {{Type: TypeReject}},
+			},
+		},
+	})
+	ctx := context.Background()
+
+	dgst, err := v.Verify(ctx, registry.host()+"/team/signed:v1", Credentials{})
+	require.NoError(t, err)
+	assert.Equal(t, signed, dgst)
+
+	dgst, err = v.Verify(ctx, registry.host()+"/team/signed@"+signed.String(), Credentials{})
+	require.NoError(t, err)
+	assert.Equal(t, signed, dgst)
+
+	for _, image := range []string{"team/unsigned:v1", "team/wrongkey:v1", "team/forbidden:v1"} {
+		_, err = v.Verify(ctx, registry.host()+"/"+image, Credentials{})
+		assert.True(t, IsRejected(err), "image %s: %v", image, err)
+	}
+
+	// Other registries fall back to the default requirement.
+	dgst, err = v.Verify(ctx, "busybox", Credentials{})
+	require.NoError(t, err)
+	assert.Empty(t, dgst)
+}
+
+func TestVerifySimpleSigningSignature(t *testing.T) {
+	registry := newTestRegistry(t)
+	dgst := registry.pushImage("team/app", "v1")
+	registry.pushImage("team/other", "v1")
+
+	config := &packet.Config{DefaultHash: crypto.SHA256}
+	entity, err := openpgp.NewEntity("signer", "", "signer@example.com", config)
+	require.NoError(t, err)
+	keyring := &bytes.Buffer{}
+	require.NoError(t, entity.Serialize(keyring))
+	keyPath := filepath.Join(t.TempDir(), "pubring.gpg")
+	require.NoError(t, os.WriteFile(keyPath, keyring.Bytes(), 0o644))
+
+	// The signatures are stored as containers/image does, in
+	// <lookaside>/<path>@sha256=<hex>/signature-<n>.
+	lookaside := t.TempDir()
+	signature := &bytes.Buffer{}
+	w, err := openpgp.Sign(signature, entity, nil, config)
+	require.NoError(t, err)
+	_, err = w.Write(testPayload(simpleSigningType, registry.host()+"/team/app:v1", dgst))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	dir := filepath.Join(lookaside, "team", "app@sha256="+dgst.Encoded())
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "signature-1"), signature.Bytes(), 0o644))
+
+	v := newTestVerifier(t, registry, &Policy{
+		Default: []Requirement{{
+			Type:      TypeSignedBy,
+			KeyType:   "GPGKeys",
+			KeyPath:   keyPath,
+			Lookaside: "file://" + lookaside,
+		}},
+	})
+
+	verified, err := v.Verify(context.Background(), registry.host()+"/team/app:v1", Credentials{})
+	require.NoError(t, err)
+	assert.Equal(t, dgst, verified)
+
+	_, err = v.Verify(context.Background(), registry.host()+"/team/other:v1", Credentials{})
+	assert.True(t, IsRejected(err), "unexpected error: %v", err)
+}
+
+func TestVerifySimpleSigningSignatureTooLarge(t *testing.T) {
+	config := &packet.Config{DefaultHash: crypto.SHA256}
+	entity, err := openpgp.NewEntity("signer", "", "signer@example.com", config)
+	require.NoError(t, err)
+	r := &signedByRequirement{keyring: openpgp.EntityList{entity}}
+	image, err := reference.ParseNormalizedNamed("example.com/team/app:v1")
+	require.NoError(t, err)
+	dgst := digest.FromString("manifest")
+
+	// The signature is only checked past the end of the payload, which must
+	// be read in full even when it is over the limit.
+	signature := &bytes.Buffer{}
+	w, err := openpgp.Sign(signature, entity, nil, config)
+	require.NoError(t, err)
+	_, err = w.Write(testPayload(simpleSigningType, "example.com/team/app:v1", dgst))
+	require.NoError(t, err)
+	_, err = w.Write(bytes.Repeat([]byte(" "), maxFetchSize))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	err = r.verifySignature(signature.Bytes(), image, dgst)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "larger than")
+
+	// The lookaside signature storage is bounded by the same limit.
+	lookaside := t.TempDir()
+	dir := filepath.Join(lookaside, "team", "app@sha256="+dgst.Encoded())
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "signature-1"), signature.Bytes(), 0o644))
+	r.lookaside = &url.URL{Scheme: "file", Path: lookaside}
+	_, err = r.fetch(context.Background(), image, dgst, 1)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "larger than")
+}
+
+func TestSignaturePayloadCheck(t *testing.T) {
+	image, err := reference.ParseNormalizedNamed("example.com/team/app:v1")
+	require.NoError(t, err)
+	dgst := digest.FromString("manifest")
+	check := func(payload string) error {
+		return (&signaturePayload{}).check([]byte(payload), simpleSigningType, "", image, dgst)
+	}
+
+	assert.NoError(t, check(string(testPayload(simpleSigningType, "example.com/team/app:v1", dgst))))
+	assert.NoError(t, check(fmt.Sprintf(
+		`{"critical":{"identity":{"docker-reference":"example.com/team/app:v1"},"image":{"docker-manifest-digest":%q},"type":%q},"optional":{"creator":"test","timestamp":1}}`,
+		dgst, simpleSigningType)))
+
+	for desc, payload := range map[string]string{
+		"other type": string(testPayload(cosignSignatureType, "example.com/team/app:v1", dgst)),
+		"unknown critical field": fmt.Sprintf(
+			`{"critical":{"identity":{"docker-reference":"example.com/team/app:v1"},"image":{"docker-manifest-digest":%q},"type":%q,"extra":true}}`,
+			dgst, simpleSigningType),
+		"unknown identity field": fmt.Sprintf(
+			`{"critical":{"identity":{"docker-reference":"example.com/team/app:v1","extra":true},"image":{"docker-manifest-digest":%q},"type":%q}}`,
+			dgst, simpleSigningType),
+		"no critical section": `{"optional":null}`,
+	} {
+		assert.Error(t, check(payload), desc)
+	}
+}
+
+func TestNewVerifierRejectsInvalidPolicies(t *testing.T) {
+	for name, policy := range map[string]*Policy{
+		"no default":       {},
+		"unknown type":     {Default: []Requirement{{Type: "signedByEveryone"}}},
+		"missing key":      {Default: []Requirement{{Type: TypeSigstoreSigned}}},
+		"unknown key type": {Default: []Requirement{{Type: TypeSignedBy, KeyType: "X509Certificates"}}},
+		"unknown identity": {Default: []Requirement{{
+			Type:           TypeInsecureAcceptAnything,
+			SignedIdentity: &SignedIdentity{Type: "matchAnything"},
+		}}},
+	} {
+		_, err := NewVerifier(policy)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:team/app:pull"`)
+	assert.Equal(t, "Bearer", scheme)
+	assert.Equal(t, map[string]string{
+		"realm":   "https://auth.example.com/token",
+		"service": "registry.example.com",
+		"scope":   "repository:team/app:pull",
+	}, params)
+}
@@ -0,0 +1,249 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package imagepolicy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// maxFetchSize bounds the size of the manifests and signature blobs read
+// from registries.
+const maxFetchSize = 4 << 20
+
+// readLimited reads r to EOF, failing if it is larger than maxFetchSize
+// rather than truncating it.
+func readLimited(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxFetchSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxFetchSize {
+		return nil, fmt.Errorf("larger than %d bytes", maxFetchSize)
+	}
+	return data, nil
+}
+
+var manifestMediaTypes = []string{
+	ocispec.MediaTypeImageIndex,
+	ocispec.MediaTypeImageManifest,
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+var errNotFound = errors.New("not found")
+
+// repository is a minimal client of the registry API of a repository.
+type repository struct {
+	httpClient *http.Client
+	host       string
+	path       string
+	creds      Credentials
+	token      string
+}
+
+func newRepository(httpClient *http.Client, image reference.Named, creds Credentials) *repository {
+	host := reference.Domain(image)
+	if host == "docker.io" {
+		host = "registry-1.docker.io"
+	}
+	return &repository{
+		httpClient: httpClient,
+		host:       host,
+		path:       reference.Path(image),
+		creds:      creds,
+		token:      creds.RegistryToken,
+	}
+}
+
+func (r *repository) url(kind, ref string) string {
+	return fmt.Sprintf("https://%s/v2/%s/%s/%s", r.host, r.path, kind, ref)
+}
+
+// resolve returns the manifest digest of the tag.
+func (r *repository) resolve(ctx context.Context, tag string) (digest.Digest, error) {
+	resp, err := r.do(ctx, http.MethodHead, r.url("manifests", tag), manifestMediaTypes)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if dgst, err := digest.Parse(resp.Header.Get("Docker-Content-Digest")); err == nil {
+		return dgst, nil
+	}
+	// Registries may not send the digest, hash the manifest instead.
+	data, err := r.getManifest(ctx, tag)
+	if err != nil {
+		return "", err
+	}
+	return digest.FromBytes(data), nil
+}
+
+// getManifest returns the manifest of the tag or digest, errNotFound if there
+// is none.
+func (r *repository) getManifest(ctx context.Context, ref string) ([]byte, error) {
+	resp, err := r.do(ctx, http.MethodGet, r.url("manifests", ref), manifestMediaTypes)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return readLimited(resp.Body)
+}
+
+// getBlob returns the blob, checking its digest.
+func (r *repository) getBlob(ctx context.Context, dgst digest.Digest) ([]byte, error) {
+	resp, err := r.do(ctx, http.MethodGet, r.url("blobs", dgst.String()), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := readLimited(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if dgst.Validate() != nil || dgst.Algorithm().FromBytes(data) != dgst {
+		return nil, fmt.Errorf("blob %s doesn't match its digest", dgst)
+	}
+	return data, nil
+}
+
+// do sends the request, authenticating when the registry asks to. The
+// response has a successful status.
+func (r *repository) do(ctx context.Context, method, u string, accept []string) (*http.Response, error) {
+	resp, err := r.send(ctx, method, u, accept)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized && r.token == "" {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		if err := r.authenticate(ctx, challenge); err != nil {
+			return nil, err
+		}
+		if resp, err = r.send(ctx, method, u, accept); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		resp.Body.Close()
+		return nil, errNotFound
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: unexpected status %s", method, u, resp.Status)
+	}
+	return resp, nil
+}
+
+func (r *repository) send(ctx context.Context, method, u string, accept []string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, mediaType := range accept {
+		req.Header.Add("Accept", mediaType)
+	}
+	switch {
+	case r.token != "":
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	case r.creds.Username != "":
+		req.SetBasicAuth(r.creds.Username, r.creds.Password)
+	}
+	return r.httpClient.Do(req)
+}
+
+// authenticate gets a bearer token for the challenge of the registry.
+func (r *repository) authenticate(ctx context.Context, challenge string) error {
+	scheme, params := parseChallenge(challenge)
+	if !strings.EqualFold(scheme, "bearer") || params["realm"] == "" {
+		return fmt.Errorf("unsupported authentication challenge %q", challenge)
+	}
+	u, err := url.Parse(params["realm"])
+	if err != nil {
+		return fmt.Errorf("invalid authentication realm %q: %v", params["realm"], err)
+	}
+	q := u.Query()
+	if service := params["service"]; service != "" {
+		q.Set("service", service)
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = fmt.Sprintf("repository:%s:pull", r.path)
+	}
+	q.Set("scope", scope)
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	if r.creds.Username != "" {
+		req.SetBasicAuth(r.creds.Username, r.creds.Password)
+	}
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to get registry token: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to get registry token: unexpected status %s", resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxFetchSize)).Decode(&token); err != nil {
+		return fmt.Errorf("failed to decode registry token: %v", err)
+	}
+	r.token = token.Token
+	if r.token == "" {
+		r.token = token.AccessToken
+	}
+	if r.token == "" {
+		return errors.New("the registry returned an empty token")
+	}
+	return nil
+}
+
+// parseChallenge parses a WWW-Authenticate header such as
+// `Bearer realm="https://auth.example.com/token",service="registry"`.
+func parseChallenge(challenge string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	params := make(map[string]string)
+	for rest != "" {
+		var key, value string
+		key, rest, _ = strings.Cut(strings.TrimLeft(rest, " ,"), "=")
+		if strings.HasPrefix(rest, `"`) {
+			value, rest, _ = strings.Cut(rest[1:], `"`)
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		if key = strings.ToLower(strings.TrimSpace(key)); key != "" {
+			params[key] = value
+		}
+	}
+	return scheme, params
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package imagepolicy
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// cosignSignatureAnnotation is the annotation of the layers of cosign
+// signature manifests holding the signature of the layer, the payload.
+const cosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+
+// cosignSignatureType is the critical type of the payloads of cosign
+// signatures.
+const cosignSignatureType = "cosign container image signature"
+
+// sigstoreRequirement requires a cosign signature made with one of the keys,
+// stored in the registry next to the image.
+type sigstoreRequirement struct {
+	keys     []crypto.PublicKey
+	identity string
+}
+
+func newSigstoreRequirement(keyPaths []string, identity string) (*sigstoreRequirement, error) {
+	if len(keyPaths) == 0 {
+		return nil, errors.New("no public key for sigstoreSigned requirement")
+	}
+	r := &sigstoreRequirement{identity: identity}
+	for _, path := range keyPaths {
+		keys, err := loadPublicKeys(path)
+		if err != nil {
+			return nil, err
+		}
+		r.keys = append(r.keys, keys...)
+	}
+	return r, nil
+}
+
+// loadPublicKeys reads the PEM encoded public keys of the file.
+func loadPublicKeys(path string) ([]crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key %q: %v", path, err)
+	}
+	var keys []crypto.PublicKey
+	for {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			break
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public key %q: %v", path, err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no PEM encoded public key in %q", path)
+	}
+	return keys, nil
+}
+
+func (r *sigstoreRequirement) needsSignature() bool {
+	return true
+}
+
+func (r *sigstoreRequirement) verify(ctx context.Context, repo *repository, image reference.Named, dgst digest.Digest) error {
+	// cosign stores the signatures of sha256:<hex> as tag sha256-<hex>.sig.
+	data, err := repo.getManifest(ctx, strings.Replace(dgst.String(), ":", "-", 1)+".sig")
+	if errors.Is(err, errNotFound) {
+		return errors.New("no sigstore signature")
+	} else if err != nil {
+		return fmt.Errorf("failed to get sigstore signatures: %v", err)
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return fmt.Errorf("invalid sigstore signature manifest: %v", err)
+	}
+
+	var errs []error
+	for _, layer := range manifest.Layers {
+		signature, ok := layer.Annotations[cosignSignatureAnnotation]
+		if !ok {
+			continue
+		}
+		if err := r.verifyLayer(ctx, repo, layer.Digest, signature, image, dgst); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		return nil
+	}
+	if len(errs) == 0 {
+		return errors.New("no sigstore signature")
+	}
+	return fmt.Errorf("no valid sigstore signature: %v", utilerrors.NewAggregate(errs))
+}
+
+func (r *sigstoreRequirement) verifyLayer(
+	ctx context.Context,
+	repo *repository,
+	layer digest.Digest,
+	signature string,
+	image reference.Named,
+	dgst digest.Digest,
+) error {
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %v", err)
+	}
+	payload, err := repo.getBlob(ctx, layer)
+	if err != nil {
+		return fmt.Errorf("failed to get signature payload %s: %v", layer, err)
+	}
+	verified := false
+	for _, key := range r.keys {
+		if verifySignature(key, payload, sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return errors.New("signature not made by any of the trusted keys")
+	}
+	return (&signaturePayload{}).check(payload, cosignSignatureType, r.identity, image, dgst)
+}
+
+// verifySignature returns whether sig is a signature of the SHA-256 digest of
+// payload made with the key.
+func verifySignature(key crypto.PublicKey, payload, sig []byte) bool {
+	hash := sha256.Sum256(payload)
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(k, hash[:], sig)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, hash[:], sig) == nil ||
+			rsa.VerifyPSS(k, crypto.SHA256, hash[:], sig, nil) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, payload, sig)
+	default:
+		return false
+	}
+}
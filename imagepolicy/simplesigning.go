@@ -0,0 +1,176 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package imagepolicy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// maxSignatures bounds the number of simple signing signatures looked up for
+// an image.
+const maxSignatures = 64
+
+// simpleSigningType is the critical type of the payloads of simple signing
+// signatures.
+const simpleSigningType = "atomic container signature"
+
+// signedByRequirement requires a containers/image simple signing signature
+// made with one of the GPG keys, stored in a lookaside signature storage.
+type signedByRequirement struct {
+	keyring   openpgp.EntityList
+	lookaside *url.URL
+	identity  string
+	verifier  *Verifier
+}
+
+func newSignedByRequirement(keyPaths []string, lookaside, identity string, v *Verifier) (*signedByRequirement, error) {
+	if len(keyPaths) == 0 {
+		return nil, errors.New("no GPG key for signedBy requirement")
+	}
+	if lookaside == "" {
+		return nil, errors.New("no lookaside signature storage for signedBy requirement")
+	}
+	u, err := url.Parse(lookaside)
+	if err != nil {
+		return nil, fmt.Errorf("invalid lookaside signature storage %q: %v", lookaside, err)
+	}
+	switch u.Scheme {
+	case "file", "http", "https":
+	default:
+		return nil, fmt.Errorf("unsupported lookaside signature storage %q", lookaside)
+	}
+	r := &signedByRequirement{lookaside: u, identity: identity, verifier: v}
+	for _, path := range keyPaths {
+		keyring, err := loadKeyring(path)
+		if err != nil {
+			return nil, err
+		}
+		r.keyring = append(r.keyring, keyring...)
+	}
+	return r, nil
+}
+
+// loadKeyring reads the GPG keys of the file, armored or not.
+func loadKeyring(path string) (openpgp.EntityList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GPG keys %q: %v", path, err)
+	}
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	if err != nil {
+		keyring, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse GPG keys %q: %v", path, err)
+	}
+	return keyring, nil
+}
+
+func (r *signedByRequirement) needsSignature() bool {
+	return true
+}
+
+func (r *signedByRequirement) verify(ctx context.Context, _ *repository, image reference.Named, dgst digest.Digest) error {
+	var errs []error
+	for i := 1; i <= maxSignatures; i++ {
+		signature, err := r.fetch(ctx, image, dgst, i)
+		if errors.Is(err, errNotFound) {
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to get signature %d: %v", i, err)
+		}
+		if err := r.verifySignature(signature, image, dgst); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		return nil
+	}
+	if len(errs) == 0 {
+		return errors.New("no simple signing signature")
+	}
+	return fmt.Errorf("no valid simple signing signature: %v", utilerrors.NewAggregate(errs))
+}
+
+// fetch returns the i-th signature of the image from the lookaside storage,
+// errNotFound if there is none.
+func (r *signedByRequirement) fetch(ctx context.Context, image reference.Named, dgst digest.Digest, i int) ([]byte, error) {
+	u := *r.lookaside
+	u.Path = path.Join(u.Path, reference.Path(image)+fmt.Sprintf("@%s=%s", dgst.Algorithm(), dgst.Encoded()),
+		fmt.Sprintf("signature-%d", i))
+
+	if u.Scheme == "file" {
+		f, err := os.Open(u.Path)
+		if os.IsNotExist(err) {
+			return nil, errNotFound
+		} else if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return readLimited(f)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.verifier.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, errNotFound
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("GET %s: unexpected status %s", u.String(), resp.Status)
+	}
+	return readLimited(resp.Body)
+}
+
+func (r *signedByRequirement) verifySignature(signature []byte, image reference.Named, dgst digest.Digest) error {
+	md, err := openpgp.ReadMessage(bytes.NewReader(signature), r.keyring, nil, nil)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %v", err)
+	}
+	if !md.IsSigned || md.SignedBy == nil {
+		return errors.New("signature not made by any of the trusted keys")
+	}
+	// The signature is only checked once the body is read to EOF, so a body
+	// over the limit is rejected rather than truncated.
+	payload, err := readLimited(md.UnverifiedBody)
+	if err != nil {
+		return fmt.Errorf("failed to read signature payload: %v", err)
+	}
+	if md.SignatureError != nil {
+		return fmt.Errorf("invalid signature: %v", md.SignatureError)
+	}
+	if md.Signature == nil {
+		return errors.New("invalid signature: no signature packet")
+	}
+	return (&signaturePayload{}).check(payload, simpleSigningType, r.identity, image, dgst)
+}
@@ -17,12 +17,14 @@ limitations under the License.
 package libdocker
 
 import (
+	"context"
 	"os"
 	"time"
 
 	dockertypes "github.com/docker/docker/api/types"
 	dockerbackend "github.com/docker/docker/api/types/backend"
 	dockercontainer "github.com/docker/docker/api/types/container"
+	dockerevents "github.com/docker/docker/api/types/events"
 	dockerimagetypes "github.com/docker/docker/api/types/image"
 	dockerregistry "github.com/docker/docker/api/types/registry"
 	dockersystem "github.com/docker/docker/api/types/system"
@@ -62,6 +64,7 @@ type DockerClientInterface interface {
 	ListImages(opts dockerimagetypes.ListOptions) ([]dockerimagetypes.Summary, error)
 	PullImage(image string, auth dockerregistry.AuthConfig, opts dockerimagetypes.PullOptions) error
 	RemoveImage(imageStr string, opts dockerimagetypes.RemoveOptions) ([]dockerimagetypes.DeleteResponse, error)
+	TagImage(source, target string) error
 	ImageHistory(id string) ([]dockerimagetypes.HistoryResponseItem, error)
 	Logs(string, dockercontainer.LogsOptions, StreamOptions) error
 	Version() (*dockertypes.Version, error)
@@ -73,6 +76,7 @@ type DockerClientInterface interface {
 	ResizeContainerTTY(id string, height, width uint) error
 	ResizeExecTTY(id string, height, width uint) error
 	GetContainerStats(id string) (*dockercontainer.StatsResponse, error)
+	Events(ctx context.Context, opts dockerevents.ListOptions) (<-chan dockerevents.Message, <-chan error)
 }
 
 // Get a *dockerapi.Client, either using the endpoint passed in, or using
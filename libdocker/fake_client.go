@@ -17,6 +17,7 @@ limitations under the License.
 package libdocker
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"hash/fnv"
@@ -31,6 +32,7 @@ import (
 	dockertypes "github.com/docker/docker/api/types"
 	dockerbackend "github.com/docker/docker/api/types/backend"
 	dockercontainer "github.com/docker/docker/api/types/container"
+	dockerevents "github.com/docker/docker/api/types/events"
 	dockerimagetypes "github.com/docker/docker/api/types/image"
 	dockerregistry "github.com/docker/docker/api/types/registry"
 	dockersystem "github.com/docker/docker/api/types/system"
@@ -80,6 +82,14 @@ type FakeDockerClient struct {
 	EnableSleep       bool
 	ImageHistoryMap   map[string][]dockerimagetypes.HistoryResponseItem
 	ContainerStatsMap map[string]*dockercontainer.StatsResponse
+
+	eventSubscribers []fakeEventSubscriber
+}
+
+type fakeEventSubscriber struct {
+	ctx      context.Context
+	messages chan dockerevents.Message
+	errs     chan error
 }
 
 const (
@@ -371,6 +381,14 @@ func (f *FakeDockerClient) ListContainers(
 			match := true
 			for _, labelFilter := range labelFilters {
 				kv := strings.Split(labelFilter, "=")
+				if len(kv) == 1 {
+					// Only the presence of the label is checked.
+					if _, ok := container.Labels[kv[0]]; !ok {
+						match = false
+						break
+					}
+					continue
+				}
 				if len(kv) != 2 {
 					return nil, fmt.Errorf("invalid label filter %q", labelFilter)
 				}
@@ -769,6 +787,17 @@ func (f *FakeDockerClient) RemoveImage(
 	defer f.Unlock()
 	f.appendCalled(CalledDetail{name: "remove_image", arguments: []interface{}{image, opts}})
 	err := f.popError("remove_image")
+	if inspect, ok := f.ImageInspects[image]; err == nil && ok && len(inspect.RepoTags) > 1 {
+		// Removing one of the tags of an image untags it.
+		delete(f.ImageInspects, image)
+		inspect.RepoTags = removeString(inspect.RepoTags, image)
+		for i := range f.Images {
+			if f.Images[i].ID == inspect.ID {
+				f.Images[i].RepoTags = removeString(f.Images[i].RepoTags, image)
+			}
+		}
+		return []dockerimagetypes.DeleteResponse{{Untagged: image}}, nil
+	}
 	if err == nil {
 		for i := range f.Images {
 			if f.Images[i].ID == image {
@@ -780,6 +809,27 @@ func (f *FakeDockerClient) RemoveImage(
 	return []dockerimagetypes.DeleteResponse{{Deleted: image}}, err
 }
 
+func (f *FakeDockerClient) TagImage(source, target string) error {
+	f.Lock()
+	defer f.Unlock()
+	f.appendCalled(CalledDetail{name: "tag_image", arguments: []interface{}{source, target}})
+	if err := f.popError("tag_image"); err != nil {
+		return err
+	}
+	inspect, ok := f.ImageInspects[source]
+	if !ok {
+		return ImageNotFoundError{ID: source}
+	}
+	inspect.RepoTags = append(inspect.RepoTags, target)
+	f.ImageInspects[target] = inspect
+	for i := range f.Images {
+		if f.Images[i].ID == inspect.ID {
+			f.Images[i].RepoTags = append(f.Images[i].RepoTags, target)
+		}
+	}
+	return nil
+}
+
 func (f *FakeDockerClient) InjectImages(images []dockerimagetypes.Summary) {
 	f.Lock()
 	defer f.Unlock()
@@ -927,3 +977,55 @@ func (f *FakeDockerClient) GetContainerStats(id string) (*dockercontainer.StatsR
 	}
 	return stats, nil
 }
+
+// Events is a test-spy implementation of DockerClientInterface.Events.
+// Events are only sent with EmitEvent.
+func (f *FakeDockerClient) Events(
+	ctx context.Context,
+	opts dockerevents.ListOptions,
+) (<-chan dockerevents.Message, <-chan error) {
+	f.Lock()
+	defer f.Unlock()
+	f.appendCalled(CalledDetail{name: "events"})
+	subscriber := fakeEventSubscriber{
+		ctx:      ctx,
+		messages: make(chan dockerevents.Message, 100),
+		errs:     make(chan error, 1),
+	}
+	f.eventSubscribers = append(f.eventSubscribers, subscriber)
+	return subscriber.messages, subscriber.errs
+}
+
+// EmitEvent sends the event to the subscribers of Events.
+func (f *FakeDockerClient) EmitEvent(msg dockerevents.Message) {
+	f.Lock()
+	subscribers := append([]fakeEventSubscriber{}, f.eventSubscribers...)
+	f.Unlock()
+	for _, s := range subscribers {
+		select {
+		case s.messages <- msg:
+		case <-s.ctx.Done():
+		}
+	}
+}
+
+// FailEvents ends the event streams of the subscribers of Events with err.
+func (f *FakeDockerClient) FailEvents(err error) {
+	f.Lock()
+	subscribers := f.eventSubscribers
+	f.eventSubscribers = nil
+	f.Unlock()
+	for _, s := range subscribers {
+		s.errs <- err
+	}
+}
+
+func removeString(values []string, value string) []string {
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		if v != value {
+			result = append(result, v)
+		}
+	}
+	return result
+}
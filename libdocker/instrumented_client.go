@@ -17,22 +17,32 @@ limitations under the License.
 package libdocker
 
 import (
+	"context"
 	"time"
 
 	dockertypes "github.com/docker/docker/api/types"
 	dockerbackend "github.com/docker/docker/api/types/backend"
 	dockercontainer "github.com/docker/docker/api/types/container"
+	dockerevents "github.com/docker/docker/api/types/events"
 	dockerimagetypes "github.com/docker/docker/api/types/image"
 	dockerregistry "github.com/docker/docker/api/types/registry"
 	dockersystem "github.com/docker/docker/api/types/system"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/Mirantis/cri-dockerd/metrics"
 )
 
+// tracerName is the name of the tracer of the docker operations.
+const tracerName = "github.com/Mirantis/cri-dockerd/libdocker"
+
 // instrumentedInterface wraps the DockerClientInterface and records the operations
 // and errors metrics.
 type instrumentedInterface struct {
 	client DockerClientInterface
+	// ctx is the context the operations are traced in, if any.
+	ctx context.Context
 }
 
 // NewInstrumentedInterface creates an instrumented DockerClientInterface from an existing DockerClientInterface.
@@ -42,6 +52,26 @@ func NewInstrumentedInterface(dockerClient DockerClientInterface) DockerClientIn
 	}
 }
 
+// WithContext returns the client with its operations traced as children of
+// the span of ctx, the client itself if it isn't instrumented.
+func WithContext(ctx context.Context, client DockerClientInterface) DockerClientInterface {
+	if in, ok := client.(instrumentedInterface); ok {
+		in.ctx = ctx
+		return in
+	}
+	return client
+}
+
+// startSpan starts the span of the operation, when the client has a context
+// with a span, so that background operations aren't traced.
+func (in instrumentedInterface) startSpan(operation string) trace.Span {
+	if in.ctx == nil || !trace.SpanContextFromContext(in.ctx).IsValid() {
+		return trace.SpanFromContext(context.Background())
+	}
+	_, span := otel.Tracer(tracerName).Start(in.ctx, "docker."+operation, trace.WithSpanKind(trace.SpanKindClient))
+	return span
+}
+
 // recordOperation records the duration of the operation.
 func recordOperation(operation string, start time.Time) {
 	metrics.DockerOperations.WithLabelValues(operation).Inc()
@@ -52,9 +82,11 @@ func recordOperation(operation string, start time.Time) {
 	)
 }
 
-// recordError records error for metric if an error occurred.
-func recordError(operation string, err error) {
+// recordError records error for metric and in the span if an error occurred.
+func recordError(span trace.Span, operation string, err error) {
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		if _, ok := err.(operationTimeout); ok {
 			metrics.DockerOperationsTimeout.WithLabelValues(operation).Inc()
 		}
@@ -68,18 +100,22 @@ func (in instrumentedInterface) ListContainers(
 ) ([]dockertypes.Container, error) {
 	const operation = "list_containers"
 	defer recordOperation(operation, time.Now())
+	span := in.startSpan(operation)
+	defer span.End()
 
 	out, err := in.client.ListContainers(options)
-	recordError(operation, err)
+	recordError(span, operation, err)
 	return out, err
 }
 
 func (in instrumentedInterface) InspectContainer(id string) (*dockertypes.ContainerJSON, error) {
 	const operation = "inspect_container"
 	defer recordOperation(operation, time.Now())
+	span := in.startSpan(operation)
+	defer span.End()
 
 	out, err := in.client.InspectContainer(id)
-	recordError(operation, err)
+	recordError(span, operation, err)
 	return out, err
 }
 
@@ -88,9 +124,11 @@ func (in instrumentedInterface) InspectContainerWithSize(
 ) (*dockertypes.ContainerJSON, error) {
 	const operation = "inspect_container_withsize"
 	defer recordOperation(operation, time.Now())
+	span := in.startSpan(operation)
+	defer span.End()
 
 	out, err := in.client.InspectContainerWithSize(id)
-	recordError(operation, err)
+	recordError(span, operation, err)
 	return out, err
 }
 
@@ -99,27 +137,33 @@ func (in instrumentedInterface) CreateContainer(
 ) (*dockercontainer.CreateResponse, error) {
 	const operation = "create_container"
 	defer recordOperation(operation, time.Now())
+	span := in.startSpan(operation)
+	defer span.End()
 
 	out, err := in.client.CreateContainer(opts)
-	recordError(operation, err)
+	recordError(span, operation, err)
 	return out, err
 }
 
 func (in instrumentedInterface) StartContainer(id string) error {
 	const operation = "start_container"
 	defer recordOperation(operation, time.Now())
+	span := in.startSpan(operation)
+	defer span.End()
 
 	err := in.client.StartContainer(id)
-	recordError(operation, err)
+	recordError(span, operation, err)
 	return err
 }
 
 func (in instrumentedInterface) StopContainer(id string, timeout time.Duration) error {
 	const operation = "stop_container"
 	defer recordOperation(operation, time.Now())
+	span := in.startSpan(operation)
+	defer span.End()
 
 	err := in.client.StopContainer(id, timeout)
-	recordError(operation, err)
+	recordError(span, operation, err)
 	return err
 }
 
@@ -129,9 +173,11 @@ func (in instrumentedInterface) RemoveContainer(
 ) error {
 	const operation = "remove_container"
 	defer recordOperation(operation, time.Now())
+	span := in.startSpan(operation)
+	defer span.End()
 
 	err := in.client.RemoveContainer(id, opts)
-	recordError(operation, err)
+	recordError(span, operation, err)
 	return err
 }
 
@@ -141,27 +187,33 @@ func (in instrumentedInterface) UpdateContainerResources(
 ) error {
 	const operation = "update_container"
 	defer recordOperation(operation, time.Now())
+	span := in.startSpan(operation)
+	defer span.End()
 
 	err := in.client.UpdateContainerResources(id, updateConfig)
-	recordError(operation, err)
+	recordError(span, operation, err)
 	return err
 }
 
 func (in instrumentedInterface) InspectImageByRef(image string) (*dockertypes.ImageInspect, error) {
 	const operation = "inspect_image"
 	defer recordOperation(operation, time.Now())
+	span := in.startSpan(operation)
+	defer span.End()
 
 	out, err := in.client.InspectImageByRef(image)
-	recordError(operation, err)
+	recordError(span, operation, err)
 	return out, err
 }
 
 func (in instrumentedInterface) InspectImageByID(image string) (*dockertypes.ImageInspect, error) {
 	const operation = "inspect_image"
 	defer recordOperation(operation, time.Now())
+	span := in.startSpan(operation)
+	defer span.End()
 
 	out, err := in.client.InspectImageByID(image)
-	recordError(operation, err)
+	recordError(span, operation, err)
 	return out, err
 }
 
@@ -170,9 +222,11 @@ func (in instrumentedInterface) ListImages(
 ) ([]dockerimagetypes.Summary, error) {
 	const operation = "list_images"
 	defer recordOperation(operation, time.Now())
+	span := in.startSpan(operation)
+	defer span.End()
 
 	out, err := in.client.ListImages(opts)
-	recordError(operation, err)
+	recordError(span, operation, err)
 	return out, err
 }
 
@@ -183,8 +237,10 @@ func (in instrumentedInterface) PullImage(
 ) error {
 	const operation = "pull_image"
 	defer recordOperation(operation, time.Now())
+	span := in.startSpan(operation)
+	defer span.End()
 	err := in.client.PullImage(imageID, auth, opts)
-	recordError(operation, err)
+	recordError(span, operation, err)
 	return err
 }
 
@@ -194,12 +250,25 @@ func (in instrumentedInterface) RemoveImage(
 ) ([]dockerimagetypes.DeleteResponse, error) {
 	const operation = "remove_image"
 	defer recordOperation(operation, time.Now())
+	span := in.startSpan(operation)
+	defer span.End()
 
 	imageDelete, err := in.client.RemoveImage(image, opts)
-	recordError(operation, err)
+	recordError(span, operation, err)
 	return imageDelete, err
 }
 
+func (in instrumentedInterface) TagImage(source, target string) error {
+	const operation = "tag_image"
+	defer recordOperation(operation, time.Now())
+	span := in.startSpan(operation)
+	defer span.End()
+
+	err := in.client.TagImage(source, target)
+	recordError(span, operation, err)
+	return err
+}
+
 func (in instrumentedInterface) Logs(
 	id string,
 	opts dockercontainer.LogsOptions,
@@ -207,27 +276,33 @@ func (in instrumentedInterface) Logs(
 ) error {
 	const operation = "logs"
 	defer recordOperation(operation, time.Now())
+	span := in.startSpan(operation)
+	defer span.End()
 
 	err := in.client.Logs(id, opts, sopts)
-	recordError(operation, err)
+	recordError(span, operation, err)
 	return err
 }
 
 func (in instrumentedInterface) Version() (*dockertypes.Version, error) {
 	const operation = "version"
 	defer recordOperation(operation, time.Now())
+	span := in.startSpan(operation)
+	defer span.End()
 
 	out, err := in.client.Version()
-	recordError(operation, err)
+	recordError(span, operation, err)
 	return out, err
 }
 
 func (in instrumentedInterface) Info() (*dockersystem.Info, error) {
 	const operation = "info"
 	defer recordOperation(operation, time.Now())
+	span := in.startSpan(operation)
+	defer span.End()
 
 	out, err := in.client.Info()
-	recordError(operation, err)
+	recordError(span, operation, err)
 	return out, err
 }
 
@@ -237,9 +312,11 @@ func (in instrumentedInterface) CreateExec(
 ) (*dockertypes.IDResponse, error) {
 	const operation = "create_exec"
 	defer recordOperation(operation, time.Now())
+	span := in.startSpan(operation)
+	defer span.End()
 
 	out, err := in.client.CreateExec(id, opts)
-	recordError(operation, err)
+	recordError(span, operation, err)
 	return out, err
 }
 
@@ -250,18 +327,22 @@ func (in instrumentedInterface) StartExec(
 ) error {
 	const operation = "start_exec"
 	defer recordOperation(operation, time.Now())
+	span := in.startSpan(operation)
+	defer span.End()
 
 	err := in.client.StartExec(startExec, opts, sopts)
-	recordError(operation, err)
+	recordError(span, operation, err)
 	return err
 }
 
 func (in instrumentedInterface) InspectExec(id string) (*dockertypes.ContainerExecInspect, error) {
 	const operation = "inspect_exec"
 	defer recordOperation(operation, time.Now())
+	span := in.startSpan(operation)
+	defer span.End()
 
 	out, err := in.client.InspectExec(id)
-	recordError(operation, err)
+	recordError(span, operation, err)
 	return out, err
 }
 
@@ -272,9 +353,11 @@ func (in instrumentedInterface) AttachToContainer(
 ) error {
 	const operation = "attach"
 	defer recordOperation(operation, time.Now())
+	span := in.startSpan(operation)
+	defer span.End()
 
 	err := in.client.AttachToContainer(id, opts, sopts)
-	recordError(operation, err)
+	recordError(span, operation, err)
 	return err
 }
 
@@ -283,35 +366,52 @@ func (in instrumentedInterface) ImageHistory(
 ) ([]dockerimagetypes.HistoryResponseItem, error) {
 	const operation = "image_history"
 	defer recordOperation(operation, time.Now())
+	span := in.startSpan(operation)
+	defer span.End()
 
 	out, err := in.client.ImageHistory(id)
-	recordError(operation, err)
+	recordError(span, operation, err)
 	return out, err
 }
 
 func (in instrumentedInterface) ResizeExecTTY(id string, height, width uint) error {
 	const operation = "resize_exec"
 	defer recordOperation(operation, time.Now())
+	span := in.startSpan(operation)
+	defer span.End()
 
 	err := in.client.ResizeExecTTY(id, height, width)
-	recordError(operation, err)
+	recordError(span, operation, err)
 	return err
 }
 
 func (in instrumentedInterface) ResizeContainerTTY(id string, height, width uint) error {
 	const operation = "resize_container"
 	defer recordOperation(operation, time.Now())
+	span := in.startSpan(operation)
+	defer span.End()
 
 	err := in.client.ResizeContainerTTY(id, height, width)
-	recordError(operation, err)
+	recordError(span, operation, err)
 	return err
 }
 
 func (in instrumentedInterface) GetContainerStats(id string) (*dockercontainer.StatsResponse, error) {
 	const operation = "stats"
 	defer recordOperation(operation, time.Now())
+	span := in.startSpan(operation)
+	defer span.End()
 
 	out, err := in.client.GetContainerStats(id)
-	recordError(operation, err)
+	recordError(span, operation, err)
 	return out, err
 }
+
+func (in instrumentedInterface) Events(
+	ctx context.Context,
+	opts dockerevents.ListOptions,
+) (<-chan dockerevents.Message, <-chan error) {
+	const operation = "events"
+	recordOperation(operation, time.Now())
+	return in.client.Events(ctx, opts)
+}
@@ -32,6 +32,7 @@ import (
 	dockertypes "github.com/docker/docker/api/types"
 	dockerbackend "github.com/docker/docker/api/types/backend"
 	dockercontainer "github.com/docker/docker/api/types/container"
+	dockerevents "github.com/docker/docker/api/types/events"
 	dockerimagetypes "github.com/docker/docker/api/types/image"
 	dockerregistry "github.com/docker/docker/api/types/registry"
 	dockersystem "github.com/docker/docker/api/types/system"
@@ -457,6 +458,19 @@ func (d *kubeDockerClient) RemoveImage(
 	return resp, err
 }
 
+func (d *kubeDockerClient) TagImage(source, target string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	err := d.client.ImageTag(ctx, source, target)
+	if ctxErr := contextError(ctx); ctxErr != nil {
+		return ctxErr
+	}
+	if dockerapi.IsErrNotFound(err) {
+		return ImageNotFoundError{ID: source}
+	}
+	return err
+}
+
 func (d *kubeDockerClient) Logs(
 	id string,
 	opts dockercontainer.LogsOptions,
@@ -641,6 +655,15 @@ func (d *kubeDockerClient) GetContainerStats(id string) (*dockercontainer.StatsR
 	return &stats, nil
 }
 
+// Events streams the docker events matching opts until ctx is cancelled or
+// an error is sent on the returned error channel.
+func (d *kubeDockerClient) Events(
+	ctx context.Context,
+	opts dockerevents.ListOptions,
+) (<-chan dockerevents.Message, <-chan error) {
+	return d.client.Events(ctx, opts)
+}
+
 // redirectResponseToOutputStream redirect the response stream to stdout and stderr. When tty is true, all stream will
 // only be redirected to stdout.
 func (d *kubeDockerClient) redirectResponseToOutputStream(
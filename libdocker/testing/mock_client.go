@@ -5,6 +5,7 @@
 package testing
 
 import (
+	context "context"
 	reflect "reflect"
 	time "time"
 
@@ -12,6 +13,7 @@ import (
 	types "github.com/docker/docker/api/types"
 	backend "github.com/docker/docker/api/types/backend"
 	container "github.com/docker/docker/api/types/container"
+	events "github.com/docker/docker/api/types/events"
 	image "github.com/docker/docker/api/types/image"
 	registry "github.com/docker/docker/api/types/registry"
 	system "github.com/docker/docker/api/types/system"
@@ -85,6 +87,21 @@ func (mr *MockDockerClientInterfaceMockRecorder) CreateExec(arg0, arg1 interface
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateExec", reflect.TypeOf((*MockDockerClientInterface)(nil).CreateExec), arg0, arg1)
 }
 
+// Events mocks base method.
+func (m *MockDockerClientInterface) Events(ctx context.Context, opts events.ListOptions) (<-chan events.Message, <-chan error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Events", ctx, opts)
+	ret0, _ := ret[0].(<-chan events.Message)
+	ret1, _ := ret[1].(<-chan error)
+	return ret0, ret1
+}
+
+// Events indicates an expected call of Events.
+func (mr *MockDockerClientInterfaceMockRecorder) Events(ctx, opts interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Events", reflect.TypeOf((*MockDockerClientInterface)(nil).Events), ctx, opts)
+}
+
 // GetContainerStats mocks base method.
 func (m *MockDockerClientInterface) GetContainerStats(id string) (*container.StatsResponse, error) {
 	m.ctrl.T.Helper()
@@ -292,6 +309,20 @@ func (mr *MockDockerClientInterfaceMockRecorder) RemoveImage(imageStr, opts inte
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveImage", reflect.TypeOf((*MockDockerClientInterface)(nil).RemoveImage), imageStr, opts)
 }
 
+// TagImage mocks base method.
+func (m *MockDockerClientInterface) TagImage(source, target string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TagImage", source, target)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// TagImage indicates an expected call of TagImage.
+func (mr *MockDockerClientInterfaceMockRecorder) TagImage(source, target interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TagImage", reflect.TypeOf((*MockDockerClientInterface)(nil).TagImage), source, target)
+}
+
 // ResizeContainerTTY mocks base method.
 func (m *MockDockerClientInterface) ResizeContainerTTY(id string, height, width uint) error {
 	m.ctrl.T.Helper()
@@ -33,6 +33,29 @@ const (
 	DockerOperationsErrorsKey = "docker_operations_errors_total"
 	// DockerOperationsTimeoutKey is the key for the operation timeout metrics.
 	DockerOperationsTimeoutKey = "docker_operations_timeout_total"
+	// CRIRequestsLatencyKey is the key for the CRI request latency metrics.
+	CRIRequestsLatencyKey = "cri_requests_duration_seconds"
+	// CRIRequestsPanicsKey is the key for the CRI request panic metrics.
+	CRIRequestsPanicsKey = "cri_requests_panics_total"
+	// ContainerIndexLagKey is the key for the container index lag metric.
+	ContainerIndexLagKey = "container_index_lag_seconds"
+	// ImagePullQueueDurationKey is the key for the image pull queue wait
+	// metrics.
+	ImagePullQueueDurationKey = "image_pull_queue_duration_seconds"
+	// ImagePullQueueLengthKey is the key for the image pull queue length
+	// metric.
+	ImagePullQueueLengthKey = "image_pull_queue_length"
+	// ImagePullsMergedKey is the key for the merged image pull metrics.
+	ImagePullsMergedKey = "image_pulls_merged_total"
+	// ContainerPressureKey is the key for the container pressure stall
+	// metrics.
+	ContainerPressureKey = "container_pressure_stalled_seconds_total"
+	// StreamingSessionsKey is the key for the active streaming session
+	// metrics.
+	StreamingSessionsKey = "streaming_sessions"
+	// StreamingSessionsRejectedKey is the key for the rejected streaming
+	// session metrics.
+	StreamingSessionsRejectedKey = "streaming_sessions_rejected_total"
 
 	// Keep the "kubelet" subsystem for backward compatibility.
 	kubeletSubsystem = "kubelet"
@@ -82,6 +105,105 @@ var (
 		},
 		[]string{"operation_type"},
 	)
+	// CRIRequestsLatency collects CRI request latency numbers by method and
+	// gRPC status code.
+	CRIRequestsLatency = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Subsystem:      kubeletSubsystem,
+			Name:           CRIRequestsLatencyKey,
+			Help:           "Latency in seconds of CRI requests. Broken down by method and gRPC status code.",
+			Buckets:        metrics.DefBuckets,
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"method", "code"},
+	)
+	// CRIRequestsPanics collects the panics recovered while handling CRI
+	// requests by method.
+	CRIRequestsPanics = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      kubeletSubsystem,
+			Name:           CRIRequestsPanicsKey,
+			Help:           "Cumulative number of panics recovered while handling CRI requests by method.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"method"},
+	)
+	// ContainerIndexLag reports how far the container index lags behind
+	// dockerd, as the age of the last docker event applied to it.
+	ContainerIndexLag = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Subsystem:      kubeletSubsystem,
+			Name:           ContainerIndexLagKey,
+			Help:           "Age in seconds of the last docker event applied to the container index.",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+	// ImagePullQueueDuration collects the time image pulls wait for a pull
+	// slot by priority.
+	ImagePullQueueDuration = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Subsystem:      kubeletSubsystem,
+			Name:           ImagePullQueueDurationKey,
+			Help:           "Time in seconds image pulls waited in the pull queue. Broken down by priority.",
+			Buckets:        []float64{.01, .1, .5, 1, 5, 10, 30, 60, 120, 300, 600},
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"priority"},
+	)
+	// ImagePullQueueLength reports the number of image pulls waiting for a
+	// pull slot.
+	ImagePullQueueLength = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Subsystem:      kubeletSubsystem,
+			Name:           ImagePullQueueLengthKey,
+			Help:           "Number of image pulls waiting in the pull queue.",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+	// ImagePullsMerged collects the image pull requests merged into a pull of
+	// the same image already queued or in progress.
+	ImagePullsMerged = metrics.NewCounter(
+		&metrics.CounterOpts{
+			Subsystem:      kubeletSubsystem,
+			Name:           ImagePullsMergedKey,
+			Help:           "Cumulative number of image pull requests merged into a pull of the same image.",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+	// StreamingSessions reports the active exec, attach and port forward
+	// sessions by type.
+	StreamingSessions = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Subsystem:      kubeletSubsystem,
+			Name:           StreamingSessionsKey,
+			Help:           "Number of active streaming sessions. Broken down by type.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"type"},
+	)
+	// StreamingSessionsRejected collects the streaming sessions rejected for
+	// going over the session limits by type.
+	StreamingSessionsRejected = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      kubeletSubsystem,
+			Name:           StreamingSessionsRejectedKey,
+			Help:           "Cumulative number of streaming sessions rejected for going over the session limits. Broken down by type.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"type"},
+	)
+)
+
+// ContainerPressure describes the cumulative time in seconds the tasks of
+// the containers stalled on a resource, which a collector of the runtime
+// reports.
+var ContainerPressure = metrics.NewDesc(
+	metrics.BuildFQName("", kubeletSubsystem, ContainerPressureKey),
+	"Cumulative time in seconds some or all of the tasks of a container stalled on a resource. Broken down by container, resource and kind.",
+	[]string{"container_id", "resource", "kind"},
+	nil,
+	metrics.ALPHA,
+	"",
 )
 
 var registerMetrics sync.Once
@@ -93,6 +215,24 @@ func Register() {
 		legacyregistry.MustRegister(DockerOperations)
 		legacyregistry.MustRegister(DockerOperationsErrors)
 		legacyregistry.MustRegister(DockerOperationsTimeout)
+		legacyregistry.MustRegister(CRIRequestsLatency)
+		legacyregistry.MustRegister(CRIRequestsPanics)
+		legacyregistry.MustRegister(ContainerIndexLag)
+		legacyregistry.MustRegister(ImagePullQueueDuration)
+		legacyregistry.MustRegister(ImagePullQueueLength)
+		legacyregistry.MustRegister(ImagePullsMerged)
+		legacyregistry.MustRegister(StreamingSessions)
+		legacyregistry.MustRegister(StreamingSessionsRejected)
+	})
+}
+
+var registerContainerPressure sync.Once
+
+// RegisterContainerPressure registers the collector of the container pressure
+// stall metrics, the first one only.
+func RegisterContainerPressure(collector metrics.StableCollector) {
+	registerContainerPressure.Do(func() {
+		legacyregistry.CustomMustRegister(collector)
 	})
 }
 
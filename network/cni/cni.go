@@ -31,6 +31,8 @@ import (
 	"github.com/Mirantis/cri-dockerd/network/bandwidth"
 	"github.com/containernetworking/cni/libcni"
 	cnitypes "github.com/containernetworking/cni/pkg/types"
+	cnitypes100 "github.com/containernetworking/cni/pkg/types/100"
+	cniversion "github.com/containernetworking/cni/pkg/version"
 	"github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/util/wait"
 	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
@@ -39,6 +41,7 @@ import (
 	utilexec "k8s.io/utils/exec"
 
 	"github.com/Mirantis/cri-dockerd/network"
+	"github.com/Mirantis/cri-dockerd/utils/logging"
 )
 
 const (
@@ -48,6 +51,14 @@ const (
 	// defaultSyncConfigPeriod is the default period to sync CNI config
 	defaultSyncConfigPeriod = time.Second * 5
 
+	// statusTimeout bounds a CNI STATUS invocation, which runs on every
+	// runtime Status call.
+	statusTimeout = time.Second * 10
+
+	// gcAndStatusMinVersion is the first CNI spec version with the GC and
+	// STATUS verbs.
+	gcAndStatusMinVersion = "1.1.0"
+
 	// supported capabilities
 	// https://github.com/containernetworking/cni/blob/master/CONVENTIONS.md
 	portMappingsCapability = "portMappings"
@@ -65,6 +76,9 @@ type cniNetworkPlugin struct {
 
 	sync.RWMutex
 	defaultNetwork *cniNetwork
+	// networks are the networks used as default network since the start, by
+	// name, to tear pods down from the network they were attached to.
+	networks map[string]*cniNetwork
 
 	host        network.Host
 	execer      utilexec.Interface
@@ -142,6 +156,7 @@ func ProbeNetworkPlugins(confDir, cacheDir string, binDirs []string) []network.N
 
 	plugin := &cniNetworkPlugin{
 		defaultNetwork: nil,
+		networks:       make(map[string]*cniNetwork),
 		loNetwork:      getLoNetwork(binDirs),
 		execer:         utilexec.New(),
 		confDir:        confDir,
@@ -154,7 +169,7 @@ func ProbeNetworkPlugins(confDir, cacheDir string, binDirs []string) []network.N
 	return []network.NetworkPlugin{plugin}
 }
 
-func getDefaultCNINetwork(confDir string, binDirs []string) (*cniNetwork, error) {
+func getDefaultCNINetwork(confDir, cacheDir string, binDirs []string) (*cniNetwork, error) {
 	files, err := libcni.ConfFiles(confDir, []string{".conf", ".conflist", ".json"})
 	switch {
 	case err != nil:
@@ -163,7 +178,8 @@ func getDefaultCNINetwork(confDir string, binDirs []string) (*cniNetwork, error)
 		return nil, fmt.Errorf("no networks found in %s", confDir)
 	}
 
-	cniConfig := &libcni.CNIConfig{Path: binDirs}
+	// The cache dir is needed by GC to find the attachments made through this config.
+	cniConfig := libcni.NewCNIConfigWithCacheDir(binDirs, cacheDir, newTracingExec())
 
 	sort.Strings(files)
 	for _, confFile := range files {
@@ -249,7 +265,7 @@ func (plugin *cniNetworkPlugin) Init(
 }
 
 func (plugin *cniNetworkPlugin) syncNetworkConfig() {
-	network, err := getDefaultCNINetwork(plugin.confDir, plugin.binDirs)
+	network, err := getDefaultCNINetwork(plugin.confDir, plugin.cacheDir, plugin.binDirs)
 	if err != nil {
 		logrus.Debugf("Unable to update cni config: %v", err)
 		return
@@ -263,10 +279,72 @@ func (plugin *cniNetworkPlugin) getDefaultNetwork() *cniNetwork {
 	return plugin.defaultNetwork
 }
 
+// DumpState returns the networks pods are attached to and where their
+// configuration and plugins are found.
+func (plugin *cniNetworkPlugin) DumpState() map[string]interface{} {
+	plugin.RLock()
+	defaultNetwork, podCIDR := plugin.defaultNetwork, plugin.podCidr
+	plugin.RUnlock()
+	state := map[string]interface{}{
+		"confDir":  plugin.confDir,
+		"binDirs":  plugin.binDirs,
+		"cacheDir": plugin.cacheDir,
+		"podCIDR":  podCIDR,
+	}
+	if n := defaultNetwork; n != nil {
+		state["defaultNetwork"] = n.dumpState()
+	}
+	if plugin.loNetwork != nil {
+		state["loNetwork"] = plugin.loNetwork.dumpState()
+	}
+	return state
+}
+
+func (n *cniNetwork) dumpState() map[string]interface{} {
+	return map[string]interface{}{
+		"name":         n.name,
+		"capabilities": n.Capabilities,
+		"config":       json.RawMessage(n.NetworkConfig.Bytes),
+	}
+}
+
 func (plugin *cniNetworkPlugin) setDefaultNetwork(n *cniNetwork) {
 	plugin.Lock()
 	defer plugin.Unlock()
 	plugin.defaultNetwork = n
+	if n != nil {
+		if plugin.networks == nil {
+			plugin.networks = make(map[string]*cniNetwork)
+		}
+		plugin.networks[n.name] = n
+	}
+}
+
+// getNetwork returns the named network the pod was attached to: the default
+// network, one used as default network since the start, or, after a restart,
+// the configuration libcni cached when the pod was attached to it.
+func (plugin *cniNetworkPlugin) getNetwork(networkName string, podSandboxID config.ContainerID) (*cniNetwork, error) {
+	plugin.RLock()
+	n := plugin.networks[networkName]
+	plugin.RUnlock()
+	if n != nil {
+		return n, nil
+	}
+
+	cniConfig := libcni.NewCNIConfigWithCacheDir(plugin.binDirs, plugin.cacheDir, newTracingExec())
+	rt := &libcni.RuntimeConf{ContainerID: podSandboxID.ID, IfName: network.DefaultInterfaceName}
+	confBytes, _, err := cniConfig.GetNetworkListCachedConfig(&libcni.NetworkConfigList{Name: networkName}, rt)
+	if err != nil {
+		return nil, err
+	}
+	if confBytes == nil {
+		return nil, fmt.Errorf("no cached configuration of CNI network %q", networkName)
+	}
+	confList, err := libcni.ConfListFromBytes(confBytes)
+	if err != nil {
+		return nil, err
+	}
+	return &cniNetwork{name: confList.Name, NetworkConfig: confList, CNIConfig: cniConfig}, nil
 }
 
 func (plugin *cniNetworkPlugin) checkInitialized() error {
@@ -313,9 +391,76 @@ func (plugin *cniNetworkPlugin) Name() string {
 	return CNIPluginName
 }
 
+// NetworkName returns the name of the default CNI network configuration.
+func (plugin *cniNetworkPlugin) NetworkName() string {
+	if network := plugin.getDefaultNetwork(); network != nil {
+		return network.name
+	}
+	return ""
+}
+
 func (plugin *cniNetworkPlugin) Status() error {
 	// Can't set up pods if we don't have any CNI network configs yet
-	return plugin.checkInitialized()
+	if err := plugin.checkInitialized(); err != nil {
+		return err
+	}
+
+	defaultNetwork := plugin.getDefaultNetwork()
+	if !supportsGCAndStatus(defaultNetwork.NetworkConfig) {
+		return nil
+	}
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), statusTimeout)
+	defer cancelFunc()
+	if err := defaultNetwork.CNIConfig.GetStatusNetworkList(ctx, defaultNetwork.NetworkConfig); err != nil {
+		return fmt.Errorf("CNI network %q is not ready: %v", defaultNetwork.name, err)
+	}
+	return nil
+}
+
+// GC removes the attachments of the default network which don't belong to
+// one of the live sandboxes, and lets the plugins release any other resource
+// they still hold for them.
+func (plugin *cniNetworkPlugin) GC(ctx context.Context, liveSandboxIDs []config.ContainerID) error {
+	log := logging.FromContext(ctx)
+	defaultNetwork := plugin.getDefaultNetwork()
+	if defaultNetwork == nil {
+		return nil
+	}
+	if !supportsGCAndStatus(defaultNetwork.NetworkConfig) {
+		log.Debugf(
+			"Skipping garbage collection of CNI network %q: cniVersion %s does not support GC",
+			defaultNetwork.name,
+			defaultNetwork.NetworkConfig.CNIVersion,
+		)
+		return nil
+	}
+
+	gcArgs := &libcni.GCArgs{
+		ValidAttachments: make([]cnitypes.GCAttachment, 0, len(liveSandboxIDs)),
+	}
+	for _, id := range liveSandboxIDs {
+		gcArgs.ValidAttachments = append(gcArgs.ValidAttachments, cnitypes.GCAttachment{
+			ContainerID: id.ID,
+			IfName:      network.DefaultInterfaceName,
+		})
+	}
+
+	cniTimeoutCtx, cancelFunc := context.WithTimeout(ctx, network.CNITimeoutSec*time.Second)
+	defer cancelFunc()
+	log.Debugf(
+		"Garbage collecting CNI network %q, keeping %d attachments",
+		defaultNetwork.name,
+		len(gcArgs.ValidAttachments),
+	)
+	return defaultNetwork.CNIConfig.GCNetworkList(cniTimeoutCtx, defaultNetwork.NetworkConfig, gcArgs)
+}
+
+// supportsGCAndStatus returns whether the config list version supports the GC
+// and STATUS verbs.
+func supportsGCAndStatus(confList *libcni.NetworkConfigList) bool {
+	ok, err := cniversion.GreaterThanOrEqualTo(confList.CNIVersion, gcAndStatusMinVersion)
+	return err == nil && ok
 }
 
 func (plugin *cniNetworkPlugin) SetUpPod(
@@ -323,6 +468,18 @@ func (plugin *cniNetworkPlugin) SetUpPod(
 	name string,
 	id config.ContainerID,
 	annotations, options map[string]string,
+) error {
+	return plugin.SetUpPodWithContext(context.Background(), namespace, name, id, annotations, options)
+}
+
+// SetUpPodWithContext is SetUpPod in the context of the CRI request, the
+// plugins get the CNI timeout even if it's canceled.
+func (plugin *cniNetworkPlugin) SetUpPodWithContext(
+	ctx context.Context,
+	namespace string,
+	name string,
+	id config.ContainerID,
+	annotations, options map[string]string,
 ) error {
 	if err := plugin.checkInitialized(); err != nil {
 		return err
@@ -332,9 +489,8 @@ func (plugin *cniNetworkPlugin) SetUpPod(
 		return fmt.Errorf("CNI failed to retrieve network namespace path: %v", err)
 	}
 
-	// Todo get the timeout from parent ctx
 	cniTimeoutCtx, cancelFunc := context.WithTimeout(
-		context.Background(),
+		context.WithoutCancel(ctx),
 		network.CNITimeoutSec*time.Second,
 	)
 	defer cancelFunc()
@@ -363,19 +519,56 @@ func (plugin *cniNetworkPlugin) TearDownPod(
 	name string,
 	id config.ContainerID,
 ) error {
-	if err := plugin.checkInitialized(); err != nil {
-		return err
+	return plugin.TearDownPodWithContext(context.Background(), namespace, name, id)
+}
+
+// TearDownPodWithContext is TearDownPod in the context of the CRI request, the
+// plugins get the CNI timeout even if it's canceled.
+func (plugin *cniNetworkPlugin) TearDownPodWithContext(
+	ctx context.Context,
+	namespace string,
+	name string,
+	id config.ContainerID,
+) error {
+	return plugin.TearDownPodFromNetwork(ctx, namespace, name, id, "")
+}
+
+// TearDownPodFromNetwork is TearDownPodWithContext from the named network the
+// pod was attached to, which may not be the default network anymore. The pod
+// is torn down from the default network if networkName is empty or if the
+// configuration of the network is not found.
+func (plugin *cniNetworkPlugin) TearDownPodFromNetwork(
+	ctx context.Context,
+	namespace string,
+	name string,
+	id config.ContainerID,
+	networkName string,
+) error {
+	var podNetwork *cniNetwork
+	if defaultNetwork := plugin.getDefaultNetwork(); networkName != "" &&
+		(defaultNetwork == nil || defaultNetwork.name != networkName) {
+		attached, err := plugin.getNetwork(networkName, id)
+		if err != nil {
+			networkLogger(ctx, namespace, name, id).WithError(err).WithField("network", networkName).
+				Warning("Failed to find the network the pod was attached to, tearing it down from the default one")
+		}
+		podNetwork = attached
+	}
+	if podNetwork == nil {
+		if err := plugin.checkInitialized(); err != nil {
+			return err
+		}
+		podNetwork = plugin.getDefaultNetwork()
 	}
 
 	// Lack of namespace should not be fatal on teardown
 	netnsPath, err := plugin.host.GetNetNS(id.ID)
 	if err != nil {
-		logrus.Debugf("CNI failed to retrieve network namespace path: %v", err)
+		logging.FromContext(ctx).WithError(err).Debug("CNI failed to retrieve network namespace path")
 	}
 
-	// Todo get the timeout from parent ctx
 	cniTimeoutCtx, cancelFunc := context.WithTimeout(
-		context.Background(),
+		context.WithoutCancel(ctx),
 		network.CNITimeoutSec*time.Second,
 	)
 	defer cancelFunc()
@@ -383,13 +576,13 @@ func (plugin *cniNetworkPlugin) TearDownPod(
 	if plugin.loNetwork != nil {
 		// Loopback network deletion failure should not be fatal on teardown
 		if err := plugin.deleteFromNetwork(cniTimeoutCtx, plugin.loNetwork, name, namespace, id, netnsPath, nil); err != nil {
-			logrus.Errorf("CNI failed to delete loopback network: %v", err)
+			logging.FromContext(ctx).WithError(err).Error("CNI failed to delete loopback network")
 		}
 	}
 
 	return plugin.deleteFromNetwork(
 		cniTimeoutCtx,
-		plugin.getDefaultNetwork(),
+		podNetwork,
 		name,
 		namespace,
 		id,
@@ -398,6 +591,40 @@ func (plugin *cniNetworkPlugin) TearDownPod(
 	)
 }
 
+// GetPodInterfaces returns the names of the interfaces in the pod of the
+// result libcni cached when the pod was attached to the named network.
+func (plugin *cniNetworkPlugin) GetPodInterfaces(id config.ContainerID, networkName string) ([]string, error) {
+	podNetwork, err := plugin.getNetwork(networkName, id)
+	if err != nil {
+		return nil, err
+	}
+	rt := &libcni.RuntimeConf{ContainerID: id.ID, IfName: network.DefaultInterfaceName}
+	cached, err := podNetwork.CNIConfig.GetNetworkListCachedResult(podNetwork.NetworkConfig, rt)
+	if err != nil {
+		return nil, err
+	}
+	if cached == nil {
+		return nil, fmt.Errorf("no cached result of CNI network %q", networkName)
+	}
+	result, err := cnitypes100.NewResultFromResult(cached)
+	if err != nil {
+		return nil, err
+	}
+	var interfaces []string
+	for _, intf := range result.Interfaces {
+		// The interfaces outside of the pod, e.g. bridges, have no sandbox.
+		if intf.Sandbox != "" {
+			interfaces = append(interfaces, intf.Name)
+		}
+	}
+	if len(interfaces) == 0 {
+		// Results before CNI 0.3.0 don't list the interfaces, the plugins
+		// created the one they were asked to.
+		interfaces = []string{rt.IfName}
+	}
+	return interfaces, nil
+}
+
 func (plugin *cniNetworkPlugin) addToNetwork(
 	ctx context.Context,
 	network *cniNetwork,
@@ -408,6 +635,7 @@ func (plugin *cniNetworkPlugin) addToNetwork(
 	annotations, options map[string]string,
 ) (cnitypes.Result, error) {
 	rt, err := plugin.buildCNIRuntimeConf(
+		ctx,
 		podName,
 		podNamespace,
 		podSandboxID,
@@ -415,8 +643,9 @@ func (plugin *cniNetworkPlugin) addToNetwork(
 		annotations,
 		options,
 	)
+	log := networkLogger(ctx, podNamespace, podName, podSandboxID)
 	if err != nil {
-		logrus.Errorf("Error adding network when building cni runtime conf: %v", err)
+		log.WithError(err).Error("Error adding network when building cni runtime conf")
 		return nil, err
 	}
 
@@ -424,16 +653,11 @@ func (plugin *cniNetworkPlugin) addToNetwork(
 
 	res, err := cniNet.AddNetworkList(ctx, netConf, rt)
 	if err != nil {
-		logrus.Errorf(
-			"Error adding pod %s/%s to network %s:%s:%s:%s: %v",
-			podNamespace,
-			podName,
-			podSandboxID,
-			podNetnsPath,
-			netConf.Plugins[0].Network.Type,
-			netConf.Name,
-			err,
-		)
+		log.WithError(err).WithFields(logrus.Fields{
+			"netns":   podNetnsPath,
+			"plugin":  netConf.Plugins[0].Network.Type,
+			"network": netConf.Name,
+		}).Error("Error adding pod to network")
 		return nil, err
 	}
 
@@ -450,6 +674,7 @@ func (plugin *cniNetworkPlugin) deleteFromNetwork(
 	annotations map[string]string,
 ) error {
 	rt, err := plugin.buildCNIRuntimeConf(
+		ctx,
 		podName,
 		podNamespace,
 		podSandboxID,
@@ -457,8 +682,9 @@ func (plugin *cniNetworkPlugin) deleteFromNetwork(
 		annotations,
 		nil,
 	)
+	log := networkLogger(ctx, podNamespace, podName, podSandboxID)
 	if err != nil {
-		logrus.Errorf("Error deleting network when building cni runtime conf: %v", err)
+		log.WithError(err).Error("Error deleting network when building cni runtime conf")
 		return err
 	}
 	netConf, cniNet := network.NetworkConfig, network.CNIConfig
@@ -467,22 +693,31 @@ func (plugin *cniNetworkPlugin) deleteFromNetwork(
 	// The pod may not get deleted successfully at the first time.
 	// Ignore "no such file or directory" error in case the network has already been deleted in previous attempts.
 	if err != nil && !strings.Contains(err.Error(), "no such file or directory") {
-		logrus.Errorf(
-			"Error deleting pod %s/%s from network %s:%s:%s:%s: %v",
-			podNamespace,
-			podName,
-			podSandboxID,
-			podNetnsPath,
-			netConf.Plugins[0].Network.Type,
-			netConf.Name,
-			err,
-		)
+		log.WithError(err).WithFields(logrus.Fields{
+			"netns":   podNetnsPath,
+			"plugin":  netConf.Plugins[0].Network.Type,
+			"network": netConf.Name,
+		}).Error("Error deleting pod from network")
 		return err
 	}
 	return nil
 }
 
+// networkLogger returns the logger of the request, with the pod and its
+// sandbox, which background network operations don't otherwise have.
+func networkLogger(
+	ctx context.Context,
+	podNamespace, podName string,
+	podSandboxID config.ContainerID,
+) *logrus.Entry {
+	return logging.FromContext(ctx).WithFields(logrus.Fields{
+		logging.PodKey:          podNamespace + "/" + podName,
+		logging.PodSandboxIDKey: podSandboxID.ID,
+	})
+}
+
 func (plugin *cniNetworkPlugin) buildCNIRuntimeConf(
+	ctx context.Context,
 	podName string,
 	podNs string,
 	podSandboxID config.ContainerID,
@@ -504,7 +739,7 @@ func (plugin *cniNetworkPlugin) buildCNIRuntimeConf(
 
 	// port mappings are a cni capability-based args, rather than parameters
 	// to a specific plugin
-	portMappings, err := plugin.host.GetPodPortMappings(podSandboxID.ID)
+	portMappings, err := plugin.host.GetPodPortMappings(ctx, podSandboxID.ID)
 	if err != nil {
 		return nil, fmt.Errorf("could not retrieve port mappings: %v", err)
 	}
@@ -46,7 +46,7 @@ func getLoNetwork(binDirs []string) *cniNetwork {
 	loNetwork := &cniNetwork{
 		name:          "lo",
 		NetworkConfig: loConfig,
-		CNIConfig:     &libcni.CNIConfig{Path: binDirs},
+		CNIConfig:     libcni.NewCNIConfig(binDirs, newTracingExec()),
 	}
 
 	return loNetwork
@@ -21,12 +21,14 @@ package cni
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"math/rand"
 	"net"
 	"os"
 	"path"
+	"path/filepath"
 	"reflect"
 	"testing"
 	"text/template"
@@ -35,7 +37,10 @@ import (
 
 	"github.com/Mirantis/cri-dockerd/config"
 
+	"github.com/containernetworking/cni/libcni"
+	cnitypes "github.com/containernetworking/cni/pkg/types"
 	types020 "github.com/containernetworking/cni/pkg/types/020"
+	types100 "github.com/containernetworking/cni/pkg/types/100"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 	v1 "k8s.io/api/core/v1"
@@ -430,3 +435,146 @@ func TestLoNetNonNil(t *testing.T) {
 		t.Error("Expected non-nil lo network")
 	}
 }
+
+func TestCNIPluginGCAndStatus(t *testing.T) {
+	for _, tc := range []struct {
+		cniVersion string
+		supported  bool
+	}{
+		{cniVersion: "0.4.0", supported: false},
+		{cniVersion: "1.0.0", supported: false},
+		{cniVersion: "1.1.0", supported: true},
+	} {
+		t.Run(tc.cniVersion, func(t *testing.T) {
+			confList, err := libcni.ConfListFromBytes([]byte(fmt.Sprintf(`{
+  "cniVersion": "%s",
+  "name": "test-net",
+  "plugins": [{"type": "bridge"}]
+}`, tc.cniVersion)))
+			require.NoError(t, err)
+
+			mockCNI := &mock_cni.MockCNI{}
+			plugin := &cniNetworkPlugin{
+				defaultNetwork: &cniNetwork{
+					name:          confList.Name,
+					NetworkConfig: confList,
+					CNIConfig:     mockCNI,
+				},
+			}
+
+			live := []config.ContainerID{{Type: "docker", ID: "live"}}
+			if tc.supported {
+				mockCNI.On(
+					"GetStatusNetworkList",
+					mock.Anything,
+					confList,
+				).Return(fmt.Errorf("plugin not ready")).Once()
+				mockCNI.On(
+					"GCNetworkList",
+					mock.Anything,
+					confList,
+					&libcni.GCArgs{ValidAttachments: []cnitypes.GCAttachment{
+						{ContainerID: "live", IfName: network.DefaultInterfaceName},
+					}},
+				).Return(nil).Once()
+			}
+
+			err = plugin.Status()
+			if tc.supported {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			require.NoError(t, plugin.GC(context.Background(), live))
+			mockCNI.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCNIPluginTearDownFromAttachedNetwork(t *testing.T) {
+	confList := func(name string) *libcni.NetworkConfigList {
+		list, err := libcni.ConfListFromBytes([]byte(fmt.Sprintf(`{
+  "cniVersion": "1.0.0",
+  "name": "%s",
+  "plugins": [{"type": "bridge"}]
+}`, name)))
+		require.NoError(t, err)
+		return list
+	}
+	oldConf, newConf := confList("old-net"), confList("new-net")
+	oldCNI, newCNI := &mock_cni.MockCNI{}, &mock_cni.MockCNI{}
+	cacheDir := t.TempDir()
+	plugin := &cniNetworkPlugin{
+		host:     NewFakeHost(nil, nil, nil),
+		cacheDir: cacheDir,
+	}
+	plugin.setDefaultNetwork(&cniNetwork{name: "old-net", NetworkConfig: oldConf, CNIConfig: oldCNI})
+	plugin.setDefaultNetwork(&cniNetwork{name: "new-net", NetworkConfig: newConf, CNIConfig: newCNI})
+	id := config.ContainerID{Type: "docker", ID: "sandbox"}
+
+	// The pod is torn down from the network it was attached to.
+	oldCNI.On("DelNetworkList", mock.Anything, oldConf, mock.Anything).Return(nil).Once()
+	require.NoError(t, plugin.TearDownPodFromNetwork(context.Background(), "ns", "pod", id, "old-net"))
+	// And from the default network if that network is unknown.
+	newCNI.On("DelNetworkList", mock.Anything, newConf, mock.Anything).Return(nil).Twice()
+	require.NoError(t, plugin.TearDownPodFromNetwork(context.Background(), "ns", "pod", id, "gone-net"))
+	require.NoError(t, plugin.TearDownPodFromNetwork(context.Background(), "ns", "pod", id, ""))
+	oldCNI.AssertExpectations(t)
+	newCNI.AssertExpectations(t)
+
+	// After a restart, the network is found in the CNI cache.
+	cached, err := json.Marshal(map[string]interface{}{
+		"kind":        libcni.CNICacheV1,
+		"containerId": id.ID,
+		"config":      confList("cached-net").Bytes,
+		"ifName":      network.DefaultInterfaceName,
+		"networkName": "cached-net",
+	})
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Join(cacheDir, "results"), 0o700))
+	require.NoError(t, os.WriteFile(
+		filepath.Join(cacheDir, "results", "cached-net-sandbox-"+network.DefaultInterfaceName),
+		cached,
+		0o600,
+	))
+	attached, err := plugin.getNetwork("cached-net", id)
+	require.NoError(t, err)
+	require.Equal(t, "cached-net", attached.name)
+	require.Equal(t, "bridge", attached.NetworkConfig.Plugins[0].Network.Type)
+	_, err = plugin.getNetwork("gone-net", id)
+	require.Error(t, err)
+}
+
+func TestCNIPluginGetPodInterfaces(t *testing.T) {
+	confList, err := libcni.ConfListFromBytes([]byte(`{
+  "cniVersion": "1.0.0",
+  "name": "test-net",
+  "plugins": [{"type": "bridge"}]
+}`))
+	require.NoError(t, err)
+	mockCNI := &mock_cni.MockCNI{}
+	plugin := &cniNetworkPlugin{}
+	plugin.setDefaultNetwork(&cniNetwork{name: "test-net", NetworkConfig: confList, CNIConfig: mockCNI})
+	id := config.ContainerID{Type: "docker", ID: "sandbox"}
+
+	mockCNI.On("GetNetworkListCachedResult", confList, mock.Anything).Return(&types100.Result{
+		CNIVersion: "1.0.0",
+		Interfaces: []*types100.Interface{
+			{Name: "cni0"},
+			{Name: "eth0", Sandbox: "/var/run/netns/pod"},
+			{Name: "net1", Sandbox: "/var/run/netns/pod"},
+		},
+	}, nil).Once()
+	interfaces, err := plugin.GetPodInterfaces(id, "test-net")
+	require.NoError(t, err)
+	require.Equal(t, []string{"eth0", "net1"}, interfaces)
+
+	// Results without interfaces report the one the plugins were asked for.
+	mockCNI.On("GetNetworkListCachedResult", confList, mock.Anything).Return(&types100.Result{
+		CNIVersion: "1.0.0",
+	}, nil).Once()
+	interfaces, err = plugin.GetPodInterfaces(id, "test-net")
+	require.NoError(t, err)
+	require.Equal(t, []string{network.DefaultInterfaceName}, interfaces)
+	mockCNI.AssertExpectations(t)
+}
@@ -23,6 +23,7 @@ import (
 
 	"github.com/containernetworking/cni/libcni"
 	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/version"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -131,3 +132,35 @@ func (m *MockCNI) ValidateNetwork(
 	args := m.Called(ctx, net)
 	return args.Get(0).([]string), args.Error(0)
 }
+
+func (m *MockCNI) GCNetworkList(
+	ctx context.Context,
+	net *libcni.NetworkConfigList,
+	gcArgs *libcni.GCArgs,
+) error {
+	args := m.Called(ctx, net, gcArgs)
+	return args.Error(0)
+}
+
+func (m *MockCNI) GetStatusNetworkList(
+	ctx context.Context,
+	net *libcni.NetworkConfigList,
+) error {
+	args := m.Called(ctx, net)
+	return args.Error(0)
+}
+
+func (m *MockCNI) GetCachedAttachments(
+	containerID string,
+) ([]*libcni.NetworkAttachment, error) {
+	args := m.Called(containerID)
+	return args.Get(0).([]*libcni.NetworkAttachment), args.Error(1)
+}
+
+func (m *MockCNI) GetVersionInfo(
+	ctx context.Context,
+	pluginType string,
+) (version.PluginInfo, error) {
+	args := m.Called(ctx, pluginType)
+	return args.Get(0).(version.PluginInfo), args.Error(1)
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cni
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/containernetworking/cni/pkg/version"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the name of the tracer of the CNI plugin invocations.
+const tracerName = "github.com/Mirantis/cri-dockerd/network/cni"
+
+// tracingExec executes CNI plugins like libcni does by default, in a span
+// when the invocation is part of a traced request.
+type tracingExec struct {
+	*invoke.RawExec
+	version.PluginDecoder
+}
+
+func newTracingExec() invoke.Exec {
+	return &tracingExec{RawExec: &invoke.RawExec{Stderr: os.Stderr}}
+}
+
+func (e *tracingExec) ExecPlugin(
+	ctx context.Context,
+	pluginPath string,
+	stdinData []byte,
+	environ []string,
+) ([]byte, error) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return e.RawExec.ExecPlugin(ctx, pluginPath, stdinData, environ)
+	}
+	plugin := filepath.Base(pluginPath)
+	attributes := []attribute.KeyValue{attribute.String("cni.plugin", plugin)}
+	for _, env := range environ {
+		if command, ok := strings.CutPrefix(env, "CNI_COMMAND="); ok {
+			attributes = append(attributes, attribute.String("cni.command", command))
+		}
+	}
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "cni."+plugin, trace.WithAttributes(attributes...))
+	defer span.End()
+	out, err := e.RawExec.ExecPlugin(ctx, pluginPath, stdinData, environ)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return out, err
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package hostport
 
 import (
+	"bytes"
 	"fmt"
 	"net"
 	"strconv"
@@ -38,6 +39,34 @@ const (
 	kubeMarkMasqChain string = "KUBE-MARK-MASQ"
 )
 
+// hostportChainPrefixes are the prefixes of the nat chains implementing
+// hostports, ours and the ones of the CNI portmap plugin.
+var hostportChainPrefixes = []string{
+	string(kubeHostportsChain),
+	kubeHostportChainPrefix,
+	"CNI-HOSTPORT-",
+	"CNI-DN-",
+}
+
+// DumpChains returns the iptables-save lines of the nat table declaring,
+// filling or jumping to the hostport chains.
+func DumpChains(iptables utiliptables.Interface) ([]string, error) {
+	var data bytes.Buffer
+	if err := iptables.SaveInto(utiliptables.TableNAT, &data); err != nil {
+		return nil, fmt.Errorf("failed to save the nat table: %v", err)
+	}
+	var lines []string
+	for _, line := range strings.Split(data.String(), "\n") {
+		for _, prefix := range hostportChainPrefixes {
+			if strings.Contains(line, prefix) {
+				lines = append(lines, line)
+				break
+			}
+		}
+	}
+	return lines, nil
+}
+
 // PortMapping represents a network port in a container
 type PortMapping struct {
 	HostPort      int32
@@ -85,3 +85,23 @@ func TestEnsureKubeHostportChains(t *testing.T) {
 		assert.Contains(t, chain.rules[0], jumpRule)
 	}
 }
+
+func TestDumpChains(t *testing.T) {
+	iptables := NewFakeIPTables()
+	_, err := iptables.EnsureChain(utiliptables.TableNAT, "KUBE-HP-ABC")
+	assert.NoError(t, err)
+	_, err = iptables.EnsureRule(utiliptables.Append, utiliptables.TableNAT, "KUBE-HP-ABC", "-j", "DNAT")
+	assert.NoError(t, err)
+	_, err = iptables.EnsureChain(utiliptables.TableNAT, "KUBE-SERVICES")
+	assert.NoError(t, err)
+	_, err = iptables.EnsureRule(utiliptables.Append, utiliptables.TableNAT, utiliptables.ChainPrerouting, "-j", "KUBE-HOSTPORTS")
+	assert.NoError(t, err)
+
+	lines, err := DumpChains(iptables)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		":KUBE-HP-ABC - [0:0]",
+		"-A KUBE-HP-ABC -j DNAT",
+		"-A PREROUTING -j KUBE-HOSTPORTS",
+	}, lines)
+}
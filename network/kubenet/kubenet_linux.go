@@ -480,7 +480,7 @@ func (plugin *kubenetNetworkPlugin) addPortMapping(
 	id config.ContainerID,
 	name, namespace string,
 ) error {
-	portMappings, err := plugin.host.GetPodPortMappings(id.ID)
+	portMappings, err := plugin.host.GetPodPortMappings(context.Background(), id.ID)
 	if err != nil {
 		return err
 	}
@@ -581,7 +581,7 @@ func (plugin *kubenetNetworkPlugin) teardown(
 	}
 
 	// get the list of port mappings
-	portMappings, err := plugin.host.GetPodPortMappings(id.ID)
+	portMappings, err := plugin.host.GetPodPortMappings(context.Background(), id.ID)
 	if err != nil {
 		errList = append(errList, err)
 	}
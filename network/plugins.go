@@ -17,6 +17,7 @@ limitations under the License.
 package network
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"strings"
@@ -29,12 +30,14 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	utilsets "k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
 	utilsysctl "k8s.io/component-helpers/node/util/sysctl"
 	utilexec "k8s.io/utils/exec"
 
 	"github.com/Mirantis/cri-dockerd/network/hostport"
 	"github.com/Mirantis/cri-dockerd/network/metrics"
 	utilerrors "github.com/Mirantis/cri-dockerd/utils/errors"
+	"github.com/Mirantis/cri-dockerd/utils/logging"
 )
 
 const (
@@ -87,6 +90,68 @@ type NetworkPlugin interface {
 	Status() error
 }
 
+// ContextNetworkPlugin is implemented by network plugins which set up and tear
+// down pods in the context of the CRI request, to trace them.
+type ContextNetworkPlugin interface {
+	SetUpPodWithContext(
+		ctx context.Context,
+		namespace string,
+		name string,
+		podSandboxID config.ContainerID,
+		annotations, options map[string]string,
+	) error
+	TearDownPodWithContext(
+		ctx context.Context,
+		namespace string,
+		name string,
+		podSandboxID config.ContainerID,
+	) error
+}
+
+// GarbageCollector is implemented by network plugins which are able to release
+// resources (IPAM leases, cache entries, ...) left behind by sandboxes that
+// were removed without a successful teardown.
+type GarbageCollector interface {
+	// GC releases all resources held by the plugin, except the ones belonging
+	// to the given live sandboxes.
+	GC(ctx context.Context, liveSandboxIDs []config.ContainerID) error
+}
+
+// NetworkNamer is implemented by network plugins attaching pods to a named
+// network, e.g. the name of a CNI network configuration.
+type NetworkNamer interface {
+	// NetworkName returns the name of the network new pods are attached to.
+	NetworkName() string
+}
+
+// NamedNetworkTearDowner is implemented by network plugins which can tear a
+// pod down from the named network it was attached to, after new pods started
+// to be attached to another one.
+type NamedNetworkTearDowner interface {
+	TearDownPodFromNetwork(
+		ctx context.Context,
+		namespace string,
+		name string,
+		podSandboxID config.ContainerID,
+		networkName string,
+	) error
+}
+
+// PodInterfacesGetter is implemented by network plugins which know the
+// interfaces they created in the pods.
+type PodInterfacesGetter interface {
+	// GetPodInterfaces returns the names of the interfaces created in the
+	// pod when it was attached to the named network.
+	GetPodInterfaces(podSandboxID config.ContainerID, networkName string) ([]string, error)
+}
+
+// StateDumper is implemented by network plugins which expose their internal
+// state for debugging.
+type StateDumper interface {
+	// DumpState returns the state of the plugin, encodable as JSON.
+	DumpState() map[string]interface{}
+}
+
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/cmd/runtime.Object
 
 // PodNetworkStatus stores the network status of a pod (currently just the primary IP address)
@@ -131,7 +196,15 @@ type NamespaceGetter interface {
 // CNI plugin wrappers like kubenet.
 type PortMappingGetter interface {
 	// GetPodPortMappings returns sandbox port mappings information.
-	GetPodPortMappings(containerID string) ([]*hostport.PortMapping, error)
+	GetPodPortMappings(ctx context.Context, containerID string) ([]*hostport.PortMapping, error)
+}
+
+// SandboxLister is an interface to retrieve the IDs of every sandbox known to
+// the runtime, including the ones which only exist as a checkpoint.
+type SandboxLister interface {
+	// ListSandboxIDs returns the IDs of all known sandboxes. Implementations
+	// must return an error rather than a partial list.
+	ListSandboxIDs() ([]config.ContainerID, error)
 }
 
 // InitNetworkPlugin inits the plugin that matches networkPluginName. Plugins must have unique names.
@@ -355,6 +428,7 @@ func GetPodIPs(
 type NoopPortMappingGetter struct{}
 
 func (*NoopPortMappingGetter) GetPodPortMappings(
+	_ context.Context,
 	containerID string,
 ) ([]*hostport.PortMapping, error) {
 	return nil, nil
@@ -371,6 +445,19 @@ type PluginManager struct {
 	// Pod list and lock
 	podsLock sync.Mutex
 	pods     map[string]*podLock
+
+	// gcLock is held for writing while garbage collection computes the set of
+	// live sandboxes, and for reading while pods are set up, so that the set
+	// includes the pods being set up.
+	gcLock sync.RWMutex
+	// gcStateLock protects gcLive and gcDone.
+	gcStateLock sync.Mutex
+	// gcLive is the set of live sandboxes of the running garbage collection,
+	// nil if none is running. The pods of other sandboxes are only set up
+	// once it is done, so that their attachments are never collected.
+	gcLive map[string]bool
+	// gcDone is closed once the running garbage collection is done.
+	gcDone chan struct{}
 }
 
 func NewPluginManager(plugin NetworkPlugin) *PluginManager {
@@ -393,6 +480,125 @@ func (pm *PluginManager) Status() error {
 	return pm.plugin.Status()
 }
 
+// NetworkName returns the name of the network new pods are attached to, or an
+// empty string if the wrapped plugin does not name its network.
+func (pm *PluginManager) NetworkName() string {
+	if namer, ok := pm.plugin.(NetworkNamer); ok {
+		return namer.NetworkName()
+	}
+	return ""
+}
+
+// GetPodInterfaces returns the names of the interfaces created in the pod when
+// it was attached to the named network, or the default interface if the
+// wrapped plugin does not report them.
+func (pm *PluginManager) GetPodInterfaces(id config.ContainerID, networkName string) ([]string, error) {
+	if getter, ok := pm.plugin.(PodInterfacesGetter); ok {
+		return getter.GetPodInterfaces(id, networkName)
+	}
+	return []string{DefaultInterfaceName}, nil
+}
+
+// DumpState returns the name of the wrapped plugin, and its state if it
+// exposes it.
+func (pm *PluginManager) DumpState() map[string]interface{} {
+	state := map[string]interface{}{"plugin": pm.plugin.Name()}
+	if dumper, ok := pm.plugin.(StateDumper); ok {
+		state["state"] = dumper.DumpState()
+	}
+	return state
+}
+
+// GC releases the network resources of every sandbox not returned by lister,
+// if the wrapped plugin supports garbage collection.
+func (pm *PluginManager) GC(ctx context.Context, lister SandboxLister) error {
+	gc, ok := pm.plugin.(GarbageCollector)
+	if !ok {
+		return nil
+	}
+
+	const operation = "gc"
+	defer recordOperation(operation, time.Now())
+	live, err := pm.startGC(lister)
+	if err != nil {
+		recordError(operation)
+		return fmt.Errorf("failed to list live sandboxes: %v", err)
+	}
+	defer pm.finishGC()
+
+	if err := gc.GC(ctx, live); err != nil {
+		recordError(operation)
+		return fmt.Errorf(
+			"networkPlugin %s failed to garbage collect: %v",
+			pm.plugin.Name(),
+			err,
+		)
+	}
+
+	return nil
+}
+
+// startGC lists the live sandboxes, and records them as the ones whose pods
+// may be set up until finishGC is called.
+func (pm *PluginManager) startGC(lister SandboxLister) ([]config.ContainerID, error) {
+	pm.gcLock.Lock()
+	defer pm.gcLock.Unlock()
+	live, err := lister.ListSandboxIDs()
+	if err != nil {
+		return nil, err
+	}
+	pm.gcStateLock.Lock()
+	defer pm.gcStateLock.Unlock()
+	pm.gcLive = make(map[string]bool, len(live))
+	for _, id := range live {
+		pm.gcLive[id.ID] = true
+	}
+	pm.gcDone = make(chan struct{})
+	return live, nil
+}
+
+func (pm *PluginManager) finishGC() {
+	pm.gcStateLock.Lock()
+	defer pm.gcStateLock.Unlock()
+	close(pm.gcDone)
+	pm.gcLive = nil
+	pm.gcDone = nil
+}
+
+// waitForGC returns once the pod of the sandbox can be set up: when no garbage
+// collection is running, or when the sandbox is live for the running one. It
+// must be called with gcLock held for reading, and returns with it held.
+func (pm *PluginManager) waitForGC(id config.ContainerID) {
+	for {
+		pm.gcStateLock.Lock()
+		live, done := pm.gcLive, pm.gcDone
+		pm.gcStateLock.Unlock()
+		if live == nil || live[id.ID] {
+			return
+		}
+		pm.gcLock.RUnlock()
+		<-done
+		pm.gcLock.RLock()
+	}
+}
+
+// StartGC runs GC every period until the process exits. A zero period
+// disables garbage collection.
+func (pm *PluginManager) StartGC(lister SandboxLister, period time.Duration) {
+	if period <= 0 {
+		return
+	}
+	if _, ok := pm.plugin.(GarbageCollector); !ok {
+		logrus.Debugf("Network plugin %s does not support garbage collection", pm.plugin.Name())
+		return
+	}
+	go wait.Forever(func() {
+		if err := pm.GC(context.Background(), lister); err != nil {
+			logrus.Errorf("Network garbage collection failed: %v", err)
+		}
+	}, period)
+}
+
 type podLock struct {
 	// Count of in-flight operations for this pod; when this reaches zero
 	// the lock can be removed from the pod map
@@ -421,18 +627,18 @@ func (pm *PluginManager) podLock(fullPodName string) *sync.Mutex {
 // Unlock network operations for a specific pod.  The reference count for the
 // pod will be decreased.  If the reference count reaches zero, the pod will be
 // removed from the pod map.
-func (pm *PluginManager) podUnlock(fullPodName string) {
+func (pm *PluginManager) podUnlock(ctx context.Context, fullPodName string) {
 	pm.podsLock.Lock()
 	defer pm.podsLock.Unlock()
 
 	lock, ok := pm.pods[fullPodName]
 	if !ok {
-		logrus.Debugf("Unbalanced pod lock unref for pod %s", fullPodName)
+		logging.FromContext(ctx).Debugf("Unbalanced pod lock unref for pod %s", fullPodName)
 		return
 	} else if lock.refcount == 0 {
 		// This should never ever happen, but handle it anyway
 		delete(pm.pods, fullPodName)
-		logrus.Debugf("Pod lock for the pod still in map with zero refcount: %s", fullPodName)
+		logging.FromContext(ctx).Debugf("Pod lock for the pod still in map with zero refcount: %s", fullPodName)
 		return
 	}
 	lock.refcount--
@@ -458,6 +664,7 @@ func recordError(operation string) {
 }
 
 func (pm *PluginManager) GetPodNetworkStatus(
+	ctx context.Context,
 	podNamespace, podName string,
 	id config.ContainerID,
 ) (*PodNetworkStatus, error) {
@@ -465,7 +672,7 @@ func (pm *PluginManager) GetPodNetworkStatus(
 	defer recordOperation(operation, time.Now())
 	fullPodName := buildPodFullName(podName, podNamespace)
 	pm.podLock(fullPodName).Lock()
-	defer pm.podUnlock(fullPodName)
+	defer pm.podUnlock(ctx, fullPodName)
 
 	netStatus, err := pm.plugin.GetPodNetworkStatus(podNamespace, podName, id)
 	if err != nil {
@@ -482,17 +689,27 @@ func (pm *PluginManager) GetPodNetworkStatus(
 }
 
 func (pm *PluginManager) SetUpPod(
+	ctx context.Context,
 	podNamespace, podName string,
 	id config.ContainerID,
 	annotations, options map[string]string,
 ) error {
 	const operation = "set_up_pod"
 	defer recordOperation(operation, time.Now())
+	pm.gcLock.RLock()
+	defer pm.gcLock.RUnlock()
+	pm.waitForGC(id)
 	fullPodName := buildPodFullName(podName, podNamespace)
 	pm.podLock(fullPodName).Lock()
-	defer pm.podUnlock(fullPodName)
+	defer pm.podUnlock(ctx, fullPodName)
 
-	if err := pm.plugin.SetUpPod(podNamespace, podName, id, annotations, options); err != nil {
+	setUpPod := pm.plugin.SetUpPod
+	if plugin, ok := pm.plugin.(ContextNetworkPlugin); ok {
+		setUpPod = func(namespace, name string, id config.ContainerID, annotations, options map[string]string) error {
+			return plugin.SetUpPodWithContext(ctx, namespace, name, id, annotations, options)
+		}
+	}
+	if err := setUpPod(podNamespace, podName, id, annotations, options); err != nil {
 		recordError(operation)
 		return fmt.Errorf(
 			"networkPlugin %s failed to set up pod %q network: %v",
@@ -506,16 +723,40 @@ func (pm *PluginManager) SetUpPod(
 }
 
 func (pm *PluginManager) TearDownPod(
+	ctx context.Context,
+	podNamespace, podName string,
+	id config.ContainerID,
+) error {
+	return pm.TearDownPodFromNetwork(ctx, podNamespace, podName, id, "")
+}
+
+// TearDownPodFromNetwork tears the pod down from the named network it was
+// attached to, if the wrapped plugin supports it, and from the network new
+// pods are attached to otherwise or if networkName is empty.
+func (pm *PluginManager) TearDownPodFromNetwork(
+	ctx context.Context,
 	podNamespace, podName string,
 	id config.ContainerID,
+	networkName string,
 ) error {
 	const operation = "tear_down_pod"
 	defer recordOperation(operation, time.Now())
 	fullPodName := buildPodFullName(podName, podNamespace)
 	pm.podLock(fullPodName).Lock()
-	defer pm.podUnlock(fullPodName)
+	defer pm.podUnlock(ctx, fullPodName)
 
-	if err := pm.plugin.TearDownPod(podNamespace, podName, id); err != nil {
+	tearDownPod := pm.plugin.TearDownPod
+	if plugin, ok := pm.plugin.(ContextNetworkPlugin); ok {
+		tearDownPod = func(namespace, name string, id config.ContainerID) error {
+			return plugin.TearDownPodWithContext(ctx, namespace, name, id)
+		}
+	}
+	if plugin, ok := pm.plugin.(NamedNetworkTearDowner); ok && networkName != "" {
+		tearDownPod = func(namespace, name string, id config.ContainerID) error {
+			return plugin.TearDownPodFromNetwork(ctx, namespace, name, id, networkName)
+		}
+	}
+	if err := tearDownPod(podNamespace, podName, id); err != nil {
 		recordError(operation)
 		return fmt.Errorf(
 			"networkPlugin %s failed to teardown pod %q network: %v",
@@ -17,6 +17,9 @@ limitations under the License.
 package network
 
 import (
+	"context"
+	"fmt"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -24,6 +27,7 @@ import (
 	"k8s.io/component-base/metrics/legacyregistry"
 	"k8s.io/component-base/metrics/testutil"
 
+	"github.com/Mirantis/cri-dockerd/config"
 	"github.com/Mirantis/cri-dockerd/network/metrics"
 )
 
@@ -64,3 +68,136 @@ kubelet_network_plugin_operations_errors_total{operation_type="test_operation"}
 		})
 	}
 }
+
+type fakeGCPlugin struct {
+	NoopNetworkPlugin
+	live []config.ContainerID
+}
+
+func (p *fakeGCPlugin) GC(_ context.Context, liveSandboxIDs []config.ContainerID) error {
+	p.live = liveSandboxIDs
+	return nil
+}
+
+type fakeSandboxLister struct {
+	ids []config.ContainerID
+	err error
+}
+
+func (l *fakeSandboxLister) ListSandboxIDs() ([]config.ContainerID, error) {
+	return l.ids, l.err
+}
+
+func TestPluginManagerGC(t *testing.T) {
+	plugin := &fakeGCPlugin{}
+	pm := NewPluginManager(plugin)
+
+	ids := []config.ContainerID{{Type: "docker", ID: "sandbox1"}}
+	if err := pm.GC(context.Background(), &fakeSandboxLister{ids: ids}); err != nil {
+		t.Fatalf("unexpected GC error: %v", err)
+	}
+	if !reflect.DeepEqual(plugin.live, ids) {
+		t.Errorf("expected live sandboxes %v, got %v", ids, plugin.live)
+	}
+
+	// A failed listing must never reach the plugin with a partial set.
+	plugin.live = nil
+	if err := pm.GC(context.Background(), &fakeSandboxLister{ids: ids, err: fmt.Errorf("list failed")}); err == nil {
+		t.Errorf("expected GC to fail when sandboxes can't be listed")
+	}
+	if plugin.live != nil {
+		t.Errorf("expected plugin GC not to run, got %v", plugin.live)
+	}
+
+	// Plugins without GC support are skipped.
+	if err := NewPluginManager(&NoopNetworkPlugin{}).GC(context.Background(), &fakeSandboxLister{err: fmt.Errorf("unused")}); err != nil {
+		t.Errorf("unexpected GC error for plugin without GC support: %v", err)
+	}
+}
+
+type fakeNamedNetworkPlugin struct {
+	NoopNetworkPlugin
+	tornDownFrom []string
+}
+
+func (p *fakeNamedNetworkPlugin) TearDownPod(string, string, config.ContainerID) error {
+	p.tornDownFrom = append(p.tornDownFrom, "default")
+	return nil
+}
+
+func (p *fakeNamedNetworkPlugin) TearDownPodFromNetwork(
+	_ context.Context,
+	_, _ string,
+	_ config.ContainerID,
+	networkName string,
+) error {
+	p.tornDownFrom = append(p.tornDownFrom, networkName)
+	return nil
+}
+
+func TestPluginManagerTearDownPodFromNetwork(t *testing.T) {
+	plugin := &fakeNamedNetworkPlugin{}
+	pm := NewPluginManager(plugin)
+	id := config.ContainerID{Type: "docker", ID: "sandbox1"}
+
+	for _, networkName := range []string{"old-net", ""} {
+		if err := pm.TearDownPodFromNetwork(context.Background(), "ns", "pod", id, networkName); err != nil {
+			t.Fatalf("unexpected teardown error: %v", err)
+		}
+	}
+	if expected := []string{"old-net", "default"}; !reflect.DeepEqual(plugin.tornDownFrom, expected) {
+		t.Errorf("expected teardowns from %v, got %v", expected, plugin.tornDownFrom)
+	}
+
+	// Plugins which don't report interfaces create the default one.
+	interfaces, err := pm.GetPodInterfaces(id, "old-net")
+	if err != nil || !reflect.DeepEqual(interfaces, []string{DefaultInterfaceName}) {
+		t.Errorf("expected the default interface, got %v, %v", interfaces, err)
+	}
+}
+
+type fakeBlockingGCPlugin struct {
+	NoopNetworkPlugin
+	started chan struct{}
+	release chan struct{}
+}
+
+func (p *fakeBlockingGCPlugin) GC(context.Context, []config.ContainerID) error {
+	close(p.started)
+	<-p.release
+	return nil
+}
+
+func TestPluginManagerSetUpPodDuringGC(t *testing.T) {
+	plugin := &fakeBlockingGCPlugin{started: make(chan struct{}), release: make(chan struct{})}
+	pm := NewPluginManager(plugin)
+	live := config.ContainerID{Type: "docker", ID: "live"}
+	gcDone := make(chan error)
+	go func() {
+		gcDone <- pm.GC(context.Background(), &fakeSandboxLister{ids: []config.ContainerID{live}})
+	}()
+	<-plugin.started
+
+	// The pods of live sandboxes are set up while the plugin collects.
+	if err := pm.SetUpPod(context.Background(), "ns", "live", live, nil, nil); err != nil {
+		t.Fatalf("unexpected set up error: %v", err)
+	}
+
+	// The pods of new sandboxes wait for the collection to be done.
+	setUpDone := make(chan error)
+	go func() {
+		setUpDone <- pm.SetUpPod(context.Background(), "ns", "new", config.ContainerID{Type: "docker", ID: "new"}, nil, nil)
+	}()
+	select {
+	case <-setUpDone:
+		t.Fatalf("expected the set up of a new sandbox to wait for the garbage collection")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(plugin.release)
+	if err := <-gcDone; err != nil {
+		t.Fatalf("unexpected GC error: %v", err)
+	}
+	if err := <-setUpDone; err != nil {
+		t.Fatalf("unexpected set up error: %v", err)
+	}
+}
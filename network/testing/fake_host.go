@@ -20,6 +20,8 @@ package testing
 // a fake host is created here that can be used by plugins for testing
 
 import (
+	"context"
+
 	v1 "k8s.io/api/core/v1"
 	clientset "k8s.io/client-go/kubernetes"
 
@@ -63,6 +65,7 @@ type FakePortMappingGetter struct {
 }
 
 func (pm *FakePortMappingGetter) GetPodPortMappings(
+	_ context.Context,
 	containerID string,
 ) ([]*hostport.PortMapping, error) {
 	return pm.PortMaps[containerID], nil
@@ -17,6 +17,7 @@ limitations under the License.
 package testing
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"sync"
@@ -134,17 +135,17 @@ func TestPluginManager(t *testing.T) {
 				// concurrently.
 				allCreatedWg.Wait()
 
-				if err := pm.SetUpPod("", name, id, nil, nil); err != nil {
+				if err := pm.SetUpPod(context.Background(), "", name, id, nil, nil); err != nil {
 					t.Errorf("Failed to set up pod %q: %v", name, err)
 					return
 				}
 
-				if _, err := pm.GetPodNetworkStatus("", name, id); err != nil {
+				if _, err := pm.GetPodNetworkStatus(context.Background(), "", name, id); err != nil {
 					t.Errorf("Failed to inspect pod %q: %v", name, err)
 					return
 				}
 
-				if err := pm.TearDownPod("", name, id); err != nil {
+				if err := pm.TearDownPod(context.Background(), "", name, id); err != nil {
 					t.Errorf("Failed to tear down pod %q: %v", name, err)
 					return
 				}
@@ -254,12 +255,12 @@ func TestMultiPodParallelNetworkOps(t *testing.T) {
 		// Setup will block on the runner pod completing.  If network
 		// operations locking isn't correct (eg pod network operations
 		// block other pods) setUpPod() will never return.
-		if err := pm.SetUpPod("", podName, containerID, nil, nil); err != nil {
+		if err := pm.SetUpPod(context.Background(), "", podName, containerID, nil, nil); err != nil {
 			t.Errorf("Failed to set up waiter pod: %v", err)
 			return
 		}
 
-		if err := pm.TearDownPod("", podName, containerID); err != nil {
+		if err := pm.TearDownPod(context.Background(), "", podName, containerID); err != nil {
 			t.Errorf("Failed to tear down waiter pod: %v", err)
 			return
 		}
@@ -274,12 +275,12 @@ func TestMultiPodParallelNetworkOps(t *testing.T) {
 		podName := "runner"
 		containerID := config.ContainerID{ID: podName}
 
-		if err := pm.SetUpPod("", podName, containerID, nil, nil); err != nil {
+		if err := pm.SetUpPod(context.Background(), "", podName, containerID, nil, nil); err != nil {
 			t.Errorf("Failed to set up runner pod: %v", err)
 			return
 		}
 
-		if err := pm.TearDownPod("", podName, containerID); err != nil {
+		if err := pm.TearDownPod(context.Background(), "", podName, containerID); err != nil {
 			t.Errorf("Failed to tear down runner pod: %v", err)
 			return
 		}
@@ -29,9 +29,10 @@ func NewErrorStreamingDisabled(method string) error {
 	return grpcstatus.Errorf(codes.NotFound, "streaming method %s disabled", method)
 }
 
-// NewErrorTooManyInFlight creates an error for exceeding the maximum number of in-flight requests.
-func NewErrorTooManyInFlight() error {
-	return grpcstatus.Error(codes.ResourceExhausted, "maximum number of in-flight requests exceeded")
+// NewErrorTooManyInFlight creates an error for exceeding the maximum number, limit, of in-flight
+// requests or sessions, what.
+func NewErrorTooManyInFlight(what string, limit int) error {
+	return grpcstatus.Errorf(codes.ResourceExhausted, "maximum number of in-flight %s (%d) exceeded", what, limit)
 }
 
 // WriteError translates a CRI streaming error into an appropriate HTTP response.
@@ -42,9 +43,9 @@ func WriteError(err error, w http.ResponseWriter) error {
 	case codes.NotFound:
 		status = http.StatusNotFound
 	case codes.ResourceExhausted:
-		// We only expect to hit this if there is a DoS, so we just wait the full TTL.
-		// If this is ever hit in steady-state operations, consider increasing the maxInFlight requests,
-		// or plumbing through the time to next expiration.
+		// We only expect to hit this if there is a DoS or if a session limit is reached, so we just
+		// wait the full TTL. If this is ever hit in steady-state operations, consider increasing the
+		// maxInFlight requests or the session limits, or plumbing through the time to next expiration.
 		w.Header().Set("Retry-After", strconv.Itoa(int(cacheTTL.Seconds())))
 		status = http.StatusTooManyRequests
 	default:
@@ -0,0 +1,317 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package streaming
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+const (
+	// RecordSessionsAnnotation is the pod annotation which, set to "true",
+	// records the exec and attach sessions of the pod.
+	RecordSessionsAnnotation = "cri-dockerd.mirantis.com/record-sessions"
+
+	// The terminal size of the recordings of sessions without a TTY, or
+	// whose TTY was not resized before the first event.
+	defaultRecordingWidth  = 80
+	defaultRecordingHeight = 24
+)
+
+// Session is an exec or attach session.
+type Session struct {
+	// ID identifies the session, see SessionIDFromURL.
+	ID          string
+	Type        string
+	Namespace   string
+	Pod         string
+	Container   string
+	ContainerID string
+	Command     []string
+	TTY         bool
+	Start       time.Time
+}
+
+// RecordingSink stores the recordings of the sessions.
+type RecordingSink interface {
+	// Create returns the writer the asciicast v2 recording of session is
+	// written to, closed when the session ends.
+	Create(session *Session) (io.WriteCloser, error)
+}
+
+// Streams are the streams of a session.
+type Streams struct {
+	In     io.Reader
+	Out    io.WriteCloser
+	Err    io.WriteCloser
+	Resize <-chan remotecommand.TerminalSize
+}
+
+// Recorder records the exec and attach sessions of the pods in the
+// configured namespaces and of the pods annotated with
+// RecordSessionsAnnotation.
+type Recorder struct {
+	sink       RecordingSink
+	maxSize    int64
+	namespaces map[string]bool
+}
+
+// NewRecorder returns a Recorder writing the recordings to sink, each one
+// truncated to maxSize bytes if maxSize is positive.
+func NewRecorder(sink RecordingSink, maxSize int64, namespaces []string) *Recorder {
+	r := &Recorder{
+		sink:       sink,
+		maxSize:    maxSize,
+		namespaces: make(map[string]bool),
+	}
+	for _, namespace := range namespaces {
+		r.namespaces[namespace] = true
+	}
+	return r
+}
+
+// Records returns whether the sessions of the pod in namespace with
+// annotations are recorded.
+func (r *Recorder) Records(namespace string, annotations map[string]string) bool {
+	return r.namespaces[namespace] || annotations[RecordSessionsAnnotation] == "true"
+}
+
+// Record starts recording session and returns the streams to use in place
+// of streams, which copy what goes through them to the recording, and a
+// function ending the recording.
+func (r *Recorder) Record(session *Session, streams Streams) (Streams, func(), error) {
+	w, err := r.sink.Create(session)
+	if err != nil {
+		return Streams{}, nil, fmt.Errorf("failed to create the recording of %s session %s: %v", session.Type, session.ID, err)
+	}
+	rec := &recording{
+		session: session,
+		w:       w,
+		maxSize: r.maxSize,
+	}
+	logrus.WithFields(logrus.Fields{
+		"session":     session.ID,
+		"pod":         session.Namespace + "/" + session.Pod,
+		"containerID": session.ContainerID,
+	}).Infof("Recording %s session", session.Type)
+
+	recorded := Streams{}
+	if streams.In != nil {
+		recorded.In = &recordingReader{Reader: streams.In, rec: rec}
+	}
+	if streams.Out != nil {
+		recorded.Out = &recordingWriter{WriteCloser: streams.Out, rec: rec}
+	}
+	if streams.Err != nil {
+		recorded.Err = &recordingWriter{WriteCloser: streams.Err, rec: rec}
+	}
+	if streams.Resize != nil {
+		resize := make(chan remotecommand.TerminalSize)
+		go func() {
+			defer close(resize)
+			for size := range streams.Resize {
+				rec.resize(size)
+				resize <- size
+			}
+		}()
+		recorded.Resize = resize
+	}
+	return recorded, rec.close, nil
+}
+
+// recording writes the events of a session as asciicast v2, see
+// https://docs.asciinema.org/manual/asciicast/v2/.
+type recording struct {
+	session *Session
+	maxSize int64
+
+	mu        sync.Mutex
+	w         io.WriteCloser
+	size      int64
+	started   bool
+	truncated bool
+	closed    bool
+}
+
+type recordingHeader struct {
+	Version   int    `json:"version"`
+	Width     uint16 `json:"width"`
+	Height    uint16 `json:"height"`
+	Timestamp int64  `json:"timestamp"`
+	Command   string `json:"command,omitempty"`
+	Title     string `json:"title"`
+}
+
+// start writes the header, with the size of the terminal if known. It must
+// be called with mu held.
+func (r *recording) start(width, height uint16) {
+	r.started = true
+	r.writeLine(recordingHeader{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: r.session.Start.Unix(),
+		Command:   strings.Join(r.session.Command, " "),
+		Title: fmt.Sprintf(
+			"%s session %s in %s/%s/%s",
+			r.session.Type,
+			r.session.ID,
+			r.session.Namespace,
+			r.session.Pod,
+			r.session.Container,
+		),
+	})
+}
+
+// event writes an event of type code, "i" for input, "o" for output, "r"
+// for resize or "m" for marker.
+func (r *recording) event(code, data string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed || r.truncated {
+		return
+	}
+	if !r.started {
+		r.start(defaultRecordingWidth, defaultRecordingHeight)
+	}
+	elapsed := time.Since(r.session.Start).Seconds()
+	line, err := json.Marshal([]interface{}{elapsed, code, data})
+	if err != nil {
+		logrus.WithError(err).WithField("session", r.session.ID).Error("Failed to encode the recording event")
+		return
+	}
+	if r.maxSize > 0 && r.size+int64(len(line))+1 > r.maxSize {
+		// The marker may go over the maximum size, by a few bytes.
+		r.truncated = true
+		r.writeLine([]interface{}{elapsed, "m", fmt.Sprintf("recording truncated at %d bytes", r.size)})
+		return
+	}
+	r.write(line)
+}
+
+// resize records the new size of the terminal, in the header if nothing was
+// recorded yet.
+func (r *recording) resize(size remotecommand.TerminalSize) {
+	r.mu.Lock()
+	if !r.started && !r.closed {
+		r.start(size.Width, size.Height)
+		r.mu.Unlock()
+		return
+	}
+	r.mu.Unlock()
+	r.event("r", fmt.Sprintf("%dx%d", size.Width, size.Height))
+}
+
+// writeLine writes v as a JSON line. It must be called with mu held.
+func (r *recording) writeLine(v interface{}) {
+	line, err := json.Marshal(v)
+	if err != nil {
+		logrus.WithError(err).WithField("session", r.session.ID).Error("Failed to encode the recording event")
+		return
+	}
+	r.write(line)
+}
+
+// write writes line and a newline. It must be called with mu held.
+func (r *recording) write(line []byte) {
+	n, err := r.w.Write(append(line, '\n'))
+	r.size += int64(n)
+	if err != nil {
+		logrus.WithError(err).WithField("session", r.session.ID).Error("Failed to write the recording")
+	}
+}
+
+func (r *recording) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	if !r.started {
+		r.start(defaultRecordingWidth, defaultRecordingHeight)
+	}
+	r.closed = true
+	if err := r.w.Close(); err != nil {
+		logrus.WithError(err).WithField("session", r.session.ID).Error("Failed to close the recording")
+	}
+}
+
+type recordingReader struct {
+	io.Reader
+	rec *recording
+}
+
+func (r *recordingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		r.rec.event("i", string(p[:n]))
+	}
+	return n, err
+}
+
+type recordingWriter struct {
+	io.WriteCloser
+	rec *recording
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	if n > 0 {
+		w.rec.event("o", string(p[:n]))
+	}
+	return n, err
+}
+
+type sessionIDKey struct{}
+
+// sessionID returns the ID of the session of token. The token, which grants
+// access to the session, can't be derived from it.
+func sessionID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
+
+// SessionIDFromURL returns the ID of the session served at rawURL, as
+// returned by GetExec or GetAttach.
+func SessionIDFromURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Path == "" {
+		return ""
+	}
+	return sessionID(path.Base(u.Path))
+}
+
+func withSessionID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, sessionIDKey{}, id)
+}
+
+func sessionIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(sessionIDKey{}).(string)
+	return id
+}
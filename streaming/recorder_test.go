@@ -0,0 +1,208 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package streaming
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+type bufferSink struct {
+	bytes.Buffer
+	sessions []*Session
+	closed   bool
+}
+
+func (s *bufferSink) Create(session *Session) (io.WriteCloser, error) {
+	s.sessions = append(s.sessions, session)
+	return s, nil
+}
+
+func (s *bufferSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// readRecording returns the header and the events of an asciicast v2
+// recording.
+func readRecording(t *testing.T, r io.Reader) (map[string]interface{}, [][]interface{}) {
+	scanner := bufio.NewScanner(r)
+	require.True(t, scanner.Scan())
+	var header map[string]interface{}
+	require.NoError(t, json.Unmarshal(scanner.Bytes(), &header))
+	var events [][]interface{}
+	for scanner.Scan() {
+		var event []interface{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		require.Len(t, event, 3)
+		events = append(events, event)
+	}
+	return header, events
+}
+
+func testSession() *Session {
+	return &Session{
+		ID:          "0123456789abcdef",
+		Type:        "exec",
+		Namespace:   "prod",
+		Pod:         "web",
+		Container:   "app",
+		ContainerID: testContainerID,
+		Command:     []string{"sh", "-i"},
+		TTY:         true,
+		Start:       time.Now(),
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	sink := &bufferSink{}
+	recorder := NewRecorder(sink, 0, nil)
+	session := testSession()
+	resize := make(chan remotecommand.TerminalSize)
+	stdout := &bytes.Buffer{}
+	streams, stop, err := recorder.Record(session, Streams{
+		In:     strings.NewReader("ls\n"),
+		Out:    nopWriteCloser{stdout},
+		Resize: resize,
+	})
+	require.NoError(t, err)
+	assert.Nil(t, streams.Err)
+
+	resize <- remotecommand.TerminalSize{Width: 100, Height: 30}
+	assert.Equal(t, remotecommand.TerminalSize{Width: 100, Height: 30}, <-streams.Resize)
+	input, err := io.ReadAll(streams.In)
+	require.NoError(t, err)
+	assert.Equal(t, "ls\n", string(input))
+	_, err = streams.Out.Write([]byte("bin etc\r\n"))
+	require.NoError(t, err)
+	resize <- remotecommand.TerminalSize{Width: 120, Height: 40}
+	<-streams.Resize
+	close(resize)
+	_, ok := <-streams.Resize
+	assert.False(t, ok)
+	stop()
+
+	assert.True(t, sink.closed)
+	assert.Equal(t, []*Session{session}, sink.sessions)
+	assert.Equal(t, "bin etc\r\n", stdout.String())
+	header, events := readRecording(t, &sink.Buffer)
+	assert.Equal(t, map[string]interface{}{
+		"version":   float64(2),
+		"width":     float64(100),
+		"height":    float64(30),
+		"timestamp": float64(session.Start.Unix()),
+		"command":   "sh -i",
+		"title":     "exec session 0123456789abcdef in prod/web/app",
+	}, header)
+	require.Len(t, events, 3)
+	assert.Equal(t, []interface{}{"i", "ls\n"}, events[0][1:])
+	assert.Equal(t, []interface{}{"o", "bin etc\r\n"}, events[1][1:])
+	assert.Equal(t, []interface{}{"r", "120x40"}, events[2][1:])
+	assert.LessOrEqual(t, events[0][0], events[2][0])
+}
+
+func TestRecorderMaxSize(t *testing.T) {
+	sink := &bufferSink{}
+	recorder := NewRecorder(sink, 256, nil)
+	stdout := &bytes.Buffer{}
+	streams, stop, err := recorder.Record(testSession(), Streams{Out: nopWriteCloser{stdout}})
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		_, err := streams.Out.Write([]byte(strings.Repeat("x", 50)))
+		require.NoError(t, err)
+	}
+	stop()
+
+	// The output is never truncated, only its recording.
+	assert.Equal(t, 500, stdout.Len())
+	assert.LessOrEqual(t, sink.Len(), 256+64)
+	header, events := readRecording(t, &sink.Buffer)
+	assert.Equal(t, float64(80), header["width"])
+	assert.Equal(t, "m", events[len(events)-1][1])
+	assert.Contains(t, events[len(events)-1][2], "recording truncated")
+}
+
+func TestRecorderRecords(t *testing.T) {
+	recorder := NewRecorder(&bufferSink{}, 0, []string{"prod"})
+	assert.True(t, recorder.Records("prod", nil))
+	assert.False(t, recorder.Records("dev", nil))
+	assert.False(t, recorder.Records("dev", map[string]string{RecordSessionsAnnotation: "false"}))
+	assert.True(t, recorder.Records("dev", map[string]string{RecordSessionsAnnotation: "true"}))
+}
+
+func TestSessionIDFromURL(t *testing.T) {
+	id := SessionIDFromURL("http://127.0.0.1:10010/cri/exec/Xo3lOhJ5")
+	assert.Len(t, id, 16)
+	assert.Equal(t, sessionID("Xo3lOhJ5"), id)
+	assert.Equal(t, id, SessionIDFromURL("/cri/exec/Xo3lOhJ5"))
+	assert.NotEqual(t, id, SessionIDFromURL("/cri/exec/Xo3lOhJ6"))
+	assert.Equal(t, "", SessionIDFromURL(""))
+}
+
+func TestDirectorySink(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	writeRecording := func(name string, size int, age time.Duration) {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, make([]byte, size), 0o600))
+		require.NoError(t, os.Chtimes(path, now.Add(-age), now.Add(-age)))
+	}
+	writeRecording("expired.cast", 10, 48*time.Hour)
+	writeRecording("old.cast", 600, 3*time.Hour)
+	writeRecording("recent.cast", 300, time.Hour)
+	writeRecording("other.log", 10, 48*time.Hour)
+
+	sink, err := NewDirectorySink(dir, 24*time.Hour, 1000)
+	require.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(dir, "expired.cast"))
+	assert.FileExists(t, filepath.Join(dir, "old.cast"))
+	assert.FileExists(t, filepath.Join(dir, "recent.cast"))
+	assert.FileExists(t, filepath.Join(dir, "other.log"))
+
+	session := testSession()
+	w, err := sink.Create(session)
+	require.NoError(t, err)
+	_, err = w.Write(make([]byte, 600))
+	require.NoError(t, err)
+	// The recording being written counts in the total size, but the oldest
+	// ones are removed instead of it.
+	w2, err := sink.Create(&Session{ID: "fedcba9876543210", Namespace: "prod", Pod: "web", Start: now})
+	require.NoError(t, err)
+	require.NoError(t, w2.Close())
+	name := session.Start.UTC().Format("20060102T150405Z") + "_prod_web_0123456789abcdef.cast"
+	assert.FileExists(t, filepath.Join(dir, name))
+	assert.NoFileExists(t, filepath.Join(dir, "old.cast"))
+	assert.FileExists(t, filepath.Join(dir, "recent.cast"))
+	require.NoError(t, w.Close())
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package streaming
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const recordingExtension = ".cast"
+
+// DirectorySink is a RecordingSink writing each recording to a file of a
+// directory, named after the start time, the pod and the ID of the session.
+// The recordings older than the maximum age are removed, then the oldest
+// ones until the directory is under the maximum total size.
+type DirectorySink struct {
+	dir          string
+	maxAge       time.Duration
+	maxTotalSize int64
+
+	mu sync.Mutex
+	// open are the recordings being written, which count in the total size
+	// but are never removed.
+	open map[string]bool
+}
+
+var _ RecordingSink = &DirectorySink{}
+
+// NewDirectorySink returns a DirectorySink writing to dir. The recordings
+// are kept forever if maxAge is 0 and whatever their total size if
+// maxTotalSize is 0.
+func NewDirectorySink(dir string, maxAge time.Duration, maxTotalSize int64) (*DirectorySink, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create the recording directory %s: %v", dir, err)
+	}
+	s := &DirectorySink{
+		dir:          dir,
+		maxAge:       maxAge,
+		maxTotalSize: maxTotalSize,
+		open:         make(map[string]bool),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(time.Now())
+	return s, nil
+}
+
+// Create creates the file of the recording of session, after removing the
+// expired recordings.
+func (s *DirectorySink) Create(session *Session) (io.WriteCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(time.Now())
+
+	name := fmt.Sprintf(
+		"%s_%s_%s_%s%s",
+		session.Start.UTC().Format("20060102T150405Z"),
+		session.Namespace,
+		session.Pod,
+		session.ID,
+		recordingExtension,
+	)
+	f, err := os.OpenFile(filepath.Join(s.dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	s.open[name] = true
+	return &recordingFile{File: f, sink: s, name: name}, nil
+}
+
+// prune removes the recordings over the maximum age and total size. It must
+// be called with mu held.
+func (s *DirectorySink) prune(now time.Time) {
+	if s.maxAge <= 0 && s.maxTotalSize <= 0 {
+		return
+	}
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		logrus.WithError(err).Errorf("Failed to list the recordings in %s", s.dir)
+		return
+	}
+	var recordings []os.FileInfo
+	var totalSize int64
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), recordingExtension) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if s.open[entry.Name()] {
+			totalSize += info.Size()
+			continue
+		}
+		if s.maxAge > 0 && now.Sub(info.ModTime()) > s.maxAge {
+			s.remove(info.Name())
+			continue
+		}
+		recordings = append(recordings, info)
+		totalSize += info.Size()
+	}
+	if s.maxTotalSize <= 0 {
+		return
+	}
+	sort.Slice(recordings, func(i, j int) bool {
+		return recordings[i].ModTime().Before(recordings[j].ModTime())
+	})
+	for _, info := range recordings {
+		if totalSize <= s.maxTotalSize {
+			break
+		}
+		s.remove(info.Name())
+		totalSize -= info.Size()
+	}
+}
+
+func (s *DirectorySink) remove(name string) {
+	if err := os.Remove(filepath.Join(s.dir, name)); err != nil && !os.IsNotExist(err) {
+		logrus.WithError(err).Errorf("Failed to remove the expired recording %s", name)
+		return
+	}
+	logrus.Debugf("Removed the expired recording %s", name)
+}
+
+type recordingFile struct {
+	*os.File
+	sink *DirectorySink
+	name string
+}
+
+func (f *recordingFile) Close() error {
+	f.sink.mu.Lock()
+	delete(f.sink.open, f.name)
+	f.sink.mu.Unlock()
+	return f.File.Close()
+}
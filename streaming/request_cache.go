@@ -25,6 +25,7 @@ import (
 	"sync"
 	"time"
 
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
 	"k8s.io/utils/clock"
 )
 
@@ -78,7 +79,7 @@ func (c *requestCache) Insert(req request) (token string, err error) {
 	c.gc()
 	// If the cache is full, reject the request.
 	if c.ll.Len() == maxInFlight {
-		return "", NewErrorTooManyInFlight()
+		return "", NewErrorTooManyInFlight("requests", maxInFlight)
 	}
 	token, err = c.uniqueToken()
 	if err != nil {
@@ -109,6 +110,46 @@ func (c *requestCache) Consume(token string) (req request, found bool) {
 	return entry.req, true
 }
 
+// CachedRequest is a request waiting in the cache for its stream. The token
+// isn't exposed, it grants access to the stream.
+type CachedRequest struct {
+	Type         string    `json:"type"`
+	ContainerID  string    `json:"containerID,omitempty"`
+	PodSandboxID string    `json:"podSandboxID,omitempty"`
+	Cmd          []string  `json:"cmd,omitempty"`
+	Ports        []int32   `json:"ports,omitempty"`
+	ExpireTime   time.Time `json:"expireTime"`
+}
+
+// List returns the cached requests which haven't expired, newest first.
+func (c *requestCache) List() []CachedRequest {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.gc()
+	requests := make([]CachedRequest, 0, c.ll.Len())
+	for ele := c.ll.Front(); ele != nil; ele = ele.Next() {
+		entry := ele.Value.(*cacheEntry)
+		cached := CachedRequest{ExpireTime: entry.expireTime}
+		switch req := entry.req.(type) {
+		case *runtimeapi.ExecRequest:
+			cached.Type = "exec"
+			cached.ContainerID = req.ContainerId
+			cached.Cmd = req.Cmd
+		case *runtimeapi.AttachRequest:
+			cached.Type = "attach"
+			cached.ContainerID = req.ContainerId
+		case *runtimeapi.PortForwardRequest:
+			cached.Type = "portforward"
+			cached.PodSandboxID = req.PodSandboxId
+			cached.Ports = req.Port
+		default:
+			cached.Type = fmt.Sprintf("%T", req)
+		}
+		requests = append(requests, cached)
+	}
+	return requests
+}
+
 // uniqueToken generates a random URL-safe token and ensures uniqueness.
 func (c *requestCache) uniqueToken() (string, error) {
 	const maxTries = 10
@@ -25,6 +25,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
 
 	clock "k8s.io/utils/clock/testing"
 )
@@ -200,6 +201,24 @@ func TestGC(t *testing.T) {
 	assertCacheSize(t, c, 1)
 }
 
+func TestList(t *testing.T) {
+	c, clock := newTestCache()
+	_, err := c.Insert(&runtimeapi.ExecRequest{ContainerId: "c1", Cmd: []string{"sh"}})
+	require.NoError(t, err)
+	clock.Step(2 * cacheTTL)
+	_, err = c.Insert(&runtimeapi.PortForwardRequest{PodSandboxId: "p1", Port: []int32{80}})
+	require.NoError(t, err)
+	_, err = c.Insert(&runtimeapi.AttachRequest{ContainerId: "c2"})
+	require.NoError(t, err)
+
+	// The expired exec request is gone.
+	expireTime := clock.Now().Add(cacheTTL)
+	assert.Equal(t, []CachedRequest{
+		{Type: "attach", ContainerID: "c2", ExpireTime: expireTime},
+		{Type: "portforward", PodSandboxID: "p1", Ports: []int32{80}, ExpireTime: expireTime},
+	}, c.List())
+}
+
 func newTestCache() (*requestCache, *clock.FakeClock) {
 	c := newRequestCache()
 	fakeClock := clock.NewFakeClock(time.Now())
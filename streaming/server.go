@@ -25,6 +25,7 @@ import (
 	"net/http"
 	"net/url"
 	"path"
+	"sync/atomic"
 	"time"
 
 	"google.golang.org/grpc/codes"
@@ -32,12 +33,13 @@ import (
 
 	restful "github.com/emicklei/go-restful"
 
+	remotecommandserver "github.com/Mirantis/cri-dockerd/streaming/remotecommand"
+
 	"k8s.io/apimachinery/pkg/types"
 	remotecommandconsts "k8s.io/apimachinery/pkg/util/remotecommand"
 	"k8s.io/client-go/tools/remotecommand"
 	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
 	"k8s.io/kubelet/pkg/cri/streaming/portforward"
-	remotecommandserver "k8s.io/kubelet/pkg/cri/streaming/remotecommand"
 )
 
 // Server is the library interface to serve the stream requests.
@@ -58,6 +60,10 @@ type Server interface {
 	Start(stayUp bool) error
 	// Stop the server, and terminate any open connections.
 	Stop() error
+	// Addr returns the address the server listens on, empty until it does.
+	Addr() string
+	// CachedRequests returns the requests waiting for their stream.
+	CachedRequests() []CachedRequest
 }
 
 // Runtime is the interface to execute the commands and provide the streams.
@@ -93,6 +99,13 @@ type Config struct {
 
 	// The config for serving over TLS. If nil, TLS will not be used.
 	TLSConfig *tls.Config
+
+	// The maximum number of concurrent exec, attach and port forward sessions per container,
+	// counting the port forwards in their pod sandbox. No limit if not positive.
+	MaxSessionsPerContainer int
+	// The maximum number of concurrent exec, attach and port forward sessions. No limit if not
+	// positive.
+	MaxSessions int
 }
 
 // DefaultConfig provides default values for server Config. The DefaultConfig is partial, so
@@ -111,6 +124,7 @@ func NewServer(config Config, runtime Runtime) (Server, error) {
 		config:  config,
 		runtime: &criAdapter{runtime},
 		cache:   newRequestCache(),
+		limiter: newSessionLimiter(config.MaxSessionsPerContainer, config.MaxSessions),
 	}
 
 	if s.config.BaseURL == nil {
@@ -159,7 +173,10 @@ type server struct {
 	runtime *criAdapter
 	handler http.Handler
 	cache   *requestCache
+	limiter *sessionLimiter
 	server  *http.Server
+	// addr is the address of the listener, once it listens.
+	addr atomic.Value
 }
 
 func validateExecRequest(req *runtimeapi.ExecRequest) error {
@@ -181,6 +198,9 @@ func (s *server) GetExec(req *runtimeapi.ExecRequest) (*runtimeapi.ExecResponse,
 	if err := validateExecRequest(req); err != nil {
 		return nil, err
 	}
+	if err := s.limiter.check("exec", req.ContainerId); err != nil {
+		return nil, err
+	}
 	token, err := s.cache.Insert(req)
 	if err != nil {
 		return nil, err
@@ -209,6 +229,9 @@ func (s *server) GetAttach(req *runtimeapi.AttachRequest) (*runtimeapi.AttachRes
 	if err := validateAttachRequest(req); err != nil {
 		return nil, err
 	}
+	if err := s.limiter.check("attach", req.ContainerId); err != nil {
+		return nil, err
+	}
 	token, err := s.cache.Insert(req)
 	if err != nil {
 		return nil, err
@@ -222,6 +245,9 @@ func (s *server) GetPortForward(req *runtimeapi.PortForwardRequest) (*runtimeapi
 	if req.PodSandboxId == "" {
 		return nil, status.Errorf(codes.InvalidArgument, "missing required pod_sandbox_id")
 	}
+	if err := s.limiter.check("portforward", req.PodSandboxId); err != nil {
+		return nil, err
+	}
 	token, err := s.cache.Insert(req)
 	if err != nil {
 		return nil, err
@@ -243,6 +269,7 @@ func (s *server) Start(stayUp bool) error {
 	}
 	// Use the actual address as baseURL host. This handles the "0" port case.
 	s.config.BaseURL.Host = listener.Addr().String()
+	s.addr.Store(listener.Addr().String())
 	if s.config.TLSConfig != nil {
 		return s.server.ServeTLS(listener, "", "") // Use certs from TLSConfig.
 	}
@@ -253,6 +280,15 @@ func (s *server) Stop() error {
 	return s.server.Close()
 }
 
+func (s *server) CachedRequests() []CachedRequest {
+	return s.cache.List()
+}
+
+func (s *server) Addr() string {
+	addr, _ := s.addr.Load().(string)
+	return addr
+}
+
 func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.handler.ServeHTTP(w, r)
 }
@@ -275,6 +311,13 @@ func (s *server) serveExec(req *restful.Request, resp *restful.Response) {
 		http.NotFound(resp.ResponseWriter, req.Request)
 		return
 	}
+	release, err := s.limiter.acquire("exec", exec.ContainerId)
+	if err != nil {
+		WriteError(err, resp.ResponseWriter)
+		return
+	}
+	defer release()
+	req.Request = req.Request.WithContext(withSessionID(req.Request.Context(), sessionID(token)))
 
 	streamOpts := &remotecommandserver.Options{
 		Stdin:  exec.Stdin,
@@ -309,6 +352,13 @@ func (s *server) serveAttach(req *restful.Request, resp *restful.Response) {
 		http.NotFound(resp.ResponseWriter, req.Request)
 		return
 	}
+	release, err := s.limiter.acquire("attach", attach.ContainerId)
+	if err != nil {
+		WriteError(err, resp.ResponseWriter)
+		return
+	}
+	defer release()
+	req.Request = req.Request.WithContext(withSessionID(req.Request.Context(), sessionID(token)))
 
 	streamOpts := &remotecommandserver.Options{
 		Stdin:  attach.Stdin,
@@ -347,6 +397,12 @@ func (s *server) servePortForward(req *restful.Request, resp *restful.Response)
 		resp.WriteError(http.StatusBadRequest, err)
 		return
 	}
+	release, err := s.limiter.acquire("portforward", pf.PodSandboxId)
+	if err != nil {
+		WriteError(err, resp.ResponseWriter)
+		return
+	}
+	defer release()
 
 	portforward.ServePortForward(
 		resp.ResponseWriter,
@@ -17,6 +17,7 @@ limitations under the License.
 package streaming
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"io"
@@ -27,12 +28,16 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"k8s.io/client-go/tools/remotecommand"
 
 	api "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
 	restclient "k8s.io/client-go/rest"
 	"k8s.io/client-go/transport/spdy"
 	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
@@ -264,11 +269,56 @@ func TestGetPortForward(t *testing.T) {
 }
 
 func TestServeExec(t *testing.T) {
-	runRemoteCommandTest(t, "exec")
+	runRemoteCommandTest(t, "exec", false)
 }
 
 func TestServeAttach(t *testing.T) {
-	runRemoteCommandTest(t, "attach")
+	runRemoteCommandTest(t, "attach", false)
+}
+
+func TestServeExecWebSocket(t *testing.T) {
+	runRemoteCommandTest(t, "exec", true)
+}
+
+func TestServeAttachWebSocket(t *testing.T) {
+	runRemoteCommandTest(t, "attach", true)
+}
+
+// TestServeExecWebSocketStdinEOF runs the equivalent of
+// `echo abcdefg | kubectl exec -it -- cat`: the client half-closes stdin
+// once it is read, and the exec ends as soon as cat sees the EOF.
+func TestServeExecWebSocketStdinEOF(t *testing.T) {
+	s, testServer := startTestServer(t)
+	defer testServer.Close()
+
+	resp, err := s.GetExec(&runtimeapi.ExecRequest{
+		ContainerId: testContainerID,
+		Cmd:         []string{"cat"},
+		Tty:         true,
+		Stdin:       true,
+		Stdout:      true,
+	})
+	require.NoError(t, err)
+
+	exec, err := remotecommand.NewWebSocketExecutor(&restclient.Config{}, "GET", resp.Url)
+	require.NoError(t, err)
+	stdout := &bytes.Buffer{}
+	done := make(chan error)
+	go func() {
+		done <- exec.StreamWithContext(context.Background(), remotecommand.StreamOptions{
+			Stdin:             strings.NewReader(testInput),
+			Stdout:            stdout,
+			Tty:               true,
+			TerminalSizeQueue: &fixedSizeQueue{size: &remotecommand.TerminalSize{Width: 80, Height: 24}},
+		})
+	}()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatal("exec did not end on stdin EOF")
+	}
+	assert.Equal(t, testInput, stdout.String())
 }
 
 func TestServePortForward(t *testing.T) {
@@ -305,9 +355,66 @@ func TestServePortForward(t *testing.T) {
 	doClientStreams(t, "portforward", stream, stream, nil)
 }
 
+func TestServeExecSessionLimit(t *testing.T) {
+	config := DefaultConfig
+	config.MaxSessionsPerContainer = 1
+	s, testServer := startTestServerWithConfig(t, config)
+	defer testServer.Close()
+
+	request := &runtimeapi.ExecRequest{
+		ContainerId: testContainerID,
+		Cmd:         []string{"cat"},
+		Tty:         true,
+		Stdin:       true,
+		Stdout:      true,
+	}
+	resp, err := s.GetExec(request)
+	require.NoError(t, err)
+	// Handed out before the session started, so only rejected once served.
+	pendingResp, err := s.GetExec(request)
+	require.NoError(t, err)
+
+	stdinR, stdinW := io.Pipe()
+	done := make(chan error)
+	go func() {
+		exec, err := remotecommand.NewWebSocketExecutor(&restclient.Config{}, "GET", resp.Url)
+		if err != nil {
+			done <- err
+			return
+		}
+		done <- exec.StreamWithContext(context.Background(), remotecommand.StreamOptions{
+			Stdin:             stdinR,
+			Stdout:            io.Discard,
+			Tty:               true,
+			TerminalSizeQueue: &fixedSizeQueue{size: &remotecommand.TerminalSize{Width: 80, Height: 24}},
+		})
+	}()
+	require.Eventually(t, func() bool {
+		_, err := s.GetExec(request)
+		return status.Code(err) == codes.ResourceExhausted
+	}, wait.ForeverTestTimeout, 10*time.Millisecond)
+
+	httpResp, err := http.Get(pendingResp.Url)
+	require.NoError(t, err)
+	httpResp.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, httpResp.StatusCode)
+
+	// Other containers are not limited.
+	_, err = s.GetExec(&runtimeapi.ExecRequest{ContainerId: "other", Cmd: []string{"cat"}, Stdout: true})
+	assert.NoError(t, err)
+
+	require.NoError(t, stdinW.Close())
+	require.NoError(t, <-done)
+	require.Eventually(t, func() bool {
+		_, err := s.GetExec(request)
+		return err == nil
+	}, wait.ForeverTestTimeout, 10*time.Millisecond)
+}
+
 // Run the remote command test.
-// commandType is either "exec" or "attach".
-func runRemoteCommandTest(t *testing.T, commandType string) {
+// commandType is either "exec" or "attach", the streams are created over a
+// v5 WebSocket if webSocket is set and over SPDY otherwise.
+func runRemoteCommandTest(t *testing.T, commandType string, webSocket bool) {
 	s, testServer := startTestServer(t)
 	defer testServer.Close()
 
@@ -347,7 +454,13 @@ func runRemoteCommandTest(t *testing.T, commandType string) {
 
 	go func() {
 		defer wg.Done()
-		exec, err := remotecommand.NewSPDYExecutor(&restclient.Config{}, "POST", reqURL)
+		var exec remotecommand.Executor
+		var err error
+		if webSocket {
+			exec, err = remotecommand.NewWebSocketExecutor(&restclient.Config{}, "GET", reqURL.String())
+		} else {
+			exec, err = remotecommand.NewSPDYExecutor(&restclient.Config{}, "POST", reqURL)
+		}
 		require.NoError(t, err)
 
 		opts := remotecommand.StreamOptions{
@@ -373,6 +486,10 @@ func runRemoteCommandTest(t *testing.T, commandType string) {
 }
 
 func startTestServer(t *testing.T) (Server, *httptest.Server) {
+	return startTestServerWithConfig(t, DefaultConfig)
+}
+
+func startTestServerWithConfig(t *testing.T, config Config) (Server, *httptest.Server) {
 	var s Server
 	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		s.ServeHTTP(w, r)
@@ -388,7 +505,6 @@ func startTestServer(t *testing.T) (Server, *httptest.Server) {
 	require.NoError(t, err)
 
 	rt := newFakeRuntime(t)
-	config := DefaultConfig
 	config.BaseURL = testURL
 	s, err = NewServer(config, rt)
 	require.NoError(t, err)
@@ -416,6 +532,12 @@ type fakeRuntime struct {
 
 func (f *fakeRuntime) Exec(ctx context.Context, containerID string, cmd []string, stdin io.Reader, stdout, stderr io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize) error {
 	assert.Equal(f.t, testContainerID, containerID)
+	if cmd[0] == "cat" {
+		assert.NotEmpty(f.t, sessionIDFromContext(ctx))
+		assert.Equal(f.t, remotecommand.TerminalSize{Width: 80, Height: 24}, <-resize)
+		_, err := io.Copy(stdout, stdin)
+		return err
+	}
 	doServerStreams(f.t, "exec", stdin, stdout, stderr)
 	return nil
 }
@@ -433,6 +555,17 @@ func (f *fakeRuntime) PortForward(ctx context.Context, podSandboxID string, port
 	return nil
 }
 
+// fixedSizeQueue is a remotecommand.TerminalSizeQueue returning size once.
+type fixedSizeQueue struct {
+	size *remotecommand.TerminalSize
+}
+
+func (q *fixedSizeQueue) Next() *remotecommand.TerminalSize {
+	size := q.size
+	q.size = nil
+	return size
+}
+
 // Send & receive expected input/output. Must be the inverse of doClientStreams.
 // Function will block until the expected i/o is finished.
 func doServerStreams(t *testing.T, prefix string, stdin io.Reader, stdout, stderr io.Writer) {
@@ -0,0 +1,93 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package streaming
+
+import (
+	"sync"
+
+	"github.com/Mirantis/cri-dockerd/metrics"
+)
+
+// sessionLimiter limits the concurrent exec, attach and port forward
+// sessions per container, the pod sandbox counting as the container of the
+// port forwards, and on the node.
+type sessionLimiter struct {
+	// maxPerContainer is the maximum number of sessions per container, no
+	// limit if not positive.
+	maxPerContainer int
+	// max is the maximum number of sessions on the node, no limit if not
+	// positive.
+	max int
+
+	lock         sync.Mutex
+	total        int
+	perContainer map[string]int
+}
+
+func newSessionLimiter(maxPerContainer, max int) *sessionLimiter {
+	return &sessionLimiter{
+		maxPerContainer: maxPerContainer,
+		max:             max,
+		perContainer:    make(map[string]int),
+	}
+}
+
+// check returns the error a session in container would be rejected with if
+// it started now, so that it is not handed out a streaming URL.
+func (l *sessionLimiter) check(sessionType, containerID string) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.checkLocked(sessionType, containerID)
+}
+
+func (l *sessionLimiter) checkLocked(sessionType, containerID string) error {
+	if l.maxPerContainer > 0 && l.perContainer[containerID] >= l.maxPerContainer {
+		metrics.StreamingSessionsRejected.WithLabelValues(sessionType).Inc()
+		return NewErrorTooManyInFlight("streaming sessions in container "+containerID, l.maxPerContainer)
+	}
+	if l.max > 0 && l.total >= l.max {
+		metrics.StreamingSessionsRejected.WithLabelValues(sessionType).Inc()
+		return NewErrorTooManyInFlight("streaming sessions on the node", l.max)
+	}
+	return nil
+}
+
+// acquire counts a session in container until the returned function is
+// called, or returns an error if it would go over a limit.
+func (l *sessionLimiter) acquire(sessionType, containerID string) (func(), error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if err := l.checkLocked(sessionType, containerID); err != nil {
+		return nil, err
+	}
+	l.total++
+	l.perContainer[containerID]++
+	metrics.StreamingSessions.WithLabelValues(sessionType).Inc()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.lock.Lock()
+			defer l.lock.Unlock()
+			l.total--
+			if l.perContainer[containerID]--; l.perContainer[containerID] == 0 {
+				delete(l.perContainer, containerID)
+			}
+			metrics.StreamingSessions.WithLabelValues(sessionType).Dec()
+		})
+	}, nil
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package streaming
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSessionLimiter(t *testing.T) {
+	l := newSessionLimiter(2, 3)
+	release1, err := l.acquire("exec", "c1")
+	require.NoError(t, err)
+	release2, err := l.acquire("attach", "c1")
+	require.NoError(t, err)
+
+	err = l.check("exec", "c1")
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Contains(t, err.Error(), "maximum number of in-flight streaming sessions in container c1 (2) exceeded")
+	_, err = l.acquire("exec", "c1")
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	release3, err := l.acquire("portforward", "sandbox")
+	require.NoError(t, err)
+	err = l.check("exec", "c2")
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Contains(t, err.Error(), "maximum number of in-flight streaming sessions on the node (3) exceeded")
+
+	release1()
+	// Releasing twice is a no-op.
+	release1()
+	assert.NoError(t, l.check("exec", "c1"))
+	release2()
+	release3()
+	assert.Equal(t, 0, l.total)
+	assert.Empty(t, l.perContainer)
+
+	unlimited := newSessionLimiter(0, 0)
+	for i := 0; i < 100; i++ {
+		_, err := unlimited.acquire("exec", "c1")
+		require.NoError(t, err)
+	}
+}
@@ -31,12 +31,19 @@ import (
 
 	"k8s.io/kubelet/pkg/cri/streaming"
 
+	"github.com/Mirantis/cri-dockerd/config"
 	"github.com/Mirantis/cri-dockerd/libdocker"
 )
 
 type StreamingRuntime struct {
 	Client      libdocker.DockerClientInterface
 	ExecHandler ExecHandler
+	// Recorder records the exec and attach sessions of the pods it selects,
+	// none are if nil.
+	Recorder *Recorder
+	// PodAnnotations returns the annotations of the pod of container, for
+	// the Recorder to select the pod.
+	PodAnnotations func(container *dockertypes.ContainerJSON) (map[string]string, error)
 }
 
 // ExecHandler knows how to execute a command in a running Docker container.
@@ -61,11 +68,24 @@ func (r *StreamingRuntime) Exec(
 	containerID string,
 	cmd []string,
 	in io.Reader,
-	out, err io.WriteCloser,
+	out, errw io.WriteCloser,
 	tty bool,
 	resize <-chan remotecommand.TerminalSize,
 ) error {
-	return r.ExecWithContext(context.TODO(), containerID, cmd, in, out, err, tty, resize, 0)
+	if r.Recorder != nil {
+		container, err := libdocker.CheckContainerStatus(r.Client, containerID)
+		if err != nil {
+			return err
+		}
+		streams, stop, err := r.record(ctx, "exec", container, cmd, tty, Streams{in, out, errw, resize})
+		if err != nil {
+			return err
+		}
+		defer stop()
+		in, out, errw, resize = streams.In, streams.Out, streams.Err, streams.Resize
+	}
+
+	return r.ExecWithContext(ctx, containerID, cmd, in, out, errw, tty, resize, 0)
 }
 
 // ExecWithContext adds a context.
@@ -106,14 +126,53 @@ func (r *StreamingRuntime) Attach(
 	tty bool,
 	resize <-chan remotecommand.TerminalSize,
 ) error {
-	_, err := libdocker.CheckContainerStatus(r.Client, containerID)
+	container, err := libdocker.CheckContainerStatus(r.Client, containerID)
 	if err != nil {
 		return err
 	}
+	if r.Recorder != nil {
+		streams, stop, err := r.record(ctx, "attach", container, nil, tty, Streams{in, out, errw, resize})
+		if err != nil {
+			return err
+		}
+		defer stop()
+		in, out, errw, resize = streams.In, streams.Out, streams.Err, streams.Resize
+	}
 
 	return attachContainer(r.Client, containerID, in, out, errw, tty, resize)
 }
 
+// record starts recording the session if the Recorder selects the pod of
+// container, it returns the streams unchanged otherwise.
+func (r *StreamingRuntime) record(
+	ctx context.Context,
+	sessionType string,
+	container *dockertypes.ContainerJSON,
+	cmd []string,
+	tty bool,
+	streams Streams,
+) (Streams, func(), error) {
+	labels := container.Config.Labels
+	annotations, err := r.PodAnnotations(container)
+	if err != nil {
+		return Streams{}, nil, fmt.Errorf("failed to get the pod annotations of container %s: %v", container.ID, err)
+	}
+	if !r.Recorder.Records(labels[config.KubernetesPodNamespaceLabel], annotations) {
+		return streams, func() {}, nil
+	}
+	return r.Recorder.Record(&Session{
+		ID:          sessionIDFromContext(ctx),
+		Type:        sessionType,
+		Namespace:   labels[config.KubernetesPodNamespaceLabel],
+		Pod:         labels[config.KubernetesPodNameLabel],
+		Container:   labels[config.KubernetesContainerNameLabel],
+		ContainerID: container.ID,
+		Command:     cmd,
+		TTY:         tty,
+		Start:       time.Now(),
+	}, streams)
+}
+
 func (r *StreamingRuntime) PortForward(
 	ctx context.Context,
 	podSandboxID string,
@@ -0,0 +1,102 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package logging configures the logs of cri-dockerd and carries the fields
+// identifying a CRI request to the logs written while handling it.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// The names of the fields identifying what a log line is about. Log with
+// these rather than formatting the identifiers into the message.
+const (
+	RequestIDKey    = "requestID"
+	MethodKey       = "method"
+	TraceIDKey      = "traceID"
+	PodKey          = "pod"
+	PodSandboxIDKey = "podSandboxID"
+	ContainerKey    = "container"
+	ContainerIDKey  = "containerID"
+	ImageKey        = "image"
+)
+
+const (
+	// FormatText is the default logrus format.
+	FormatText = "text"
+	// FormatJSON writes a JSON object per line.
+	FormatJSON = "json"
+)
+
+type entryKey struct{}
+
+// WithEntry returns a copy of ctx carrying the logger.
+func WithEntry(ctx context.Context, entry *logrus.Entry) context.Context {
+	return context.WithValue(ctx, entryKey{}, entry)
+}
+
+// WithFields returns a copy of ctx whose logger has the fields too.
+func WithFields(ctx context.Context, fields logrus.Fields) context.Context {
+	return WithEntry(ctx, FromContext(ctx).WithFields(fields))
+}
+
+// FromContext returns the logger of the request ctx belongs to, the standard
+// logger without fields if there is none.
+func FromContext(ctx context.Context) *logrus.Entry {
+	if entry, ok := ctx.Value(entryKey{}).(*logrus.Entry); ok {
+		return entry
+	}
+	return logrus.NewEntry(logrus.StandardLogger())
+}
+
+// Options configures the format and the output of the logs.
+type Options struct {
+	// Format is FormatText or FormatJSON.
+	Format string
+	// File is the file the logs are written to besides stderr, if not empty.
+	File string
+	// MaxSize is the size in megabytes File is rotated at.
+	MaxSize int
+	// MaxBackups is the number of rotated files kept, all of them if 0.
+	MaxBackups int
+}
+
+// Configure sets the format and the output of the standard logger.
+func Configure(opts Options) error {
+	switch opts.Format {
+	case FormatText, "":
+	case FormatJSON:
+		logrus.SetFormatter(&logrus.JSONFormatter{TimestampFormat: time.RFC3339Nano})
+	default:
+		return fmt.Errorf("unknown log format %q, expected %s or %s", opts.Format, FormatText, FormatJSON)
+	}
+	if opts.File != "" {
+		logrus.SetOutput(io.MultiWriter(os.Stderr, &lumberjack.Logger{
+			Filename:   opts.File,
+			MaxSize:    opts.MaxSize,
+			MaxBackups: opts.MaxBackups,
+		}))
+	}
+	return nil
+}
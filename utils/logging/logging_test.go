@@ -0,0 +1,54 @@
+/*
+Copyright 2021 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromContext(t *testing.T) {
+	var out bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&out)
+	logger.SetFormatter(&logrus.JSONFormatter{})
+
+	assert.Empty(t, FromContext(context.Background()).Data)
+
+	ctx := WithEntry(context.Background(), logrus.NewEntry(logger).WithField(RequestIDKey, "r1"))
+	ctx = WithFields(ctx, logrus.Fields{ContainerIDKey: "c1"})
+	FromContext(ctx).Info("Stopped container")
+
+	var line map[string]interface{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &line))
+	assert.Equal(t, "r1", line[RequestIDKey])
+	assert.Equal(t, "c1", line[ContainerIDKey])
+	assert.Equal(t, "Stopped container", line["msg"])
+}
+
+func TestConfigure(t *testing.T) {
+	defer logrus.SetFormatter(logrus.StandardLogger().Formatter)
+	assert.ErrorContains(t, Configure(Options{Format: "xml"}), `unknown log format "xml"`)
+	require.NoError(t, Configure(Options{Format: FormatJSON}))
+	assert.IsType(t, &logrus.JSONFormatter{}, logrus.StandardLogger().Formatter)
+}